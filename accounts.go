@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Compared against when a login names an unknown account, so both failures take as long
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := hashPassword("not a real password")
+	if err != nil {
+		log.Printf("Error hashing dummy password: %v", err)
+	}
+	return hash
+})
+
+// accountCredentials is the body of account creation and login
+type accountCredentials struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+	Captcha  string `json:"captcha,omitempty"` // Required on login after LoginCaptchaThreshold failures
+}
+
+// createAccount stores a new account, reporting false when the user ID is taken
+func createAccount(ctx context.Context, userID, password string) (bool, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	now := dbNow()
+	result, err := db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO accounts (user_id, password_hash, created_at, updated_at) VALUES (?, ?, ?, ?)
+	`, userID, hash, now, now)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// checkPassword reports whether password matches the account's. A match against a bcrypt hash,
+// or an argon2id one with outdated parameters, is re-hashed with the current settings while
+// the plain password is at hand.
+func checkPassword(ctx context.Context, userID, password string) (bool, error) {
+	var hash string
+	err := db.QueryRowContext(ctx, "SELECT password_hash FROM accounts WHERE user_id = ?", userID).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		verifyPassword(dummyPasswordHash(), password)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	match, needsRehash, err := verifyPassword(hash, password)
+	if err != nil || !match {
+		return false, err
+	}
+	if needsRehash {
+		if err := rehashPassword(ctx, userID, hash, password); err != nil {
+			log.Printf("Error re-hashing password for %s: %v", userID, err)
+		}
+	}
+	return true, nil
+}
+
+// rehashPassword replaces an account's password hash, unless it changed since it was read
+func rehashPassword(ctx context.Context, userID, oldHash, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, "UPDATE accounts SET password_hash = ?, updated_at = ? WHERE user_id = ? AND password_hash = ?",
+		hash, dbNow(), userID, oldHash)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		log.Printf("Upgraded password hash for %s to argon2id", userID)
+	}
+	return nil
+}
+
+// mergeAnonymousHistory moves an anonymous session's reading lists, ratings and viewed books to
+// the account it logged in to. A list named like one of the account's is folded into it: books
+// on both keep the earlier added_at and either read mark, and the anonymous list, share link
+// included, goes. Where both rated the same book the account's rating stands and the anonymous
+// one is dropped; the rating recompute job takes it out of the aggregate. Views keep whichever
+// is later.
+func mergeAnonymousHistory(ctx context.Context, anonymousUserID, userID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO reading_list_items (list_id, book_id, added_at, read_at)
+		SELECT target.id, i.book_id, i.added_at, i.read_at
+		FROM reading_list_items i
+		JOIN reading_lists source ON source.id = i.list_id
+		JOIN reading_lists target ON target.user_id = ? AND target.name = source.name
+		WHERE source.user_id = ?
+		ON CONFLICT(list_id, book_id) DO UPDATE SET
+			added_at = MIN(added_at, excluded.added_at),
+			read_at = COALESCE(read_at, excluded.read_at)
+	`, userID, anonymousUserID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM reading_lists
+		WHERE user_id = ? AND name IN (SELECT name FROM reading_lists WHERE user_id = ?)
+	`, anonymousUserID, userID); err != nil {
+		return err
+	}
+	lists, err := tx.ExecContext(ctx, "UPDATE reading_lists SET user_id = ?, updated_at = ? WHERE user_id = ?", userID, dbNow(), anonymousUserID)
+	if err != nil {
+		return err
+	}
+	ratings, err := tx.ExecContext(ctx, "UPDATE OR IGNORE book_ratings SET user_id = ? WHERE user_id = ?", userID, anonymousUserID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM book_ratings WHERE user_id = ?", anonymousUserID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO recently_viewed (user_id, book_id, viewed_at)
+		SELECT ?, book_id, viewed_at FROM recently_viewed WHERE user_id = ?
+		ON CONFLICT(user_id, book_id) DO UPDATE SET viewed_at = MAX(viewed_at, excluded.viewed_at)
+	`, userID, anonymousUserID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recently_viewed WHERE user_id = ?", anonymousUserID); err != nil {
+		return err
+	}
+	if err := trimRecentlyViewed(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	movedLists, _ := lists.RowsAffected()
+	movedRatings, _ := ratings.RowsAffected()
+	if movedLists > 0 || movedRatings > 0 {
+		log.Printf("Merged %d reading lists and %d ratings from an anonymous session into %s", movedLists, movedRatings, userID)
+	}
+	invalidatePersonalRecommendations(anonymousUserID)
+	invalidatePersonalRecommendations(userID)
+	return nil
+}
+
+// logIn starts a session for userID, carrying over whatever the visitor did anonymously. The
+// session gets a new ID so one planted before login is useless after it.
+func logIn(w http.ResponseWriter, r *http.Request, userID string) (Session, error) {
+	if previous, ok := SessionFromContext(r.Context()); ok && previous.Anonymous() {
+		if err := mergeAnonymousHistory(r.Context(), previous.actingUserID(), userID); err != nil {
+			return Session{}, err
+		}
+	}
+	session := newSession(userID)
+	setSessionCookie(w, session)
+	return session, nil
+}
+
+// decodeCredentials parses and validates a user ID and password, writing the error response on
+// failure. New accounts also get their user ID and password checked against the rules.
+func decodeCredentials(w http.ResponseWriter, r *http.Request, creating bool) (accountCredentials, bool) {
+	var credentials accountCredentials
+	if !decodeJSONBody(w, r, &credentials) {
+		return credentials, false
+	}
+	credentials.UserID = strings.TrimSpace(credentials.UserID)
+	if credentials.UserID == "" || credentials.Password == "" {
+		writeError(w, r, http.StatusBadRequest, "user_id and password are required")
+		return credentials, false
+	}
+	if len(credentials.Password) > maxPasswordBytes {
+		writeError(w, r, http.StatusBadRequest, "password is too long")
+		return credentials, false
+	}
+	if !creating {
+		return credentials, true
+	}
+
+	if len(credentials.UserID) > 64 || strings.Contains(credentials.UserID, "/") || credentials.UserID == "me" ||
+		strings.HasPrefix(credentials.UserID, anonymousUserPrefix) {
+		writeError(w, r, http.StatusBadRequest, "user_id must be at most 64 characters, without '/', and not 'me' or start with '"+anonymousUserPrefix+"'")
+		return credentials, false
+	}
+	// Logins to these are admins, so they can't be claimed by whoever signs up first. Answered
+	// like a taken ID, so the admin list isn't given away.
+	if containsString(config.AdminUsers, credentials.UserID) {
+		log.Printf("Refused sign-up as admin user %s", credentials.UserID)
+		writeError(w, r, http.StatusConflict, "user_id is taken")
+		return credentials, false
+	}
+	if err := validatePassword(credentials.UserID, credentials.Password); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return credentials, false
+	}
+	return credentials, true
+}
+
+// AccountsHandler handles POST /api/accounts, creating an account and logging it in
+func AccountsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	credentials, ok := decodeCredentials(w, r, true)
+	if !ok {
+		return
+	}
+
+	created, err := createAccount(r.Context(), credentials.UserID, credentials.Password)
+	if err != nil {
+		log.Printf("Error creating account %s: %v", credentials.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to create account")
+		return
+	}
+	if !created {
+		writeError(w, r, http.StatusConflict, "user_id is taken")
+		return
+	}
+	session, err := logIn(w, r, credentials.UserID)
+	if err != nil {
+		log.Printf("Error logging in new account %s: %v", credentials.UserID, err)
+		writeError(w, r, http.StatusInternalServerError, "Account created, but logging in failed")
+		return
+	}
+	log.Printf("Created account %s", credentials.UserID)
+	writeJSON(w, r, http.StatusCreated, newSessionInfo(session))
+}
+
+// SessionHandler handles the visitor's session:
+//
+//	GET  /api/session          Who the session belongs to
+//	POST /api/session/login    Log in with {"user_id", "password"}
+//	POST /api/session/logout   End the session
+func SessionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/session":
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		session, ok := SessionFromContext(r.Context())
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, "No session")
+			return
+		}
+		info := newSessionInfo(session)
+		if impersonation, ok := ImpersonationFromContext(r.Context()); ok {
+			info.ImpersonatedBy = impersonation.AdminID
+		}
+		writeJSON(w, r, http.StatusOK, info)
+
+	case "/api/session/login":
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		credentials, ok := decodeCredentials(w, r, false)
+		if !ok {
+			return
+		}
+		attempt, ok := guardLogin(w, r, credentials)
+		if !ok {
+			return
+		}
+		valid, err := checkPassword(r.Context(), credentials.UserID, credentials.Password)
+		if err != nil {
+			log.Printf("Error checking password for %s: %v", credentials.UserID, err)
+			releaseLoginAttemptOrLog(r, credentials.UserID, attempt)
+			writeError(w, r, http.StatusInternalServerError, "Failed to log in")
+			return
+		}
+		if !valid {
+			recordFailedLogin(r, credentials.UserID, attempt)
+			writeError(w, r, http.StatusUnauthorized, "Invalid user_id or password")
+			return
+		}
+		releaseLoginAttemptOrLog(r, credentials.UserID, attempt)
+		session, err := logIn(w, r, credentials.UserID)
+		if err != nil {
+			log.Printf("Error logging in %s: %v", credentials.UserID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to log in")
+			return
+		}
+		if err := clearLoginFailures(r.Context(), credentials.UserID); err != nil {
+			log.Printf("Error clearing failed logins for %s: %v", credentials.UserID, err)
+		}
+		recordSecurityEvent(r, "login.succeeded", credentials.UserID, http.StatusOK, "")
+		writeJSON(w, r, http.StatusOK, newSessionInfo(session))
+
+	case "/api/session/logout":
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		clearSessionCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusNotFound, "Not found")
+	}
+}
+
+// SessionInfo is the body of the session endpoints
+type SessionInfo struct {
+	UserID    string    `json:"user_id,omitempty"` // Omitted while anonymous
+	Anonymous bool      `json:"anonymous"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	ImpersonatedBy string `json:"impersonated_by,omitempty"` // Admin acting as the user through an impersonation token
+}
+
+func newSessionInfo(session Session) SessionInfo {
+	return SessionInfo{
+		UserID:    session.UserID,
+		Anonymous: session.Anonymous(),
+		ExpiresAt: time.Unix(session.ExpiresAt, 0).UTC(),
+	}
+}
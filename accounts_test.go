@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// createListWithBooks makes a reading list through client and puts books on it, marking those
+// in read as read, and returns the list
+func createListWithBooks(t *testing.T, client *http.Client, url, name string, books []string, read ...string) ReadingList {
+	t.Helper()
+	status, body := doRequest(t, client, http.MethodPost, url+"/api/users/me/lists", `{"name": "`+name+`"}`)
+	var list ReadingList
+	if err := json.Unmarshal([]byte(body), &list); status != http.StatusCreated || err != nil {
+		t.Fatalf("creating list %s = %d %s", name, status, body)
+	}
+	for _, bookID := range books {
+		itemBody := ""
+		if containsString(read, bookID) {
+			itemBody = `{"read": true}`
+		}
+		if status, body := doRequest(t, client, http.MethodPut, fmt.Sprintf("%s/api/users/me/lists/%d/books/%s", url, list.ID, bookID), itemBody); status != http.StatusOK {
+			t.Fatalf("adding book %s to %s = %d %s", bookID, name, status, body)
+		}
+	}
+	return list
+}
+
+func TestLoginMergesAnonymousHistoryIntoAccount(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	// alice's account already has a list, a rating and a view
+	alice := newTestClient(t)
+	if status, body := doRequest(t, alice, http.MethodPost, server.URL+"/api/accounts", `{"user_id": "alice", "password": "Correct-Horse-42"}`); status != http.StatusCreated {
+		t.Fatalf("signing up = %d %s", status, body)
+	}
+	createListWithBooks(t, alice, server.URL, "Favourites", []string{"1"})
+	if _, _, err := SaveRating(ctx, "1", "alice", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordBookView(ctx, "alice", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before logging in, the visitor builds overlapping history: a list with the same name
+	// sharing book 1, which they have also read, a list of their own, another rating of book 1
+	// and views of books 2 and 4
+	visitor := newTestClient(t)
+	favourites := createListWithBooks(t, visitor, server.URL, "Favourites", []string{"1", "3"}, "1")
+	createListWithBooks(t, visitor, server.URL, "Later", []string{"4"})
+	anonymousID := favourites.UserID
+	if _, _, err := SaveRating(ctx, "1", anonymousID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := SaveRating(ctx, "3", anonymousID, 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, bookID := range []string{"2", "4"} {
+		if err := recordBookView(ctx, anonymousID, bookID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status, body := doRequest(t, visitor, http.MethodPost, server.URL+"/api/session/login", `{"user_id": "alice", "password": "Correct-Horse-42"}`); status != http.StatusOK {
+		t.Fatalf("logging in over the anonymous session = %d %s, want 200", status, body)
+	}
+
+	lists, err := listReadingLists(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := map[string][]string{}
+	for _, list := range lists {
+		for _, item := range list.Items {
+			books[list.Name] = append(books[list.Name], item.BookID)
+			if list.Name == "Favourites" && item.BookID == "1" && item.ReadAt == nil {
+				t.Errorf("book 1 lost the read mark from the anonymous list")
+			}
+		}
+	}
+	if len(lists) != 2 || fmt.Sprint(books["Favourites"]) != "[1 3]" || fmt.Sprint(books["Later"]) != "[4]" {
+		t.Errorf("alice's lists after the merge = %v, want Favourites [1 3] and Later [4]", books)
+	}
+
+	ratings, err := UserRatings(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ratings) != 2 || ratings["1"] != 5 || ratings["3"] != 4 {
+		t.Errorf("alice's ratings after the merge = %v, want her own 5 for book 1 and the visitor's 4 for book 3", ratings)
+	}
+
+	viewed, err := listRecentlyViewed(ctx, "alice", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewedIDs := []string{}
+	for _, book := range viewed {
+		viewedIDs = append(viewedIDs, book.BookID)
+	}
+	if len(viewedIDs) != 2 || !containsString(viewedIDs, "2") || !containsString(viewedIDs, "4") {
+		t.Errorf("alice's recently viewed after the merge = %v, want books 2 and 4 once each", viewedIDs)
+	}
+
+	for _, table := range []string{"reading_lists", "book_ratings", "recently_viewed"} {
+		var left int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE user_id = ?", anonymousID).Scan(&left); err != nil {
+			t.Fatal(err)
+		}
+		if left != 0 {
+			t.Errorf("%d %s rows left under the anonymous ID", left, table)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Restock events shown in the admin book view
+const adminRestockEventLimit = 50
+
+// AdminBookDetail is the body of GET /api/admin/books/{id}. Its sections are the same models
+// the public endpoints serve; fields tagged visibility:"admin" are filled in and kept here.
+type AdminBookDetail struct {
+	BookID        string          `json:"book_id"`
+	Metadata      BookMetadata    `json:"metadata"`
+	Pricing       *BookPricing    `json:"pricing"`   // null when the book has no pricing row
+	Inventory     *BookInventory  `json:"inventory"` // null when the book has no inventory row
+	Reviews       *BookReviews    `json:"reviews"`   // null when the book has no reviews row
+	RestockEvents []RestockEvent  `json:"restock_events" visibility:"admin"`
+	IncomingStock []PurchaseOrder `json:"incoming_stock" visibility:"admin"` // Outstanding purchase orders, soonest first
+}
+
+// RestockEvent is one increase in a book's stock, newest first
+type RestockEvent struct {
+	QuantityAdded int       `json:"quantity_added"`
+	QuantityAfter int       `json:"quantity_after"`
+	RestockedAt   time.Time `json:"restocked_at"`
+}
+
+// createRestockLog creates the restock_events table and the trigger that appends to it
+// whenever an inventory quantity goes up, whichever writer raised it
+func createRestockLog() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS restock_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			book_id TEXT NOT NULL,
+			quantity_added INTEGER NOT NULL,
+			quantity_after INTEGER NOT NULL,
+			restocked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS inventory_restock AFTER UPDATE OF quantity ON inventory
+		WHEN NEW.quantity > OLD.quantity
+		BEGIN
+			INSERT INTO restock_events (book_id, quantity_added, quantity_after) VALUES (NEW.book_id, NEW.quantity - OLD.quantity, NEW.quantity);
+		END
+	`)
+	return err
+}
+
+// LoadAdminBookDetail reads a book with its internal-only fields. The public sections come from
+// the same fetch functions the details endpoints use, bypassing the cache so operators see
+// what is stored right now.
+func LoadAdminBookDetail(ctx context.Context, bookID string) (AdminBookDetail, error) {
+	detail := AdminBookDetail{BookID: bookID, RestockEvents: []RestockEvent{}}
+	ctx = withoutRepositoryCache(ctx)
+
+	metadata, err := FetchBookMetadata(ctx, bookID)
+	if err != nil {
+		return detail, err
+	}
+	detail.Metadata = metadata
+	if pricing, err := FetchBookPricing(ctx, bookID); err == nil {
+		detail.Pricing = &pricing
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return detail, err
+	}
+	if inventory, err := FetchBookInventory(ctx, bookID); err == nil {
+		detail.Inventory = &inventory
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return detail, err
+	}
+	if reviews, err := FetchBookReviews(ctx, bookID); err == nil {
+		detail.Reviews = &reviews
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return detail, err
+	}
+
+	if err := loadInternalFields(ctx, &detail); err != nil {
+		return detail, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT quantity_added, quantity_after, restocked_at
+		FROM restock_events
+		WHERE book_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, bookID, adminRestockEventLimit)
+	if err != nil {
+		return detail, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event RestockEvent
+		if err := rows.Scan(&event.QuantityAdded, &event.QuantityAfter, &event.RestockedAt); err != nil {
+			return detail, err
+		}
+		detail.RestockEvents = append(detail.RestockEvents, event)
+	}
+	if err := rows.Err(); err != nil {
+		return detail, err
+	}
+
+	detail.IncomingStock, err = listPurchaseOrders(ctx, purchaseOrderFilter{BookID: bookID, Status: "outstanding"})
+	return detail, err
+}
+
+// loadInternalFields fills the admin-only fields of every section in one query
+func loadInternalFields(ctx context.Context, detail *AdminBookDetail) error {
+	var moderationFlags, supplier sql.NullString
+	var costPrice sql.NullFloat64
+	var createdAt, updatedAt, pricingUpdatedAt, lastRestocked, inventoryUpdatedAt, reviewsUpdatedAt sql.NullTime
+
+	err := db.QueryRowContext(ctx, `
+		SELECT b.moderation_flags, b.created_at, b.updated_at, p.cost_price, p.updated_at,
+			i.supplier, i.last_restocked, i.updated_at, r.updated_at
+		FROM books b
+		LEFT JOIN pricing p ON p.book_id = b.id
+		LEFT JOIN inventory i ON i.book_id = b.id
+		LEFT JOIN reviews r ON r.book_id = b.id
+		WHERE b.id = ?
+	`, detail.BookID).Scan(&moderationFlags, &createdAt, &updatedAt, &costPrice, &pricingUpdatedAt,
+		&supplier, &lastRestocked, &inventoryUpdatedAt, &reviewsUpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	detail.Metadata.ModerationFlags = []string{}
+	for _, flag := range strings.Split(moderationFlags.String, ",") {
+		if flag = strings.TrimSpace(flag); flag != "" {
+			detail.Metadata.ModerationFlags = append(detail.Metadata.ModerationFlags, flag)
+		}
+	}
+	detail.Metadata.CreatedAt = nullTimePtr(createdAt)
+	detail.Metadata.UpdatedAt = nullTimePtr(updatedAt)
+	if detail.Pricing != nil {
+		if costPrice.Valid {
+			detail.Pricing.CostPrice = &costPrice.Float64
+		}
+		detail.Pricing.UpdatedAt = nullTimePtr(pricingUpdatedAt)
+	}
+	if detail.Inventory != nil {
+		detail.Inventory.Supplier = nullStringPtr(supplier)
+		detail.Inventory.LastRestocked = nullTimePtr(lastRestocked)
+		detail.Inventory.UpdatedAt = nullTimePtr(inventoryUpdatedAt)
+	}
+	if detail.Reviews != nil {
+		detail.Reviews.UpdatedAt = nullTimePtr(reviewsUpdatedAt)
+	}
+	return nil
+}
+
+// AdminBookDetailHandler handles GET /api/admin/books/{id}
+func AdminBookDetailHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	detail, err := LoadAdminBookDetail(r.Context(), bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "Book not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading admin view of book %s: %v", bookID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load book")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, detail)
+}
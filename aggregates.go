@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RatingCorrection is one aggregate value the recompute changed
+type RatingCorrection struct {
+	BookID string   `json:"book_id"`
+	Field  string   `json:"field"`
+	Before *float64 `json:"before"` // null for a missing average or a reviews row that didn't exist
+	After  *float64 `json:"after"`
+}
+
+// RecomputeResponse is the body of POST /api/admin/ratings/recompute
+type RecomputeResponse struct {
+	BooksChecked int                `json:"books_checked"`
+	Corrections  []RatingCorrection `json:"corrections"`
+}
+
+// StartRatingRecompute repairs rating aggregates once and then every interval until ctx is done
+func StartRatingRecompute(ctx context.Context, interval time.Duration) {
+	runPeriodically(ctx, "rating aggregate recompute", interval, func(ctx context.Context) error {
+		_, err := RecomputeRatingAggregates(ctx)
+		return err
+	})
+}
+
+// RecomputeRatingAggregates repairs drift in the reviews table, where SaveRating updates the
+// aggregate incrementally. The star histogram is the authority, because imported review
+// counts have no individual rows behind them; but each bucket must hold at least the
+// individual ratings in book_ratings with that value. total_reviews and average_rating are
+// then derived from the histogram. Every correction is logged and returned.
+func RecomputeRatingAggregates(ctx context.Context) (RecomputeResponse, error) {
+	response := RecomputeResponse{Corrections: []RatingCorrection{}}
+
+	tx, err := dbFor(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return response, err
+	}
+	defer tx.Rollback()
+
+	// Individual ratings per book and star value
+	rated := map[string]map[int]int{}
+	rows, err := tx.QueryContext(ctx, "SELECT book_id, rating, COUNT(*) FROM book_ratings GROUP BY book_id, rating")
+	if err != nil {
+		return response, err
+	}
+	for rows.Next() {
+		var bookID string
+		var rating, count int
+		if err := rows.Scan(&bookID, &rating, &count); err != nil {
+			rows.Close()
+			return response, err
+		}
+		if rated[bookID] == nil {
+			rated[bookID] = map[int]int{}
+		}
+		rated[bookID][rating] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return response, err
+	}
+
+	// Books with ratings but no aggregate row get an empty one to be filled in below
+	for bookID := range rated {
+		result, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO reviews (book_id) VALUES (?)", bookID)
+		if err != nil {
+			return response, err
+		}
+		if created, _ := result.RowsAffected(); created > 0 {
+			response.Corrections = append(response.Corrections, RatingCorrection{BookID: bookID, Field: "reviews_row"})
+		}
+	}
+
+	type aggregate struct {
+		bookID  string
+		average sql.NullFloat64
+		total   int
+		stars   map[int]int
+	}
+	var aggregates []aggregate
+	rows, err = tx.QueryContext(ctx, `
+		SELECT book_id, average_rating, COALESCE(total_reviews, 0), COALESCE(five_star, 0), COALESCE(four_star, 0),
+			COALESCE(three_star, 0), COALESCE(two_star, 0), COALESCE(one_star, 0)
+		FROM reviews
+	`)
+	if err != nil {
+		return response, err
+	}
+	for rows.Next() {
+		current := aggregate{stars: map[int]int{}}
+		var five, four, three, two, one int
+		if err := rows.Scan(&current.bookID, &current.average, &current.total, &five, &four, &three, &two, &one); err != nil {
+			rows.Close()
+			return response, err
+		}
+		current.stars[5], current.stars[4], current.stars[3], current.stars[2], current.stars[1] = five, four, three, two, one
+		aggregates = append(aggregates, current)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return response, err
+	}
+	response.BooksChecked = len(aggregates)
+
+	now := dbNow()
+	for _, current := range aggregates {
+		var corrections []RatingCorrection
+		correct := func(field string, before, after *float64) {
+			corrections = append(corrections, RatingCorrection{BookID: current.bookID, Field: field, Before: before, After: after})
+		}
+
+		stars := map[int]int{}
+		total, weighted := 0, 0
+		for rating := 5; rating >= 1; rating-- {
+			column := ratingColumns[rating]
+			stars[rating] = max(current.stars[rating], rated[current.bookID][rating])
+			if stars[rating] != current.stars[rating] {
+				correct(column, floatPtr(float64(current.stars[rating])), floatPtr(float64(stars[rating])))
+			}
+			total += stars[rating]
+			weighted += rating * stars[rating]
+		}
+		if total != current.total {
+			correct("total_reviews", floatPtr(float64(current.total)), floatPtr(float64(total)))
+		}
+
+		var average *float64
+		if total > 0 {
+			average = floatPtr(math.Round(float64(weighted)/float64(total)*10) / 10)
+		}
+		var before *float64
+		if current.average.Valid {
+			before = floatPtr(current.average.Float64)
+		}
+		if (before == nil) != (average == nil) || (before != nil && math.Abs(*before-*average) > 0.001) {
+			correct("average_rating", before, average)
+		}
+
+		if len(corrections) == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE reviews SET five_star = ?, four_star = ?, three_star = ?, two_star = ?, one_star = ?,
+				total_reviews = ?, average_rating = ?, updated_at = ?
+			WHERE book_id = ?
+		`, stars[5], stars[4], stars[3], stars[2], stars[1], total, average, now, current.bookID); err != nil {
+			return response, err
+		}
+		for _, correction := range corrections {
+			log.Printf("Corrected %s for book %s: %s -> %s", correction.Field, correction.BookID,
+				formatOptionalFloat(correction.Before), formatOptionalFloat(correction.After))
+		}
+		response.Corrections = append(response.Corrections, corrections...)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return response, err
+	}
+	for _, correction := range response.Corrections {
+		invalidateBookRepository(correction.BookID, "reviews")
+	}
+	return response, nil
+}
+
+// floatPtr returns a pointer to a copy of value
+func floatPtr(value float64) *float64 {
+	return &value
+}
+
+// formatOptionalFloat formats a correction value for the log
+func formatOptionalFloat(value *float64) string {
+	if value == nil {
+		return "null"
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+// RatingRecomputeHandler handles POST /api/admin/ratings/recompute, running the repair now
+func RatingRecomputeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response, err := RecomputeRatingAggregates(withBatchPriority(r.Context()))
+	if err != nil {
+		log.Printf("Error recomputing rating aggregates: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to recompute rating aggregates")
+		return
+	}
+	log.Printf("Recomputed rating aggregates for %d books, %d corrections", response.BooksChecked, len(response.Corrections))
+	writeJSON(w, r, http.StatusOK, response)
+}
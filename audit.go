@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// recordAudit appends an entry to the audit log. Failures are logged rather than returned:
+// the action being audited has already happened.
+func recordAudit(ctx context.Context, entry AuditEntry) {
+	_, err := db.ExecContext(context.WithoutCancel(ctx), `
+		INSERT INTO audit_log (occurred_at, actor, action, user_id, impersonated, impersonation_id, status, request_id, detail)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, dbNow(), entry.Actor, entry.Action, entry.UserID, entry.Impersonated, entry.ImpersonationID, entry.Status,
+		RequestIDFromContext(ctx), entry.Detail)
+	if err != nil {
+		log.Printf("Error writing audit entry %q by %s: %v", entry.Action, entry.Actor, err)
+	}
+}
+
+// auditFilter narrows an audit log listing; zero values match everything
+type auditFilter struct {
+	UserID           string
+	Actor            string
+	ImpersonationID  string
+	ImpersonatedOnly bool
+	Limit            int
+}
+
+// listAuditEntries returns matching entries, newest first
+func listAuditEntries(ctx context.Context, filter auditFilter) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.UserID != "" {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.ImpersonationID != "" {
+		conditions = append(conditions, "impersonation_id = ?")
+		args = append(args, filter.ImpersonationID)
+	}
+	if filter.ImpersonatedOnly {
+		conditions = append(conditions, "impersonated = 1")
+	}
+
+	query := `
+		SELECT id, occurred_at, actor, action, user_id, impersonated, impersonation_id, status, request_id, detail
+		FROM audit_log
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.OccurredAt, &entry.Actor, &entry.Action, &entry.UserID, &entry.Impersonated,
+			&entry.ImpersonationID, &entry.Status, &entry.RequestID, &entry.Detail); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// AuditLogHandler handles GET /api/admin/audit-log, newest first, filtered by ?user_id=,
+// ?actor=, ?impersonation_id= and ?impersonated=true, at most ?limit=N (default 100, max 1000)
+func AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := auditFilter{
+		UserID:          query.Get("user_id"),
+		Actor:           query.Get("actor"),
+		ImpersonationID: query.Get("impersonation_id"),
+		Limit:           100,
+	}
+	if raw := query.Get("impersonated"); raw != "" {
+		impersonated, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "impersonated must be true or false")
+			return
+		}
+		filter.ImpersonatedOnly = impersonated
+	}
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			writeError(w, r, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	entries, err := listAuditEntries(r.Context(), filter)
+	if err != nil {
+		log.Printf("Error loading audit log: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load audit log")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, entries)
+}
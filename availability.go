@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Longest a client may ask an availability request to wait
+const maxAvailabilityWait = 60 * time.Second
+
+// How often the hub looks for new entries in the change log
+const catalogHubPollInterval = 500 * time.Millisecond
+
+// catalogHub fans catalog changes out to in-process subscribers by book. One goroutine tails
+// catalog_changes, so every writer (including other processes sharing the database) is seen,
+// and waiting clients cost a channel each rather than a query loop each.
+type catalogHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]bool // By entity and book ID, e.g. "inventory/1"
+	start       sync.Once
+}
+
+// Hub shared by long-polling handlers and the repository cache; it starts tailing on first
+// subscription, or when NewServer starts it for the cache
+var changeHub = &catalogHub{subscribers: map[string]map[chan struct{}]bool{}}
+
+// Start begins tailing the change log from its current end, once
+func (h *catalogHub) Start() {
+	h.start.Do(func() {
+		// Find the end of the log before returning, so changes after the caller's first read
+		// can't slip in ahead of the tail's starting point
+		var cursor int64
+		if err := db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM catalog_changes").Scan(&cursor); err != nil {
+			log.Printf("Error starting catalog hub: %v", err)
+		}
+		go h.tail(context.Background(), cursor)
+	})
+}
+
+// Subscribe returns a channel that receives after each change to one entity of a book
+// ("inventory", "pricing", ...), and a function to unsubscribe. Notifications coalesce: a slow
+// reader sees at least one after any number of changes.
+func (h *catalogHub) Subscribe(entity, bookID string) (<-chan struct{}, func()) {
+	h.Start()
+
+	key := entity + "/" + bookID
+	notify := make(chan struct{}, 1)
+	h.mu.Lock()
+	if h.subscribers[key] == nil {
+		h.subscribers[key] = map[chan struct{}]bool{}
+	}
+	h.subscribers[key][notify] = true
+	h.mu.Unlock()
+
+	return notify, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers[key], notify)
+		if len(h.subscribers[key]) == 0 {
+			delete(h.subscribers, key)
+		}
+	}
+}
+
+// tail follows the change log after cursor and notifies the subscribers of each change. The
+// repository cache entry a change affects is dropped first, so a subscriber woken by it reads
+// the new row.
+func (h *catalogHub) tail(ctx context.Context, cursor int64) {
+	ticker := time.NewTicker(catalogHubPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changes, _, err := loadCatalogChanges(ctx, cursor, maxChangesPageSize)
+		if err != nil {
+			log.Printf("Error tailing catalog changes: %v", err)
+			continue
+		}
+		h.mu.Lock()
+		for _, change := range changes {
+			cursor = change.Seq
+			if entity, ok := repositoryEntityForChange(change.Entity); ok {
+				invalidateBookRepository(change.BookID, entity)
+			}
+			for notify := range h.subscribers[change.Entity+"/"+change.BookID] {
+				select {
+				case notify <- struct{}{}:
+				default: // Already has a pending notification
+				}
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// BookAvailability is the body of GET /api/books/{id}/availability
+type BookAvailability struct {
+	BookID    string        `json:"book_id"`
+	Status    string        `json:"status"` // "in_stock" or "backordered"; this is what waiting watches
+	Inventory BookInventory `json:"inventory"`
+}
+
+// etag identifies the availability status under the current cache version; quantity changes
+// alone don't wake waiting clients
+func (a BookAvailability) etag() string {
+	return `"` + cacheKey(a.Status) + `"`
+}
+
+// loadAvailability reads a book's inventory and derives its stock status
+func loadAvailability(ctx context.Context, bookID string) (BookAvailability, error) {
+	inventory, err := FetchBookInventory(ctx, bookID)
+	if err != nil {
+		return BookAvailability{}, err
+	}
+	availability := BookAvailability{BookID: bookID, Status: "in_stock", Inventory: inventory}
+	if !inventory.InStock || inventory.Quantity <= 0 {
+		availability.Status = "backordered"
+	}
+	return availability, nil
+}
+
+// AvailabilityHandler handles GET /api/books/{id}/availability?wait=30s, a long poll for
+// clients that can't hold a WebSocket. With If-None-Match set to the ETag of a previous answer,
+// the request blocks until the stock status differs from it, answering 200, or until wait runs
+// out, answering 304. Without If-None-Match, or with wait=0, it answers right away.
+func AvailabilityHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !checkRegion(w, r, bookID) {
+		return
+	}
+
+	var wait time.Duration
+	if rawWait := r.URL.Query().Get("wait"); rawWait != "" {
+		parsed, err := time.ParseDuration(rawWait)
+		if err != nil {
+			// Plain numbers are seconds
+			seconds, numberErr := strconv.Atoi(rawWait)
+			parsed, err = time.Duration(seconds)*time.Second, numberErr
+		}
+		if err != nil || parsed < 0 || parsed > maxAvailabilityWait {
+			writeError(w, r, http.StatusBadRequest, "wait must be a duration between 0s and 60s")
+			return
+		}
+		wait = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	// Subscribe before the first read so a change in between still wakes us
+	changed, unsubscribe := changeHub.Subscribe("inventory", bookID)
+	defer unsubscribe()
+
+	known := r.Header.Get("If-None-Match")
+	for {
+		// Reads get their own deadline; the wait may already be over when the last one starts
+		readCtx, cancelRead := context.WithTimeout(r.Context(), config.DetailRequestTimeout)
+		availability, err := loadAvailability(readCtx, bookID)
+		cancelRead()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeError(w, r, http.StatusNotFound, "Book not found")
+			return
+		case err != nil:
+			writeError(w, r, http.StatusInternalServerError, "Failed to load availability")
+			return
+		}
+
+		w.Header().Set("ETag", availability.etag())
+		w.Header().Set("Cache-Control", "no-store")
+		if known != availability.etag() {
+			writeJSON(w, r, http.StatusOK, availability)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if r.Context().Err() == nil {
+				w.WriteHeader(http.StatusNotModified)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// runPeriodically runs task once right away and then every interval until ctx is done,
+// logging failures and how long each successful run took
+func runPeriodically(ctx context.Context, name string, interval time.Duration, task func(ctx context.Context) error) {
+	runOnSchedule(ctx, name, interval, nil, task)
+}
+
+// runOnSchedule is runPeriodically with an extra trigger: a send on wake runs the task right
+// away instead of waiting for the next tick. A nil wake channel never fires.
+func runOnSchedule(ctx context.Context, name string, interval time.Duration, wake <-chan struct{}, task func(ctx context.Context) error) {
+	// Scheduled work is batch work: its queries use the batch pool so it can't starve requests
+	ctx = withBatchPriority(ctx)
+	run := func() {
+		// A panicking job is reported and tried again next time rather than taking the process down
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				stack := debug.Stack()
+				log.Printf("Panic running %s: %v\n%s", name, recovered, stack)
+				reportJobError(ctx, name, fmt.Errorf("panic: %v", recovered), stack)
+			}
+		}()
+		startTime := time.Now()
+		if err := task(ctx); err != nil {
+			log.Printf("Error running %s: %v", name, err)
+			reportJobError(ctx, name, err, nil)
+			return
+		}
+		log.Printf("Finished %s in %v", name, time.Since(startTime))
+	}
+
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			case <-wake:
+				run()
+			}
+		}
+	}()
+}
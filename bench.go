@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchResult summarizes one mode's run
+type benchResult struct {
+	Mode      string
+	Requests  int
+	Errors    int
+	Elapsed   time.Duration
+	Latencies []time.Duration // Sorted, successful requests only
+}
+
+// runBench implements the "bench" subcommand: it drives the details endpoint of a running
+// instance in each coordination mode and prints a comparison table
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	target := flags.String("url", "http://localhost:8080", "Base URL of the instance to load")
+	bookIDs := flags.String("books", "1,2,3,4", "Comma-separated book IDs to cycle through")
+	modes := flags.String("modes", "sequential,concurrent", "Comma-separated modes to compare")
+	concurrency := flags.Int("concurrency", 10, "Requests in flight at once")
+	requests := flags.Int("requests", 200, "Requests per mode")
+	timeout := flags.Duration("timeout", 10*time.Second, "Per-request timeout")
+	api := flags.String("api", "v1", "Details API to call: v1 or v2")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *concurrency < 1 || *requests < 1 || (*api != "v1" && *api != "v2") {
+		fmt.Fprintln(os.Stderr, "bench: -concurrency and -requests must be positive and -api v1 or v2")
+		return 2
+	}
+
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+	}
+	prefix := "/api/books/"
+	if *api == "v2" {
+		prefix = "/api/v2/books/"
+	}
+	books := strings.Split(*bookIDs, ",")
+
+	fmt.Printf("Benchmarking %s%s{id}/details: %d requests per mode, concurrency %d\n\n",
+		strings.TrimSuffix(*target, "/"), prefix, *requests, *concurrency)
+
+	var results []benchResult
+	for _, mode := range strings.Split(*modes, ",") {
+		mode = strings.TrimSpace(mode)
+		urlFor := func(i int) string {
+			return fmt.Sprintf("%s%s%s/details?mode=%s", strings.TrimSuffix(*target, "/"), prefix, books[i%len(books)], mode)
+		}
+		results = append(results, benchMode(client, mode, urlFor, *requests, *concurrency))
+	}
+
+	printBenchResults(os.Stdout, results)
+	return 0
+}
+
+// benchMode issues the requests for one mode through a fixed pool of workers
+func benchMode(client *http.Client, mode string, urlFor func(int) string, requests, concurrency int) benchResult {
+	result := benchResult{Mode: mode, Requests: requests}
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	startTime := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				requestStart := time.Now()
+				err := benchRequest(client, urlFor(i))
+				latency := time.Since(requestStart)
+
+				mu.Lock()
+				if err != nil {
+					result.Errors++
+				} else {
+					result.Latencies = append(result.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	result.Elapsed = time.Since(startTime)
+	sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+	return result
+}
+
+// benchRequest performs one GET; anything but a 2xx counts as an error. Partial v2 answers
+// (207) are successes, since the endpoint still served them.
+func benchRequest(client *http.Client, url string) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body) // Read the whole body so the timing includes it and the connection is reused
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// percentile returns the p-th percentile (0-100) of sorted latencies by nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// printBenchResults writes the comparison table
+func printBenchResults(out io.Writer, results []benchResult) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "mode\trequests\terrors\terror %\treq/s\tp50\tp90\tp99\tmax\t")
+	for _, result := range results {
+		maxLatency := time.Duration(0)
+		if len(result.Latencies) > 0 {
+			maxLatency = result.Latencies[len(result.Latencies)-1]
+		}
+		fmt.Fprintf(table, "%s\t%d\t%d\t%.1f\t%.1f\t%v\t%v\t%v\t%v\t\n",
+			result.Mode,
+			result.Requests,
+			result.Errors,
+			100*float64(result.Errors)/float64(result.Requests),
+			float64(result.Requests)/result.Elapsed.Seconds(),
+			percentile(result.Latencies, 50).Round(time.Microsecond*10),
+			percentile(result.Latencies, 90).Round(time.Microsecond*10),
+			percentile(result.Latencies, 99).Round(time.Microsecond*10),
+			maxLatency.Round(time.Microsecond*10),
+		)
+	}
+	table.Flush()
+}
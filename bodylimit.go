@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// bodyLimitFor returns the body size limit for a path: the longest configured prefix that
+// matches, or the global limit
+func bodyLimitFor(path string) int64 {
+	limit, matched := config.MaxBodyBytes, ""
+	for prefix, prefixLimit := range config.RouteBodyLimits {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(matched) {
+			limit, matched = prefixLimit, prefix
+		}
+	}
+	return limit
+}
+
+// bodyLimitMiddleware caps request bodies. A declared Content-Length over the limit is refused
+// before the handler runs; otherwise the body is wrapped in http.MaxBytesReader, which stops
+// reading at the limit and makes decodeJSONBody answer 413.
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := bodyLimitFor(r.URL.Path)
+		if r.ContentLength > limit {
+			writeBodyTooLarge(w, r, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyTooLarge sends the 413 answer
+func writeBodyTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	detail := fmt.Sprintf("Request bodies for %s are limited to %d bytes", r.URL.Path, limit)
+	// A body the server stopped reading can't be drained, so don't offer the connection for reuse
+	w.Header().Set("Connection", "close")
+	writeProblem(w, r, problemDetails{
+		Title:      "Request body too large",
+		Status:     http.StatusRequestEntityTooLarge,
+		Detail:     detail,
+		LimitBytes: limit,
+	})
+}
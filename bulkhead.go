@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bulkhead bounds how many operations of one kind run at once, so a slow dependency can only
+// tie up its own slots and never the capacity reserved for other work
+type Bulkhead struct {
+	name  string
+	slots chan struct{}
+
+	inFlight    atomic.Int64
+	acquired    atomic.Int64
+	rejected    atomic.Int64 // Callers whose context ended while waiting for a slot
+	waitNsTotal atomic.Int64
+}
+
+// Separate bulkheads for database queries and external API calls, sized from config in main
+var (
+	dbBulkhead       = NewBulkhead("database", config.DatabaseBulkheadSize)
+	externalBulkhead = NewBulkhead("external_api", config.ExternalBulkheadSize)
+)
+
+// bulkheads indexes every bulkhead by name for metrics
+var bulkheads = struct {
+	sync.Mutex
+	byName map[string]*Bulkhead
+}{byName: make(map[string]*Bulkhead)}
+
+func init() {
+	expvar.Publish("bulkheads", expvar.Func(func() interface{} {
+		return BulkheadStats()
+	}))
+}
+
+// NewBulkhead creates a bulkhead with the given number of slots and registers it for metrics,
+// replacing any earlier bulkhead of the same name
+func NewBulkhead(name string, capacity int) *Bulkhead {
+	if capacity < 1 {
+		capacity = 1
+	}
+	bulkhead := &Bulkhead{name: name, slots: make(chan struct{}, capacity)}
+
+	bulkheads.Lock()
+	bulkheads.byName[name] = bulkhead
+	bulkheads.Unlock()
+
+	return bulkhead
+}
+
+// Acquire waits for a free slot, giving up when ctx is done. Every successful Acquire must be
+// paired with a Release.
+func (b *Bulkhead) Acquire(ctx context.Context) error {
+	startTime := time.Now()
+	select {
+	case b.slots <- struct{}{}:
+		b.inFlight.Add(1)
+		b.acquired.Add(1)
+		b.waitNsTotal.Add(int64(time.Since(startTime)))
+		return nil
+	case <-ctx.Done():
+		b.rejected.Add(1)
+		return ctx.Err()
+	}
+}
+
+// TryAcquire takes a slot only if one is free right now, reporting whether it did.
+// A successful TryAcquire must be paired with a Release.
+func (b *Bulkhead) TryAcquire() bool {
+	select {
+	case b.slots <- struct{}{}:
+		b.inFlight.Add(1)
+		b.acquired.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire or TryAcquire
+func (b *Bulkhead) Release() {
+	b.inFlight.Add(-1)
+	<-b.slots
+}
+
+// BulkheadStat is a point-in-time view of one bulkhead's saturation
+type BulkheadStat struct {
+	Name        string  `json:"name"`
+	Capacity    int     `json:"capacity"`
+	InFlight    int64   `json:"in_flight"`
+	Saturation  float64 `json:"saturation"` // in_flight / capacity
+	Acquired    int64   `json:"acquired_total"`
+	Rejected    int64   `json:"rejected_total"`
+	WaitMsTotal int64   `json:"wait_ms_total"`
+}
+
+// BulkheadStats returns saturation stats for every bulkhead sorted by name
+func BulkheadStats() []BulkheadStat {
+	bulkheads.Lock()
+	defer bulkheads.Unlock()
+
+	stats := make([]BulkheadStat, 0, len(bulkheads.byName))
+	for _, b := range bulkheads.byName {
+		inFlight := b.inFlight.Load()
+		stats = append(stats, BulkheadStat{
+			Name:        b.name,
+			Capacity:    cap(b.slots),
+			InFlight:    inFlight,
+			Saturation:  float64(inFlight) / float64(cap(b.slots)),
+			Acquired:    b.acquired.Load(),
+			Rejected:    b.rejected.Load(),
+			WaitMsTotal: time.Duration(b.waitNsTotal.Load()).Milliseconds(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
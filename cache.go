@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Once the cache holds this many entries, inserts sweep out anything too old to be served
+const maxRecommendationCacheEntries = 10000
+
+// recommendationCacheEntry is a cached recommendations payload and when it was fetched
+type recommendationCacheEntry struct {
+	value    Recommendations
+	userID   string // Owner of a personalized entry, so it can be dropped when their history changes
+	storedAt time.Time
+}
+
+// recommendationCache keeps the last successful upstream response per (book, user)
+type recommendationCache struct {
+	mu      sync.Mutex
+	entries map[string]recommendationCacheEntry
+	ttls    func() (fresh, stale time.Duration) // Read from config on use, so reloads apply
+
+	seq         uint64            // Moves on with every invalidation
+	invalidated map[string]uint64 // seq of each user's last invalidation, so a compute that raced one isn't stored
+	floor       uint64            // invalidated forgets everything up to this; computes started before it aren't stored
+}
+
+// Anonymous recommendations depend only on the book and are shared by every visitor.
+// Personalized ones are built from one user's reading lists and ratings, so they live in a
+// cache of their own, are kept for less time, and are dropped as soon as that history changes.
+// The two never share entries, even for a request that sends an empty user_id.
+var (
+	recommendationsCache = &recommendationCache{
+		entries:     make(map[string]recommendationCacheEntry),
+		invalidated: make(map[string]uint64),
+		ttls: func() (time.Duration, time.Duration) {
+			return config.RecommendationCacheTTL, config.RecommendationStaleTTL
+		},
+	}
+	personalRecommendationsCache = &recommendationCache{
+		entries:     make(map[string]recommendationCacheEntry),
+		invalidated: make(map[string]uint64),
+		ttls: func() (time.Duration, time.Duration) {
+			return config.PersonalizedCacheTTL, config.PersonalizedStaleTTL
+		},
+	}
+)
+
+// recommendationCacheFor returns the cache that holds a user's recommendations
+func recommendationCacheFor(userID string) *recommendationCache {
+	if userID == "" {
+		return recommendationsCache
+	}
+	return personalRecommendationsCache
+}
+
+// recommendationCacheKey builds the cache key for a book and user pair
+func recommendationCacheKey(bookID, userID string) string {
+	return cacheKey("recommendations", bookID, userID)
+}
+
+// Get returns the cached entry and its age, if present (fresh or stale). Entries past the stale
+// window are deleted rather than kept around unused.
+func (c *recommendationCache) Get(key string) (recommendationCacheEntry, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return entry, 0, false
+	}
+	age := clock.Now().Sub(entry.storedAt)
+	if _, stale := c.ttls(); age > stale {
+		delete(c.entries, key)
+		return recommendationCacheEntry{}, 0, false
+	}
+	return entry, age, true
+}
+
+// Sequence marks the start of a compute; pass it to Set so the result is dropped if the user
+// is invalidated before it is stored
+func (c *recommendationCache) Sequence() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq
+}
+
+// Set stores a payload computed since started (see Sequence), evicting entries past the stale
+// window when the cache is full. A payload for a user invalidated since then is not stored, as
+// it may have been built from the history that changed.
+func (c *recommendationCache) Set(key, userID string, value Recommendations, storedAt time.Time, started uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if started < c.floor || c.invalidated[userID] > started {
+		return
+	}
+	if len(c.entries) >= maxRecommendationCacheEntries {
+		_, stale := c.ttls()
+		for k, entry := range c.entries {
+			if clock.Now().Sub(entry.storedAt) > stale {
+				delete(c.entries, k)
+			}
+		}
+		// Everything is still servable; drop an arbitrary entry to stay bounded
+		for k := range c.entries {
+			if len(c.entries) < maxRecommendationCacheEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = recommendationCacheEntry{value: value, userID: userID, storedAt: storedAt}
+}
+
+// InvalidateUser drops every entry built for a user, returning how many there were. Computes
+// for the user already under way won't be stored either.
+func (c *recommendationCache) InvalidateUser(userID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	if len(c.invalidated) >= maxRecommendationCacheEntries {
+		c.invalidated = make(map[string]uint64)
+		c.floor = c.seq
+	}
+	c.invalidated[userID] = c.seq
+	dropped := 0
+	for k, entry := range c.entries {
+		if entry.userID == userID {
+			delete(c.entries, k)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// invalidatePersonalRecommendations forgets a user's cached recommendations after they rate,
+// buy or change a reading list, so the next view reflects it
+func invalidatePersonalRecommendations(userID string) {
+	if userID == "" {
+		return
+	}
+	personalRecommendationsCache.InvalidateUser(userID)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// gatedRecommendationProvider is the fake provider, holding the first fetch until release is
+// closed
+type gatedRecommendationProvider struct {
+	fakeRecommendationProvider
+	entered, release chan struct{}
+}
+
+func (p gatedRecommendationProvider) Fetch(ctx context.Context, bookID, userID string) (Recommendations, error) {
+	select {
+	case <-p.entered:
+	default:
+		close(p.entered)
+		<-p.release
+	}
+	return p.fakeRecommendationProvider.Fetch(ctx, bookID, userID)
+}
+
+func TestPersonalRecommendationsRacingInvalidationAreNotCached(t *testing.T) {
+	newTestServer(t)
+	gated := gatedRecommendationProvider{entered: make(chan struct{}), release: make(chan struct{})}
+	previous := recommendationProviders
+	recommendationProviders = []RecommendationProvider{gated}
+	t.Cleanup(func() { recommendationProviders = previous })
+	ctx := context.Background()
+
+	// The compute is under way when the user's history changes
+	done := make(chan sectionResult[Recommendations])
+	go func() { done <- FetchPersonalizedRecommendations(ctx, "1", "racer") }()
+	<-gated.entered
+	invalidatePersonalRecommendations("racer")
+	close(gated.release)
+	if result := <-done; result.Err != nil {
+		t.Fatalf("racing compute: %v", result.Err)
+	}
+
+	key := recommendationCacheKey("1", "racer")
+	if _, _, ok := personalRecommendationsCache.Get(key); ok {
+		t.Fatalf("a compute that started before the invalidation was cached")
+	}
+
+	// The next compute started after it, so its result is kept
+	if result := FetchPersonalizedRecommendations(ctx, "1", "racer"); result.Err != nil || result.Source == "cache" {
+		t.Fatalf("compute after the invalidation came from %q (%v), want a fresh fetch", result.Source, result.Err)
+	}
+	if result := FetchPersonalizedRecommendations(ctx, "1", "racer"); result.Source != "cache" {
+		t.Fatalf("repeat fetch came from %q, want the cache", result.Source)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response types that end up in a cache, in process or downstream through validators and
+// Cache-Control. Their shape is hashed into every cache key, so a deploy that changes one of
+// them never serves a body cached by the previous build.
+var cachedResponseShapes = []reflect.Type{
+	reflect.TypeOf(BookDetailsResponse{}),
+	reflect.TypeOf(BookDetailsV2Response{}),
+	reflect.TypeOf(Recommendations{}),
+	reflect.TypeOf(BookAvailability{}),
+	reflect.TypeOf(ReadingList{}),
+	reflect.TypeOf(StorefrontConfig{}),
+	reflect.TypeOf(atomFeed{}),
+	reflect.TypeOf(sitemapURLSet{}),
+	reflect.TypeOf(sitemapIndex{}),
+}
+
+// responseSchemaHash is the hash of this build's cachedResponseShapes
+var responseSchemaHash = sync.OnceValue(func() string {
+	var shape strings.Builder
+	seen := map[reflect.Type]bool{}
+	for _, t := range cachedResponseShapes {
+		describeShape(&shape, t, seen)
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(shape.String()))
+	return strconv.FormatUint(hash.Sum64(), 36)
+})
+
+// describeShape writes what a type looks like on the wire: exported fields with their encoded
+// names and the kinds of their values. Reordering fields also changes it, which only costs one
+// extra flush.
+func describeShape(shape *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		shape.WriteString(t.Kind().String() + "(")
+		describeShape(shape, t.Elem(), seen)
+		shape.WriteString(")")
+	case reflect.Map:
+		shape.WriteString("map(")
+		describeShape(shape, t.Key(), seen)
+		shape.WriteString(",")
+		describeShape(shape, t.Elem(), seen)
+		shape.WriteString(")")
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) || seen[t] {
+			shape.WriteString(t.String())
+			return
+		}
+		seen[t] = true
+		shape.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			shape.WriteString(field.Name + " " + field.Tag.Get("json") + " " + field.Tag.Get("xml") + ":")
+			describeShape(shape, field.Type, seen)
+			shape.WriteString(";")
+		}
+		shape.WriteString("}")
+	default:
+		shape.WriteString(t.Kind().String())
+	}
+}
+
+// cacheVersionCache holds the shared cache version in memory, refreshed like the flag cache so
+// a bump on one instance reaches the others within flagCacheTTL
+var cacheVersionCache = struct {
+	sync.RWMutex
+	version  CacheVersion
+	loadedAt time.Time
+}{}
+
+// SyncCacheVersion records this build's response schema hash at startup. When it differs from
+// the stored one the generation moves on, flushing every cache built against the old shapes.
+func SyncCacheVersion() error {
+	var stored string
+	err := db.QueryRow("SELECT schema_hash FROM cache_version WHERE id = 1").Scan(&stored)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = db.Exec("INSERT INTO cache_version (id, schema_hash, generation, changed_at) VALUES (1, ?, 1, ?)",
+			responseSchemaHash(), dbNow())
+	case err == nil && stored != responseSchemaHash():
+		log.Printf("Response schema changed (%s -> %s), bumping the cache generation", stored, responseSchemaHash())
+		_, err = db.Exec("UPDATE cache_version SET schema_hash = ?, generation = generation + 1, changed_at = ? WHERE id = 1",
+			responseSchemaHash(), dbNow())
+	}
+	if err != nil {
+		return err
+	}
+	return LoadCacheVersion()
+}
+
+// LoadCacheVersion reads the shared cache version into memory
+func LoadCacheVersion() error {
+	var version CacheVersion
+	err := db.QueryRow("SELECT generation, changed_at FROM cache_version WHERE id = 1").Scan(&version.Generation, &version.ChangedAt)
+	if err != nil {
+		return err
+	}
+	// The key carries this build's hash even while an older build is still running elsewhere
+	version.SchemaHash = responseSchemaHash()
+
+	cacheVersionCache.Lock()
+	cacheVersionCache.version = version
+	cacheVersionCache.loadedAt = clock.Now()
+	cacheVersionCache.Unlock()
+	return nil
+}
+
+// currentCacheVersion returns the cache version, refreshing it when it is stale
+func currentCacheVersion() CacheVersion {
+	cacheVersionCache.RLock()
+	stale := clock.Now().Sub(cacheVersionCache.loadedAt) > flagCacheTTL
+	cacheVersionCache.RUnlock()
+
+	if stale {
+		if err := LoadCacheVersion(); err != nil {
+			log.Printf("Error refreshing cache version: %v", err)
+		}
+	}
+
+	cacheVersionCache.RLock()
+	defer cacheVersionCache.RUnlock()
+	return cacheVersionCache.version
+}
+
+// cacheKey prefixes parts with the current schema hash and generation. Entries written under an
+// older version are never looked up again and age out of their cache.
+func cacheKey(parts ...string) string {
+	version := currentCacheVersion()
+	return fmt.Sprintf("%s.%d|%s", version.SchemaHash, version.Generation, strings.Join(parts, "|"))
+}
+
+// bumpCacheGeneration moves the generation on, logically flushing every cache
+func bumpCacheGeneration() error {
+	_, err := db.Exec("UPDATE cache_version SET generation = generation + 1, changed_at = ? WHERE id = 1", dbNow())
+	if err != nil {
+		return err
+	}
+	return LoadCacheVersion()
+}
+
+// CacheVersionHandler handles /api/admin/cache/version: GET shows the current version, POST
+// bumps the generation
+func CacheVersionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, r, http.StatusOK, currentCacheVersion())
+
+	case http.MethodPost:
+		if err := bumpCacheGeneration(); err != nil {
+			log.Printf("Error bumping cache generation: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to bump cache generation")
+			return
+		}
+		version := currentCacheVersion()
+		log.Printf("Bumped cache generation to %d", version.Generation)
+		writeJSON(w, r, http.StatusOK, version)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Catalog tables whose writes are logged, with the entity name used in the feed and the column
+// holding the book ID
+var changeFeedTables = []struct {
+	table, entity, bookColumn string
+}{
+	{"books", "book", "id"},
+	{"pricing", "pricing", "book_id"},
+	{"inventory", "inventory", "book_id"},
+	{"reviews", "reviews", "book_id"},
+	{"book_translations", "translation", "book_id"},
+}
+
+// Largest page of changes one request returns
+const maxChangesPageSize = 1000
+
+// CatalogChange is one logged write to the catalog
+type CatalogChange struct {
+	Seq       int64     `json:"seq"`
+	BookID    string    `json:"book_id"`
+	Entity    string    `json:"entity"`    // "book", "pricing", "inventory", "reviews" or "translation"
+	Operation string    `json:"operation"` // "insert", "update" or "delete"
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ChangesResponse is the body of GET /api/changes
+type ChangesResponse struct {
+	Changes   []CatalogChange `json:"changes"`
+	NextSince int64           `json:"next_since"` // Pass as ?since= to continue after this page
+	LatestSeq int64           `json:"latest_seq"` // Newest sequence number when the page was read
+	HasMore   bool            `json:"has_more"`
+}
+
+// createChangeFeed creates the catalog_changes log and a trigger per table and operation that
+// appends to it. Triggers catch every writer, including admin endpoints, the seed subcommand
+// and manual SQL, without each one having to remember to log. They run inside the writing
+// statement's transaction, so a rolled-back write leaves no entry. Trigger timestamps come
+// from SQLite's clock rather than the service clock.
+func createChangeFeed() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS catalog_changes (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			book_id TEXT NOT NULL,
+			entity TEXT NOT NULL,
+			operation TEXT NOT NULL,
+			changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range changeFeedTables {
+		for _, operation := range []string{"insert", "update", "delete"} {
+			row := "NEW"
+			if operation == "delete" {
+				row = "OLD"
+			}
+			_, err := db.Exec(fmt.Sprintf(`
+				CREATE TRIGGER IF NOT EXISTS %[1]s_%[2]s_change AFTER %[2]s ON %[1]s
+				BEGIN
+					INSERT INTO catalog_changes (book_id, entity, operation) VALUES (%[3]s.%[4]s, '%[5]s', '%[2]s');
+				END
+			`, source.table, operation, row, source.bookColumn, source.entity))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadCatalogChanges returns up to limit changes after since, in sequence order, and the
+// newest sequence number
+func loadCatalogChanges(ctx context.Context, since int64, limit int) ([]CatalogChange, int64, error) {
+	var latest int64
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(seq), 0) FROM catalog_changes").Scan(&latest); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT seq, book_id, entity, operation, changed_at
+		FROM catalog_changes
+		WHERE seq > ? AND seq <= ?
+		ORDER BY seq
+		LIMIT ?
+	`, since, latest, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	changes := []CatalogChange{}
+	for rows.Next() {
+		var change CatalogChange
+		if err := rows.Scan(&change.Seq, &change.BookID, &change.Entity, &change.Operation, &change.ChangedAt); err != nil {
+			return nil, 0, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, latest, rows.Err()
+}
+
+// ChangesHandler handles GET /api/changes?since=<seq>&limit=N. Consumers bootstrap from a
+// full read of the catalog, remember latest_seq from a first call, and then follow next_since.
+// Entries name what changed, not the new values; consumers re-read the book.
+func ChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	var since int64
+	if rawSince := query.Get("since"); rawSince != "" {
+		parsed, err := strconv.ParseInt(rawSince, 10, 64)
+		if err != nil || parsed < 0 {
+			writeError(w, r, http.StatusBadRequest, "since must be a sequence number of 0 or more")
+			return
+		}
+		since = parsed
+	}
+	limit := 100
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 || parsed > maxChangesPageSize {
+			writeError(w, r, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = parsed
+	}
+
+	changes, latest, err := loadCatalogChanges(r.Context(), since, limit)
+	if err != nil {
+		log.Printf("Error loading catalog changes since %d: %v", since, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load changes")
+		return
+	}
+
+	response := ChangesResponse{Changes: changes, NextSince: since, LatestSeq: latest}
+	if len(changes) > 0 {
+		response.NextSince = changes[len(changes)-1].Seq
+	}
+	response.HasMore = response.NextSince < latest
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock supplies the current time for anything the service stores or compares against stored
+// times: row timestamps, cache expiry, delivery dates. Elapsed-time measurements for metrics
+// and request deadlines keep using the real time package.
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator supplies opaque identifiers such as request IDs and share tokens
+type IDGenerator interface {
+	NewID() string
+}
+
+// Time and ID sources used by the service; tests and tools can swap in the fixed versions
+var (
+	clock       Clock       = systemClock{}
+	idGenerator IDGenerator = randomIDGenerator{}
+)
+
+// Layout SQLite's CURRENT_TIMESTAMP writes, so rows stamped from Go sort and compare with the rest
+const sqliteTimestampLayout = "2006-01-02 15:04:05"
+
+// dbNow is the clock's current time formatted for a TIMESTAMP column
+func dbNow() string {
+	return clock.Now().UTC().Format(sqliteTimestampLayout)
+}
+
+// systemClock reads the real time
+type systemClock struct{}
+
+// Now implements Clock
+func (systemClock) Now() time.Time { return time.Now() }
+
+// fixedClock reports a set time that only moves when told to
+type fixedClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// newFixedClock returns a clock stopped at the given time
+func newFixedClock(now time.Time) *fixedClock {
+	return &fixedClock{now: now}
+}
+
+// Now implements Clock
+func (c *fixedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward, e.g. past a cache TTL
+func (c *fixedClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// randomIDGenerator returns 128-bit random hex IDs, unguessable enough for share links
+type randomIDGenerator struct{}
+
+// NewID implements IDGenerator
+func (randomIDGenerator) NewID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// sequentialIDGenerator returns predictable IDs: prefix-1, prefix-2, ...
+type sequentialIDGenerator struct {
+	mu     sync.Mutex
+	prefix string
+	next   int
+}
+
+// newSequentialIDGenerator returns a generator whose first ID is prefix-1
+func newSequentialIDGenerator(prefix string) *sequentialIDGenerator {
+	return &sequentialIDGenerator{prefix: prefix, next: 1}
+}
+
+// NewID implements IDGenerator
+func (g *sequentialIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id := fmt.Sprintf("%s-%d", g.prefix, g.next)
+	g.next++
+	return id
+}
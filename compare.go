@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Limits on how many books one comparison can cover
+const (
+	compareMinBooks = 2
+	compareMaxBooks = 10
+)
+
+// Attributes in a comparison, in display order
+var compareAttributes = []string{
+	"title", "author", "isbn", "publish_date",
+	"price", "sale_price", "currency",
+	"average_rating", "total_reviews",
+	"in_stock", "quantity", "shipping_time",
+}
+
+// BookComparisonResponse is the body of GET /api/books/compare. Rows holds one entry per
+// attribute with a value per book, in the same order as BookIDs; a value is null when that
+// book's section couldn't be loaded (see Statuses).
+type BookComparisonResponse struct {
+	BookIDs    []string                 `json:"book_ids"`
+	Attributes []string                 `json:"attributes"`
+	Rows       map[string][]interface{} `json:"rows"`
+	Statuses   []string                 `json:"statuses"` // Overall details status per book: ok, partial, not_found or restricted
+	Duration   int64                    `json:"duration_ms"`
+}
+
+// CompareHandler handles GET /api/books/compare?ids=1,2,3
+func CompareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bookIDs []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			bookIDs = append(bookIDs, id)
+		}
+	}
+	if len(bookIDs) < compareMinBooks || len(bookIDs) > compareMaxBooks {
+		writeError(w, r, http.StatusBadRequest, "Query parameter 'ids' must list between 2 and 10 distinct book IDs")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.DetailRequestTimeout)
+	defer cancel()
+	ctx = withRequestCache(ctx)
+
+	startTime := time.Now()
+	details := loadComparedBooks(ctx, bookIDs)
+
+	response := BookComparisonResponse{
+		BookIDs:    bookIDs,
+		Attributes: compareAttributes,
+		Rows:       make(map[string][]interface{}, len(compareAttributes)),
+		Statuses:   make([]string, len(bookIDs)),
+	}
+	for _, attribute := range compareAttributes {
+		response.Rows[attribute] = make([]interface{}, len(bookIDs))
+	}
+	country := requestCountry(w, r)
+	for i, book := range details {
+		// A book that can't be sold in the country is compared like one that doesn't exist
+		if bookRestrictedIn(bookIDs[i], country) {
+			response.Statuses[i] = "restricted"
+			continue
+		}
+		localizePricing(bookIDs[i], country, &book.Pricing)
+		response.Statuses[i] = book.overallStatus()
+		for attribute, value := range comparisonValues(book) {
+			response.Rows[attribute][i] = value
+		}
+	}
+	response.Duration = time.Since(startTime).Milliseconds()
+
+	writeJSON(w, r, http.StatusOK, response)
+	log.Printf("Compared %d books in %v", len(bookIDs), time.Since(startTime))
+}
+
+// loadComparedBooks loads the catalog sections of every book concurrently, keeping the input order
+func loadComparedBooks(ctx context.Context, bookIDs []string) []bookDetails {
+	type indexedDetails struct {
+		index   int
+		details bookDetails
+	}
+
+	// Buffered so every goroutine can finish even though we collect in arrival order
+	results := make(chan indexedDetails, len(bookIDs))
+	for i, bookID := range bookIDs {
+		go func(index int, bookID string) {
+			results <- indexedDetails{index: index, details: loadCatalogSectionsConcurrent(ctx, bookID)}
+		}(i, bookID)
+	}
+
+	details := make([]bookDetails, len(bookIDs))
+	for range bookIDs {
+		result := <-results
+		details[result.index] = result.details
+	}
+	return details
+}
+
+// comparisonValues flattens the sections that loaded into attribute values; attributes of
+// failed sections are left out and stay null
+func comparisonValues(book bookDetails) map[string]interface{} {
+	values := map[string]interface{}{}
+
+	if book.Metadata.Err == nil {
+		metadata := book.Metadata.Data
+		values["title"] = metadata.Title
+		values["author"] = metadata.Author
+		values["isbn"] = metadata.ISBN
+		if metadata.PublishDate != nil {
+			values["publish_date"] = metadata.PublishDate.Format("2006-01-02")
+		}
+	}
+	if book.Pricing.Err == nil {
+		values["price"] = book.Pricing.Data.Price
+		values["sale_price"] = book.Pricing.Data.SalePrice
+		values["currency"] = book.Pricing.Data.Currency
+	}
+	if book.Reviews.Err == nil {
+		values["average_rating"] = book.Reviews.Data.AverageRating
+		values["total_reviews"] = book.Reviews.Data.TotalReviews
+	}
+	if book.Inventory.Err == nil {
+		values["in_stock"] = book.Inventory.Data.InStock
+		values["quantity"] = book.Inventory.Data.Quantity
+		values["shipping_time"] = book.Inventory.Data.ShippingTime
+	}
+	return values
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// checkNotModified looks up when the book's stored data last changed, sets Last-Modified, and
+// answers 304 Not Modified when the client's If-Modified-Since copy is still current. It returns
+// true when the response has been written. Recommendations are external and not part of the
+// calculation, so a 304 means the stored book data is unchanged.
+func checkNotModified(ctx context.Context, w http.ResponseWriter, r *http.Request, bookID string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	lastModified, err := FetchBookLastModified(ctx, bookID)
+	if err != nil {
+		// Unknown book or lookup failure: let the normal path produce the response
+		return false
+	}
+	// Another instance may go on serving the previous version from its repository cache for a
+	// while; a copy fetched meanwhile gets no validator, so a 304 can't pin it once that passes
+	if clock.Now().Sub(lastModified) < repositoryCacheWindow() {
+		return false
+	}
+
+	return writeNotModified(w, r, lastModified)
+}
+
+// writeNotModified sets Last-Modified and answers 304 Not Modified when the client's
+// If-Modified-Since copy is still current, returning true when the response has been written.
+// A cache version change counts as a modification, so copies from before it are refetched.
+func writeNotModified(w http.ResponseWriter, r *http.Request, lastModified time.Time) bool {
+	if changedAt := currentCacheVersion().ChangedAt; changedAt.After(lastModified) {
+		lastModified = changedAt
+	}
+	// HTTP dates have second precision
+	lastModified = lastModified.Truncate(time.Second)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil || lastModified.After(since) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,937 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds runtime settings read from the environment at startup
+type Config struct {
+	ListenAddr   string // Address the HTTP server binds to
+	DatabasePath string // SQLite database file
+
+	// Database connections in total, and how many of them background jobs and bulk imports may
+	// hold; the rest are reserved for request traffic
+	DatabaseMaxConns   int
+	DatabaseBatchConns int
+
+	// Optional controller that moves the request pool's limit between the min and max as
+	// connection waits rise and fall, checked every interval
+	DatabaseAutosize           bool
+	DatabaseAutosizeMin        int
+	DatabaseAutosizeMax        int
+	DatabaseAutosizeInterval   time.Duration
+	DatabaseAutosizeTargetWait time.Duration // Average wait per waiting query that triggers growth
+
+	// Public origin of the storefront API, used for absolute links in feeds and the sitemap
+	PublicBaseURL string
+
+	// Book URLs per sitemap page (the protocol allows at most 50,000)
+	SitemapPageSize int
+
+	// Share (0-100) of detail requests without an explicit ?mode= that are routed
+	// through concurrent mode; the rest use sequential mode
+	ConcurrentCanaryPercent int
+
+	// Total time budget for a detail request, and how much of it is held back from the
+	// external call so the response can still be assembled before the deadline
+	DetailRequestTimeout time.Duration
+	UpstreamSafetyMargin time.Duration
+
+	// Per query class (metadata, pricing, inventory, reviews) delay after which a slow read is
+	// hedged with a second identical query; classes not listed are never hedged
+	DatabaseHedgeDelays map[string]time.Duration
+
+	// Per section (metadata, pricing, inventory, reviews, recommendations) deadline in
+	// concurrent mode; a section that misses it is reported as timed out while the others are
+	// still delivered. Sections not listed only have the request deadline.
+	SectionTimeouts map[string]time.Duration
+
+	// Per entity (metadata, pricing, inventory, reviews) time a row read from the database is
+	// reused by later requests. Writes made by this instance drop it at once, and writes by
+	// others once they show in the change log. Inventory must stay under a second. Entities not
+	// listed, or set to 0, are read every time.
+	RepositoryCacheTTLs map[string]time.Duration
+
+	// Concurrency limits for database queries and external API calls (bulkheads)
+	DatabaseBulkheadSize int
+	ExternalBulkheadSize int
+
+	// Wrap every JSON response in the {data, error, meta} envelope; clients can also opt in
+	// per request with Accept: application/vnd.bookstore.envelope+json
+	ResponseEnvelope bool
+
+	// Append sanitized GET and HEAD requests to this file for the replay subcommand; empty disables recording
+	RecordFile string
+
+	// Largest request body accepted, in bytes, with overrides per path prefix (the longest
+	// matching prefix wins) so small write endpoints such as ratings are held tighter
+	MaxBodyBytes    int64
+	RouteBodyLimits map[string]int64
+
+	// Reject unknown fields and trailing data in JSON request bodies, and how deeply bodies
+	// may nest objects and arrays
+	StrictJSON   bool
+	JSONMaxDepth int
+
+	// Search backend ("sqlite" searches the catalog in process; "elasticsearch" queries an
+	// external index that is rebuilt from the catalog every SearchReindexInterval)
+	SearchBackend         string
+	ElasticsearchURL      string
+	ElasticsearchIndex    string
+	SearchReindexInterval time.Duration
+
+	// Embedding provider for "more like this" ("hashing" runs locally; "openai" calls any
+	// OpenAI-compatible /embeddings endpoint) and how often changed descriptions are re-embedded
+	EmbeddingProvider        string
+	EmbeddingURL             string
+	EmbeddingModel           string
+	EmbeddingAPIKey          string
+	EmbeddingRefreshInterval time.Duration
+
+	// How often review aggregates are recomputed from the star counts and individual ratings
+	RatingRecomputeInterval time.Duration
+
+	// Shipping estimate provider (carrier integration); "static" uses a built-in rate table
+	ShippingProvider string
+
+	// Country resolution for regional pricing: a header set by the CDN or load balancer, with
+	// an optional "prefix,country" file to look client addresses up in when it is missing
+	CountryHeader string
+	GeoIPFile     string
+
+	// Recommendation responses are reused for RecommendationCacheTTL, and past that
+	// are still served for up to RecommendationStaleTTL when the upstream call fails
+	RecommendationCacheTTL time.Duration
+	RecommendationStaleTTL time.Duration
+
+	// The same for recommendations personalized to a user_id. These hold one user's reading
+	// history, so they are kept for less time and dropped when the user rates, buys or edits a list.
+	PersonalizedCacheTTL time.Duration
+	PersonalizedStaleTTL time.Duration
+
+	// Recommendation providers queried concurrently per fetch; the first success wins
+	RecommendationProviders []string
+
+	// Most books kept per user in the recently viewed list
+	RecentlyViewedLimit int
+
+	// Session cookies, issued to anonymous visitors and on login. The secret signs them; when
+	// unset a random one is generated, so sessions don't survive a restart or span instances.
+	SessionSecret string
+	SessionMaxAge time.Duration
+	SecureCookies bool // Mark cookies Secure; turn on wherever TLS terminates in front of the service
+
+	// Password policy for new accounts: at least PasswordMinLength characters drawn from at least
+	// PasswordMinClasses of lowercase, uppercase, digits and symbols
+	PasswordMinLength  int
+	PasswordMinClasses int
+
+	// Argon2id cost for password hashes. Changing them re-hashes each account at its next login.
+	Argon2MemoryKiB   int
+	Argon2Iterations  int
+	Argon2Parallelism int
+
+	// Brute-force protection for logins, counted per account and per address. Failures older
+	// than LoginFailureWindow are forgotten. From LoginCaptchaThreshold failures on a CAPTCHA is
+	// required (unless CaptchaProvider is "none"); from LoginLockoutThreshold logins are locked
+	// for LoginLockoutBase, doubling with each further failure up to LoginLockoutMax.
+	LoginFailureWindow    time.Duration
+	LoginCaptchaThreshold int
+	LoginLockoutThreshold int
+	LoginLockoutBase      time.Duration
+	LoginLockoutMax       time.Duration
+	CaptchaProvider       string // "none" or "static"
+	CaptchaTestToken      string // The one response the static provider accepts
+
+	// Catalog export archives are written to ExportArchiveDir and kept for ExportArchiveTTL, long
+	// enough to resume an interrupted download
+	ExportArchiveDir string
+	ExportArchiveTTL time.Duration
+
+	// Panics and failed background jobs are reported to ErrorReporter ("none", "stderr", "file"
+	// or "http"), which writes to ErrorReportFile or posts to the Sentry-style ErrorReportDSN.
+	// Non-fatal events are sampled at ErrorReportSamplePercent; headers, query parameters and
+	// extra fields whose names contain one of ErrorReportScrubFields are filtered out.
+	ErrorReporter            string
+	ErrorReportFile          string
+	ErrorReportDSN           string
+	ErrorReportSamplePercent int
+	ErrorReportScrubFields   []string
+
+	// With LogFile set the log goes there too (or only there, without LogToStderr). The file is
+	// rotated past LogFileMaxSizeMB or LogFileMaxAge, rotated files are gzipped with
+	// LogFileCompress, and the newest LogFileMaxBackups are kept (0 keeps all).
+	LogFile           string
+	LogToStderr       bool
+	LogFileMaxSizeMB  int
+	LogFileMaxAge     time.Duration
+	LogFileMaxBackups int
+	LogFileCompress   bool
+
+	// Schema migration backfills copy MigrationBackfillBatch rows at a time, pausing
+	// MigrationBackfillPause in between. A replica not heard from for MigrationReplicaStaleAfter
+	// no longer holds back a contraction, and a backfill that long without progress may be
+	// taken over.
+	MigrationBackfillBatch     int
+	MigrationBackfillPause     time.Duration
+	MigrationReplicaStaleAfter time.Duration
+
+	// StartupCheck decides what a failed database integrity check at startup does: "fail" stops
+	// the process, "degrade" starts it unready (503 except /readyz and the admin API) and "off"
+	// skips the check. StartupCheckFull runs integrity_check instead of the quicker quick_check.
+	StartupCheck     string
+	StartupCheckFull bool
+
+	// Every WatchdogInterval (0 turns it off) the goroutine and in-flight request counts are
+	// sampled; a warning is logged when goroutines grew at each of the last WatchdogSamples by
+	// WatchdogMinGrowth or more in total without requests in flight growing as much
+	WatchdogInterval  time.Duration
+	WatchdogSamples   int
+	WatchdogMinGrowth int
+
+	// LogSystem also sends the log to "syslog" or "journald" (syslog where journald isn't
+	// running), with a severity read from each line. SyslogAddress is empty for the local daemon
+	// or udp://host:514 / tcp://host:514; SyslogTag names the service in the system log.
+	LogSystem     string
+	SyslogAddress string
+	SyslogTag     string
+
+	// Per-request log lines of busy endpoints, by logger ("books", "details", "search"): keep
+	// one request's lines in LogSampleEvery and at most LogRateLimits lines per second. Both can
+	// be changed at runtime through /api/admin/log-sampling; errors are never sampled.
+	LogSampleEvery map[string]int
+	LogRateLimits  map[string]int
+
+	// Latency and availability objectives per route (mux pattern), tracked in process with
+	// burn rate alerts; see slo.go
+	SLOs map[string]SLOTarget
+
+	// Bearer token required on /api/admin/; without one the admin API refuses every request.
+	// AdminOpen serves it to anyone instead, for local development only. Signed URLs grant GET
+	// access to single admin resources for SignedURLTTL unless another duration up to
+	// SignedURLMaxTTL is asked for.
+	AdminToken string
+	AdminOpen  bool
+
+	// Accounts whose logins count as admins, like the admin token but under their own names.
+	// Sign-up refuses these IDs, so create the account before listing it.
+	AdminUsers      []string
+	SignedURLTTL    time.Duration
+	SignedURLMaxTTL time.Duration
+
+	// Impersonation tokens last ImpersonationTTL unless the admin asks for another duration,
+	// which may not exceed ImpersonationMaxTTL
+	ImpersonationTTL    time.Duration
+	ImpersonationMaxTTL time.Duration
+
+	// A book editing lock lapses EditLockTTL after it was taken or last heartbeat
+	EditLockTTL time.Duration
+
+	// Outbound HTTP client tuning for external API calls
+	UpstreamTimeout             time.Duration // Overall cap per external request
+	UpstreamDialTimeout         time.Duration // TCP connect timeout
+	UpstreamTLSHandshakeTimeout time.Duration
+	UpstreamKeepAlive           time.Duration // TCP keep-alive probe interval
+	UpstreamMaxIdleConnsPerHost int
+	UpstreamDisableKeepAlives   bool // Open a fresh connection for every request
+
+	// Egress controls: an explicit proxy for external calls (otherwise HTTP(S)_PROXY is
+	// honored) and the upstream hosts calls may go to (empty allows any host)
+	UpstreamProxyURL string
+	EgressAllowlist  []string
+}
+
+// Active configuration, populated by LoadConfig in main before anything else starts
+var config = DefaultConfig()
+
+// DefaultConfig returns the settings used when no environment overrides are present
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:         ":8080",
+		DatabasePath:       "bookstore.db",
+		DatabaseMaxConns:   25,
+		DatabaseBatchConns: 5,
+
+		DatabaseAutosizeMin:        4,
+		DatabaseAutosizeMax:        100,
+		DatabaseAutosizeInterval:   10 * time.Second,
+		DatabaseAutosizeTargetWait: 5 * time.Millisecond,
+
+		PublicBaseURL:           "http://localhost:8080",
+		SitemapPageSize:         sitemapMaxPageSize,
+		ConcurrentCanaryPercent: 0,
+		DetailRequestTimeout:    5 * time.Second,
+		UpstreamSafetyMargin:    100 * time.Millisecond,
+		DatabaseHedgeDelays:     map[string]time.Duration{},
+		SectionTimeouts:         map[string]time.Duration{},
+		RepositoryCacheTTLs: map[string]time.Duration{
+			"metadata":  5 * time.Minute,
+			"pricing":   1 * time.Minute,
+			"inventory": 500 * time.Millisecond,
+			"reviews":   5 * time.Minute,
+		},
+		MaxBodyBytes: 1 << 20,
+		StrictJSON:   true,
+		JSONMaxDepth: 32,
+		RouteBodyLimits: map[string]int64{
+			"/api/books/": 4 << 10,  // Ratings
+			"/api/users/": 16 << 10, // Reading lists
+		},
+		DatabaseBulkheadSize:       20,
+		ExternalBulkheadSize:       50,
+		ResponseEnvelope:           false,
+		SearchBackend:              "sqlite",
+		ElasticsearchIndex:         "books",
+		SearchReindexInterval:      5 * time.Minute,
+		EmbeddingProvider:          "hashing",
+		EmbeddingModel:             "text-embedding-3-small",
+		EmbeddingRefreshInterval:   10 * time.Minute,
+		RatingRecomputeInterval:    1 * time.Hour,
+		ShippingProvider:           "static",
+		CountryHeader:              "X-Country-Code",
+		RecommendationCacheTTL:     1 * time.Minute,
+		RecommendationStaleTTL:     1 * time.Hour,
+		PersonalizedCacheTTL:       30 * time.Second,
+		PersonalizedStaleTTL:       5 * time.Minute,
+		RecommendationProviders:    []string{"zenquotes"},
+		RecentlyViewedLimit:        20,
+		SessionMaxAge:              30 * 24 * time.Hour,
+		PasswordMinLength:          10,
+		PasswordMinClasses:         2,
+		Argon2MemoryKiB:            64 * 1024,
+		Argon2Iterations:           3,
+		Argon2Parallelism:          2,
+		LoginFailureWindow:         time.Hour,
+		LoginCaptchaThreshold:      3,
+		LoginLockoutThreshold:      5,
+		LoginLockoutBase:           30 * time.Second,
+		LoginLockoutMax:            time.Hour,
+		CaptchaProvider:            "none",
+		ExportArchiveDir:           filepath.Join(os.TempDir(), "bookstore-exports"),
+		ExportArchiveTTL:           24 * time.Hour,
+		LogToStderr:                true,
+		LogFileMaxSizeMB:           100,
+		LogFileMaxAge:              24 * time.Hour,
+		LogFileMaxBackups:          14,
+		LogFileCompress:            true,
+		SyslogTag:                  "bookstore",
+		MigrationBackfillBatch:     500,
+		MigrationBackfillPause:     100 * time.Millisecond,
+		MigrationReplicaStaleAfter: 5 * time.Minute,
+		StartupCheck:               "degrade",
+		WatchdogInterval:           30 * time.Second,
+		WatchdogSamples:            10,
+		WatchdogMinGrowth:          50,
+		LogSampleEvery:             map[string]int{},
+		LogRateLimits:              map[string]int{},
+		ErrorReporter:              "none",
+		ErrorReportSamplePercent:   100,
+		ErrorReportScrubFields:     []string{"authorization", "cookie", "password", "token", "secret", "key", "sig", "captcha", "session"},
+		SLOs: map[string]SLOTarget{
+			"/api/books/":       {Latency: 300 * time.Millisecond, Objective: 0.995},
+			"/api/books/search": {Latency: 500 * time.Millisecond, Objective: 0.99},
+			"/api/v2/books/":    {Latency: 300 * time.Millisecond, Objective: 0.995},
+			"/api/session/":     {Latency: time.Second, Objective: 0.999},
+		},
+		SignedURLTTL:        24 * time.Hour,
+		SignedURLMaxTTL:     7 * 24 * time.Hour,
+		ImpersonationTTL:    15 * time.Minute,
+		ImpersonationMaxTTL: time.Hour,
+		EditLockTTL:         2 * time.Minute,
+
+		UpstreamTimeout:             5 * time.Second,
+		UpstreamDialTimeout:         2 * time.Second,
+		UpstreamTLSHandshakeTimeout: 2 * time.Second,
+		UpstreamKeepAlive:           30 * time.Second,
+		UpstreamMaxIdleConnsPerHost: 10,
+		UpstreamDisableKeepAlives:   false,
+	}
+}
+
+// LoadConfig builds the configuration from defaults overridden by BOOKSTORE_* environment variables
+func LoadConfig() (Config, error) {
+	cfg := DefaultConfig()
+	var err error
+
+	cfg.ListenAddr = envString("BOOKSTORE_ADDR", cfg.ListenAddr)
+	cfg.DatabasePath = envString("BOOKSTORE_DB_PATH", cfg.DatabasePath)
+	if cfg.DatabaseMaxConns, err = envInt("BOOKSTORE_DB_MAX_CONNS", cfg.DatabaseMaxConns); err != nil {
+		return cfg, err
+	}
+	if cfg.DatabaseBatchConns, err = envInt("BOOKSTORE_DB_BATCH_CONNS", cfg.DatabaseBatchConns); err != nil {
+		return cfg, err
+	}
+	if cfg.DatabaseBatchConns < 1 || cfg.DatabaseBatchConns >= cfg.DatabaseMaxConns {
+		return cfg, fmt.Errorf("BOOKSTORE_DB_BATCH_CONNS (%d) must be at least 1 and less than BOOKSTORE_DB_MAX_CONNS (%d)", cfg.DatabaseBatchConns, cfg.DatabaseMaxConns)
+	}
+	if cfg.DatabaseAutosize, err = envBool("BOOKSTORE_DB_AUTOSIZE", cfg.DatabaseAutosize); err != nil {
+		return cfg, err
+	}
+	if cfg.DatabaseAutosizeMin, err = envInt("BOOKSTORE_DB_AUTOSIZE_MIN", cfg.DatabaseAutosizeMin); err != nil {
+		return cfg, err
+	}
+	if cfg.DatabaseAutosizeMax, err = envInt("BOOKSTORE_DB_AUTOSIZE_MAX", cfg.DatabaseAutosizeMax); err != nil {
+		return cfg, err
+	}
+	if cfg.DatabaseAutosizeMin < 1 || cfg.DatabaseAutosizeMax < cfg.DatabaseAutosizeMin {
+		return cfg, fmt.Errorf("BOOKSTORE_DB_AUTOSIZE_MIN (%d) must be at least 1 and at most BOOKSTORE_DB_AUTOSIZE_MAX (%d)", cfg.DatabaseAutosizeMin, cfg.DatabaseAutosizeMax)
+	}
+	if cfg.DatabaseAutosizeInterval, err = envDuration("BOOKSTORE_DB_AUTOSIZE_INTERVAL", cfg.DatabaseAutosizeInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.DatabaseAutosizeInterval <= 0 {
+		return cfg, fmt.Errorf("BOOKSTORE_DB_AUTOSIZE_INTERVAL must be positive")
+	}
+	if cfg.DatabaseAutosizeTargetWait, err = envDuration("BOOKSTORE_DB_AUTOSIZE_TARGET_WAIT", cfg.DatabaseAutosizeTargetWait); err != nil {
+		return cfg, err
+	}
+	cfg.PublicBaseURL = strings.TrimSuffix(envString("BOOKSTORE_PUBLIC_BASE_URL", cfg.PublicBaseURL), "/")
+	if baseURL, err := url.Parse(cfg.PublicBaseURL); err != nil || baseURL.Host == "" || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		return cfg, fmt.Errorf("BOOKSTORE_PUBLIC_BASE_URL must be an absolute http(s) URL, got %q", cfg.PublicBaseURL)
+	}
+	if cfg.SitemapPageSize, err = envInt("BOOKSTORE_SITEMAP_PAGE_SIZE", cfg.SitemapPageSize); err != nil {
+		return cfg, err
+	}
+	if cfg.SitemapPageSize < 1 || cfg.SitemapPageSize > sitemapMaxPageSize {
+		return cfg, fmt.Errorf("BOOKSTORE_SITEMAP_PAGE_SIZE must be between 1 and %d, got %d", sitemapMaxPageSize, cfg.SitemapPageSize)
+	}
+
+	if cfg.ConcurrentCanaryPercent, err = envInt("BOOKSTORE_CANARY_CONCURRENT_PERCENT", cfg.ConcurrentCanaryPercent); err != nil {
+		return cfg, err
+	}
+	if cfg.ConcurrentCanaryPercent < 0 || cfg.ConcurrentCanaryPercent > 100 {
+		return cfg, fmt.Errorf("BOOKSTORE_CANARY_CONCURRENT_PERCENT must be between 0 and 100, got %d", cfg.ConcurrentCanaryPercent)
+	}
+
+	if cfg.DetailRequestTimeout, err = envDuration("BOOKSTORE_DETAIL_TIMEOUT", cfg.DetailRequestTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.UpstreamSafetyMargin, err = envDuration("BOOKSTORE_UPSTREAM_SAFETY_MARGIN", cfg.UpstreamSafetyMargin); err != nil {
+		return cfg, err
+	}
+	if cfg.DetailRequestTimeout <= cfg.UpstreamSafetyMargin {
+		return cfg, fmt.Errorf("BOOKSTORE_DETAIL_TIMEOUT (%v) must be longer than BOOKSTORE_UPSTREAM_SAFETY_MARGIN (%v)", cfg.DetailRequestTimeout, cfg.UpstreamSafetyMargin)
+	}
+	if cfg.DatabaseHedgeDelays, err = envDurationMap("BOOKSTORE_DB_HEDGE_DELAYS", cfg.DatabaseHedgeDelays); err != nil {
+		return cfg, err
+	}
+	for class := range cfg.DatabaseHedgeDelays {
+		switch class {
+		case "metadata", "pricing", "inventory", "reviews":
+		default:
+			return cfg, fmt.Errorf("BOOKSTORE_DB_HEDGE_DELAYS: unknown query class %q", class)
+		}
+	}
+	if cfg.RepositoryCacheTTLs, err = envDurationMap("BOOKSTORE_REPOSITORY_CACHE_TTLS", cfg.RepositoryCacheTTLs); err != nil {
+		return cfg, err
+	}
+	for entity := range cfg.RepositoryCacheTTLs {
+		if !containsString(repositoryEntities, entity) {
+			return cfg, fmt.Errorf("BOOKSTORE_REPOSITORY_CACHE_TTLS: unknown entity %q", entity)
+		}
+	}
+	if ttl := cfg.RepositoryCacheTTLs["inventory"]; ttl >= maxInventoryCacheTTL {
+		return cfg, fmt.Errorf("BOOKSTORE_REPOSITORY_CACHE_TTLS: inventory TTL (%v) must be under %v", ttl, maxInventoryCacheTTL)
+	}
+	if cfg.SectionTimeouts, err = envDurationMap("BOOKSTORE_SECTION_TIMEOUTS", cfg.SectionTimeouts); err != nil {
+		return cfg, err
+	}
+	for section, timeout := range cfg.SectionTimeouts {
+		switch section {
+		case "metadata", "pricing", "inventory", "reviews", "recommendations":
+		default:
+			return cfg, fmt.Errorf("BOOKSTORE_SECTION_TIMEOUTS: unknown section %q", section)
+		}
+		if timeout <= 0 {
+			return cfg, fmt.Errorf("BOOKSTORE_SECTION_TIMEOUTS: timeout for %s must be positive", section)
+		}
+	}
+	if timeout, ok := cfg.SectionTimeouts["recommendations"]; ok && timeout <= cfg.UpstreamSafetyMargin {
+		return cfg, fmt.Errorf("BOOKSTORE_SECTION_TIMEOUTS: recommendations (%v) must be longer than BOOKSTORE_UPSTREAM_SAFETY_MARGIN (%v)", timeout, cfg.UpstreamSafetyMargin)
+	}
+	if cfg.DatabaseBulkheadSize, err = envInt("BOOKSTORE_DB_BULKHEAD_SIZE", cfg.DatabaseBulkheadSize); err != nil {
+		return cfg, err
+	}
+	if cfg.ExternalBulkheadSize, err = envInt("BOOKSTORE_EXTERNAL_BULKHEAD_SIZE", cfg.ExternalBulkheadSize); err != nil {
+		return cfg, err
+	}
+	if cfg.DatabaseBulkheadSize < 1 || cfg.ExternalBulkheadSize < 1 {
+		return cfg, fmt.Errorf("bulkhead sizes must be at least 1")
+	}
+	if cfg.ResponseEnvelope, err = envBool("BOOKSTORE_RESPONSE_ENVELOPE", cfg.ResponseEnvelope); err != nil {
+		return cfg, err
+	}
+	cfg.SearchBackend = envString("BOOKSTORE_SEARCH_BACKEND", cfg.SearchBackend)
+	if _, ok := searchIndexRegistry[cfg.SearchBackend]; !ok {
+		return cfg, fmt.Errorf("BOOKSTORE_SEARCH_BACKEND: unknown backend %q", cfg.SearchBackend)
+	}
+	cfg.ElasticsearchURL = strings.TrimSuffix(envString("BOOKSTORE_ELASTICSEARCH_URL", cfg.ElasticsearchURL), "/")
+	cfg.ElasticsearchIndex = envString("BOOKSTORE_ELASTICSEARCH_INDEX", cfg.ElasticsearchIndex)
+	if cfg.SearchBackend == "elasticsearch" && cfg.ElasticsearchURL == "" {
+		return cfg, fmt.Errorf("BOOKSTORE_ELASTICSEARCH_URL is required when BOOKSTORE_SEARCH_BACKEND=elasticsearch")
+	}
+	if cfg.SearchReindexInterval, err = envDuration("BOOKSTORE_SEARCH_REINDEX_INTERVAL", cfg.SearchReindexInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.SearchReindexInterval <= 0 {
+		return cfg, fmt.Errorf("BOOKSTORE_SEARCH_REINDEX_INTERVAL must be positive")
+	}
+	cfg.EmbeddingProvider = envString("BOOKSTORE_EMBEDDING_PROVIDER", cfg.EmbeddingProvider)
+	if _, ok := embeddingProviderRegistry[cfg.EmbeddingProvider]; !ok {
+		return cfg, fmt.Errorf("BOOKSTORE_EMBEDDING_PROVIDER: unknown provider %q", cfg.EmbeddingProvider)
+	}
+	cfg.EmbeddingURL = strings.TrimSuffix(envString("BOOKSTORE_EMBEDDING_URL", cfg.EmbeddingURL), "/")
+	cfg.EmbeddingModel = envString("BOOKSTORE_EMBEDDING_MODEL", cfg.EmbeddingModel)
+	cfg.EmbeddingAPIKey = envString("BOOKSTORE_EMBEDDING_API_KEY", cfg.EmbeddingAPIKey)
+	if cfg.EmbeddingProvider == "openai" && cfg.EmbeddingURL == "" {
+		return cfg, fmt.Errorf("BOOKSTORE_EMBEDDING_URL is required when BOOKSTORE_EMBEDDING_PROVIDER=openai")
+	}
+	if cfg.EmbeddingRefreshInterval, err = envDuration("BOOKSTORE_EMBEDDING_REFRESH_INTERVAL", cfg.EmbeddingRefreshInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.EmbeddingRefreshInterval <= 0 {
+		return cfg, fmt.Errorf("BOOKSTORE_EMBEDDING_REFRESH_INTERVAL must be positive")
+	}
+	if cfg.RatingRecomputeInterval, err = envDuration("BOOKSTORE_RATING_RECOMPUTE_INTERVAL", cfg.RatingRecomputeInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.RatingRecomputeInterval <= 0 {
+		return cfg, fmt.Errorf("BOOKSTORE_RATING_RECOMPUTE_INTERVAL must be positive")
+	}
+	cfg.RecordFile = envString("BOOKSTORE_RECORD_FILE", cfg.RecordFile)
+	maxBodyBytes, err := envInt("BOOKSTORE_MAX_BODY_BYTES", int(cfg.MaxBodyBytes))
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.MaxBodyBytes = int64(maxBodyBytes); cfg.MaxBodyBytes < 1 {
+		return cfg, fmt.Errorf("BOOKSTORE_MAX_BODY_BYTES must be positive")
+	}
+	if cfg.RouteBodyLimits, err = envByteSizeMap("BOOKSTORE_ROUTE_BODY_LIMITS", cfg.RouteBodyLimits); err != nil {
+		return cfg, err
+	}
+	if cfg.StrictJSON, err = envBool("BOOKSTORE_STRICT_JSON", cfg.StrictJSON); err != nil {
+		return cfg, err
+	}
+	if cfg.JSONMaxDepth, err = envInt("BOOKSTORE_JSON_MAX_DEPTH", cfg.JSONMaxDepth); err != nil {
+		return cfg, err
+	}
+	if cfg.JSONMaxDepth < 1 {
+		return cfg, fmt.Errorf("BOOKSTORE_JSON_MAX_DEPTH must be at least 1")
+	}
+	cfg.ShippingProvider = envString("BOOKSTORE_SHIPPING_PROVIDER", cfg.ShippingProvider)
+	if _, ok := shippingProviderRegistry[cfg.ShippingProvider]; !ok {
+		return cfg, fmt.Errorf("BOOKSTORE_SHIPPING_PROVIDER: unknown provider %q", cfg.ShippingProvider)
+	}
+	cfg.CountryHeader = envString("BOOKSTORE_COUNTRY_HEADER", cfg.CountryHeader)
+	cfg.GeoIPFile = envString("BOOKSTORE_GEOIP_FILE", cfg.GeoIPFile)
+	if cfg.RecommendationCacheTTL, err = envDuration("BOOKSTORE_RECOMMENDATION_CACHE_TTL", cfg.RecommendationCacheTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.RecommendationStaleTTL, err = envDuration("BOOKSTORE_RECOMMENDATION_STALE_TTL", cfg.RecommendationStaleTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.RecommendationStaleTTL < cfg.RecommendationCacheTTL {
+		return cfg, fmt.Errorf("BOOKSTORE_RECOMMENDATION_STALE_TTL (%v) must not be shorter than BOOKSTORE_RECOMMENDATION_CACHE_TTL (%v)", cfg.RecommendationStaleTTL, cfg.RecommendationCacheTTL)
+	}
+	if cfg.PersonalizedCacheTTL, err = envDuration("BOOKSTORE_PERSONALIZED_CACHE_TTL", cfg.PersonalizedCacheTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.PersonalizedStaleTTL, err = envDuration("BOOKSTORE_PERSONALIZED_STALE_TTL", cfg.PersonalizedStaleTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.PersonalizedStaleTTL < cfg.PersonalizedCacheTTL {
+		return cfg, fmt.Errorf("BOOKSTORE_PERSONALIZED_STALE_TTL (%v) must not be shorter than BOOKSTORE_PERSONALIZED_CACHE_TTL (%v)", cfg.PersonalizedStaleTTL, cfg.PersonalizedCacheTTL)
+	}
+	if cfg.RecentlyViewedLimit, err = envInt("BOOKSTORE_RECENTLY_VIEWED_LIMIT", cfg.RecentlyViewedLimit); err != nil {
+		return cfg, err
+	}
+	if cfg.RecentlyViewedLimit < 1 {
+		return cfg, fmt.Errorf("BOOKSTORE_RECENTLY_VIEWED_LIMIT must be at least 1")
+	}
+	cfg.SessionSecret = envString("BOOKSTORE_SESSION_SECRET", cfg.SessionSecret)
+	if cfg.SessionMaxAge, err = envDuration("BOOKSTORE_SESSION_MAX_AGE", cfg.SessionMaxAge); err != nil {
+		return cfg, err
+	}
+	if cfg.SecureCookies, err = envBool("BOOKSTORE_SECURE_COOKIES", cfg.SecureCookies); err != nil {
+		return cfg, err
+	}
+	if cfg.SessionMaxAge <= 0 {
+		return cfg, fmt.Errorf("BOOKSTORE_SESSION_MAX_AGE must be positive")
+	}
+	if cfg.PasswordMinLength, err = envInt("BOOKSTORE_PASSWORD_MIN_LENGTH", cfg.PasswordMinLength); err != nil {
+		return cfg, err
+	}
+	if cfg.PasswordMinClasses, err = envInt("BOOKSTORE_PASSWORD_MIN_CLASSES", cfg.PasswordMinClasses); err != nil {
+		return cfg, err
+	}
+	if cfg.PasswordMinLength < 8 || cfg.PasswordMinLength > maxPasswordBytes {
+		return cfg, fmt.Errorf("BOOKSTORE_PASSWORD_MIN_LENGTH must be between 8 and %d", maxPasswordBytes)
+	}
+	if cfg.PasswordMinClasses < 1 || cfg.PasswordMinClasses > 4 {
+		return cfg, fmt.Errorf("BOOKSTORE_PASSWORD_MIN_CLASSES must be between 1 and 4")
+	}
+	if cfg.Argon2MemoryKiB, err = envInt("BOOKSTORE_ARGON2_MEMORY_KIB", cfg.Argon2MemoryKiB); err != nil {
+		return cfg, err
+	}
+	if cfg.Argon2Iterations, err = envInt("BOOKSTORE_ARGON2_ITERATIONS", cfg.Argon2Iterations); err != nil {
+		return cfg, err
+	}
+	if cfg.Argon2Parallelism, err = envInt("BOOKSTORE_ARGON2_PARALLELISM", cfg.Argon2Parallelism); err != nil {
+		return cfg, err
+	}
+	if cfg.Argon2Parallelism < 1 || cfg.Argon2Parallelism > 255 || cfg.Argon2Iterations < 1 || cfg.Argon2MemoryKiB < 8*cfg.Argon2Parallelism {
+		return cfg, fmt.Errorf("BOOKSTORE_ARGON2_*: parallelism must be 1-255, iterations at least 1 and memory at least 8 KiB per lane")
+	}
+	if cfg.LoginFailureWindow, err = envDuration("BOOKSTORE_LOGIN_FAILURE_WINDOW", cfg.LoginFailureWindow); err != nil {
+		return cfg, err
+	}
+	if cfg.LoginCaptchaThreshold, err = envInt("BOOKSTORE_LOGIN_CAPTCHA_THRESHOLD", cfg.LoginCaptchaThreshold); err != nil {
+		return cfg, err
+	}
+	if cfg.LoginLockoutThreshold, err = envInt("BOOKSTORE_LOGIN_LOCKOUT_THRESHOLD", cfg.LoginLockoutThreshold); err != nil {
+		return cfg, err
+	}
+	if cfg.LoginLockoutBase, err = envDuration("BOOKSTORE_LOGIN_LOCKOUT_BASE", cfg.LoginLockoutBase); err != nil {
+		return cfg, err
+	}
+	if cfg.LoginLockoutMax, err = envDuration("BOOKSTORE_LOGIN_LOCKOUT_MAX", cfg.LoginLockoutMax); err != nil {
+		return cfg, err
+	}
+	if cfg.LoginFailureWindow <= 0 || cfg.LoginCaptchaThreshold < 1 || cfg.LoginLockoutThreshold < 1 {
+		return cfg, fmt.Errorf("BOOKSTORE_LOGIN_FAILURE_WINDOW must be positive and the login thresholds at least 1")
+	}
+	if cfg.LoginLockoutBase <= 0 || cfg.LoginLockoutMax < cfg.LoginLockoutBase {
+		return cfg, fmt.Errorf("BOOKSTORE_LOGIN_LOCKOUT_BASE (%v) must be positive and at most BOOKSTORE_LOGIN_LOCKOUT_MAX (%v)", cfg.LoginLockoutBase, cfg.LoginLockoutMax)
+	}
+	cfg.CaptchaProvider = envString("BOOKSTORE_CAPTCHA_PROVIDER", cfg.CaptchaProvider)
+	if _, ok := captchaVerifierRegistry[cfg.CaptchaProvider]; !ok {
+		return cfg, fmt.Errorf("BOOKSTORE_CAPTCHA_PROVIDER: unknown provider %q", cfg.CaptchaProvider)
+	}
+	cfg.CaptchaTestToken = envString("BOOKSTORE_CAPTCHA_TEST_TOKEN", cfg.CaptchaTestToken)
+	if cfg.CaptchaProvider == "static" && cfg.CaptchaTestToken == "" {
+		return cfg, fmt.Errorf("BOOKSTORE_CAPTCHA_TEST_TOKEN is required with the static CAPTCHA provider")
+	}
+	cfg.ExportArchiveDir = envString("BOOKSTORE_EXPORT_ARCHIVE_DIR", cfg.ExportArchiveDir)
+	if cfg.ExportArchiveTTL, err = envDuration("BOOKSTORE_EXPORT_ARCHIVE_TTL", cfg.ExportArchiveTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.ExportArchiveTTL <= 0 {
+		return cfg, fmt.Errorf("BOOKSTORE_EXPORT_ARCHIVE_TTL must be positive")
+	}
+	cfg.LogFile = envString("BOOKSTORE_LOG_FILE", cfg.LogFile)
+	if cfg.LogToStderr, err = envBool("BOOKSTORE_LOG_TO_STDERR", cfg.LogToStderr); err != nil {
+		return cfg, err
+	}
+	if cfg.LogFileMaxSizeMB, err = envInt("BOOKSTORE_LOG_FILE_MAX_SIZE_MB", cfg.LogFileMaxSizeMB); err != nil {
+		return cfg, err
+	}
+	if cfg.LogFileMaxSizeMB < 1 {
+		return cfg, fmt.Errorf("BOOKSTORE_LOG_FILE_MAX_SIZE_MB must be at least 1, got %d", cfg.LogFileMaxSizeMB)
+	}
+	if cfg.LogFileMaxAge, err = envDuration("BOOKSTORE_LOG_FILE_MAX_AGE", cfg.LogFileMaxAge); err != nil {
+		return cfg, err
+	}
+	if cfg.LogFileMaxBackups, err = envInt("BOOKSTORE_LOG_FILE_MAX_BACKUPS", cfg.LogFileMaxBackups); err != nil {
+		return cfg, err
+	}
+	if cfg.LogFileMaxBackups < 0 {
+		return cfg, fmt.Errorf("BOOKSTORE_LOG_FILE_MAX_BACKUPS must not be negative")
+	}
+	if cfg.LogFileCompress, err = envBool("BOOKSTORE_LOG_FILE_COMPRESS", cfg.LogFileCompress); err != nil {
+		return cfg, err
+	}
+	if cfg.MigrationBackfillBatch, err = envInt("BOOKSTORE_MIGRATION_BACKFILL_BATCH", cfg.MigrationBackfillBatch); err != nil {
+		return cfg, err
+	}
+	if cfg.MigrationBackfillBatch < 1 {
+		return cfg, fmt.Errorf("BOOKSTORE_MIGRATION_BACKFILL_BATCH must be at least 1, got %d", cfg.MigrationBackfillBatch)
+	}
+	if cfg.MigrationBackfillPause, err = envDuration("BOOKSTORE_MIGRATION_BACKFILL_PAUSE", cfg.MigrationBackfillPause); err != nil {
+		return cfg, err
+	}
+	if cfg.MigrationReplicaStaleAfter, err = envDuration("BOOKSTORE_MIGRATION_REPLICA_STALE_AFTER", cfg.MigrationReplicaStaleAfter); err != nil {
+		return cfg, err
+	}
+	cfg.StartupCheck = envString("BOOKSTORE_STARTUP_CHECK", cfg.StartupCheck)
+	if cfg.StartupCheck != "fail" && cfg.StartupCheck != "degrade" && cfg.StartupCheck != "off" {
+		return cfg, fmt.Errorf("BOOKSTORE_STARTUP_CHECK must be fail, degrade or off, got %q", cfg.StartupCheck)
+	}
+	if cfg.StartupCheckFull, err = envBool("BOOKSTORE_STARTUP_CHECK_FULL", cfg.StartupCheckFull); err != nil {
+		return cfg, err
+	}
+	if cfg.WatchdogInterval, err = envDuration("BOOKSTORE_WATCHDOG_INTERVAL", cfg.WatchdogInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.WatchdogSamples, err = envInt("BOOKSTORE_WATCHDOG_SAMPLES", cfg.WatchdogSamples); err != nil {
+		return cfg, err
+	}
+	if cfg.WatchdogSamples < 2 {
+		return cfg, fmt.Errorf("BOOKSTORE_WATCHDOG_SAMPLES must be at least 2, got %d", cfg.WatchdogSamples)
+	}
+	if cfg.WatchdogMinGrowth, err = envInt("BOOKSTORE_WATCHDOG_MIN_GROWTH", cfg.WatchdogMinGrowth); err != nil {
+		return cfg, err
+	}
+	cfg.LogSystem = envString("BOOKSTORE_LOG_SYSTEM", cfg.LogSystem)
+	if cfg.LogSystem != "" && cfg.LogSystem != "syslog" && cfg.LogSystem != "journald" {
+		return cfg, fmt.Errorf("BOOKSTORE_LOG_SYSTEM must be syslog or journald, got %q", cfg.LogSystem)
+	}
+	cfg.SyslogAddress = envString("BOOKSTORE_SYSLOG_ADDRESS", cfg.SyslogAddress)
+	cfg.SyslogTag = envString("BOOKSTORE_SYSLOG_TAG", cfg.SyslogTag)
+	if cfg.LogSampleEvery, err = envIntMap("BOOKSTORE_LOG_SAMPLE_EVERY", cfg.LogSampleEvery, 1); err != nil {
+		return cfg, err
+	}
+	if cfg.LogRateLimits, err = envIntMap("BOOKSTORE_LOG_RATE_LIMITS", cfg.LogRateLimits, 0); err != nil {
+		return cfg, err
+	}
+	cfg.ErrorReporter = envString("BOOKSTORE_ERROR_REPORTER", cfg.ErrorReporter)
+	cfg.ErrorReportFile = envString("BOOKSTORE_ERROR_REPORT_FILE", cfg.ErrorReportFile)
+	cfg.ErrorReportDSN = envString("BOOKSTORE_ERROR_REPORT_DSN", cfg.ErrorReportDSN)
+	if cfg.ErrorReportSamplePercent, err = envInt("BOOKSTORE_ERROR_REPORT_SAMPLE_PERCENT", cfg.ErrorReportSamplePercent); err != nil {
+		return cfg, err
+	}
+	if cfg.ErrorReportSamplePercent < 0 || cfg.ErrorReportSamplePercent > 100 {
+		return cfg, fmt.Errorf("BOOKSTORE_ERROR_REPORT_SAMPLE_PERCENT must be between 0 and 100, got %d", cfg.ErrorReportSamplePercent)
+	}
+	cfg.ErrorReportScrubFields = envList("BOOKSTORE_ERROR_REPORT_SCRUB_FIELDS", cfg.ErrorReportScrubFields)
+	if cfg.SLOs, err = envSLOs("BOOKSTORE_SLOS", cfg.SLOs); err != nil {
+		return cfg, err
+	}
+	cfg.AdminToken = envString("BOOKSTORE_ADMIN_TOKEN", cfg.AdminToken)
+	if cfg.AdminOpen, err = envBool("BOOKSTORE_ADMIN_OPEN", cfg.AdminOpen); err != nil {
+		return cfg, err
+	}
+	cfg.AdminUsers = envList("BOOKSTORE_ADMIN_USERS", cfg.AdminUsers)
+	if cfg.AdminOpen && cfg.AdminToken != "" {
+		return cfg, fmt.Errorf("BOOKSTORE_ADMIN_OPEN and BOOKSTORE_ADMIN_TOKEN can't both be set")
+	}
+	if cfg.SignedURLTTL, err = envDuration("BOOKSTORE_SIGNED_URL_TTL", cfg.SignedURLTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.SignedURLMaxTTL, err = envDuration("BOOKSTORE_SIGNED_URL_MAX_TTL", cfg.SignedURLMaxTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.SignedURLTTL <= 0 || cfg.SignedURLTTL > cfg.SignedURLMaxTTL {
+		return cfg, fmt.Errorf("BOOKSTORE_SIGNED_URL_TTL (%v) must be positive and at most BOOKSTORE_SIGNED_URL_MAX_TTL (%v)", cfg.SignedURLTTL, cfg.SignedURLMaxTTL)
+	}
+	if cfg.ImpersonationTTL, err = envDuration("BOOKSTORE_IMPERSONATION_TTL", cfg.ImpersonationTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.ImpersonationMaxTTL, err = envDuration("BOOKSTORE_IMPERSONATION_MAX_TTL", cfg.ImpersonationMaxTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.ImpersonationTTL <= 0 || cfg.ImpersonationTTL > cfg.ImpersonationMaxTTL {
+		return cfg, fmt.Errorf("BOOKSTORE_IMPERSONATION_TTL (%v) must be positive and at most BOOKSTORE_IMPERSONATION_MAX_TTL (%v)", cfg.ImpersonationTTL, cfg.ImpersonationMaxTTL)
+	}
+	if cfg.EditLockTTL, err = envDuration("BOOKSTORE_EDIT_LOCK_TTL", cfg.EditLockTTL); err != nil {
+		return cfg, err
+	}
+	if cfg.EditLockTTL <= 0 {
+		return cfg, fmt.Errorf("BOOKSTORE_EDIT_LOCK_TTL must be positive")
+	}
+
+	if cfg.UpstreamTimeout, err = envDuration("BOOKSTORE_UPSTREAM_TIMEOUT", cfg.UpstreamTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.UpstreamDialTimeout, err = envDuration("BOOKSTORE_UPSTREAM_DIAL_TIMEOUT", cfg.UpstreamDialTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.UpstreamTLSHandshakeTimeout, err = envDuration("BOOKSTORE_UPSTREAM_TLS_TIMEOUT", cfg.UpstreamTLSHandshakeTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.UpstreamKeepAlive, err = envDuration("BOOKSTORE_UPSTREAM_KEEPALIVE", cfg.UpstreamKeepAlive); err != nil {
+		return cfg, err
+	}
+	if cfg.UpstreamMaxIdleConnsPerHost, err = envInt("BOOKSTORE_UPSTREAM_MAX_IDLE_PER_HOST", cfg.UpstreamMaxIdleConnsPerHost); err != nil {
+		return cfg, err
+	}
+	if cfg.UpstreamDisableKeepAlives, err = envBool("BOOKSTORE_UPSTREAM_DISABLE_KEEPALIVES", cfg.UpstreamDisableKeepAlives); err != nil {
+		return cfg, err
+	}
+
+	cfg.RecommendationProviders = envList("BOOKSTORE_RECOMMENDATION_PROVIDERS", cfg.RecommendationProviders)
+	for _, name := range cfg.RecommendationProviders {
+		if _, ok := recommendationProviderRegistry[name]; !ok {
+			return cfg, fmt.Errorf("BOOKSTORE_RECOMMENDATION_PROVIDERS: unknown provider %q", name)
+		}
+	}
+
+	cfg.UpstreamProxyURL = envString("BOOKSTORE_UPSTREAM_PROXY", cfg.UpstreamProxyURL)
+	if cfg.UpstreamProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.UpstreamProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return cfg, fmt.Errorf("BOOKSTORE_UPSTREAM_PROXY must be an absolute URL like http://proxy:3128, got %q", cfg.UpstreamProxyURL)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return cfg, fmt.Errorf("BOOKSTORE_UPSTREAM_PROXY scheme must be http, https or socks5, got %q", proxyURL.Scheme)
+		}
+	}
+	cfg.EgressAllowlist = envList("BOOKSTORE_EGRESS_ALLOWLIST", cfg.EgressAllowlist)
+
+	return cfg, nil
+}
+
+// envString returns the environment variable value, or fallback when unset or empty
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envInt parses an integer environment variable, returning fallback when unset
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback, fmt.Errorf("%s must be an integer: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// envDuration parses a Go duration (e.g. "30s", "5m") from the environment, returning fallback when unset
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback, fmt.Errorf("%s must be a duration like 30s or 5m: %w", name, err)
+	}
+	if parsed < 0 {
+		return fallback, fmt.Errorf("%s must not be negative", name)
+	}
+	return parsed, nil
+}
+
+// envBool parses a boolean environment variable (1/0, true/false), returning fallback when unset
+func envBool(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback, fmt.Errorf("%s must be true or false: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// envList parses a comma-separated environment variable, returning fallback when unset
+func envList(name string, fallback []string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envDurationMap parses "key=duration" pairs separated by commas (e.g. "reviews=50ms,pricing=80ms"),
+// returning fallback when unset
+func envDurationMap(name string, fallback map[string]time.Duration) (map[string]time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		key, rawDuration, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return fallback, fmt.Errorf("%s entries must look like key=duration, got %q", name, pair)
+		}
+		duration, err := time.ParseDuration(rawDuration)
+		if err != nil || duration < 0 {
+			return fallback, fmt.Errorf("%s: invalid duration for %s: %q", name, key, rawDuration)
+		}
+		parsed[key] = duration
+	}
+	return parsed, nil
+}
+
+// envSLOs parses "route=latency:objective" pairs separated by commas, the objective a percentage
+// (e.g. "/api/books/=300ms:99.5,/api/books/search=200ms:99"), returning fallback when unset
+func envSLOs(name string, fallback map[string]SLOTarget) (map[string]SLOTarget, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed := make(map[string]SLOTarget)
+	for _, pair := range strings.Split(value, ",") {
+		route, spec, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		rawLatency, rawObjective, hasObjective := strings.Cut(spec, ":")
+		if !ok || !hasObjective || route == "" {
+			return fallback, fmt.Errorf("%s entries must look like route=latency:objective, got %q", name, pair)
+		}
+		latency, err := time.ParseDuration(rawLatency)
+		if err != nil || latency <= 0 {
+			return fallback, fmt.Errorf("%s: invalid latency for %s: %q", name, route, rawLatency)
+		}
+		objective, err := strconv.ParseFloat(rawObjective, 64)
+		if err != nil || objective <= 0 || objective >= 100 {
+			return fallback, fmt.Errorf("%s: objective for %s must be a percentage between 0 and 100, got %q", name, route, rawObjective)
+		}
+		parsed[route] = SLOTarget{Latency: latency, Objective: objective / 100}
+	}
+	return parsed, nil
+}
+
+// envIntMap parses "key=n" pairs separated by commas (e.g. "details=10,search=5"), each at
+// least min, returning fallback when unset
+func envIntMap(name string, fallback map[string]int, min int) (map[string]int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		key, rawNumber, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return fallback, fmt.Errorf("%s entries must look like key=n, got %q", name, pair)
+		}
+		number, err := strconv.Atoi(rawNumber)
+		if err != nil || number < min {
+			return fallback, fmt.Errorf("%s: %s must be an integer of at least %d, got %q", name, key, min, rawNumber)
+		}
+		parsed[key] = number
+	}
+	return parsed, nil
+}
+
+// envByteSizeMap parses "key=bytes" pairs separated by commas (e.g. "/api/books/=4096"),
+// returning fallback when unset
+func envByteSizeMap(name string, fallback map[string]int64) (map[string]int64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed := make(map[string]int64)
+	for _, pair := range strings.Split(value, ",") {
+		key, rawSize, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return fallback, fmt.Errorf("%s entries must look like key=bytes, got %q", name, pair)
+		}
+		size, err := strconv.ParseInt(rawSize, 10, 64)
+		if err != nil || size < 1 {
+			return fallback, fmt.Errorf("%s: invalid size for %s: %q", name, key, rawSize)
+		}
+		parsed[key] = size
+	}
+	return parsed, nil
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"net/http"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -13,32 +14,61 @@ import (
 // Global database connection shared across the application
 var db *sql.DB
 
-// Simple HTTP client for external API calls
-var httpClient = &http.Client{
-	Timeout: 5 * time.Second,
+// Separate pool for background jobs and bulk work, opened by main next to db. Capping it at
+// DatabaseBatchConns keeps the rest of the connections free for requests however much batch
+// work is running. Nil means batch work shares db, as in tests.
+var batchDB *sql.DB
+
+const batchPriorityKey contextKey = "batch_priority"
+
+// withBatchPriority marks ctx as background work, whose queries go to the batch pool
+func withBatchPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, batchPriorityKey, true)
 }
 
-// InitializeDatabase sets up the database connection and ensures schema exists
-func InitializeDatabase() error {
-	var err error
+// dbFor returns the pool for ctx: the batch pool for background work, db for everything else
+func dbFor(ctx context.Context) *sql.DB {
+	if batch, _ := ctx.Value(batchPriorityKey).(bool); batch && batchDB != nil {
+		return batchDB
+	}
+	return db
+}
 
-	// Open database connection
-	db, err = sql.Open("sqlite3", "bookstore.db")
+// OpenDatabase opens the SQLite database at path with a pool of up to maxConns connections
+func OpenDatabase(path string, maxConns int) (*sql.DB, error) {
+	database, err := sql.Open("sqlite3", withForeignKeys(path))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Configure connection pool for optimal concurrent performance
-	db.SetMaxOpenConns(25)                 // Maximum total connections
-	db.SetMaxIdleConns(25)                 // Keep connections alive for reuse
-	db.SetConnMaxLifetime(5 * time.Minute) // Refresh connections periodically
+	database.SetMaxOpenConns(maxConns)           // Maximum total connections
+	database.SetMaxIdleConns(maxConns)           // Keep connections alive for reuse
+	database.SetConnMaxLifetime(5 * time.Minute) // Refresh connections periodically
+	return database, nil
+}
 
-	// Smart initialization - only setup if needed
-	return initializeDatabaseIfNeeded()
+// OpenMemoryDatabase opens an empty in-memory database for tests and --memory mode; NewServer
+// seeds it from the embedded dataset. Every SQLite connection to ":memory:" is a separate
+// database, so the pool is held to one connection that is never recycled.
+func OpenMemoryDatabase() (*sql.DB, error) {
+	database, err := sql.Open("sqlite3", withForeignKeys(":memory:"))
+	if err != nil {
+		return nil, err
+	}
+	database.SetMaxOpenConns(1)
+	database.SetMaxIdleConns(1)
+	database.SetConnMaxLifetime(0)
+	return database, nil
 }
 
-// CloseDatabase closes the database connection
+// CloseDatabase closes the database connections
 func CloseDatabase() error {
+	if batchDB != nil {
+		if err := batchDB.Close(); err != nil {
+			return err
+		}
+	}
 	if db != nil {
 		return db.Close()
 	}
@@ -47,7 +77,16 @@ func CloseDatabase() error {
 
 // initializeDatabaseIfNeeded checks if database is already set up before running setup
 func initializeDatabaseIfNeeded() error {
-	// Test if database is already initialized by checking if books table exists and has data
+	// Always make sure the schema is current. Every statement uses IF NOT EXISTS,
+	// so this is a no-op for existing tables and picks up tables added later on.
+	if err := createSchema(); err != nil {
+		return err
+	}
+	if err := stampSchemaVersion(context.Background()); err != nil {
+		return err
+	}
+
+	// Test if database is already initialized by checking if books table has data
 	var count int
 	err := db.QueryRow("SELECT COUNT(*) FROM books").Scan(&count)
 
@@ -57,16 +96,7 @@ func initializeDatabaseIfNeeded() error {
 		return nil
 	}
 
-	// If we get here, either:
-	// 1. Table doesn't exist (query failed)
-	// 2. Table exists but is empty (count = 0)
-	// Either way, we need to run setup
-
-	log.Println("Initializing database schema and data...")
-
-	if err := createSchema(); err != nil {
-		return err
-	}
+	log.Println("Initializing database data...")
 
 	if err := populateInitialData(); err != nil {
 		return err
@@ -87,7 +117,8 @@ func createSchema() error {
 			isbn TEXT UNIQUE,
 			publish_date DATE,
 			description TEXT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
 	`)
 	if err != nil {
@@ -104,7 +135,7 @@ func createSchema() error {
 			sale_price DECIMAL(10,2),
 			promotion TEXT,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (book_id) REFERENCES books(id)
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
 		)
 	`)
 	if err != nil {
@@ -120,7 +151,8 @@ func createSchema() error {
 			warehouse TEXT,
 			shipping_time TEXT,
 			last_restocked TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (book_id) REFERENCES books(id)
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
 		)
 	`)
 	if err != nil {
@@ -140,241 +172,742 @@ func createSchema() error {
 			two_star INTEGER DEFAULT 0,
 			one_star INTEGER DEFAULT 0,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (book_id) REFERENCES books(id)
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
 		)
 	`)
+	if err != nil {
+		return err
+	}
 
-	return err
-}
+	// Columns added after the first release; databases created earlier get them via ALTER TABLE.
+	// SQLite can't backfill CURRENT_TIMESTAMP on ALTER, so readers fall back to created_at/last_restocked.
+	if err := ensureColumn("books", "updated_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := ensureColumn("inventory", "updated_at", "TIMESTAMP"); err != nil {
+		return err
+	}
 
-// populateInitialData inserts sample data into all tables
-func populateInitialData() error {
-	// Insert book metadata
-	books := []map[string]interface{}{
-		{"id": "1", "title": "The Go Programming Language", "author": "Alan Donovan", "isbn": "978-0134190440", "publish_date": "2015-11-16", "description": "The authoritative resource to writing clear and idiomatic Go"},
-		{"id": "2", "title": "Clean Code", "author": "Robert Martin", "isbn": "978-0132350884", "publish_date": "2008-08-11", "description": "A handbook of agile software craftsmanship"},
-		{"id": "3", "title": "System Design Interview", "author": "Alex Xu", "isbn": "978-1736049112", "publish_date": "2020-06-04", "description": "An insider's guide to system design interviews"},
-		{"id": "4", "title": "Dopamine Nation", "author": "Anna Lembke", "isbn": "978-1524746728", "publish_date": "2021-08-24", "description": "Finding balance in the age of indulgence"},
-	}
-
-	for _, book := range books {
-		_, err := db.Exec(`
-			INSERT OR IGNORE INTO books (id, title, author, isbn, publish_date, description) 
-			VALUES (?, ?, ?, ?, ?, ?)
-		`, book["id"], book["title"], book["author"], book["isbn"], book["publish_date"], book["description"])
-		if err != nil {
-			return err
-		}
+	// Internal-only columns, shown by the admin book view (moderation_flags is comma-separated)
+	if err := ensureColumn("books", "moderation_flags", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn("pricing", "cost_price", "DECIMAL(10,2)"); err != nil {
+		return err
+	}
+	if err := ensureColumn("inventory", "supplier", "TEXT"); err != nil {
+		return err
 	}
 
-	// Insert pricing data
-	pricing := []map[string]interface{}{
-		{"book_id": "1", "price": 39.99, "discount": 0.10, "sale_price": 35.99, "promotion": "Holiday Sale"},
-		{"book_id": "2", "price": 32.50, "discount": 0.05, "sale_price": 30.88, "promotion": "Member Discount"},
-		{"book_id": "3", "price": 28.95, "discount": 0.00, "sale_price": 28.95, "promotion": ""},
-		{"book_id": "4", "price": 20.00, "discount": 0.15, "sale_price": 17.00, "promotion": "Limited Time"},
+	// Create book embeddings table (vector is little-endian float32; content_hash detects stale vectors)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS book_embeddings (
+			book_id TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			content_hash TEXT NOT NULL,
+			vector BLOB NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (book_id, provider),
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
 	}
 
-	for _, p := range pricing {
-		_, err := db.Exec(`
-			INSERT OR IGNORE INTO pricing (book_id, price, discount, sale_price, promotion) 
-			VALUES (?, ?, ?, ?, ?)
-		`, p["book_id"], p["price"], p["discount"], p["sale_price"], p["promotion"])
-		if err != nil {
-			return err
-		}
+	// Create processing state table: one row per book and enrichment pipeline (status is
+	// "pending", "succeeded" or "failed"; failures counts attempts since the last success)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS book_processing (
+			book_id TEXT NOT NULL,
+			pipeline TEXT NOT NULL,
+			status TEXT NOT NULL,
+			failures INTEGER DEFAULT 0,
+			last_error TEXT,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (book_id, pipeline),
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
 	}
 
-	// Insert inventory data
-	inventory := []map[string]interface{}{
-		{"book_id": "1", "in_stock": true, "quantity": 42, "warehouse": "East Coast DC", "shipping_time": "2-3 business days"},
-		{"book_id": "2", "in_stock": true, "quantity": 38, "warehouse": "Central DC", "shipping_time": "1-2 business days"},
-		{"book_id": "3", "in_stock": true, "quantity": 15, "warehouse": "West Coast DC", "shipping_time": "3-4 business days"},
-		{"book_id": "4", "in_stock": false, "quantity": 0, "warehouse": "Back Order", "shipping_time": "2-3 weeks"},
+	// Create reading lists tables (kind is "reading" or "wishlist"; share_token is set while a list is shared)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS reading_lists (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			kind TEXT NOT NULL DEFAULT 'reading',
+			share_token TEXT UNIQUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, name)
+		)
+	`)
+	if err != nil {
+		return err
 	}
 
-	for _, inv := range inventory {
-		_, err := db.Exec(`
-			INSERT OR IGNORE INTO inventory (book_id, in_stock, quantity, warehouse, shipping_time) 
-			VALUES (?, ?, ?, ?, ?)
-		`, inv["book_id"], inv["in_stock"], inv["quantity"], inv["warehouse"], inv["shipping_time"])
-		if err != nil {
-			return err
-		}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS reading_list_items (
+			list_id INTEGER NOT NULL,
+			book_id TEXT NOT NULL,
+			added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			read_at TIMESTAMP,
+			PRIMARY KEY (list_id, book_id),
+			FOREIGN KEY (list_id) REFERENCES reading_lists(id) ON DELETE CASCADE,
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
 	}
 
-	// Insert reviews data
-	reviews := []map[string]interface{}{
-		{"book_id": "1", "average_rating": 4.5, "total_reviews": 89, "recent_review": "Essential reading for Go developers", "five_star": 45, "four_star": 28, "three_star": 12, "two_star": 3, "one_star": 1},
-		{"book_id": "2", "average_rating": 4.3, "total_reviews": 127, "recent_review": "Changed how I think about writing code", "five_star": 65, "four_star": 32, "three_star": 20, "two_star": 7, "one_star": 3},
-		{"book_id": "3", "average_rating": 4.7, "total_reviews": 56, "recent_review": "Incredibly helpful for interview prep", "five_star": 38, "four_star": 14, "three_star": 3, "two_star": 1, "one_star": 0},
-		{"book_id": "4", "average_rating": 4.1, "total_reviews": 94, "recent_review": "Eye-opening perspective on modern life", "five_star": 42, "four_star": 31, "three_star": 15, "two_star": 4, "one_star": 2},
+	// Create individual ratings table; reviews holds the aggregate kept in step with it
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS book_ratings (
+			book_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (book_id, user_id),
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
 	}
 
-	for _, rev := range reviews {
-		_, err := db.Exec(`
-			INSERT OR IGNORE INTO reviews (book_id, average_rating, total_reviews, recent_review, five_star, four_star, three_star, two_star, one_star) 
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, rev["book_id"], rev["average_rating"], rev["total_reviews"], rev["recent_review"], rev["five_star"], rev["four_star"], rev["three_star"], rev["two_star"], rev["one_star"])
-		if err != nil {
+	// Create translations table; language is a lowercase BCP 47 tag such as "de" or "pt-br"
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS book_translations (
+			book_id TEXT NOT NULL,
+			language TEXT NOT NULL,
+			title TEXT NOT NULL,
+			description TEXT,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (book_id, language),
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create price experiment tables (book_ids is a comma-separated list, like feature flag tenants)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS price_experiments (
+			key TEXT PRIMARY KEY,
+			description TEXT DEFAULT '',
+			enabled BOOLEAN DEFAULT false,
+			book_ids TEXT DEFAULT '',
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS price_experiment_variants (
+			experiment_key TEXT NOT NULL,
+			name TEXT NOT NULL,
+			price_factor REAL NOT NULL,
+			weight INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (experiment_key, name),
+			FOREIGN KEY (experiment_key) REFERENCES price_experiments(key) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create price experiment events table: one exposure and at most one conversion per user and book
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS price_experiment_events (
+			experiment_key TEXT NOT NULL,
+			variant TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			book_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (experiment_key, user_id, book_id, kind),
+			FOREIGN KEY (experiment_key) REFERENCES price_experiments(key) ON DELETE CASCADE,
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create regional pricing tables: a price list per country, and countries a book can't be sold in
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS regional_prices (
+			book_id TEXT NOT NULL,
+			country TEXT NOT NULL,
+			price REAL NOT NULL,
+			sale_price REAL,
+			currency TEXT NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (book_id, country),
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS regional_restrictions (
+			book_id TEXT NOT NULL,
+			country TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (book_id, country),
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create suppliers table; inventory.supplier names a supplier's row by name
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS suppliers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			contact_email TEXT,
+			lead_time_days INTEGER NOT NULL DEFAULT 7,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create purchase orders table (expected_arrival is a calendar date)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS purchase_orders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			supplier_id INTEGER NOT NULL,
+			book_id TEXT NOT NULL,
+			quantity_ordered INTEGER NOT NULL CHECK (quantity_ordered > 0),
+			quantity_received INTEGER NOT NULL DEFAULT 0,
+			unit_cost DECIMAL(10,2),
+			status TEXT NOT NULL DEFAULT 'open',
+			expected_arrival DATE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			received_at TIMESTAMP,
+			FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create storefronts table, one row per tenant (locales and features are comma-separated lists)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS storefronts (
+			tenant_id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			currency TEXT NOT NULL,
+			default_locale TEXT NOT NULL,
+			locales TEXT DEFAULT '',
+			logo_url TEXT DEFAULT '',
+			primary_color TEXT DEFAULT '',
+			features TEXT DEFAULT '',
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create recently viewed table, one row per user and book holding the latest view
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS recently_viewed (
+			user_id TEXT NOT NULL,
+			book_id TEXT NOT NULL,
+			viewed_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, book_id),
+			FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create accounts table; user_id is the same ID reading lists and ratings are kept under
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS accounts (
+			user_id TEXT PRIMARY KEY,
+			password_hash TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create login throttle table: failed login counters keyed "account:<user_id>" or "ip:<address>"
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_throttle (
+			key TEXT PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMP NOT NULL,
+			locked_until TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create impersonation sessions table; tokens are stored as SHA-256 hashes only
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS impersonation_sessions (
+			id TEXT PRIMARY KEY,
+			token_hash TEXT NOT NULL UNIQUE,
+			admin_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			scope TEXT NOT NULL DEFAULT 'read',
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create personal access tokens table; scopes is comma-separated and tokens are stored as
+	// SHA-256 hashes only
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS personal_access_tokens (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP,
+			expires_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user ON personal_access_tokens(user_id)")
+	if err != nil {
+		return err
+	}
+
+	// Create export archives table; the files themselves live in ExportArchiveDir
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS export_archives (
+			id TEXT PRIMARY KEY,
+			format TEXT NOT NULL,
+			books INTEGER NOT NULL,
+			size INTEGER NOT NULL,
+			sha256 TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create schema migration progress, one row per expand/contract migration, and the replicas
+	// sharing the database with the migrations their build knows (comma-separated)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			id TEXT PRIMARY KEY,
+			phase TEXT NOT NULL,
+			rows_backfilled INTEGER NOT NULL DEFAULT 0,
+			expanded_at TIMESTAMP,
+			backfilled_at TIMESTAMP,
+			contracted_at TIMESTAMP,
+			last_error TEXT,
+			backfill_owner TEXT,
+			backfill_heartbeat_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if err := ensureColumn("schema_migrations", "backfill_heartbeat_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := ensureColumn("schema_migrations", "backfill_owner", "TEXT"); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migration_replicas (
+			instance_id TEXT PRIMARY KEY,
+			migrations TEXT NOT NULL DEFAULT '',
+			seen_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create book editing locks, at most one per book; expired rows count as free
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS book_edit_locks (
+			book_id TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			acquired_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create audit log table, append only
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			occurred_at TIMESTAMP NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			user_id TEXT NOT NULL DEFAULT '',
+			impersonated BOOLEAN NOT NULL DEFAULT 0,
+			impersonation_id TEXT NOT NULL DEFAULT '',
+			status INTEGER NOT NULL DEFAULT 0,
+			request_id TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log(user_id, id)")
+	if err != nil {
+		return err
+	}
+
+	// Create cache version table, a single row shared by every instance on this database
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS cache_version (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			schema_hash TEXT NOT NULL,
+			generation INTEGER NOT NULL DEFAULT 1,
+			changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create feature flags table (tenants is a comma-separated allowlist)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS feature_flags (
+			key TEXT PRIMARY KEY,
+			description TEXT DEFAULT '',
+			enabled BOOLEAN DEFAULT false,
+			rollout_percent INTEGER DEFAULT 0,
+			tenants TEXT DEFAULT '',
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Personalized recommendations shipped on for everyone; the flag lets operators dial them back
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO feature_flags (key, description, enabled, rollout_percent)
+		VALUES (?, 'History-based book recommendations; users outside the rollout get top-rated titles', true, 100)
+	`, personalizedRecommendationsFlag)
+	if err != nil {
+		return err
+	}
+
+	// Databases created before foreign keys were enforced declare them without ON DELETE
+	if err := ensureCascadingForeignKeys(); err != nil {
+		return err
+	}
+
+	// Create the restock log and the trigger that fills it; triggers go after the rebuild above,
+	// which drops those of the tables it replaces
+	if err := createRestockLog(); err != nil {
+		return err
+	}
+
+	// Create the catalog change log and the triggers that fill it
+	return createChangeFeed()
+}
+
+// ensureColumn adds a column to an existing table if it isn't there yet
+func ensureColumn(table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
 			return err
 		}
+		if name == column {
+			return nil
+		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Adding column %s.%s", table, column)
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// Database query functions for fetching book information.
+// Each one holds a database bulkhead slot for the duration of the read and returns
+// errDatabaseBusy if no slot frees up before the request deadline.
 
+// errDatabaseBusy means no database bulkhead slot became available in time
+var errDatabaseBusy = errors.New("database busy")
+
+// acquireDatabaseSlot waits for a database bulkhead slot; only database work competes for these
+func acquireDatabaseSlot(ctx context.Context, bookID string) error {
+	if err := dbBulkhead.Acquire(ctx); err != nil {
+		log.Printf("Database bulkhead unavailable for book %s: %v", bookID, err)
+		return fmt.Errorf("%w: %w", errDatabaseBusy, err)
+	}
 	return nil
 }
 
-// Database query functions for fetching book information
+// FetchBookMetadata retrieves basic book information from the books table through
+// bookRepository, at most once per request when the context carries a request cache
+func FetchBookMetadata(ctx context.Context, bookID string) (BookMetadata, error) {
+	return requestCached(ctx, "metadata", bookID, func() (BookMetadata, error) {
+		return bookRepository.Metadata(ctx, bookID)
+	})
+}
 
-// FetchBookMetadata retrieves basic book information from the books table
-func FetchBookMetadata(bookID string) map[string]interface{} {
-	var title, author, isbn, publishDate, description string
+// readBookMetadata queries the metadata row behind FetchBookMetadata
+func readBookMetadata(ctx context.Context, bookID string) (BookMetadata, error) {
+	if err := acquireDatabaseSlot(ctx, bookID); err != nil {
+		return BookMetadata{}, err
+	}
+	defer dbBulkhead.Release()
+
+	metadata, err := hedgedRead(ctx, "metadata", func(ctx context.Context) (BookMetadata, error) {
+		var metadata BookMetadata
+		var isbn, description sql.NullString
+		var publishDate sql.NullTime
+
+		err := db.QueryRowContext(ctx, `
+			SELECT title, author, isbn, publish_date, description 
+			FROM books 
+			WHERE id = ?
+		`, bookID).Scan(&metadata.Title, &metadata.Author, &isbn, &publishDate, &description)
+		if err != nil {
+			return metadata, err
+		}
 
-	err := db.QueryRow(`
-		SELECT title, author, isbn, publish_date, description 
-		FROM books 
-		WHERE id = ?
-	`, bookID).Scan(&title, &author, &isbn, &publishDate, &description)
+		metadata.ISBN = nullStringPtr(isbn)
+		metadata.PublishDate = nullTimePtr(publishDate)
+		metadata.Description = nullStringPtr(description)
+		return metadata, nil
+	})
 
 	if err != nil {
 		log.Printf("Error fetching book metadata for ID %s: %v", bookID, err)
-		return map[string]interface{}{
-			"error": "Failed to fetch book metadata",
-		}
 	}
+	return metadata, err
+}
 
-	return map[string]interface{}{
-		"title":        title,
-		"author":       author,
-		"isbn":         isbn,
-		"publish_date": publishDate,
-		"description":  description,
-	}
+// FetchBookPricing retrieves pricing information from the pricing table through
+// bookRepository, at most once per request when the context carries a request cache
+func FetchBookPricing(ctx context.Context, bookID string) (BookPricing, error) {
+	return requestCached(ctx, "pricing", bookID, func() (BookPricing, error) {
+		return bookRepository.Pricing(ctx, bookID)
+	})
 }
 
-// FetchBookPricing retrieves pricing information from the pricing table
-func FetchBookPricing(bookID string) map[string]interface{} {
-	var price, discount, salePrice float64
-	var currency, promotion string
+// readBookPricing queries the pricing row behind FetchBookPricing
+func readBookPricing(ctx context.Context, bookID string) (BookPricing, error) {
+	if err := acquireDatabaseSlot(ctx, bookID); err != nil {
+		return BookPricing{}, err
+	}
+	defer dbBulkhead.Release()
+
+	pricing, err := hedgedRead(ctx, "pricing", func(ctx context.Context) (BookPricing, error) {
+		var pricing BookPricing
+		var salePrice sql.NullFloat64
+		var promotion sql.NullString
+
+		err := db.QueryRowContext(ctx, `
+			SELECT price, currency, discount, sale_price, promotion 
+			FROM pricing 
+			WHERE book_id = ?
+		`, bookID).Scan(&pricing.Price, &pricing.Currency, &pricing.Discount, &salePrice, &promotion)
+		if err != nil {
+			return pricing, err
+		}
 
-	err := db.QueryRow(`
-		SELECT price, currency, discount, sale_price, promotion 
-		FROM pricing 
-		WHERE book_id = ?
-	`, bookID).Scan(&price, &currency, &discount, &salePrice, &promotion)
+		if salePrice.Valid {
+			pricing.SalePrice = &salePrice.Float64
+		}
+		pricing.Promotion = nullStringPtr(promotion)
+		return pricing, nil
+	})
 
 	if err != nil {
 		log.Printf("Error fetching book pricing for ID %s: %v", bookID, err)
-		return map[string]interface{}{
-			"error": "Failed to fetch pricing information",
-		}
 	}
+	return pricing, err
+}
 
-	return map[string]interface{}{
-		"price":      price,
-		"currency":   currency,
-		"discount":   discount,
-		"sale_price": salePrice,
-		"promotion":  promotion,
-	}
+// FetchBookInventory retrieves inventory status from the inventory table through
+// bookRepository, at most once per request when the context carries a request cache
+func FetchBookInventory(ctx context.Context, bookID string) (BookInventory, error) {
+	return requestCached(ctx, "inventory", bookID, func() (BookInventory, error) {
+		return bookRepository.Inventory(ctx, bookID)
+	})
 }
 
-// FetchBookInventory retrieves inventory status from the inventory table
-func FetchBookInventory(bookID string) map[string]interface{} {
-	var inStock bool
-	var quantity int
-	var warehouse, shippingTime string
+// readBookInventory queries the inventory row behind FetchBookInventory
+func readBookInventory(ctx context.Context, bookID string) (BookInventory, error) {
+	if err := acquireDatabaseSlot(ctx, bookID); err != nil {
+		return BookInventory{}, err
+	}
+	defer dbBulkhead.Release()
 
-	err := db.QueryRow(`
-		SELECT in_stock, quantity, warehouse, shipping_time 
-		FROM inventory 
-		WHERE book_id = ?
-	`, bookID).Scan(&inStock, &quantity, &warehouse, &shippingTime)
+	inventory, err := hedgedRead(ctx, "inventory", func(ctx context.Context) (BookInventory, error) {
+		var inventory BookInventory
+		var warehouse sql.NullString
+
+		err := db.QueryRowContext(ctx, `
+			SELECT in_stock, quantity, warehouse 
+			FROM inventory 
+			WHERE book_id = ?
+		`, bookID).Scan(&inventory.InStock, &inventory.Quantity, &warehouse)
+		if err != nil {
+			return inventory, err
+		}
+
+		// The shipping_time column is no longer read; the estimate is computed from the warehouse
+		inventory.Warehouse = nullStringPtr(warehouse)
+		shippingTime := ShippingTimeSummary(warehouse.String, !inventory.InStock || inventory.Quantity <= 0)
+		inventory.ShippingTime = &shippingTime
+		return inventory, nil
+	})
 
 	if err != nil {
 		log.Printf("Error fetching book inventory for ID %s: %v", bookID, err)
-		return map[string]interface{}{
-			"error": "Failed to fetch inventory information",
-		}
 	}
+	return inventory, err
+}
 
-	return map[string]interface{}{
-		"in_stock":      inStock,
-		"quantity":      quantity,
-		"warehouse":     warehouse,
-		"shipping_time": shippingTime,
-	}
+// FetchBookReviews retrieves customer review data from the reviews table through
+// bookRepository, at most once per request when the context carries a request cache
+func FetchBookReviews(ctx context.Context, bookID string) (BookReviews, error) {
+	return requestCached(ctx, "reviews", bookID, func() (BookReviews, error) {
+		return bookRepository.Reviews(ctx, bookID)
+	})
 }
 
-// FetchBookReviews retrieves customer review data from the reviews table
-func FetchBookReviews(bookID string) map[string]interface{} {
-	var averageRating float64
-	var totalReviews, fiveStar, fourStar, threeStar, twoStar, oneStar int
-	var recentReview string
+// readBookReviews queries the reviews row behind FetchBookReviews
+func readBookReviews(ctx context.Context, bookID string) (BookReviews, error) {
+	if err := acquireDatabaseSlot(ctx, bookID); err != nil {
+		return BookReviews{}, err
+	}
+	defer dbBulkhead.Release()
+
+	reviews, err := hedgedRead(ctx, "reviews", func(ctx context.Context) (BookReviews, error) {
+		var reviews BookReviews
+		var averageRating sql.NullFloat64
+		var recentReview sql.NullString
+		breakdown := &reviews.RatingBreakdown
+
+		err := db.QueryRowContext(ctx, `
+			SELECT average_rating, total_reviews, recent_review, five_star, four_star, three_star, two_star, one_star 
+			FROM reviews 
+			WHERE book_id = ?
+		`, bookID).Scan(&averageRating, &reviews.TotalReviews, &recentReview,
+			&breakdown.FiveStar, &breakdown.FourStar, &breakdown.ThreeStar, &breakdown.TwoStar, &breakdown.OneStar)
+		if err != nil {
+			return reviews, err
+		}
 
-	err := db.QueryRow(`
-		SELECT average_rating, total_reviews, recent_review, five_star, four_star, three_star, two_star, one_star 
-		FROM reviews 
-		WHERE book_id = ?
-	`, bookID).Scan(&averageRating, &totalReviews, &recentReview, &fiveStar, &fourStar, &threeStar, &twoStar, &oneStar)
+		if averageRating.Valid {
+			reviews.AverageRating = &averageRating.Float64
+		}
+		reviews.RecentReview = nullStringPtr(recentReview)
+		return reviews, nil
+	})
 
 	if err != nil {
 		log.Printf("Error fetching book reviews for ID %s: %v", bookID, err)
-		return map[string]interface{}{
-			"error": "Failed to fetch reviews",
-		}
 	}
+	return reviews, err
+}
 
-	return map[string]interface{}{
-		"average_rating": averageRating,
-		"total_reviews":  totalReviews,
-		"recent_review":  recentReview,
-		"rating_breakdown": map[string]int{
-			"5_star": fiveStar,
-			"4_star": fourStar,
-			"3_star": threeStar,
-			"2_star": twoStar,
-			"1_star": oneStar,
-		},
+// nullStringPtr maps NULL and empty strings to nil so typed responses can emit explicit nulls
+func nullStringPtr(value sql.NullString) *string {
+	if !value.Valid || value.String == "" {
+		return nil
 	}
+	return &value.String
 }
 
-// FetchPersonalizedRecommendations - Simple external API call example
-func FetchPersonalizedRecommendations(bookID string, userID string) map[string]interface{} {
-	// Step 1: Make a simple external API call to get a random quote
-	response, err := httpClient.Get("https://zenquotes.io/api/random")
-
-	// Step 2: Handle network errors
-	if err != nil {
-		log.Printf("Error calling external API: %v", err)
-		return map[string]interface{}{
-			"error":  "Failed to fetch recommendations",
-			"source": "external_api_failed",
-		}
+// nullTimePtr maps NULL times to nil
+func nullTimePtr(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
 	}
-	defer response.Body.Close() // Always close the response body!
+	return &value.Time
+}
 
-	// Step 3: Parse the JSON response
-	var quoteData []map[string]interface{}
-	err = json.NewDecoder(response.Body).Decode(&quoteData)
+// FetchBookLastModified returns the most recent update time across a book's metadata, pricing,
+// inventory, reviews and translation rows, so conditional requests can be answered without loading them
+func FetchBookLastModified(ctx context.Context, bookID string) (time.Time, error) {
+	var lastModified sql.NullString
+
+	err := db.QueryRowContext(ctx, `
+		SELECT MAX(modified_at) FROM (
+			SELECT COALESCE(updated_at, created_at) AS modified_at FROM books WHERE id = ?
+			UNION ALL SELECT updated_at FROM pricing WHERE book_id = ?
+			UNION ALL SELECT COALESCE(updated_at, last_restocked) FROM inventory WHERE book_id = ?
+			UNION ALL SELECT updated_at FROM reviews WHERE book_id = ?
+			UNION ALL SELECT MAX(updated_at) FROM book_translations WHERE book_id = ?
+		)
+	`, bookID, bookID, bookID, bookID, bookID).Scan(&lastModified)
 	if err != nil {
-		log.Printf("Error parsing API response: %v", err)
-		return map[string]interface{}{
-			"error": "Failed to parse API response",
-		}
+		return time.Time{}, err
 	}
+	if !lastModified.Valid {
+		return time.Time{}, sql.ErrNoRows
+	}
+	return parseSQLiteTimestamp(lastModified.String)
+}
 
-	// Step 4: Use the external data in your response
-	return map[string]interface{}{
-		"user_id":        userID,
-		"book_id":        bookID,
-		"external_quote": quoteData, // This is real data from the external API!
-		"recommendations": []map[string]interface{}{
-			{
-				"title":  "Based on your reading preferences...",
-				"source": "external_api_enriched",
-			},
-		},
-		"api_source": "zenquotes.io",
+// parseSQLiteTimestamp parses the timestamp formats SQLite and the driver write (always UTC here)
+func parseSQLiteTimestamp(value string) (time.Time, error) {
+	layouts := []string{
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02T15:04:05.999999999Z07:00",
+		"2006-01-02T15:04:05Z",
+		"2006-01-02",
+	}
+	for _, layout := range layouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.UTC(), nil
+		}
 	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
 }
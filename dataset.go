@@ -0,0 +1,49 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The sample catalog, compiled into the binary so a fresh or in-memory database can be
+// populated without any files next to it
+//
+//go:embed data/seed.json
+var seedDataset []byte
+
+// Tables in the seed dataset, in insert order so every book exists before the rows that
+// reference it
+var seedTables = []string{"books", "pricing", "inventory", "reviews"}
+
+// populateInitialData inserts the embedded sample data into all tables. Existing rows are left
+// alone, so running it against a partly populated database only fills the gaps.
+func populateInitialData() error {
+	var dataset map[string][]map[string]interface{}
+	if err := json.Unmarshal(seedDataset, &dataset); err != nil {
+		return fmt.Errorf("embedded seed dataset: %w", err)
+	}
+
+	for _, table := range seedTables {
+		for _, row := range dataset[table] {
+			columns := make([]string, 0, len(row))
+			for column := range row {
+				columns = append(columns, column)
+			}
+			slices.Sort(columns)
+
+			values := make([]interface{}, len(columns))
+			for i, column := range columns {
+				values[i] = row[column]
+			}
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+			query := fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)
+			if _, err := db.Exec(query, values...); err != nil {
+				return fmt.Errorf("seeding %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// decodeJSONBody decodes the request body into value. On failure it writes the error and
+// returns false: 413 when the body went over its size limit, otherwise a 400 problem listing
+// what is wrong with which field.
+//
+// With BOOKSTORE_STRICT_JSON (the default), fields the target doesn't have and anything after
+// the JSON value are rejected, so a typo like "ratng" fails loudly instead of saving a row
+// with the field left empty. Nesting is always limited to BOOKSTORE_JSON_MAX_DEPTH.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeBodyTooLarge(w, r, tooLarge.Limit)
+		return false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Failed to read request body")
+		return false
+	}
+
+	if fieldErr := decodeJSONBytes(body, value); fieldErr != nil {
+		writeInvalidBody(w, r, []FieldError{*fieldErr})
+		return false
+	}
+	return true
+}
+
+// decodeJSONBytes decodes body into value under the configured rules, describing the first
+// problem found
+func decodeJSONBytes(body []byte, value interface{}) *FieldError {
+	if depth := jsonDepth(body); depth > config.JSONMaxDepth {
+		return &FieldError{Message: fmt.Sprintf("JSON is nested %d levels deep; at most %d are allowed", depth, config.JSONMaxDepth)}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if config.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(value); err != nil {
+		return describeDecodeError(err)
+	}
+	if config.StrictJSON {
+		if err := decoder.Decode(&json.RawMessage{}); err != io.EOF {
+			return &FieldError{Message: "Unexpected data after the JSON value"}
+		}
+	}
+	return nil
+}
+
+// describeDecodeError turns an encoding/json error into a message about the offending field
+func describeDecodeError(err error) *FieldError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return &FieldError{Message: "Request body is empty"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &FieldError{Message: "Request body ends in the middle of a JSON value"}
+	case errors.As(err, &syntaxErr):
+		return &FieldError{Message: fmt.Sprintf("Malformed JSON at byte %d", syntaxErr.Offset)}
+	case errors.As(err, &typeErr):
+		return &FieldError{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be %s, got %s", jsonTypeName(typeErr.Type), typeErr.Value),
+		}
+	}
+
+	// Unknown fields have no error type of their own: `json: unknown field "ratng"`
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return &FieldError{Field: strings.Trim(name, `"`), Message: "is not a recognized field"}
+	}
+	return &FieldError{Message: "Invalid JSON body"}
+}
+
+// jsonTypeName describes a Go type by the JSON value it expects
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a " + t.String()
+}
+
+// jsonDepth returns the deepest nesting of objects and arrays in body, ignoring brackets inside
+// strings. It doesn't validate; the decoder does that.
+func jsonDepth(body []byte) int {
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, c := range body {
+		switch {
+		case inString && escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case inString && c == '"':
+			inString = false
+		case inString:
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			deepest = max(deepest, depth)
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return deepest
+}
+
+// writeInvalidBody sends a 400 problem listing the field errors
+func writeInvalidBody(w http.ResponseWriter, r *http.Request, fieldErrors []FieldError) {
+	messages := make([]string, len(fieldErrors))
+	for i, fieldErr := range fieldErrors {
+		messages[i] = fieldErr.Message
+		if fieldErr.Field != "" {
+			messages[i] = fieldErr.Field + ": " + fieldErr.Message
+		}
+	}
+	writeProblem(w, r, problemDetails{
+		Title:  "Invalid request body",
+		Status: http.StatusBadRequest,
+		Detail: strings.Join(messages, "; "),
+		Errors: fieldErrors,
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of most recent calls the error rate is computed over
+const healthWindowSize = 100
+
+// healthTracker follows the outcomes of calls to one dependency
+type healthTracker struct {
+	consecutiveFailures int
+	lastSuccess         *time.Time
+	lastFailure         *time.Time
+	lastError           string
+
+	recent     [healthWindowSize]bool // true for failures, written round-robin
+	recentNext int
+	recentLen  int
+}
+
+// record adds one call outcome
+func (t *healthTracker) record(err error) {
+	now := clock.Now()
+	if err != nil {
+		t.consecutiveFailures++
+		t.lastFailure = &now
+		t.lastError = err.Error()
+	} else {
+		t.consecutiveFailures = 0
+		t.lastSuccess = &now
+	}
+
+	t.recent[t.recentNext] = err != nil
+	t.recentNext = (t.recentNext + 1) % healthWindowSize
+	t.recentLen = min(t.recentLen+1, healthWindowSize)
+}
+
+// errorRate is the fraction of failed calls in the recent window, 0 before any calls
+func (t *healthTracker) errorRate() float64 {
+	if t.recentLen == 0 {
+		return 0
+	}
+	failures := 0
+	for i := 0; i < t.recentLen; i++ {
+		if t.recent[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(t.recentLen)
+}
+
+// healthy applies the same rule to every dependency: a run of failures marks it unhealthy
+func (t *healthTracker) healthy() bool {
+	return t.consecutiveFailures < providerUnhealthyThreshold
+}
+
+// databaseHealth tracks catalog queries made for book details
+var databaseHealth = struct {
+	sync.Mutex
+	tracker healthTracker
+}{}
+
+// recordDatabaseResult updates database health after a catalog query. Missing rows are a normal
+// answer and a client hanging up says nothing about the database, so neither counts.
+func recordDatabaseResult(err error) {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	databaseHealth.Lock()
+	databaseHealth.tracker.record(err)
+	databaseHealth.Unlock()
+}
+
+// DependencyStatus is the state of one dependency in GET /api/admin/dependencies
+type DependencyStatus struct {
+	Name                string        `json:"name"`
+	Kind                string        `json:"kind"` // "database" or "recommendation_provider"
+	Healthy             bool          `json:"healthy"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	ErrorRate           float64       `json:"error_rate"`   // Over the recent calls
+	RecentCalls         int           `json:"recent_calls"` // Up to 100
+	LastSuccess         *time.Time    `json:"last_success"`
+	LastFailure         *time.Time    `json:"last_failure"`
+	LastError           string        `json:"last_error,omitempty"`
+	PingMs              *float64      `json:"ping_ms,omitempty"` // Database only, measured for this request
+	Bulkhead            *BulkheadStat `json:"bulkhead,omitempty"`
+}
+
+// DependenciesResponse is the body of GET /api/admin/dependencies
+type DependenciesResponse struct {
+	Status       string             `json:"status"` // "ok", or "degraded" when any dependency is unhealthy
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+// Time allowed for the live database ping
+const dependencyPingTimeout = time.Second
+
+// DependenciesHandler handles GET /api/admin/dependencies
+func DependenciesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	bulkheadsByName := map[string]*BulkheadStat{}
+	for _, stat := range BulkheadStats() {
+		stat := stat
+		bulkheadsByName[stat.Name] = &stat
+	}
+
+	response := DependenciesResponse{Status: "ok"}
+	response.Dependencies = append(response.Dependencies, databaseStatus(r.Context(), bulkheadsByName["database"]))
+	response.Dependencies = append(response.Dependencies, providerStatuses(bulkheadsByName["external_api"])...)
+
+	for _, dependency := range response.Dependencies {
+		if !dependency.Healthy {
+			response.Status = "degraded"
+		}
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// databaseStatus combines tracked query outcomes with a live ping
+func databaseStatus(ctx context.Context, bulkhead *BulkheadStat) DependencyStatus {
+	databaseHealth.Lock()
+	status := newDependencyStatus("sqlite", "database", &databaseHealth.tracker)
+	databaseHealth.Unlock()
+	status.Bulkhead = bulkhead
+
+	ctx, cancel := context.WithTimeout(ctx, dependencyPingTimeout)
+	defer cancel()
+	startTime := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		status.Healthy = false
+		status.LastError = "ping: " + err.Error()
+		return status
+	}
+	pingMs := milliseconds(time.Since(startTime))
+	status.PingMs = &pingMs
+	return status
+}
+
+// providerStatuses reports every configured recommendation provider, including ones not called yet
+func providerStatuses(bulkhead *BulkheadStat) []DependencyStatus {
+	providerHealth.Lock()
+	defer providerHealth.Unlock()
+
+	var statuses []DependencyStatus
+	for _, provider := range recommendationProviders {
+		tracker, ok := providerHealth.byName[provider.Name()]
+		if !ok {
+			tracker = &healthTracker{}
+		}
+		status := newDependencyStatus(provider.Name(), "recommendation_provider", tracker)
+		status.Bulkhead = bulkhead
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// newDependencyStatus snapshots a tracker; the caller holds its lock
+func newDependencyStatus(name, kind string, tracker *healthTracker) DependencyStatus {
+	return DependencyStatus{
+		Name:                name,
+		Kind:                kind,
+		Healthy:             tracker.healthy(),
+		ConsecutiveFailures: tracker.consecutiveFailures,
+		ErrorRate:           tracker.errorRate(),
+		RecentCalls:         tracker.recentLen,
+		LastSuccess:         tracker.lastSuccess,
+		LastFailure:         tracker.lastFailure,
+		LastError:           tracker.lastError,
+	}
+}
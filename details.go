@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"time"
+)
+
+// sectionResult is the outcome of loading one section of a book's details
+type sectionResult[T any] struct {
+	Data      T
+	Err       error
+	Source    string    // Where the data came from: "database", "cache", "stale_cache" or a provider's API host
+	FetchedAt time.Time // When the data was read from its source
+	Stale     bool      // Served from cache past its TTL because the source failed
+
+	// Wall-clock timing of the fetch, for the deadline budget report
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+// Section statuses reported for partial-failure handling
+const (
+	sectionOK       = "ok"        // Fresh data from the source
+	sectionNotFound = "not_found" // The source has no row for this book
+	sectionTimeout  = "timeout"   // The source didn't answer within the request budget
+	sectionDegraded = "degraded"  // Stale data, or the source failed for another reason
+)
+
+// Status classifies the section's outcome as one of the section* constants
+func (s sectionResult[T]) Status() string {
+	var netErr net.Error
+	switch {
+	case s.Err == nil && s.Stale:
+		return sectionDegraded
+	case s.Err == nil:
+		return sectionOK
+	case errors.Is(s.Err, sql.ErrNoRows):
+		return sectionNotFound
+	case errors.Is(s.Err, context.DeadlineExceeded),
+		errors.Is(s.Err, errUpstreamBudgetExhausted),
+		errors.As(s.Err, &netErr) && netErr.Timeout():
+		return sectionTimeout
+	default:
+		return sectionDegraded
+	}
+}
+
+// bookDetails holds every section of a book's details, independent of response format
+type bookDetails struct {
+	Metadata        sectionResult[BookMetadata]
+	Pricing         sectionResult[BookPricing]
+	Inventory       sectionResult[BookInventory]
+	Reviews         sectionResult[BookReviews]
+	Recommendations sectionResult[Recommendations]
+}
+
+// databaseSection wraps the result of a database fetch as a section
+func databaseSection[T any](data T, err error) sectionResult[T] {
+	return sectionResult[T]{Data: data, Err: err, Source: "database", FetchedAt: clock.Now()}
+}
+
+// fetchDatabaseSection runs one database fetch, recording how long it took and whether it failed
+func fetchDatabaseSection[T any](ctx context.Context, bookID string, fetch func(context.Context, string) (T, error)) sectionResult[T] {
+	startedAt := time.Now()
+	section := databaseSection(fetch(ctx, bookID))
+	section.StartedAt, section.Duration = startedAt, time.Since(startedAt)
+	recordDatabaseResult(section.Err)
+	if section.Status() == sectionTimeout {
+		noteServerTimeout(ctx)
+	}
+	return section
+}
+
+// fetchRecommendationsSection loads recommendations and records how long it took
+func fetchRecommendationsSection(ctx context.Context, bookID, userID string) sectionResult[Recommendations] {
+	startedAt := time.Now()
+	section := FetchPersonalizedRecommendations(ctx, bookID, userID)
+	section.StartedAt, section.Duration = startedAt, time.Since(startedAt)
+	if section.Status() == sectionTimeout {
+		noteServerTimeout(ctx)
+	}
+	return section
+}
+
+// loadBookDetails loads every section using the given coordination mode ("sequential" or "concurrent")
+func loadBookDetails(ctx context.Context, mode, bookID, userID string) bookDetails {
+	if mode == "concurrent" {
+		return loadBookDetailsConcurrent(ctx, bookID, userID)
+	}
+	return loadBookDetailsSequential(ctx, bookID, userID)
+}
+
+// loadBookDetailsSequential processes database queries and external API calls one after another
+func loadBookDetailsSequential(ctx context.Context, bookID, userID string) bookDetails {
+	// Sequential approach: call each operation one at a time
+	return bookDetails{
+		Metadata:        fetchDatabaseSection(ctx, bookID, FetchBookMetadata),
+		Pricing:         fetchDatabaseSection(ctx, bookID, FetchBookPricing),
+		Inventory:       fetchDatabaseSection(ctx, bookID, FetchBookInventory),
+		Reviews:         fetchDatabaseSection(ctx, bookID, FetchBookReviews),
+		Recommendations: fetchRecommendationsSection(ctx, bookID, userID), // This one calls external API!
+	}
+}
+
+// loadBookDetailsConcurrent processes database queries and external API calls concurrently using goroutines
+func loadBookDetailsConcurrent(ctx context.Context, bookID, userID string) bookDetails {
+	// The external call is the slow one, so start it first and overlap the database work with it
+	startedAt := time.Now()
+	recommendationsCtx, cancel := sectionContext(ctx, "recommendations")
+	defer cancel()
+	recommendationsChannel := make(chan sectionResult[Recommendations], 1)
+	go func() {
+		recommendationsChannel <- fetchRecommendationsSection(recommendationsCtx, bookID, userID) // This one calls external API!
+	}()
+
+	details := loadCatalogSectionsConcurrent(ctx, bookID)
+	details.Recommendations = receiveSection(recommendationsCtx, recommendationsChannel, startedAt)
+	return details
+}
+
+// loadCatalogSectionsConcurrent loads the four database sections concurrently, leaving
+// Recommendations empty, for callers that only need catalog data
+func loadCatalogSectionsConcurrent(ctx context.Context, bookID string) bookDetails {
+	// Each channel holds its one result, so a goroutine can always deliver and exit even when
+	// the collector below has given up on it
+	startedAt := time.Now()
+	metadataCtx, cancelMetadata := sectionContext(ctx, "metadata")
+	defer cancelMetadata()
+	pricingCtx, cancelPricing := sectionContext(ctx, "pricing")
+	defer cancelPricing()
+	inventoryCtx, cancelInventory := sectionContext(ctx, "inventory")
+	defer cancelInventory()
+	reviewsCtx, cancelReviews := sectionContext(ctx, "reviews")
+	defer cancelReviews()
+
+	metadataChannel := make(chan sectionResult[BookMetadata], 1)
+	pricingChannel := make(chan sectionResult[BookPricing], 1)
+	inventoryChannel := make(chan sectionResult[BookInventory], 1)
+	reviewsChannel := make(chan sectionResult[BookReviews], 1)
+
+	// Launch concurrent goroutines for each operation
+	go func() {
+		metadataChannel <- fetchDatabaseSection(metadataCtx, bookID, FetchBookMetadata)
+	}()
+
+	go func() {
+		pricingChannel <- fetchDatabaseSection(pricingCtx, bookID, FetchBookPricing)
+	}()
+
+	go func() {
+		inventoryChannel <- fetchDatabaseSection(inventoryCtx, bookID, FetchBookInventory)
+	}()
+
+	go func() {
+		reviewsChannel <- fetchDatabaseSection(reviewsCtx, bookID, FetchBookReviews)
+	}()
+
+	// Collect results from all channels (fan-in coordination), giving up on any section
+	// still running once its own deadline or the request's has passed
+	return bookDetails{
+		Metadata:  receiveSection(metadataCtx, metadataChannel, startedAt),
+		Pricing:   receiveSection(pricingCtx, pricingChannel, startedAt),
+		Inventory: receiveSection(inventoryCtx, inventoryChannel, startedAt),
+		Reviews:   receiveSection(reviewsCtx, reviewsChannel, startedAt),
+	}
+}
+
+// sectionContext bounds one section by its configured timeout, if it has one; the request
+// deadline still applies when it is sooner
+func sectionContext(ctx context.Context, section string) (context.Context, context.CancelFunc) {
+	if timeout, ok := config.SectionTimeouts[section]; ok {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// receiveSection waits for a section started at startedAt. A result that has already arrived
+// is always taken; otherwise, once ctx is done, the section is reported as failed with the
+// context's error and its goroutine is left to finish into the buffered channel.
+func receiveSection[T any](ctx context.Context, results <-chan sectionResult[T], startedAt time.Time) sectionResult[T] {
+	select {
+	case section := <-results:
+		return section
+	default:
+	}
+
+	select {
+	case section := <-results:
+		return section
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			noteServerTimeout(ctx)
+		}
+		return sectionResult[T]{Err: ctx.Err(), StartedAt: startedAt, Duration: time.Since(startedAt)}
+	}
+}
+
+// statuses returns the status of every section keyed by section name
+func (d bookDetails) statuses() map[string]string {
+	return map[string]string{
+		"metadata":        d.Metadata.Status(),
+		"pricing":         d.Pricing.Status(),
+		"inventory":       d.Inventory.Status(),
+		"reviews":         d.Reviews.Status(),
+		"recommendations": d.Recommendations.Status(),
+	}
+}
+
+// overallStatus is "ok" when every section is ok, "not_found" when the book itself doesn't
+// exist, and "partial" otherwise
+func (d bookDetails) overallStatus() string {
+	if d.Metadata.Status() == sectionNotFound {
+		return sectionNotFound
+	}
+	for _, status := range d.statuses() {
+		if status != sectionOK {
+			return "partial"
+		}
+	}
+	return sectionOK
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// blockingRecommendationProvider reports on started when called, then waits for its context
+type blockingRecommendationProvider struct {
+	name    string
+	started chan<- struct{}
+}
+
+func (p blockingRecommendationProvider) Name() string { return p.name }
+
+func (p blockingRecommendationProvider) Fetch(ctx context.Context, bookID, userID string) (Recommendations, error) {
+	p.started <- struct{}{}
+	<-ctx.Done()
+	return Recommendations{}, ctx.Err()
+}
+
+func TestLoadBookDetailsConcurrentCancelLeavesNoGoroutines(t *testing.T) {
+	started := make(chan struct{}, 2)
+	newTestServer(t,
+		blockingRecommendationProvider{name: "slow-a", started: started},
+		blockingRecommendationProvider{name: "slow-b", started: started},
+	)
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bookDetails, 1)
+	go func() {
+		// A user of its own, so no earlier test's cached recommendations answer instead
+		done <- loadBookDetailsConcurrent(ctx, "1", "goroutine-leak-test")
+	}()
+
+	// Cancel once both providers are stuck mid fan-in
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("recommendation providers were never called")
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loadBookDetailsConcurrent did not return after its context was cancelled")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines still running after cancel, %d before:\n%s",
+				runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
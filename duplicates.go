@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// errISBNMismatch means two books picked for merging don't share an ISBN
+var errISBNMismatch = errors.New("books have different ISBNs")
+
+// normalizeISBN reduces an ISBN to its 13-digit form without separators, so "978-0-13-235088-4",
+// "9780132350884" and the ISBN-10 "0132350882" all compare equal. Values that aren't an ISBN
+// come back with only separators removed.
+func normalizeISBN(isbn string) string {
+	var digits strings.Builder
+	for _, c := range strings.ToUpper(isbn) {
+		if (c >= '0' && c <= '9') || c == 'X' {
+			digits.WriteRune(c)
+		}
+	}
+	normalized := digits.String()
+	if len(normalized) != 10 {
+		return normalized
+	}
+
+	// ISBN-10 becomes 978 + its first nine digits + a new ISBN-13 check digit
+	normalized = "978" + normalized[:9]
+	sum := 0
+	for i, c := range normalized {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(c-'0') * weight
+	}
+	return normalized + string(rune('0'+(10-sum%10)%10))
+}
+
+// DuplicateBook is one book in a duplicate group
+type DuplicateBook struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	ISBN   string `json:"isbn"` // As stored
+}
+
+// DuplicateGroup is a set of books whose ISBNs are the same once normalized
+type DuplicateGroup struct {
+	ISBN  string          `json:"isbn"` // Normalized ISBN-13
+	Books []DuplicateBook `json:"books"`
+}
+
+// FindDuplicateISBNs groups books sharing a normalized ISBN. The UNIQUE constraint on books.isbn
+// only catches exact matches; this finds the same ISBN stored with different hyphenation or
+// in its ISBN-10 form.
+func FindDuplicateISBNs(ctx context.Context) ([]DuplicateGroup, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, title, author, isbn FROM books WHERE isbn IS NOT NULL AND isbn != '' ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byISBN := map[string][]DuplicateBook{}
+	for rows.Next() {
+		var book DuplicateBook
+		if err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.ISBN); err != nil {
+			return nil, err
+		}
+		normalized := normalizeISBN(book.ISBN)
+		byISBN[normalized] = append(byISBN[normalized], book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	groups := []DuplicateGroup{}
+	for isbn, books := range byISBN {
+		if len(books) > 1 {
+			groups = append(groups, DuplicateGroup{ISBN: isbn, Books: books})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ISBN < groups[j].ISBN })
+	return groups, nil
+}
+
+// MergeResult reports what a merge moved
+type MergeResult struct {
+	Into       string         `json:"into"`
+	MergedFrom string         `json:"merged_from"`
+	Moved      map[string]int `json:"moved"` // Rows re-pointed per table
+}
+
+// MergeBooks folds the duplicate book source into target in one transaction and deletes source.
+// Target's pricing wins. Stock is added together, since both rows counted real copies. Review
+// aggregates are combined, less the source ratings of users who rated both books; their
+// rating on target is the one kept. Ratings, reading list entries, translations, restock
+// history and purchase orders move over unless target already has the same one. Embeddings
+// and processing state of source are derived data and are dropped. Unless force is set, both
+// books must share a normalized ISBN.
+func MergeBooks(ctx context.Context, source, target string, force bool) (MergeResult, error) {
+	result := MergeResult{Into: target, MergedFrom: source, Moved: map[string]int{}}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	isbns := map[string]string{}
+	for _, bookID := range []string{source, target} {
+		var isbn sql.NullString
+		if err := tx.QueryRowContext(ctx, "SELECT isbn FROM books WHERE id = ?", bookID).Scan(&isbn); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return result, errBookNotFound
+			}
+			return result, err
+		}
+		isbns[bookID] = normalizeISBN(isbn.String)
+	}
+	if !force && (isbns[source] == "" || isbns[source] != isbns[target]) {
+		return result, errISBNMismatch
+	}
+
+	if err := mergeReviews(ctx, tx, source, target); err != nil {
+		return result, err
+	}
+
+	// One-row-per-book tables: move source's row only when target has none
+	for _, table := range []string{"pricing", "inventory"} {
+		moved, err := execCount(ctx, tx, "UPDATE OR IGNORE "+table+" SET book_id = ? WHERE book_id = ?", target, source)
+		if err != nil {
+			return result, err
+		}
+		result.Moved[table] = moved
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE inventory SET
+			quantity = quantity + (SELECT quantity FROM inventory WHERE book_id = ?),
+			in_stock = in_stock OR (SELECT in_stock FROM inventory WHERE book_id = ?),
+			updated_at = ?
+		WHERE book_id = ? AND EXISTS (SELECT 1 FROM inventory WHERE book_id = ?)
+	`, source, source, dbNow(), target, source); err != nil {
+		return result, err
+	}
+
+	// Many-rows-per-book tables: move what doesn't collide with a row target already has
+	for _, table := range []string{"book_ratings", "reading_list_items", "book_translations", "restock_events", "purchase_orders", "regional_prices", "regional_restrictions", "recently_viewed"} {
+		moved, err := execCount(ctx, tx, "UPDATE OR IGNORE "+table+" SET book_id = ? WHERE book_id = ?", target, source)
+		if err != nil {
+			return result, err
+		}
+		result.Moved[table] = moved
+	}
+
+	// Whatever is left on source is either superseded by target or derived data
+	for _, table := range []string{"pricing", "inventory", "reviews", "book_ratings", "reading_list_items", "book_translations", "restock_events", "purchase_orders", "regional_prices", "regional_restrictions", "recently_viewed", "book_embeddings", "book_processing"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE book_id = ?", source); err != nil {
+			return result, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM books WHERE id = ?", source); err != nil {
+		return result, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE books SET updated_at = ? WHERE id = ?", dbNow(), target); err != nil {
+		return result, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	invalidateBookRepository(source)
+	invalidateBookRepository(target)
+	return result, nil
+}
+
+// mergeReviews adds source's review aggregate into target's, before ratings move
+func mergeReviews(ctx context.Context, tx *sql.Tx, source, target string) error {
+	var sourceRow int
+	err := tx.QueryRowContext(ctx, "SELECT 1 FROM reviews WHERE book_id = ?", source).Scan(&sourceRow)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO reviews (book_id) VALUES (?)", target); err != nil {
+		return err
+	}
+
+	// Users who rated both books are counted once, with their rating of target
+	overlap := map[int]int{}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT s.rating, COUNT(*)
+		FROM book_ratings s
+		JOIN book_ratings t ON t.user_id = s.user_id AND t.book_id = ?
+		WHERE s.book_id = ?
+		GROUP BY s.rating
+	`, target, source)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var rating, count int
+		if err := rows.Scan(&rating, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		overlap[rating] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE reviews SET
+			five_star = five_star + MAX((SELECT five_star FROM reviews WHERE book_id = ?) - ?, 0),
+			four_star = four_star + MAX((SELECT four_star FROM reviews WHERE book_id = ?) - ?, 0),
+			three_star = three_star + MAX((SELECT three_star FROM reviews WHERE book_id = ?) - ?, 0),
+			two_star = two_star + MAX((SELECT two_star FROM reviews WHERE book_id = ?) - ?, 0),
+			one_star = one_star + MAX((SELECT one_star FROM reviews WHERE book_id = ?) - ?, 0),
+			total_reviews = total_reviews + MAX((SELECT total_reviews FROM reviews WHERE book_id = ?) - ?, 0),
+			recent_review = COALESCE(recent_review, (SELECT recent_review FROM reviews WHERE book_id = ?))
+		WHERE book_id = ?
+	`, source, overlap[5], source, overlap[4], source, overlap[3], source, overlap[2], source, overlap[1],
+		source, overlap[1]+overlap[2]+overlap[3]+overlap[4]+overlap[5], source, target); err != nil {
+		return err
+	}
+
+	var five, four, three, two, one int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT five_star, four_star, three_star, two_star, one_star FROM reviews WHERE book_id = ?
+	`, target).Scan(&five, &four, &three, &two, &one); err != nil {
+		return err
+	}
+	var average *float64
+	if rated := five + four + three + two + one; rated > 0 {
+		value := math.Round(float64(5*five+4*four+3*three+2*two+one)/float64(rated)*10) / 10
+		average = &value
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE reviews SET average_rating = ?, updated_at = ? WHERE book_id = ?", average, dbNow(), target)
+	return err
+}
+
+// execCount runs a statement and returns how many rows it changed
+func execCount(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// DuplicatesHandler handles GET /api/admin/books/duplicates
+func DuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	groups, err := FindDuplicateISBNs(r.Context())
+	if err != nil {
+		log.Printf("Error finding duplicate ISBNs: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to find duplicates")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, groups)
+}
+
+// MergeHandler handles POST /api/admin/books/{id}/merge with body {"into": "<id>", "force": false},
+// folding book {id} into the book it duplicates
+func MergeHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body struct {
+		Into  string `json:"into"`
+		Force bool   `json:"force"` // Merge even though the ISBNs differ
+	}
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if body.Into == "" || body.Into == bookID {
+		writeError(w, r, http.StatusBadRequest, "into must name a different book")
+		return
+	}
+	// Merging changes the target too, so its lock counts as well
+	if !checkEditLock(w, r, body.Into) {
+		return
+	}
+
+	result, err := MergeBooks(r.Context(), bookID, body.Into, body.Force)
+	switch {
+	case errors.Is(err, errBookNotFound):
+		writeError(w, r, http.StatusNotFound, "Book not found")
+		return
+	case errors.Is(err, errISBNMismatch):
+		writeError(w, r, http.StatusConflict, "Books have different ISBNs; set force to merge anyway")
+		return
+	case err != nil:
+		log.Printf("Error merging book %s into %s: %v", bookID, body.Into, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to merge books")
+		return
+	}
+	log.Printf("Merged book %s into %s", bookID, body.Into)
+	writeJSON(w, r, http.StatusOK, result)
+}
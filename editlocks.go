@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// errEditLockHeld means another staff member holds an unexpired lock on the book
+var errEditLockHeld = errors.New("book is locked by another editor")
+
+// errEditLockNotHeld means the caller has no lock on the book to heartbeat
+var errEditLockNotHeld = errors.New("no editing lock held on the book")
+
+const editLockColumns = "book_id, holder, acquired_at, expires_at"
+
+// scanEditLock reads one book_edit_locks row selected with editLockColumns
+func scanEditLock(row interface{ Scan(...interface{}) error }) (EditLock, error) {
+	var lock EditLock
+	err := row.Scan(&lock.BookID, &lock.Holder, &lock.AcquiredAt, &lock.ExpiresAt)
+	return lock, err
+}
+
+// currentEditLock returns the unexpired lock on a book, if any
+func currentEditLock(ctx context.Context, bookID string) (EditLock, bool, error) {
+	lock, err := scanEditLock(db.QueryRowContext(ctx,
+		"SELECT "+editLockColumns+" FROM book_edit_locks WHERE book_id = ? AND expires_at > ?", bookID, dbNow()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return lock, false, nil
+	}
+	return lock, err == nil, err
+}
+
+// acquireEditLock takes the lock on a book for holder, or renews it if holder has it already.
+// The claim is one statement, so two instances racing for a free lock can't both win. When
+// someone else holds it, their lock is returned with errEditLockHeld.
+func acquireEditLock(ctx context.Context, bookID, holder string) (EditLock, error) {
+	var exists int
+	if err := db.QueryRowContext(ctx, "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return EditLock{}, errBookNotFound
+		}
+		return EditLock{}, err
+	}
+
+	now := clock.Now().UTC()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO book_edit_locks (book_id, holder, acquired_at, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(book_id) DO UPDATE SET
+			acquired_at = CASE WHEN holder = excluded.holder THEN acquired_at ELSE excluded.acquired_at END,
+			holder = excluded.holder,
+			expires_at = excluded.expires_at
+		WHERE holder = excluded.holder OR expires_at <= excluded.acquired_at
+	`, bookID, holder, now.Format(sqliteTimestampLayout), now.Add(config.EditLockTTL).Format(sqliteTimestampLayout))
+	if err != nil {
+		return EditLock{}, err
+	}
+
+	lock, err := scanEditLock(db.QueryRowContext(ctx, "SELECT "+editLockColumns+" FROM book_edit_locks WHERE book_id = ?", bookID))
+	if err != nil {
+		return lock, err
+	}
+	if lock.Holder != holder {
+		return lock, errEditLockHeld
+	}
+	return lock, nil
+}
+
+// heartbeatEditLock pushes holder's lock on a book on by another EditLockTTL. A lock that lapsed
+// is renewed as long as nobody else took it in the meantime.
+func heartbeatEditLock(ctx context.Context, bookID, holder string) (EditLock, error) {
+	result, err := db.ExecContext(ctx, "UPDATE book_edit_locks SET expires_at = ? WHERE book_id = ? AND holder = ?",
+		clock.Now().UTC().Add(config.EditLockTTL).Format(sqliteTimestampLayout), bookID, holder)
+	if err != nil {
+		return EditLock{}, err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		if err != nil {
+			return EditLock{}, err
+		}
+		lock, held, err := currentEditLock(ctx, bookID)
+		if err != nil {
+			return lock, err
+		}
+		if held {
+			return lock, errEditLockHeld
+		}
+		return lock, errEditLockNotHeld
+	}
+	return scanEditLock(db.QueryRowContext(ctx, "SELECT "+editLockColumns+" FROM book_edit_locks WHERE book_id = ?", bookID))
+}
+
+// releaseEditLock gives up holder's lock on a book; releasing a lock not held does nothing
+func releaseEditLock(ctx context.Context, bookID, holder string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM book_edit_locks WHERE book_id = ? AND holder = ?", bookID, holder)
+	return err
+}
+
+// editLockConflict is the message for a change refused because of someone else's lock
+func editLockConflict(lock EditLock) string {
+	return fmt.Sprintf("Book is being edited by %s; their lock expires at %s unless renewed",
+		lock.Holder, lock.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// checkEditLock refuses a change to a book with 409 Conflict while someone other than the
+// logged-in user holds its editing lock, and returns false when it has written a response.
+// Taking the lock is optional: books nobody has locked can be changed as before.
+func checkEditLock(w http.ResponseWriter, r *http.Request, bookID string) bool {
+	lock, held, err := currentEditLock(r.Context(), bookID)
+	if err != nil {
+		log.Printf("Error checking editing lock on book %s: %v", bookID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to check editing lock")
+		return false
+	}
+	if !held {
+		return true
+	}
+	if session, ok := SessionFromContext(r.Context()); ok && !session.Anonymous() && session.UserID == lock.Holder {
+		return true
+	}
+	writeError(w, r, http.StatusConflict, editLockConflict(lock))
+	return false
+}
+
+// EditLockHandler handles /api/admin/books/{id}/lock, so two staff members editing the same
+// book find out before their changes collide: GET shows the current lock, POST takes it (409
+// with the holder when someone else has it), PUT heartbeats it and DELETE releases it. Locks
+// belong to the logged-in user and lapse after EditLockTTL without a heartbeat.
+func EditLockHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	if r.Method == http.MethodGet {
+		lock, held, err := currentEditLock(r.Context(), bookID)
+		if err != nil {
+			log.Printf("Error reading editing lock on book %s: %v", bookID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to read editing lock")
+			return
+		}
+		if !held {
+			writeError(w, r, http.StatusNotFound, "Book is not locked")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, r, http.StatusOK, lock)
+		return
+	}
+
+	session, ok := SessionFromContext(r.Context())
+	if !ok || session.Anonymous() {
+		writeError(w, r, http.StatusUnauthorized, "Log in to lock a book for editing")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		var lock EditLock
+		var err error
+		if r.Method == http.MethodPost {
+			lock, err = acquireEditLock(r.Context(), bookID, session.UserID)
+		} else {
+			lock, err = heartbeatEditLock(r.Context(), bookID, session.UserID)
+		}
+		switch {
+		case errors.Is(err, errBookNotFound):
+			writeError(w, r, http.StatusNotFound, "Book not found")
+		case errors.Is(err, errEditLockHeld):
+			writeError(w, r, http.StatusConflict, editLockConflict(lock))
+		case errors.Is(err, errEditLockNotHeld):
+			writeError(w, r, http.StatusNotFound, "You hold no editing lock on this book; POST to take one")
+		case err != nil:
+			log.Printf("Error locking book %s for %s: %v", bookID, session.UserID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to lock book")
+		default:
+			writeJSON(w, r, http.StatusOK, lock)
+		}
+
+	case http.MethodDelete:
+		if err := releaseEditLock(r.Context(), bookID, session.UserID); err != nil {
+			log.Printf("Error releasing editing lock on book %s for %s: %v", bookID, session.UserID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to release editing lock")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
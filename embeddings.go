@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// EmbeddingProvider turns text into vectors whose cosine similarity reflects similarity of meaning.
+// Vectors from one provider are only comparable with each other, so they are stored per provider.
+type EmbeddingProvider interface {
+	Name() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Known embedding providers, selectable by name via BOOKSTORE_EMBEDDING_PROVIDER
+var embeddingProviderRegistry = map[string]func(cfg Config) EmbeddingProvider{
+	"hashing": func(cfg Config) EmbeddingProvider { return hashingEmbedder{dimensions: 256} },
+	"openai": func(cfg Config) EmbeddingProvider {
+		return &openAIEmbedder{baseURL: cfg.EmbeddingURL, model: cfg.EmbeddingModel, apiKey: cfg.EmbeddingAPIKey}
+	},
+}
+
+// Provider used for book description vectors, built from config in main
+var embeddingProvider = NewEmbeddingProvider(config)
+
+// NewEmbeddingProvider instantiates the configured provider, falling back to hashing for unknown names
+func NewEmbeddingProvider(cfg Config) EmbeddingProvider {
+	constructor, ok := embeddingProviderRegistry[cfg.EmbeddingProvider]
+	if !ok {
+		log.Printf("Unknown embedding provider %q, using hashing", cfg.EmbeddingProvider)
+		constructor = embeddingProviderRegistry["hashing"]
+	}
+	return constructor(cfg)
+}
+
+// Books are embedded in batches of this size to keep provider requests small
+const embeddingBatchSize = 32
+
+// errEmbeddingMissing means the book exists but hasn't been embedded yet
+var errEmbeddingMissing = errors.New("book has not been embedded yet")
+
+// StartEmbeddingPipeline embeds new and changed books once and then every interval until ctx is
+// done, or sooner when an admin asks for books to be reprocessed
+func StartEmbeddingPipeline(ctx context.Context, provider EmbeddingProvider, interval time.Duration) {
+	runOnSchedule(ctx, provider.Name()+" embedding refresh", interval, embeddingRefreshWake, func(ctx context.Context) error {
+		return RefreshEmbeddings(ctx, provider)
+	})
+}
+
+// embeddingPipeline names the processing pipeline for a provider's vectors in book_processing
+func embeddingPipeline(provider EmbeddingProvider) string {
+	return "embeddings:" + provider.Name()
+}
+
+// RefreshEmbeddings embeds every book whose title or description changed since it was last
+// embedded. A batch the provider rejects marks its books failed and the run moves on, so one
+// bad book can't hold back the rest of the catalog; they are retried on the next run.
+func RefreshEmbeddings(ctx context.Context, provider EmbeddingProvider) error {
+	documents, err := loadSearchDocuments(ctx)
+	if err != nil {
+		return err
+	}
+
+	stored, err := loadEmbeddingHashes(ctx, provider.Name())
+	if err != nil {
+		return err
+	}
+
+	var pending []searchDocument
+	for _, document := range documents {
+		if stored[document.ID] != embeddingContentHash(document) {
+			pending = append(pending, document)
+		}
+	}
+
+	pipeline := embeddingPipeline(provider)
+	failed := 0
+	var firstErr error
+	for start := 0; start < len(pending); start += embeddingBatchSize {
+		batch := pending[start:min(start+embeddingBatchSize, len(pending))]
+
+		texts := make([]string, len(batch))
+		for i, document := range batch {
+			texts[i] = embeddingText(document)
+		}
+		vectors, err := provider.Embed(ctx, texts)
+		if err == nil && len(vectors) != len(batch) {
+			err = fmt.Errorf("%s returned %d vectors for %d texts", provider.Name(), len(vectors), len(batch))
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed += len(batch)
+			for _, document := range batch {
+				if err := recordProcessingResult(ctx, document.ID, pipeline, err); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		for i, document := range batch {
+			if err := saveEmbedding(ctx, document.ID, provider.Name(), embeddingContentHash(document), vectors[i]); err != nil {
+				return err
+			}
+			if err := recordProcessingResult(ctx, document.ID, pipeline, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	if embedded := len(pending) - failed; embedded > 0 {
+		log.Printf("Embedded %d books with %s", embedded, provider.Name())
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d books failed to embed, first error: %w", failed, len(pending), firstErr)
+	}
+	return nil
+}
+
+// embeddingText is what gets embedded for a book: the title carries as much meaning as the blurb
+func embeddingText(document searchDocument) string {
+	return document.Title + "\n" + document.Description
+}
+
+// embeddingContentHash fingerprints the embedded text so unchanged books are skipped
+func embeddingContentHash(document searchDocument) string {
+	sum := sha256.Sum256([]byte(embeddingText(document)))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadEmbeddingHashes returns the content hash of every stored vector for a provider, keyed by book ID
+func loadEmbeddingHashes(ctx context.Context, provider string) (map[string]string, error) {
+	rows, err := dbFor(ctx).QueryContext(ctx, "SELECT book_id, content_hash FROM book_embeddings WHERE provider = ?", provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var bookID, hash string
+		if err := rows.Scan(&bookID, &hash); err != nil {
+			return nil, err
+		}
+		hashes[bookID] = hash
+	}
+	return hashes, rows.Err()
+}
+
+// saveEmbedding inserts or replaces a book's vector for a provider
+func saveEmbedding(ctx context.Context, bookID, provider, hash string, vector []float32) error {
+	_, err := dbFor(ctx).ExecContext(ctx, `
+		INSERT INTO book_embeddings (book_id, provider, content_hash, vector, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(book_id, provider) DO UPDATE SET
+			content_hash = excluded.content_hash,
+			vector = excluded.vector,
+			updated_at = excluded.updated_at
+	`, bookID, provider, hash, encodeVector(vector), dbNow())
+	return err
+}
+
+// encodeVector packs a vector as little-endian float32s
+func encodeVector(vector []float32) []byte {
+	encoded := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(encoded[4*i:], math.Float32bits(value))
+	}
+	return encoded
+}
+
+// decodeVector unpacks a vector stored by encodeVector
+func decodeVector(encoded []byte) []float32 {
+	vector := make([]float32, len(encoded)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(encoded[4*i:]))
+	}
+	return vector
+}
+
+// normalizeVector scales a vector to unit length so cosine similarity is a dot product
+func normalizeVector(vector []float32) []float32 {
+	var sumSquares float64
+	for _, value := range vector {
+		sumSquares += float64(value) * float64(value)
+	}
+	if sumSquares == 0 {
+		return vector
+	}
+	norm := float32(math.Sqrt(sumSquares))
+	for i := range vector {
+		vector[i] /= norm
+	}
+	return vector
+}
+
+// cosineSimilarity compares two unit vectors; vectors of different lengths don't compare
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+// SimilarBook is one "more like this" result
+type SimilarBook struct {
+	Book
+	Similarity float64 `json:"similarity"` // Cosine similarity in [-1, 1], higher is closer
+}
+
+// SimilarBooksResponse is the body of GET /api/books/{id}/similar
+type SimilarBooksResponse struct {
+	BookID   string        `json:"book_id"`
+	Provider string        `json:"provider"`
+	Results  []SimilarBook `json:"results"`
+}
+
+// FindSimilarBooks ranks other books by how close their description vectors are to this book's
+func FindSimilarBooks(ctx context.Context, provider, bookID string, limit int) ([]SimilarBook, error) {
+	var encoded []byte
+	err := db.QueryRowContext(ctx, "SELECT vector FROM book_embeddings WHERE book_id = ? AND provider = ?", bookID, provider).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Tell an unknown book apart from one the pipeline hasn't reached yet
+		var exists int
+		if err := db.QueryRowContext(ctx, "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		return nil, errEmbeddingMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	target := decodeVector(encoded)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT b.id, b.title, b.author, COALESCE(p.price, 0), e.vector
+		FROM book_embeddings e
+		JOIN books b ON b.id = e.book_id
+		LEFT JOIN pricing p ON p.book_id = b.id
+		WHERE e.provider = ? AND e.book_id != ?
+	`, provider, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SimilarBook{}
+	for rows.Next() {
+		var result SimilarBook
+		if err := rows.Scan(&result.ID, &result.Title, &result.Author, &result.Price, &encoded); err != nil {
+			return nil, err
+		}
+		result.Similarity = math.Round(cosineSimilarity(target, decodeVector(encoded))*1000) / 1000
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Similarity > results[j].Similarity })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// SimilarBooksHandler handles GET /api/books/{id}/similar?limit=N
+func SimilarBooksHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit := 5
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 || parsed > 50 {
+			writeError(w, r, http.StatusBadRequest, "limit must be between 1 and 50")
+			return
+		}
+		limit = parsed
+	}
+
+	if !checkRegion(w, r, bookID) {
+		return
+	}
+	country := requestCountry(w, r)
+
+	results, err := FindSimilarBooks(r.Context(), embeddingProvider.Name(), bookID, limit)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, r, http.StatusNotFound, "Book not found")
+		return
+	case errors.Is(err, errEmbeddingMissing):
+		w.Header().Set("Retry-After", "60")
+		writeError(w, r, http.StatusServiceUnavailable, "Similar books are not available for this book yet")
+		return
+	case err != nil:
+		log.Printf("Error finding books similar to %s: %v", bookID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to find similar books")
+		return
+	}
+
+	visible := make([]SimilarBook, 0, len(results))
+	for _, result := range results {
+		if localizeBook(&result.Book, country) {
+			visible = append(visible, result)
+		}
+	}
+	writeJSON(w, r, http.StatusOK, SimilarBooksResponse{BookID: bookID, Provider: embeddingProvider.Name(), Results: visible})
+}
+
+// hashingEmbedder is a dependency-free local provider. It hashes words and adjacent word
+// pairs into a fixed number of dimensions, so books sharing vocabulary land close together.
+// It is a baseline; a model-backed provider captures meaning beyond shared words.
+type hashingEmbedder struct {
+	dimensions int
+}
+
+// Words too common to say anything about a book
+var embeddingStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "of": true, "to": true, "in": true,
+	"for": true, "on": true, "with": true, "at": true, "by": true, "is": true, "it": true,
+}
+
+// Name implements EmbeddingProvider
+func (e hashingEmbedder) Name() string { return "hashing" }
+
+// Embed implements EmbeddingProvider
+func (e hashingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		var words []string
+		for _, word := range tokenize(text) {
+			if !embeddingStopWords[word] {
+				words = append(words, word)
+			}
+		}
+
+		counts := make(map[string]int)
+		for j, word := range words {
+			counts[word]++
+			if j > 0 {
+				counts[words[j-1]+" "+word]++
+			}
+		}
+
+		vector := make([]float32, e.dimensions)
+		for feature, count := range counts {
+			hasher := fnv.New64a()
+			hasher.Write([]byte(feature))
+			sum := hasher.Sum64()
+
+			// The top bit picks a sign so unrelated features colliding in a bucket tend to cancel out
+			weight := float32(1 + math.Log(float64(count)))
+			if sum>>63 == 1 {
+				weight = -weight
+			}
+			vector[sum%uint64(e.dimensions)] += weight
+		}
+		vectors[i] = normalizeVector(vector)
+	}
+	return vectors, nil
+}
+
+// openAIEmbedder calls an OpenAI-compatible POST {baseURL}/embeddings endpoint
+type openAIEmbedder struct {
+	baseURL string // e.g. https://api.openai.com/v1
+	model   string
+	apiKey  string
+}
+
+// Name implements EmbeddingProvider; the model is part of the name because each model has its own vector space
+func (e *openAIEmbedder) Name() string { return "openai:" + e.model }
+
+// Embed implements EmbeddingProvider
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	payload, err := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings API returned status %d", response.StatusCode)
+	}
+
+	var body struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range body.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings API returned out-of-range index %d", item.Index)
+		}
+		vectors[item.Index] = normalizeVector(item.Embedding)
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("embeddings API returned no vector for input %d", i)
+		}
+	}
+	return vectors, nil
+}
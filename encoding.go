@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Responses up to this size are held back until encoding finishes, so they get a
+// Content-Length and an encode failure can still become a 500. Larger ones are committed once
+// they outgrow the buffer.
+const responseBufferLimit = 64 << 10
+
+var (
+	// Responses whose value could not be encoded and were answered with a 500 instead
+	jsonEncodeFailures = expvar.NewInt("json_encode_failures")
+	// Responses cut short after their status was sent, by kind: buffered JSON or streamed rows
+	responsePartialWrites = expvar.NewMap("response_partial_writes")
+)
+
+// responseBuffer sits between an encoder and the ResponseWriter and holds back the status code
+// and body until the body is complete or exceeds responseBufferLimit
+type responseBuffer struct {
+	w         http.ResponseWriter
+	status    int
+	buf       bytes.Buffer
+	committed bool
+	written   int // Body bytes that reached w
+}
+
+// Write implements io.Writer
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if !b.committed && b.buf.Len()+len(p) <= responseBufferLimit {
+		return b.buf.Write(p)
+	}
+	if err := b.commit(); err != nil {
+		return 0, err
+	}
+	n, err := b.w.Write(p)
+	b.written += n
+	return n, err
+}
+
+// commit sends the status code and whatever is buffered
+func (b *responseBuffer) commit() error {
+	if b.committed {
+		return nil
+	}
+	b.committed = true
+	b.w.WriteHeader(b.status)
+	n, err := b.w.Write(b.buf.Bytes())
+	b.written += n
+	b.buf.Reset()
+	return err
+}
+
+// writeEncoded runs encode against a responseBuffer and sends the result with status. The
+// caller sets the content type. A failure before anything was committed, including a panic
+// in a MarshalJSON method, turns into a plain 500; one after the status went out can only cut
+// the body short and is counted as a partial write.
+func writeEncoded(w http.ResponseWriter, r *http.Request, status int, encode func(io.Writer) error) {
+	buffer := &responseBuffer{w: w, status: status}
+	err := recoverEncode(buffer, encode)
+	if err != nil && !buffer.committed {
+		jsonEncodeFailures.Add(1)
+		log.Printf("Error encoding JSON for %s (request %s): %v", r.URL.Path, RequestIDFromContext(r.Context()), err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		// A response that never outgrew the buffer is complete, so its length is known
+		if !buffer.committed {
+			w.Header().Set("Content-Length", strconv.Itoa(buffer.buf.Len()))
+		}
+		err = buffer.commit()
+	}
+	if err != nil {
+		recordPartialWrite("buffered", r, buffer.written, err)
+	}
+}
+
+// recoverEncode calls encode, converting a panic into an error
+func recoverEncode(w io.Writer, encode func(io.Writer) error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic while encoding: %v", recovered)
+		}
+	}()
+	return encode(w)
+}
+
+// recordPartialWrite counts and logs a response that stopped after its status was sent
+func recordPartialWrite(kind string, r *http.Request, written int, err error) {
+	responsePartialWrites.Add(kind, 1)
+	log.Printf("Response for %s (request %s) cut short after %d bytes: %v", r.URL.Path, RequestIDFromContext(r.Context()), written, err)
+}
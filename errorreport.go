@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorReporter sends error events somewhere a person will see them
+type ErrorReporter interface {
+	Name() string
+	Report(ctx context.Context, event ErrorEvent) error
+}
+
+// Known error reporters, selectable by name via BOOKSTORE_ERROR_REPORTER. "none" leaves errors
+// in the log only.
+var errorReporterRegistry = map[string]func(Config) (ErrorReporter, error){
+	"none":   func(Config) (ErrorReporter, error) { return nil, nil },
+	"stderr": func(Config) (ErrorReporter, error) { return &writerReporter{name: "stderr", out: os.Stderr}, nil },
+	"file":   newFileReporter,
+	"http":   newHTTPReporter,
+}
+
+// Reporter for panics and failed jobs, nil when off; built from config in NewServer
+var errorReporter ErrorReporter
+
+// Events wait here for the sender, so reporting never slows down a request. When the sink falls
+// behind, new events are dropped rather than queued without bound.
+var errorReportQueue = make(chan ErrorEvent, 100)
+
+var errorReportQueueOnce sync.Once
+
+var errorReports = expvar.NewMap("error_reports")
+
+// Placeholder for scrubbed values
+const scrubbedValue = "[Filtered]"
+
+// NewErrorReporter instantiates a reporter by name, falling back to none
+func NewErrorReporter(cfg Config) (ErrorReporter, error) {
+	constructor, ok := errorReporterRegistry[cfg.ErrorReporter]
+	if !ok {
+		log.Printf("Unknown error reporter %q, using none", cfg.ErrorReporter)
+		constructor = errorReporterRegistry["none"]
+	}
+	return constructor(cfg)
+}
+
+// writerReporter writes each event as a line of JSON
+type writerReporter struct {
+	name string
+	mu   sync.Mutex
+	out  interface{ Write([]byte) (int, error) }
+}
+
+func newFileReporter(cfg Config) (ErrorReporter, error) {
+	if cfg.ErrorReportFile == "" {
+		return nil, fmt.Errorf("BOOKSTORE_ERROR_REPORT_FILE is required with the file error reporter")
+	}
+	file, err := os.OpenFile(cfg.ErrorReportFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &writerReporter{name: "file", out: file}, nil
+}
+
+// Name implements ErrorReporter
+func (w *writerReporter) Name() string { return w.name }
+
+// Report implements ErrorReporter
+func (w *writerReporter) Report(_ context.Context, event ErrorEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.out.Write(append(line, '\n'))
+	return err
+}
+
+// httpReporter posts events to a Sentry-compatible store endpoint. The DSN has the usual form,
+// https://<key>@<host>/<project>, and events go to https://<host>/api/<project>/store/.
+type httpReporter struct {
+	endpoint string
+	key      string
+}
+
+func newHTTPReporter(cfg Config) (ErrorReporter, error) {
+	dsn, err := url.Parse(cfg.ErrorReportDSN)
+	if err != nil || dsn.Host == "" || dsn.User == nil || dsn.User.Username() == "" || (dsn.Scheme != "http" && dsn.Scheme != "https") {
+		return nil, fmt.Errorf("BOOKSTORE_ERROR_REPORT_DSN must look like https://key@host/project")
+	}
+	path, project := "", strings.Trim(dsn.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		path, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, fmt.Errorf("BOOKSTORE_ERROR_REPORT_DSN has no project")
+	}
+	return &httpReporter{
+		endpoint: dsn.Scheme + "://" + dsn.Host + path + "/api/" + project + "/store/",
+		key:      dsn.User.Username(),
+	}, nil
+}
+
+// Name implements ErrorReporter
+func (h *httpReporter) Name() string { return "http" }
+
+// Report implements ErrorReporter. It goes through the shared client, so the sink's host has to
+// be on the egress allowlist like any other upstream.
+func (h *httpReporter) Report(ctx context.Context, event ErrorEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=bookstore/1.0, sentry_key="+h.key)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error sink answered %s", resp.Status)
+	}
+	return nil
+}
+
+// sendErrorReports hands queued events to the reporter one at a time
+func sendErrorReports() {
+	for event := range errorReportQueue {
+		reporter := errorReporter
+		if reporter == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := reporter.Report(ctx, event); err != nil {
+			errorReports.Add("failed", 1)
+			log.Printf("Error sending error report %s to %s: %v", event.EventID, reporter.Name(), err)
+		} else {
+			errorReports.Add("sent", 1)
+		}
+		cancel()
+	}
+}
+
+// reportError samples, scrubs and queues an event. Fatal events (panics) are always kept; others
+// are kept at BOOKSTORE_ERROR_REPORT_SAMPLE_PERCENT.
+func reportError(ctx context.Context, event ErrorEvent) {
+	if errorReporter == nil {
+		return
+	}
+	if event.Level != "fatal" && mathrand.Intn(100) >= config.ErrorReportSamplePercent {
+		errorReports.Add("sampled_out", 1)
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	event.EventID = hex.EncodeToString(id)
+	event.Timestamp = clock.Now().UTC()
+	event.Platform = "go"
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		if event.Tags == nil {
+			event.Tags = map[string]string{}
+		}
+		event.Tags["request_id"] = requestID
+	}
+	scrubErrorEvent(&event)
+
+	errorReportQueueOnce.Do(func() { go sendErrorReports() })
+	select {
+	case errorReportQueue <- event:
+	default:
+		errorReports.Add("dropped", 1)
+	}
+}
+
+// reportJobError reports a background job that failed or panicked
+func reportJobError(ctx context.Context, job string, err error, stack []byte) {
+	event := ErrorEvent{
+		Level:   "error",
+		Logger:  "job",
+		Message: fmt.Sprintf("%s failed: %v", job, err),
+		Tags:    map[string]string{"job": job},
+	}
+	event.Exception = &ErrorEventExceptions{Values: []ErrorEventException{{Type: fmt.Sprintf("%T", err), Value: err.Error()}}}
+	if stack != nil {
+		event.Level = "fatal"
+		event.Exception.Values[0].Type = "panic"
+		event.Extra = map[string]string{"stack": string(stack)}
+	}
+	reportError(ctx, event)
+}
+
+// isSensitiveName reports whether a header, query parameter or extra field should be scrubbed:
+// its name contains one of BOOKSTORE_ERROR_REPORT_SCRUB_FIELDS, case-insensitively
+func isSensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range config.ErrorReportScrubFields {
+		if field != "" && strings.Contains(name, strings.ToLower(field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubErrorEvent replaces values that may be credentials or personal data before an event
+// leaves the process
+func scrubErrorEvent(event *ErrorEvent) {
+	if event.Request != nil {
+		for name := range event.Request.Headers {
+			if isSensitiveName(name) {
+				event.Request.Headers[name] = scrubbedValue
+			}
+		}
+		if query, err := url.ParseQuery(event.Request.QueryString); err == nil {
+			for name := range query {
+				if isSensitiveName(name) || redactedQueryParams[strings.ToLower(name)] {
+					query.Set(name, scrubbedValue)
+				}
+			}
+			event.Request.QueryString = query.Encode()
+		} else {
+			event.Request.QueryString = scrubbedValue
+		}
+		// Share tokens are bearer credentials in the path
+		if strings.HasPrefix(event.Request.URL, sharedListPrefix) && len(event.Request.URL) > len(sharedListPrefix) {
+			event.Request.URL = sharedListPrefix + scrubbedValue
+		}
+	}
+	for name := range event.Extra {
+		if isSensitiveName(name) {
+			event.Extra[name] = scrubbedValue
+		}
+	}
+}
+
+// recoveryMiddleware turns a handler panic into a 500 and an error report instead of a dropped
+// connection. http.ErrAbortHandler is let through, since that panic is how a handler asks for
+// exactly that.
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := &startedRecorder{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			stack := debug.Stack()
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, RequestIDFromContext(r.Context()), recovered, stack)
+
+			headers := map[string]string{}
+			for name := range r.Header {
+				headers[name] = r.Header.Get(name)
+			}
+			event := ErrorEvent{
+				Level:   "fatal",
+				Logger:  "http",
+				Message: fmt.Sprintf("panic: %v", recovered),
+				Request: &ErrorEventRequest{URL: r.URL.Path, Method: r.Method, QueryString: r.URL.RawQuery, Headers: headers},
+				Extra:   map[string]string{"stack": string(stack)},
+			}
+			event.Exception = &ErrorEventExceptions{Values: []ErrorEventException{{Type: fmt.Sprintf("%T", recovered), Value: fmt.Sprint(recovered)}}}
+			reportError(r.Context(), event)
+
+			// Once the status is out there is nothing better to do than stop
+			if !started.started {
+				writeError(w, r, http.StatusInternalServerError, "Internal server error")
+			}
+		}()
+		next.ServeHTTP(started, r)
+	})
+}
+
+// startedRecorder remembers whether a response has begun, headers or body
+type startedRecorder struct {
+	http.ResponseWriter
+	started bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (s *startedRecorder) WriteHeader(status int) {
+	s.started = true
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (s *startedRecorder) Write(b []byte) (int, error) {
+	s.started = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (s *startedRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Experiment event kinds
+const (
+	experimentExposure   = "exposure"
+	experimentConversion = "conversion"
+)
+
+// Price experiment errors surfaced to handlers
+var (
+	errExperimentExists = errors.New("price experiment already exists")
+	errNotExposed       = errors.New("user was not exposed to this experiment")
+)
+
+// experimentCache holds every price experiment in memory, indexed by book, so serving details
+// never queries for them. It follows the feature flag cache: writes through this instance
+// reload it, and the TTL bounds how long other instances' changes take to show up.
+var experimentCache = struct {
+	sync.RWMutex
+	experiments map[string]PriceExperiment
+	byBook      map[string][]string // Book ID -> keys of enabled experiments covering it
+	loadedAt    time.Time
+}{}
+
+// LoadPriceExperiments reads all experiments from the database into the in-memory cache
+func LoadPriceExperiments() error {
+	rows, err := db.Query("SELECT key, description, enabled, book_ids, updated_at FROM price_experiments")
+	if err != nil {
+		return err
+	}
+	experiments := map[string]PriceExperiment{}
+	for rows.Next() {
+		var experiment PriceExperiment
+		var bookIDs string
+		if err := rows.Scan(&experiment.Key, &experiment.Description, &experiment.Enabled, &bookIDs, &experiment.UpdatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		experiment.BookIDs = splitTenants(bookIDs)
+		experiment.Variants = []PriceVariant{}
+		experiments[experiment.Key] = experiment
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query("SELECT experiment_key, name, price_factor, weight FROM price_experiment_variants ORDER BY experiment_key, position")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var variant PriceVariant
+		if err := rows.Scan(&key, &variant.Name, &variant.PriceFactor, &variant.Weight); err != nil {
+			return err
+		}
+		if experiment, ok := experiments[key]; ok {
+			experiment.Variants = append(experiment.Variants, variant)
+			experiments[key] = experiment
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	byBook := map[string][]string{}
+	for key, experiment := range experiments {
+		if !experiment.Enabled {
+			continue
+		}
+		for _, bookID := range experiment.BookIDs {
+			byBook[bookID] = append(byBook[bookID], key)
+		}
+	}
+	for _, keys := range byBook {
+		sort.Strings(keys)
+	}
+
+	experimentCache.Lock()
+	experimentCache.experiments = experiments
+	experimentCache.byBook = byBook
+	experimentCache.loadedAt = clock.Now()
+	experimentCache.Unlock()
+	return nil
+}
+
+// refreshExperimentCache reloads the cache once it is older than the flag cache TTL
+func refreshExperimentCache() {
+	experimentCache.RLock()
+	stale := clock.Now().Sub(experimentCache.loadedAt) > flagCacheTTL
+	experimentCache.RUnlock()
+	if stale {
+		if err := LoadPriceExperiments(); err != nil {
+			log.Printf("Error refreshing price experiments: %v", err)
+		}
+	}
+}
+
+// getPriceExperiment returns one experiment from the cache
+func getPriceExperiment(key string) (PriceExperiment, bool) {
+	refreshExperimentCache()
+	experimentCache.RLock()
+	defer experimentCache.RUnlock()
+	experiment, ok := experimentCache.experiments[key]
+	return experiment, ok
+}
+
+// assignPriceVariant picks a user's variant. The bucket hashes experiment key and user, as
+// feature flag rollouts do, so a user keeps their variant across requests and instances and
+// each experiment splits users independently.
+func assignPriceVariant(experiment PriceExperiment, userID string) PriceVariant {
+	bucket := rolloutBucket(experiment.Key, userID)
+	for _, variant := range experiment.Variants {
+		if bucket < variant.Weight {
+			return variant
+		}
+		bucket -= variant.Weight
+	}
+	return experiment.Variants[len(experiment.Variants)-1]
+}
+
+// priceExperimentFor returns the enabled experiment covering a book and the user's variant in
+// it. When several cover the same book, the first key in order wins so prices stay consistent.
+func priceExperimentFor(bookID, userID string) (PriceExperiment, PriceVariant, bool) {
+	refreshExperimentCache()
+	experimentCache.RLock()
+	defer experimentCache.RUnlock()
+	for _, key := range experimentCache.byBook[bookID] {
+		experiment := experimentCache.experiments[key]
+		if len(experiment.Variants) > 0 {
+			return experiment, assignPriceVariant(experiment, userID), true
+		}
+	}
+	return PriceExperiment{}, PriceVariant{}, false
+}
+
+// applyPriceExperiment swaps in the user's variant price when the book is under test and
+// records the exposure. Only requests naming a user take part; anonymous traffic sees the
+// regular price. The variant is reported in the X-Price-Experiment header.
+func applyPriceExperiment(w http.ResponseWriter, r *http.Request, bookID string, pricing *sectionResult[BookPricing]) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" || pricing.Err != nil {
+		return
+	}
+	experiment, variant, ok := priceExperimentFor(bookID, userID)
+	if !ok {
+		return
+	}
+
+	// Section data may be shared with the details cache, so pointers get fresh values
+	pricing.Data.Price = roundCents(pricing.Data.Price * variant.PriceFactor)
+	if pricing.Data.SalePrice != nil {
+		salePrice := roundCents(*pricing.Data.SalePrice * variant.PriceFactor)
+		pricing.Data.SalePrice = &salePrice
+	}
+	w.Header().Set("X-Price-Experiment", experiment.Key+"="+variant.Name)
+
+	// Exposures are recorded off the request path; only the first per user and book is kept
+	go func() {
+		if err := recordExperimentEvent(context.Background(), experiment.Key, variant.Name, userID, bookID, experimentExposure); err != nil {
+			log.Printf("Error recording exposure to price experiment %s: %v", experiment.Key, err)
+		}
+	}()
+}
+
+// recordExperimentEvent stores an exposure or conversion, ignoring repeats
+func recordExperimentEvent(ctx context.Context, key, variant, userID, bookID, kind string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO price_experiment_events (experiment_key, variant, user_id, book_id, kind, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, key, variant, userID, bookID, kind, dbNow())
+	return err
+}
+
+// recordConversion credits a purchase to the variant the user was shown for that book
+func recordConversion(ctx context.Context, key, userID, bookID string) (string, error) {
+	var variant string
+	err := db.QueryRowContext(ctx, `
+		SELECT variant FROM price_experiment_events
+		WHERE experiment_key = ? AND user_id = ? AND book_id = ? AND kind = ?
+	`, key, userID, bookID, experimentExposure).Scan(&variant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errNotExposed
+	}
+	if err != nil {
+		return "", err
+	}
+	return variant, recordExperimentEvent(ctx, key, variant, userID, bookID, experimentConversion)
+}
+
+// savePriceExperiment inserts or replaces an experiment with its variants and reloads the cache
+func savePriceExperiment(ctx context.Context, experiment PriceExperiment, create bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO price_experiments (key, description, enabled, book_ids, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			description = excluded.description,
+			enabled = excluded.enabled,
+			book_ids = excluded.book_ids,
+			updated_at = excluded.updated_at
+	`
+	if create {
+		query = "INSERT INTO price_experiments (key, description, enabled, book_ids, updated_at) VALUES (?, ?, ?, ?, ?)"
+	}
+	_, err = tx.ExecContext(ctx, query, experiment.Key, experiment.Description, experiment.Enabled, strings.Join(experiment.BookIDs, ","), dbNow())
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+		return errExperimentExists
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM price_experiment_variants WHERE experiment_key = ?", experiment.Key); err != nil {
+		return err
+	}
+	for position, variant := range experiment.Variants {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO price_experiment_variants (experiment_key, name, price_factor, weight, position) VALUES (?, ?, ?, ?, ?)
+		`, experiment.Key, variant.Name, variant.PriceFactor, variant.Weight, position); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return LoadPriceExperiments()
+}
+
+// deletePriceExperiment removes an experiment with its variants and events
+func deletePriceExperiment(ctx context.Context, key string) (bool, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM price_experiments WHERE key = ?", key)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, LoadPriceExperiments()
+}
+
+// VariantResult is one variant's performance
+type VariantResult struct {
+	Variant        string   `json:"variant"`
+	PriceFactor    float64  `json:"price_factor"`
+	Exposures      int      `json:"exposures"`       // Distinct user and book pairs shown this price
+	Conversions    int      `json:"conversions"`     // Of those, how many bought
+	ConversionRate *float64 `json:"conversion_rate"` // Conversions per exposure, null before any exposure
+}
+
+// ExperimentResults is the body of GET /api/admin/experiments/{key}
+type ExperimentResults struct {
+	PriceExperiment
+	Results []VariantResult `json:"results"`
+}
+
+// loadExperimentResults counts exposures and conversions per variant
+func loadExperimentResults(ctx context.Context, experiment PriceExperiment) (ExperimentResults, error) {
+	results := ExperimentResults{PriceExperiment: experiment, Results: []VariantResult{}}
+	counts := map[string]map[string]int{}
+	rows, err := db.QueryContext(ctx, `
+		SELECT variant, kind, COUNT(*) FROM price_experiment_events WHERE experiment_key = ? GROUP BY variant, kind
+	`, experiment.Key)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var variant, kind string
+		var count int
+		if err := rows.Scan(&variant, &kind, &count); err != nil {
+			return results, err
+		}
+		if counts[variant] == nil {
+			counts[variant] = map[string]int{}
+		}
+		counts[variant][kind] = count
+	}
+	if err := rows.Err(); err != nil {
+		return results, err
+	}
+
+	for _, variant := range experiment.Variants {
+		result := VariantResult{
+			Variant:     variant.Name,
+			PriceFactor: variant.PriceFactor,
+			Exposures:   counts[variant.Name][experimentExposure],
+			Conversions: counts[variant.Name][experimentConversion],
+		}
+		if result.Exposures > 0 {
+			rate := math.Round(float64(result.Conversions)/float64(result.Exposures)*10000) / 10000
+			result.ConversionRate = &rate
+		}
+		results.Results = append(results.Results, result)
+	}
+	return results, nil
+}
+
+// validatePriceExperiment checks an experiment from a request body, returning the problem to
+// report or "" when it is valid
+func validatePriceExperiment(ctx context.Context, experiment PriceExperiment) string {
+	if experiment.Key == "" || strings.Contains(experiment.Key, "/") {
+		return "Experiment key is required and must not contain '/'"
+	}
+	if len(experiment.BookIDs) == 0 {
+		return "book_ids must name at least one book"
+	}
+	for _, bookID := range experiment.BookIDs {
+		var exists int
+		if err := db.QueryRowContext(ctx, "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+			return fmt.Sprintf("Book %q not found", bookID)
+		}
+	}
+	if len(experiment.Variants) < 2 {
+		return "An experiment needs at least two variants"
+	}
+	total := 0
+	names := map[string]bool{}
+	for _, variant := range experiment.Variants {
+		if variant.Name == "" || names[variant.Name] {
+			return "Variant names must be set and unique"
+		}
+		names[variant.Name] = true
+		if variant.PriceFactor <= 0 || variant.PriceFactor > 10 {
+			return "price_factor must be above 0 and at most 10"
+		}
+		if variant.Weight < 0 {
+			return "Variant weights can't be negative"
+		}
+		total += variant.Weight
+	}
+	if total != 100 {
+		return fmt.Sprintf("Variant weights must add up to 100, not %d", total)
+	}
+	return ""
+}
+
+// PriceExperimentsHandler handles /api/admin/experiments (list with results, create)
+func PriceExperimentsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if err := LoadPriceExperiments(); err != nil {
+			log.Printf("Error loading price experiments: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to load price experiments")
+			return
+		}
+		experimentCache.RLock()
+		keys := make([]string, 0, len(experimentCache.experiments))
+		for key := range experimentCache.experiments {
+			keys = append(keys, key)
+		}
+		experimentCache.RUnlock()
+		sort.Strings(keys)
+
+		all := []ExperimentResults{}
+		for _, key := range keys {
+			experiment, _ := getPriceExperiment(key)
+			results, err := loadExperimentResults(r.Context(), experiment)
+			if err != nil {
+				log.Printf("Error loading results of price experiment %s: %v", key, err)
+				writeError(w, r, http.StatusInternalServerError, "Failed to load price experiments")
+				return
+			}
+			all = append(all, results)
+		}
+		writeJSON(w, r, http.StatusOK, all)
+
+	case http.MethodPost:
+		var experiment PriceExperiment
+		if !decodeJSONBody(w, r, &experiment) {
+			return
+		}
+		if problem := validatePriceExperiment(r.Context(), experiment); problem != "" {
+			writeError(w, r, http.StatusBadRequest, problem)
+			return
+		}
+		err := savePriceExperiment(r.Context(), experiment, true)
+		if errors.Is(err, errExperimentExists) {
+			writeError(w, r, http.StatusConflict, "Price experiment already exists")
+			return
+		}
+		if err != nil {
+			log.Printf("Error creating price experiment %s: %v", experiment.Key, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to create price experiment")
+			return
+		}
+		saved, _ := getPriceExperiment(experiment.Key)
+		log.Printf("Created price experiment %s on %d books", saved.Key, len(saved.BookIDs))
+		writeJSON(w, r, http.StatusCreated, saved)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// PriceExperimentHandler handles /api/admin/experiments/{key} (results, replace, delete)
+func PriceExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/api/admin/experiments/")
+	if key == "" || strings.Contains(key, "/") {
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/admin/experiments/{key}")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		experiment, ok := getPriceExperiment(key)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "Price experiment not found")
+			return
+		}
+		results, err := loadExperimentResults(r.Context(), experiment)
+		if err != nil {
+			log.Printf("Error loading results of price experiment %s: %v", key, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to load price experiment")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, results)
+
+	case http.MethodPut:
+		var experiment PriceExperiment
+		if !decodeJSONBody(w, r, &experiment) {
+			return
+		}
+		if experiment.Key != "" && experiment.Key != key {
+			writeError(w, r, http.StatusBadRequest, "Experiment key in body does not match URL")
+			return
+		}
+		experiment.Key = key
+		if problem := validatePriceExperiment(r.Context(), experiment); problem != "" {
+			writeError(w, r, http.StatusBadRequest, problem)
+			return
+		}
+		if err := savePriceExperiment(r.Context(), experiment, false); err != nil {
+			log.Printf("Error updating price experiment %s: %v", key, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to update price experiment")
+			return
+		}
+		saved, _ := getPriceExperiment(key)
+		log.Printf("Updated price experiment %s (enabled=%t)", key, saved.Enabled)
+		writeJSON(w, r, http.StatusOK, saved)
+
+	case http.MethodDelete:
+		existed, err := deletePriceExperiment(r.Context(), key)
+		if err != nil {
+			log.Printf("Error deleting price experiment %s: %v", key, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to delete price experiment")
+			return
+		}
+		if !existed {
+			writeError(w, r, http.StatusNotFound, "Price experiment not found")
+			return
+		}
+		log.Printf("Deleted price experiment %s", key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// ExperimentConversionHandler handles POST /api/experiments/{key}/conversions with body
+// {"user_id": "u1", "book_id": "3"}, sent by the storefront when a user buys a book under
+// test. The conversion is credited to the variant the user was shown.
+func ExperimentConversionHandler(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(r.URL.Path, "/") // {"", "api", "experiments", "key", "conversions"}
+	if len(pathParts) != 5 || pathParts[3] == "" || pathParts[4] != "conversions" {
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/experiments/{key}/conversions")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	key := pathParts[3]
+
+	var body struct {
+		UserID string `json:"user_id"`
+		BookID string `json:"book_id"`
+	}
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if body.UserID == "" || body.BookID == "" {
+		writeError(w, r, http.StatusBadRequest, "user_id and book_id are required")
+		return
+	}
+	// The purchase happened whatever becomes of the conversion
+	invalidatePersonalRecommendations(body.UserID)
+	if _, ok := getPriceExperiment(key); !ok {
+		writeError(w, r, http.StatusNotFound, "Price experiment not found")
+		return
+	}
+
+	variant, err := recordConversion(r.Context(), key, body.UserID, body.BookID)
+	if errors.Is(err, errNotExposed) {
+		writeError(w, r, http.StatusConflict, "User was not shown this experiment's price for the book")
+		return
+	}
+	if err != nil {
+		log.Printf("Error recording conversion for price experiment %s: %v", key, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to record conversion")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]string{"experiment": key, "variant": variant})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveWriter lets streamJSONRows write into an archive file: it hashes what it writes and
+// ignores headers, which only matter when the archive is served
+type archiveWriter struct {
+	header http.Header
+	out    io.Writer
+}
+
+func (a *archiveWriter) Header() http.Header         { return a.header }
+func (a *archiveWriter) Write(b []byte) (int, error) { return a.out.Write(b) }
+func (a *archiveWriter) WriteHeader(int)             {}
+
+// archiveFileName is where an archive's content lives inside ExportArchiveDir
+func archiveFileName(id, format string) string {
+	return "catalog-" + id + "." + format
+}
+
+// createExportArchive snapshots the catalog export into a file, so it can be downloaded (and
+// resumed) from a fixed set of bytes rather than a live query. The request supplies the
+// audience and time zone, as for the streamed export.
+func createExportArchive(r *http.Request, format string) (ExportArchive, error) {
+	if err := os.MkdirAll(config.ExportArchiveDir, 0o755); err != nil {
+		return ExportArchive{}, err
+	}
+	archive := ExportArchive{ID: idGenerator.NewID(), Format: format}
+	path := filepath.Join(config.ExportArchiveDir, archiveFileName(archive.ID, format))
+
+	// Write to a temporary name first, so a half-written archive is never served
+	file, err := os.CreateTemp(config.ExportArchiveDir, "partial-*")
+	if err != nil {
+		return ExportArchive{}, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	ctx := withBatchPriority(r.Context())
+	rows, err := queryCatalogExport(ctx)
+	if err != nil {
+		return ExportArchive{}, err
+	}
+	hash := sha256.New()
+	writer := &archiveWriter{header: http.Header{}, out: io.MultiWriter(file, hash)}
+	if archive.Books, err = streamJSONRows(ctx, writer, r, rows, format == "ndjson", scanCatalogExportRow); err != nil {
+		return ExportArchive{}, err
+	}
+	if err := file.Sync(); err != nil {
+		return ExportArchive{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return ExportArchive{}, err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return ExportArchive{}, err
+	}
+
+	now := clock.Now().UTC().Truncate(time.Second)
+	archive.Size = info.Size()
+	archive.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	archive.CreatedAt = now
+	archive.ExpiresAt = now.Add(config.ExportArchiveTTL)
+	archive.URL = "/api/admin/export/archives/" + archive.ID
+	_, err = db.ExecContext(r.Context(), `
+		INSERT INTO export_archives (id, format, books, size, sha256, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, archive.ID, archive.Format, archive.Books, archive.Size, archive.SHA256,
+		now.Format(sqliteTimestampLayout), archive.ExpiresAt.Format(sqliteTimestampLayout))
+	if err != nil {
+		os.Remove(path)
+		return ExportArchive{}, err
+	}
+	return archive, nil
+}
+
+const exportArchiveColumns = "id, format, books, size, sha256, created_at, expires_at"
+
+func scanExportArchive(row interface{ Scan(...interface{}) error }) (ExportArchive, error) {
+	var archive ExportArchive
+	err := row.Scan(&archive.ID, &archive.Format, &archive.Books, &archive.Size, &archive.SHA256, &archive.CreatedAt, &archive.ExpiresAt)
+	archive.URL = "/api/admin/export/archives/" + archive.ID
+	return archive, err
+}
+
+// findExportArchive looks up an unexpired archive
+func findExportArchive(ctx context.Context, id string) (ExportArchive, bool, error) {
+	archive, err := scanExportArchive(db.QueryRowContext(ctx,
+		"SELECT "+exportArchiveColumns+" FROM export_archives WHERE id = ? AND expires_at > ?", id, dbNow()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return archive, false, nil
+	}
+	return archive, err == nil, err
+}
+
+// listExportArchives returns unexpired archives, newest first
+func listExportArchives(ctx context.Context) ([]ExportArchive, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT "+exportArchiveColumns+" FROM export_archives WHERE expires_at > ? ORDER BY created_at DESC, id", dbNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archives := []ExportArchive{}
+	for rows.Next() {
+		archive, err := scanExportArchive(rows)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	return archives, rows.Err()
+}
+
+// deleteExportArchives removes archives matching a condition, files first
+func deleteExportArchives(ctx context.Context, condition string, args ...interface{}) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, format FROM export_archives WHERE "+condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	var ids, formats []string
+	for rows.Next() {
+		var id, format string
+		if err := rows.Scan(&id, &format); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids, formats = append(ids, id), append(formats, format)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		err := os.Remove(filepath.Join(config.ExportArchiveDir, archiveFileName(id, formats[i])))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM export_archives WHERE id = ?", id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// ExportArchivesHandler handles catalog export archives, snapshots of the export that can be
+// downloaded with Range requests and resumed:
+//
+//	GET    /api/admin/export/archives              Unexpired archives
+//	POST   /api/admin/export/archives?format=json  Snapshot the catalog now (json or ndjson)
+//	GET    /api/admin/export/archives/{id}         Download, honouring Range and If-Range
+//	DELETE /api/admin/export/archives/{id}         Remove early
+func ExportArchivesHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/export/archives"), "/")
+	if strings.Contains(id, "/") {
+		writeError(w, r, http.StatusNotFound, "Not found")
+		return
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		archives, err := listExportArchives(r.Context())
+		if err != nil {
+			log.Printf("Error listing export archives: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to list export archives")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, archives)
+
+	case id == "" && r.Method == http.MethodPost:
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "ndjson" {
+			writeError(w, r, http.StatusBadRequest, "format must be json or ndjson")
+			return
+		}
+		if removed, err := deleteExportArchives(r.Context(), "expires_at <= ?", dbNow()); err != nil {
+			log.Printf("Error removing expired export archives: %v", err)
+		} else if removed > 0 {
+			log.Printf("Removed %d expired export archives", removed)
+		}
+
+		startTime := time.Now()
+		archive, err := createExportArchive(r, format)
+		if err != nil {
+			log.Printf("Error creating export archive: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to create export archive")
+			return
+		}
+		log.Printf("Archived %d books (%d bytes) as %s in %v", archive.Books, archive.Size, archive.ID, time.Since(startTime))
+		w.Header().Set("Location", archive.URL)
+		writeJSON(w, r, http.StatusCreated, archive)
+
+	case id != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		serveExportArchive(w, r, id)
+
+	case id != "" && r.Method == http.MethodDelete:
+		removed, err := deleteExportArchives(r.Context(), "id = ?", id)
+		if err != nil {
+			log.Printf("Error removing export archive %s: %v", id, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to remove export archive")
+			return
+		}
+		if removed == 0 {
+			writeError(w, r, http.StatusNotFound, "Export archive not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// serveExportArchive sends an archive's file. http.ServeContent does the range handling: 206
+// with Content-Range for a satisfiable Range, 416 otherwise, and the whole file when If-Range
+// names another version. The ETag is the content hash, so it is a strong validator.
+func serveExportArchive(w http.ResponseWriter, r *http.Request, id string) {
+	archive, ok, err := findExportArchive(r.Context(), id)
+	if err != nil {
+		log.Printf("Error loading export archive %s: %v", id, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load export archive")
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "Export archive not found or expired")
+		return
+	}
+	file, err := os.Open(filepath.Join(config.ExportArchiveDir, archiveFileName(archive.ID, archive.Format)))
+	if err != nil {
+		log.Printf("Error opening export archive %s: %v", id, err)
+		writeError(w, r, http.StatusNotFound, "Export archive is no longer on this instance")
+		return
+	}
+	defer file.Close()
+
+	contentType := "application/json"
+	if archive.Format == "ndjson" {
+		contentType = "application/x-ndjson"
+	}
+	name := fmt.Sprintf("catalog-%s.%s", archive.CreatedAt.Format("20060102-150405"), archive.Format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("ETag", `"`+archive.SHA256+`"`)
+	w.Header().Set("Expires", archive.ExpiresAt.Format(http.TimeFormat))
+	http.ServeContent(w, r, name, archive.CreatedAt, file)
+}
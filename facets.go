@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// FacetBucket is one filter value and how many search results fall into it
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchFacets groups the search results along each filterable dimension.
+// The catalog has no category column yet, so there is no category facet.
+type SearchFacets struct {
+	Author       []FacetBucket `json:"author"`
+	Price        []FacetBucket `json:"price"`
+	Rating       []FacetBucket `json:"rating"`
+	Availability []FacetBucket `json:"availability"`
+}
+
+// facetResult carries one facet's buckets back from its goroutine
+type facetResult struct {
+	buckets []FacetBucket
+	err     error
+}
+
+// Each facet query groups the matched books (the %s placeholder list) into buckets.
+// Buckets are ordered the way a filter sidebar lists them.
+const (
+	authorFacetQuery = `
+		SELECT b.author, COUNT(*)
+		FROM books b
+		WHERE b.id IN (%s)
+		GROUP BY b.author
+		ORDER BY COUNT(*) DESC, b.author
+	`
+	priceFacetQuery = `
+		SELECT CASE
+				WHEN p.price IS NULL THEN 'unpriced'
+				WHEN p.price < 20 THEN 'under_20'
+				WHEN p.price < 40 THEN '20_to_40'
+				WHEN p.price < 60 THEN '40_to_60'
+				ELSE '60_and_up'
+			END AS bucket, COUNT(*)
+		FROM books b
+		LEFT JOIN pricing p ON p.book_id = b.id
+		WHERE b.id IN (%s)
+		GROUP BY bucket
+		ORDER BY MIN(COALESCE(p.price, 1e9))
+	`
+	ratingFacetQuery = `
+		SELECT CASE
+				WHEN r.average_rating IS NULL OR r.total_reviews = 0 THEN 'unrated'
+				WHEN r.average_rating >= 4.5 THEN '4.5_and_up'
+				WHEN r.average_rating >= 4 THEN '4_to_4.5'
+				WHEN r.average_rating >= 3 THEN '3_to_4'
+				ELSE 'under_3'
+			END AS bucket, COUNT(*)
+		FROM books b
+		LEFT JOIN reviews r ON r.book_id = b.id
+		WHERE b.id IN (%s)
+		GROUP BY bucket
+		ORDER BY MAX(CASE WHEN r.total_reviews = 0 THEN -1 ELSE COALESCE(r.average_rating, -1) END) DESC
+	`
+	availabilityFacetQuery = `
+		SELECT CASE WHEN i.in_stock AND i.quantity > 0 THEN 'in_stock' ELSE 'out_of_stock' END AS bucket, COUNT(*)
+		FROM books b
+		LEFT JOIN inventory i ON i.book_id = b.id
+		WHERE b.id IN (%s)
+		GROUP BY bucket
+		ORDER BY bucket
+	`
+)
+
+// ComputeSearchFacets counts the given books along every facet, running the aggregate queries concurrently
+func ComputeSearchFacets(ctx context.Context, bookIDs []string) (SearchFacets, error) {
+	facets := SearchFacets{
+		Author:       []FacetBucket{},
+		Price:        []FacetBucket{},
+		Rating:       []FacetBucket{},
+		Availability: []FacetBucket{},
+	}
+	if len(bookIDs) == 0 {
+		return facets, nil
+	}
+
+	// Buffered so a goroutine never blocks if we return early on the first error
+	authorChannel := make(chan facetResult, 1)
+	priceChannel := make(chan facetResult, 1)
+	ratingChannel := make(chan facetResult, 1)
+	availabilityChannel := make(chan facetResult, 1)
+
+	go func() { authorChannel <- queryFacetBuckets(ctx, authorFacetQuery, bookIDs) }()
+	go func() { priceChannel <- queryFacetBuckets(ctx, priceFacetQuery, bookIDs) }()
+	go func() { ratingChannel <- queryFacetBuckets(ctx, ratingFacetQuery, bookIDs) }()
+	go func() { availabilityChannel <- queryFacetBuckets(ctx, availabilityFacetQuery, bookIDs) }()
+
+	for _, facet := range []struct {
+		channel chan facetResult
+		target  *[]FacetBucket
+	}{
+		{authorChannel, &facets.Author},
+		{priceChannel, &facets.Price},
+		{ratingChannel, &facets.Rating},
+		{availabilityChannel, &facets.Availability},
+	} {
+		result := <-facet.channel
+		if result.err != nil {
+			return facets, result.err
+		}
+		*facet.target = result.buckets
+	}
+	return facets, nil
+}
+
+// queryFacetBuckets runs one facet query restricted to the given books
+func queryFacetBuckets(ctx context.Context, query string, bookIDs []string) facetResult {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(bookIDs)), ",")
+	args := make([]interface{}, len(bookIDs))
+	for i, id := range bookIDs {
+		args[i] = id
+	}
+
+	rows, err := db.QueryContext(ctx, strings.Replace(query, "%s", placeholders, 1), args...)
+	if err != nil {
+		return facetResult{err: err}
+	}
+	defer rows.Close()
+
+	buckets := []FacetBucket{}
+	for rows.Next() {
+		var bucket FacetBucket
+		if err := rows.Scan(&bucket.Value, &bucket.Count); err != nil {
+			return facetResult{err: err}
+		}
+		buckets = append(buckets, bucket)
+	}
+	return facetResult{buckets: buckets, err: rows.Err()}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Number of entries in each feed
+const feedEntryLimit = 20
+
+// Feeds are cheap to regenerate but polled by many readers; let shared caches absorb most of it
+const feedCacheControl = "public, max-age=300"
+
+// atomFeed is an Atom 1.0 (RFC 4287) feed document
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is an Atom link element
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// atomEntry is one book in a feed
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published,omitempty"`
+	Link      atomLink   `xml:"link"`
+	Author    atomAuthor `xml:"author"`
+	Summary   string     `xml:"summary"`
+}
+
+// atomAuthor is an Atom person construct
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// catalogFeed describes one public feed: its title and the query that picks its books.
+// Queries return id, title, author, publish_date, summary and last update, newest first.
+// Feeds whose summaries quote the regular price list set BasePrices, so books with a regional
+// price list are left out where it applies.
+type catalogFeed struct {
+	Title      string
+	Query      string
+	BasePrices bool
+}
+
+// Feeds served under /feeds/, keyed by file name
+var catalogFeeds = map[string]catalogFeed{
+	"new-releases.xml": {
+		Title: "New releases",
+		Query: `
+			SELECT b.id, b.title, b.author, b.publish_date, COALESCE(b.description, ''),
+				COALESCE(b.updated_at, b.created_at)
+			FROM books b
+			WHERE b.publish_date IS NOT NULL
+			ORDER BY b.publish_date DESC, b.id
+			LIMIT ?
+		`,
+	},
+	"deals.xml": {
+		Title:      "Deals",
+		BasePrices: true,
+		Query: `
+			SELECT b.id, b.title, b.author, b.publish_date,
+				printf('%.2f %s, was %.2f%s', p.sale_price, p.currency, p.price,
+					CASE WHEN COALESCE(p.promotion, '') = '' THEN '' ELSE ' (' || p.promotion || ')' END),
+				MAX(COALESCE(b.updated_at, b.created_at), COALESCE(p.updated_at, b.created_at))
+			FROM books b
+			JOIN pricing p ON p.book_id = b.id
+			WHERE p.sale_price IS NOT NULL AND p.sale_price < p.price
+			ORDER BY (p.price - p.sale_price) * 1.0 / p.price DESC, b.id -- Prices may be stored as integers
+			LIMIT ?
+		`,
+	},
+}
+
+// FeedHandler handles GET /feeds/new-releases.xml and /feeds/deals.xml
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.URL.Path[len("/feeds/"):]
+	feed, ok := catalogFeeds[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	document, updated, err := buildCatalogFeed(r.Context(), name, feed, requestCountry(w, r))
+	if err != nil {
+		log.Printf("Error building feed %s: %v", name, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to build feed")
+		return
+	}
+
+	w.Header().Set("Cache-Control", feedCacheControl)
+	if writeNotModified(w, r, updated) {
+		return
+	}
+
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding feed %s: %v", name, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to build feed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// buildCatalogFeed runs a feed's query and returns the document as seen from country, along
+// with its last update time. Books restricted in the country are left out, so a feed may carry
+// fewer than feedEntryLimit entries.
+func buildCatalogFeed(ctx context.Context, name string, feed catalogFeed, country string) (atomFeed, time.Time, error) {
+	selfURL := config.PublicBaseURL + "/feeds/" + name
+	document := atomFeed{
+		Title: "Bookstore: " + feed.Title,
+		ID:    selfURL,
+		Links: []atomLink{{Rel: "self", Href: selfURL}},
+	}
+
+	rows, err := db.QueryContext(ctx, feed.Query, feedEntryLimit)
+	if err != nil {
+		return document, time.Time{}, err
+	}
+	defer rows.Close()
+
+	// An empty feed still needs an updated time; the epoch keeps it stable until books appear
+	updated := time.Unix(0, 0).UTC()
+	for rows.Next() {
+		var id, title, author, summary, modifiedAt string
+		var publishDate sql.NullTime
+		if err := rows.Scan(&id, &title, &author, &publishDate, &summary, &modifiedAt); err != nil {
+			return document, time.Time{}, err
+		}
+		if _, hasPrice, restricted := regionalOverride(id, country); restricted || (hasPrice && feed.BasePrices) {
+			continue
+		}
+
+		modified, err := parseSQLiteTimestamp(modifiedAt)
+		if err != nil {
+			return document, time.Time{}, err
+		}
+		if modified.After(updated) {
+			updated = modified
+		}
+
+		bookURL := fmt.Sprintf("%s/api/books/%s/details", config.PublicBaseURL, id)
+		entry := atomEntry{
+			Title:   title,
+			ID:      bookURL,
+			Updated: modified.Format(time.RFC3339),
+			Link:    atomLink{Href: bookURL},
+			Author:  atomAuthor{Name: author},
+			Summary: summary,
+		}
+		if publishDate.Valid {
+			entry.Published = publishDate.Time.Format(time.RFC3339)
+		}
+		document.Entries = append(document.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return document, time.Time{}, err
+	}
+
+	document.Updated = updated.Format(time.RFC3339)
+	return document, updated, nil
+}
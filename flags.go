@@ -0,0 +1,310 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long the in-memory flag cache is trusted before re-reading the database.
+// Writes through this instance refresh immediately; the TTL only bounds how long
+// changes made by other instances take to show up.
+const flagCacheTTL = 30 * time.Second
+
+// After a failed refresh the previous snapshot is served this long before the database is tried
+// again, so an outage doesn't turn every flag check into a query
+const flagRefreshRetry = 5 * time.Second
+
+// flagCache holds every flag in memory so evaluation never touches the database
+var flagCache = struct {
+	sync.RWMutex
+	flags    map[string]FeatureFlag
+	loadedAt time.Time
+	retryAt  time.Time // No refresh before this, set when one fails
+
+	refresh sync.Mutex // Held by the one caller refreshing a stale cache
+}{}
+
+// The flag gating the history-based recommendations engine. Users outside its rollout get the
+// top-rated titles everyone without history gets.
+const personalizedRecommendationsFlag = "recommendations.personalized"
+
+// LoadFeatureFlags reads all flags from the database into the in-memory cache
+func LoadFeatureFlags() error {
+	rows, err := db.Query(`
+		SELECT key, description, enabled, rollout_percent, tenants, updated_at
+		FROM feature_flags
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	flags := make(map[string]FeatureFlag)
+	for rows.Next() {
+		var flag FeatureFlag
+		var tenants string
+		if err := rows.Scan(&flag.Key, &flag.Description, &flag.Enabled, &flag.RolloutPercent, &tenants, &flag.UpdatedAt); err != nil {
+			return err
+		}
+		flag.Tenants = splitTenants(tenants)
+		flags[flag.Key] = flag
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	flagCache.Lock()
+	flagCache.flags = flags
+	flagCache.loadedAt = clock.Now()
+	flagCache.retryAt = time.Time{}
+	flagCache.Unlock()
+
+	return nil
+}
+
+// flagCacheStale reports whether the cache is past its TTL and due a refresh
+func flagCacheStale() bool {
+	flagCache.RLock()
+	defer flagCache.RUnlock()
+	now := clock.Now()
+	return now.Sub(flagCache.loadedAt) > flagCacheTTL && !now.Before(flagCache.retryAt)
+}
+
+// refreshFeatureFlags reloads a stale cache. Only one caller reloads at a time; the others go on
+// with the snapshot they have instead of queueing up behind it or querying too.
+func refreshFeatureFlags() {
+	if !flagCache.refresh.TryLock() {
+		return
+	}
+	defer flagCache.refresh.Unlock()
+	// Someone may have reloaded between our check and taking the lock
+	if !flagCacheStale() {
+		return
+	}
+	if err := LoadFeatureFlags(); err != nil {
+		// Keep serving the previous snapshot rather than flipping flags off
+		log.Printf("Error refreshing feature flags, retrying in %v: %v", flagRefreshRetry, err)
+		flagCache.Lock()
+		flagCache.retryAt = clock.Now().Add(flagRefreshRetry)
+		flagCache.Unlock()
+	}
+}
+
+// getFeatureFlag returns a flag from the cache, refreshing the cache when it is stale
+func getFeatureFlag(key string) (FeatureFlag, bool) {
+	if flagCacheStale() {
+		refreshFeatureFlags()
+	}
+
+	flagCache.RLock()
+	defer flagCache.RUnlock()
+	flag, ok := flagCache.flags[key]
+	return flag, ok
+}
+
+// IsFeatureEnabled evaluates a flag for a tenant and subject (usually a user ID).
+// Unknown and disabled flags evaluate to false. Allowlisted tenants always see an
+// enabled flag; everyone else is bucketed deterministically by subject so the same
+// user gets the same answer on every request.
+func IsFeatureEnabled(key, tenantID, subjectID string) bool {
+	flag, ok := getFeatureFlag(key)
+	if !ok || !flag.Enabled {
+		return false
+	}
+
+	for _, tenant := range flag.Tenants {
+		if tenant == tenantID {
+			return true
+		}
+	}
+
+	if flag.RolloutPercent >= 100 {
+		return true
+	}
+	return rolloutBucket(key, subjectID) < flag.RolloutPercent
+}
+
+// FeatureEnabledForRequest evaluates a flag for the request's X-Tenant-ID and session user.
+// Anonymous visitors all share the "" bucket.
+func FeatureEnabledForRequest(r *http.Request, key string) bool {
+	userID, _ := requestUserID(r)
+	return IsFeatureEnabled(key, r.Header.Get("X-Tenant-ID"), userID)
+}
+
+// rolloutBucket maps a subject to a stable bucket in [0, 100) for a given flag.
+// The flag key is part of the hash so each flag gets an independent population.
+func rolloutBucket(key, subjectID string) int {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(key + ":" + subjectID))
+	return int(hasher.Sum32() % 100)
+}
+
+// splitTenants parses the comma-separated tenants column
+func splitTenants(value string) []string {
+	tenants := []string{}
+	for _, tenant := range strings.Split(value, ",") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			tenants = append(tenants, tenant)
+		}
+	}
+	return tenants
+}
+
+// saveFeatureFlag inserts or updates a flag and refreshes the cache
+func saveFeatureFlag(flag FeatureFlag) error {
+	_, err := db.Exec(`
+		INSERT INTO feature_flags (key, description, enabled, rollout_percent, tenants, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			description = excluded.description,
+			enabled = excluded.enabled,
+			rollout_percent = excluded.rollout_percent,
+			tenants = excluded.tenants,
+			updated_at = excluded.updated_at
+	`, flag.Key, flag.Description, flag.Enabled, flag.RolloutPercent, strings.Join(flag.Tenants, ","), dbNow())
+	if err != nil {
+		return err
+	}
+	return LoadFeatureFlags()
+}
+
+// deleteFeatureFlag removes a flag and refreshes the cache, reporting whether it existed
+func deleteFeatureFlag(key string) (bool, error) {
+	result, err := db.Exec("DELETE FROM feature_flags WHERE key = ?", key)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, LoadFeatureFlags()
+}
+
+// FlagsHandler handles /api/admin/flags (list all flags, create a flag)
+func FlagsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if err := LoadFeatureFlags(); err != nil {
+			log.Printf("Error loading feature flags: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to load feature flags")
+			return
+		}
+
+		flagCache.RLock()
+		flags := make([]FeatureFlag, 0, len(flagCache.flags))
+		for _, flag := range flagCache.flags {
+			flags = append(flags, flag)
+		}
+		flagCache.RUnlock()
+
+		writeJSON(w, r, http.StatusOK, flags)
+
+	case http.MethodPost:
+		flag, ok := decodeFeatureFlag(w, r)
+		if !ok {
+			return
+		}
+		if _, exists := getFeatureFlag(flag.Key); exists {
+			writeError(w, r, http.StatusConflict, "Feature flag already exists")
+			return
+		}
+		if err := saveFeatureFlag(flag); err != nil {
+			log.Printf("Error creating feature flag %s: %v", flag.Key, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to create feature flag")
+			return
+		}
+		saved, _ := getFeatureFlag(flag.Key)
+		log.Printf("Created feature flag %s", flag.Key)
+		writeJSON(w, r, http.StatusCreated, saved)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// FlagHandler handles /api/admin/flags/{key} (get, replace, delete a single flag)
+func FlagHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/api/admin/flags/")
+	if key == "" || strings.Contains(key, "/") {
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/admin/flags/{key}")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		flag, ok := getFeatureFlag(key)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "Feature flag not found")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, flag)
+
+	case http.MethodPut:
+		flag, ok := decodeFeatureFlag(w, r)
+		if !ok {
+			return
+		}
+		if flag.Key != "" && flag.Key != key {
+			writeError(w, r, http.StatusBadRequest, "Flag key in body does not match URL")
+			return
+		}
+		flag.Key = key
+		if err := saveFeatureFlag(flag); err != nil {
+			log.Printf("Error updating feature flag %s: %v", key, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to update feature flag")
+			return
+		}
+		saved, _ := getFeatureFlag(key)
+		log.Printf("Updated feature flag %s (enabled=%t, rollout=%d%%)", key, saved.Enabled, saved.RolloutPercent)
+		writeJSON(w, r, http.StatusOK, saved)
+
+	case http.MethodDelete:
+		existed, err := deleteFeatureFlag(key)
+		if err != nil {
+			log.Printf("Error deleting feature flag %s: %v", key, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to delete feature flag")
+			return
+		}
+		if !existed {
+			writeError(w, r, http.StatusNotFound, "Feature flag not found")
+			return
+		}
+		log.Printf("Deleted feature flag %s", key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// decodeFeatureFlag parses and validates a flag from the request body, writing the error response on failure
+func decodeFeatureFlag(w http.ResponseWriter, r *http.Request) (FeatureFlag, bool) {
+	var flag FeatureFlag
+	if !decodeJSONBody(w, r, &flag) {
+		return flag, false
+	}
+
+	// PUT takes the key from the URL, POST must provide it
+	if r.Method == http.MethodPost && strings.TrimSpace(flag.Key) == "" {
+		writeError(w, r, http.StatusBadRequest, "Flag key is required")
+		return flag, false
+	}
+	if strings.Contains(flag.Key, "/") {
+		writeError(w, r, http.StatusBadRequest, "Flag key must not contain '/'")
+		return flag, false
+	}
+	if flag.RolloutPercent < 0 || flag.RolloutPercent > 100 {
+		writeError(w, r, http.StatusBadRequest, "rollout_percent must be between 0 and 100")
+		return flag, false
+	}
+	if flag.Tenants == nil {
+		flag.Tenants = []string{}
+	}
+
+	return flag, true
+}
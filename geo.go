@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CountryResolver works out which country a request comes from, as an ISO 3166-1 alpha-2
+// code, or "" when it can't tell
+type CountryResolver interface {
+	Country(r *http.Request) string
+}
+
+// Resolver used for regional pricing and availability, built from config in NewServer
+var countryResolver CountryResolver = headerCountryResolver{header: config.CountryHeader}
+
+// NewCountryResolver reads the country from the configured header, which the CDN or load
+// balancer sets, and falls back to the GeoIP file when one is configured
+func NewCountryResolver(cfg Config) (CountryResolver, error) {
+	header := headerCountryResolver{header: cfg.CountryHeader}
+	if cfg.GeoIPFile == "" {
+		return header, nil
+	}
+	geoIP, err := loadGeoIPFile(cfg.GeoIPFile)
+	if err != nil {
+		return nil, fmt.Errorf("BOOKSTORE_GEOIP_FILE: %w", err)
+	}
+	return chainedCountryResolver{header, geoIP}, nil
+}
+
+// normalizeCountry upper-cases a country code, returning "" for anything that isn't two letters
+func normalizeCountry(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return ""
+	}
+	return code
+}
+
+// headerCountryResolver trusts a country header set in front of the service
+type headerCountryResolver struct {
+	header string
+}
+
+func (resolver headerCountryResolver) Country(r *http.Request) string {
+	if resolver.header == "" {
+		return ""
+	}
+	return normalizeCountry(r.Header.Get(resolver.header))
+}
+
+// geoIPCountryResolver looks the client address up in a table of network prefixes
+type geoIPCountryResolver struct {
+	prefixes  []netip.Prefix // Most specific first
+	countries []string
+}
+
+// loadGeoIPFile reads "prefix,country" lines such as "203.0.113.0/24,FR". Blank lines and
+// lines starting with # are skipped.
+func loadGeoIPFile(path string) (geoIPCountryResolver, error) {
+	var resolver geoIPCountryResolver
+	file, err := os.Open(path)
+	if err != nil {
+		return resolver, err
+	}
+	defer file.Close()
+
+	type entry struct {
+		prefix  netip.Prefix
+		country string
+	}
+	var entries []entry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rawPrefix, rawCountry, ok := strings.Cut(text, ",")
+		if !ok {
+			return resolver, fmt.Errorf("line %d: expected prefix,country", line)
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(rawPrefix))
+		if err != nil {
+			return resolver, fmt.Errorf("line %d: %v", line, err)
+		}
+		country := normalizeCountry(rawCountry)
+		if country == "" {
+			return resolver, fmt.Errorf("line %d: %q is not a two-letter country code", line, rawCountry)
+		}
+		entries = append(entries, entry{prefix.Masked(), country})
+	}
+	if err := scanner.Err(); err != nil {
+		return resolver, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].prefix.Bits() > entries[j].prefix.Bits() })
+	for _, entry := range entries {
+		resolver.prefixes = append(resolver.prefixes, entry.prefix)
+		resolver.countries = append(resolver.countries, entry.country)
+	}
+	log.Printf("Loaded %d GeoIP prefixes from %s", len(entries), path)
+	return resolver, nil
+}
+
+func (resolver geoIPCountryResolver) Country(r *http.Request) string {
+	addr, err := netip.ParseAddr(remoteIP(r))
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	for i, prefix := range resolver.prefixes {
+		if prefix.Contains(addr) {
+			return resolver.countries[i]
+		}
+	}
+	return ""
+}
+
+// chainedCountryResolver asks each resolver in turn until one knows the country
+type chainedCountryResolver []CountryResolver
+
+func (resolvers chainedCountryResolver) Country(r *http.Request) string {
+	for _, resolver := range resolvers {
+		if country := resolver.Country(r); country != "" {
+			return country
+		}
+	}
+	return ""
+}
+
+// requestCountry resolves the request's country and marks the response as varying with the
+// country header, so shared caches don't hand one country's prices to another
+func requestCountry(w http.ResponseWriter, r *http.Request) string {
+	if config.CountryHeader != "" {
+		w.Header().Add("Vary", config.CountryHeader)
+	}
+	return countryResolver.Country(r)
+}
+
+// regionCache holds every regional price and restriction in memory so the list and detail
+// endpoints never query for them. Like the price experiment cache, writes through this
+// instance reload it and the flag cache TTL bounds how stale other instances get.
+var regionCache = struct {
+	sync.RWMutex
+	prices     map[string]map[string]RegionalPrice // Book ID -> country -> price list
+	restricted map[string]map[string]bool          // Book ID -> countries it can't be sold in
+	loadedAt   time.Time
+}{}
+
+// LoadRegionalOverrides reads all regional prices and restrictions into the in-memory cache
+func LoadRegionalOverrides() error {
+	prices := map[string]map[string]RegionalPrice{}
+	rows, err := db.Query("SELECT book_id, country, price, sale_price, currency FROM regional_prices")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var bookID string
+		var price RegionalPrice
+		var salePrice sql.NullFloat64
+		if err := rows.Scan(&bookID, &price.Country, &price.Price, &salePrice, &price.Currency); err != nil {
+			rows.Close()
+			return err
+		}
+		if salePrice.Valid {
+			price.SalePrice = &salePrice.Float64
+		}
+		if prices[bookID] == nil {
+			prices[bookID] = map[string]RegionalPrice{}
+		}
+		prices[bookID][price.Country] = price
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	restricted := map[string]map[string]bool{}
+	rows, err = db.Query("SELECT book_id, country FROM regional_restrictions")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bookID, country string
+		if err := rows.Scan(&bookID, &country); err != nil {
+			return err
+		}
+		if restricted[bookID] == nil {
+			restricted[bookID] = map[string]bool{}
+		}
+		restricted[bookID][country] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	regionCache.Lock()
+	regionCache.prices = prices
+	regionCache.restricted = restricted
+	regionCache.loadedAt = clock.Now()
+	regionCache.Unlock()
+	return nil
+}
+
+// refreshRegionCache reloads the cache once it is older than the flag cache TTL
+func refreshRegionCache() {
+	regionCache.RLock()
+	stale := clock.Now().Sub(regionCache.loadedAt) > flagCacheTTL
+	regionCache.RUnlock()
+	if stale {
+		if err := LoadRegionalOverrides(); err != nil {
+			log.Printf("Error refreshing regional overrides: %v", err)
+		}
+	}
+}
+
+// regionalOverride returns a book's price list in a country and whether it is restricted there
+func regionalOverride(bookID, country string) (RegionalPrice, bool, bool) {
+	if country == "" {
+		return RegionalPrice{}, false, false
+	}
+	refreshRegionCache()
+	regionCache.RLock()
+	defer regionCache.RUnlock()
+	price, hasPrice := regionCache.prices[bookID][country]
+	return price, hasPrice, regionCache.restricted[bookID][country]
+}
+
+// writeRegionRestricted answers for a book that can't be sold in the request's country
+func writeRegionRestricted(w http.ResponseWriter, r *http.Request, country string) {
+	writeError(w, r, http.StatusUnavailableForLegalReasons, "This book is not available in "+country)
+}
+
+// checkRegion writes a 451 and returns false when the book can't be sold in the request's
+// country, for endpoints about a single book
+func checkRegion(w http.ResponseWriter, r *http.Request, bookID string) bool {
+	if country := requestCountry(w, r); bookRestrictedIn(bookID, country) {
+		writeRegionRestricted(w, r, country)
+		return false
+	}
+	return true
+}
+
+// bookRestrictedIn reports whether a book can't be sold in country
+func bookRestrictedIn(bookID, country string) bool {
+	_, _, restricted := regionalOverride(bookID, country)
+	return restricted
+}
+
+// applyRegion shows a book's details as seen from the request's country: its regional price
+// list swapped in and recommendations restricted there left out. When the book itself is
+// restricted it writes a 451 and returns false; callers send nothing else.
+func applyRegion(w http.ResponseWriter, r *http.Request, bookID string, details *bookDetails) bool {
+	country := requestCountry(w, r)
+	if bookRestrictedIn(bookID, country) {
+		writeRegionRestricted(w, r, country)
+		return false
+	}
+	if localizePricing(bookID, country, &details.Pricing) {
+		w.Header().Set("X-Price-Region", country)
+	}
+	details.Recommendations = regionalRecommendations(country, details.Recommendations)
+	return true
+}
+
+// localizePricing swaps a book's regional price list for country into pricing, reporting
+// whether there was one. Restricted books are for the caller to check first.
+func localizePricing(bookID, country string, pricing *sectionResult[BookPricing]) bool {
+	price, hasPrice, _ := regionalOverride(bookID, country)
+	if !hasPrice || pricing.Err != nil {
+		return false
+	}
+
+	// Section data may be shared with the details cache, so pointers get fresh values. A
+	// promotion belongs to the regular price list and doesn't carry over.
+	pricing.Data.Price = price.Price
+	pricing.Data.Currency = price.Currency
+	pricing.Data.SalePrice = nil
+	pricing.Data.Discount = 0
+	pricing.Data.Promotion = nil
+	if price.SalePrice != nil {
+		salePrice := *price.SalePrice
+		pricing.Data.SalePrice = &salePrice
+		if price.Price > 0 {
+			pricing.Data.Discount = math.Round((1-salePrice/price.Price)*100) / 100
+		}
+	}
+	return true
+}
+
+// localizeBook swaps in a book's regional list price for country, returning false when the
+// book is restricted there and must be left out
+func localizeBook(book *Book, country string) bool {
+	price, hasPrice, restricted := regionalOverride(book.ID, country)
+	if restricted {
+		return false
+	}
+	if hasPrice {
+		book.Price = price.Price
+		book.Currency = price.Currency
+	}
+	return true
+}
+
+// regionalRecommendations leaves out recommended books restricted in country. Recommendations
+// are cached for every country alike, so the items are copied rather than filtered in place.
+func regionalRecommendations(country string, section sectionResult[Recommendations]) sectionResult[Recommendations] {
+	if country == "" || section.Err != nil {
+		return section
+	}
+	items := make([]RecommendationItem, 0, len(section.Data.Items))
+	for _, item := range section.Data.Items {
+		if !bookRestrictedIn(item.BookID, country) {
+			items = append(items, item)
+		}
+	}
+	section.Data.Items = items
+	return section
+}
+
+// loadBookRegions reads a book's regional prices and restrictions, bypassing the cache
+func loadBookRegions(ctx context.Context, bookID string) (BookRegions, error) {
+	regions := BookRegions{BookID: bookID, Prices: []RegionalPrice{}, Restricted: []string{}}
+	var exists int
+	if err := db.QueryRowContext(ctx, "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+		return regions, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT country, price, sale_price, currency FROM regional_prices WHERE book_id = ? ORDER BY country", bookID)
+	if err != nil {
+		return regions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var price RegionalPrice
+		var salePrice sql.NullFloat64
+		if err := rows.Scan(&price.Country, &price.Price, &salePrice, &price.Currency); err != nil {
+			return regions, err
+		}
+		if salePrice.Valid {
+			price.SalePrice = &salePrice.Float64
+		}
+		regions.Prices = append(regions.Prices, price)
+	}
+	if err := rows.Err(); err != nil {
+		return regions, err
+	}
+
+	restrictedRows, err := db.QueryContext(ctx, "SELECT country FROM regional_restrictions WHERE book_id = ? ORDER BY country", bookID)
+	if err != nil {
+		return regions, err
+	}
+	defer restrictedRows.Close()
+	for restrictedRows.Next() {
+		var country string
+		if err := restrictedRows.Scan(&country); err != nil {
+			return regions, err
+		}
+		regions.Restricted = append(regions.Restricted, country)
+	}
+	return regions, restrictedRows.Err()
+}
+
+// saveBookRegions replaces a book's regional prices and restrictions and reloads the cache
+func saveBookRegions(ctx context.Context, regions BookRegions) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"regional_prices", "regional_restrictions"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE book_id = ?", regions.BookID); err != nil {
+			return err
+		}
+	}
+	for _, price := range regions.Prices {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO regional_prices (book_id, country, price, sale_price, currency, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		`, regions.BookID, price.Country, price.Price, price.SalePrice, price.Currency, dbNow()); err != nil {
+			return err
+		}
+	}
+	for _, country := range regions.Restricted {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO regional_restrictions (book_id, country, created_at) VALUES (?, ?, ?)", regions.BookID, country, dbNow()); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return LoadRegionalOverrides()
+}
+
+// validateBookRegions normalizes the country codes in a request body, returning the problem
+// to report or "" when it is valid
+func validateBookRegions(regions *BookRegions) string {
+	seen := map[string]bool{}
+	for i, price := range regions.Prices {
+		country := normalizeCountry(price.Country)
+		if country == "" {
+			return fmt.Sprintf("%q is not a two-letter country code", price.Country)
+		}
+		if seen[country] {
+			return fmt.Sprintf("Country %s has more than one price list", country)
+		}
+		seen[country] = true
+		if price.Price <= 0 {
+			return "Regional prices must be above 0"
+		}
+		if price.SalePrice != nil && (*price.SalePrice <= 0 || *price.SalePrice > price.Price) {
+			return "sale_price must be above 0 and at most the price"
+		}
+		if len(price.Currency) != 3 {
+			return "currency must be a three-letter ISO 4217 code"
+		}
+		regions.Prices[i].Country = country
+		regions.Prices[i].Currency = strings.ToUpper(price.Currency)
+	}
+
+	restricted := map[string]bool{}
+	for i, code := range regions.Restricted {
+		country := normalizeCountry(code)
+		if country == "" {
+			return fmt.Sprintf("%q is not a two-letter country code", code)
+		}
+		if restricted[country] {
+			return fmt.Sprintf("Country %s is listed twice", country)
+		}
+		restricted[country] = true
+		regions.Restricted[i] = country
+	}
+	return ""
+}
+
+// BookRegionsHandler handles /api/admin/books/{id}/regions. GET returns the book's regional
+// prices and restrictions; PUT replaces both with the body, e.g.
+// {"prices": [{"country": "DE", "price": 34.99, "currency": "EUR"}], "restricted": ["CN"]}.
+func BookRegionsHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var regions BookRegions
+		if !decodeJSONBody(w, r, &regions) {
+			return
+		}
+		regions.BookID = bookID
+		if problem := validateBookRegions(&regions); problem != "" {
+			writeError(w, r, http.StatusBadRequest, problem)
+			return
+		}
+		var exists int
+		if err := db.QueryRowContext(r.Context(), "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "Book not found")
+			return
+		}
+		if err := saveBookRegions(r.Context(), regions); err != nil {
+			log.Printf("Error saving regional overrides for book %s: %v", bookID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to save regional overrides")
+			return
+		}
+		log.Printf("Set %d regional prices and %d restrictions for book %s", len(regions.Prices), len(regions.Restricted), bookID)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	regions, err := loadBookRegions(r.Context(), bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "Book not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading regional overrides for book %s: %v", bookID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load regional overrides")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, regions)
+}
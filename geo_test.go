@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRegionAppliesAcrossCatalogPaths(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t)
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	// Clean Code can't be sold in Germany, and The Go Programming Language has a euro price list there
+	if status, body := doRequest(t, client, http.MethodPut, server.URL+"/api/admin/books/2/regions", `{"restricted": ["DE"]}`, admin...); status != http.StatusOK {
+		t.Fatalf("restricting book 2 = %d %s", status, body)
+	}
+	if status, body := doRequest(t, client, http.MethodPut, server.URL+"/api/admin/books/1/regions",
+		`{"prices": [{"country": "DE", "price": 30, "sale_price": null, "currency": "EUR"}]}`, admin...); status != http.StatusOK {
+		t.Fatalf("pricing book 1 = %d %s", status, body)
+	}
+	germany := []string{config.CountryHeader, "DE"}
+
+	status, body := doRequest(t, client, http.MethodGet, server.URL+"/api/books/search?q=clean+code", "", germany...)
+	var search SearchResponse
+	if err := json.Unmarshal([]byte(body), &search); status != http.StatusOK || err != nil {
+		t.Fatalf("search = %d %s", status, body)
+	}
+	for _, result := range search.Results {
+		if result.ID == "2" {
+			t.Errorf("search from Germany returned restricted book 2")
+		}
+	}
+	if _, body := doRequest(t, client, http.MethodGet, server.URL+"/api/books/search?q=clean+code", ""); !strings.Contains(body, `"id":"2"`) {
+		t.Errorf("search without a country left out book 2: %s", body)
+	}
+
+	status, body = doRequest(t, client, http.MethodGet, server.URL+"/api/books/compare?ids=1,2", "", germany...)
+	var comparison BookComparisonResponse
+	if err := json.Unmarshal([]byte(body), &comparison); status != http.StatusOK || err != nil {
+		t.Fatalf("compare = %d %s", status, body)
+	}
+	if comparison.Statuses[1] != "restricted" || comparison.Rows["price"][1] != nil {
+		t.Errorf("compare from Germany shows book 2 as %q priced %v, want restricted with no price", comparison.Statuses[1], comparison.Rows["price"][1])
+	}
+	if comparison.Rows["price"][0] != 30.0 || comparison.Rows["currency"][0] != "EUR" {
+		t.Errorf("compare from Germany prices book 1 at %v %v, want 30 EUR", comparison.Rows["price"][0], comparison.Rows["currency"][0])
+	}
+
+	if status, _ := doRequest(t, client, http.MethodGet, server.URL+"/api/books/2/shipping?postal_code=94105", "", germany...); status != http.StatusUnavailableForLegalReasons {
+		t.Errorf("shipping quote for book 2 from Germany = %d, want 451", status)
+	}
+
+	// The deals feed quotes the regular price list, which book 1 doesn't sell at in Germany
+	_, body = doRequest(t, client, http.MethodGet, server.URL+"/feeds/deals.xml", "", germany...)
+	for _, id := range []string{"1", "2"} {
+		if strings.Contains(body, "/api/books/"+id+"/details") {
+			t.Errorf("deals feed from Germany lists book %s", id)
+		}
+	}
+	if !strings.Contains(body, "/api/books/4/details") {
+		t.Errorf("deals feed from Germany lost unaffected book 4: %s", body)
+	}
+}
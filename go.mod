@@ -2,4 +2,9 @@ module github.com/mounirkanane/scalable-webservice
 
 go 1.23.4
 
-require github.com/mattn/go-sqlite3 v1.14.28
+require (
+	github.com/mattn/go-sqlite3 v1.14.28
+	golang.org/x/crypto v0.31.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
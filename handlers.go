@@ -1,8 +1,11 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
+	"errors"
 	"log"
+	"math/rand"
 	"net/http"
 	"strings"
 	"time"
@@ -13,22 +16,120 @@ func BooksHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate the HTTP method
 	if r.Method != http.MethodGet {
 		log.Printf("Method %s not allowed for %s", r.Method, r.URL.Path)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Set content type header
-	w.Header().Set("Content-Type", "application/json")
+	ctx := r.Context()
+	rows, err := dbFor(ctx).QueryContext(ctx, `
+		SELECT b.id, b.title, b.author, COALESCE(p.price, 0)
+		FROM books b
+		LEFT JOIN pricing p ON p.book_id = b.id
+		ORDER BY b.id
+	`)
+	if err != nil {
+		log.Printf("Error listing books: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to list books")
+		return
+	}
 
-	// Encode and stream books as a JSON response
-	err := json.NewEncoder(w).Encode(books)
+	// Priced for the request's country, with books that can't be sold there left out
+	country := requestCountry(w, r)
+	scan := func(rows *sql.Rows) (Book, error) {
+		var book Book
+		if err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.Price); err != nil {
+			return book, err
+		}
+		if !localizeBook(&book, country) {
+			return book, errSkipRow
+		}
+		return book, nil
+	}
+
+	// The envelope's meta comes after the data and pretty printing needs the whole value, so
+	// those are built in memory; everything else streams however large the catalog is
+	w.Header().Add("Vary", "Accept")
+	if wantsEnvelope(r) || wantsPretty(r) {
+		visible, err := collectRows(ctx, rows, scan)
+		if err != nil {
+			log.Printf("Error listing books: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to list books")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, visible)
+		logRequest(r, "books", "Successfully returned %d books to %s", len(visible), r.RemoteAddr)
+		return
+	}
+	written, err := streamJSONRows(ctx, w, r, rows, false, scan)
 	if err != nil {
-		log.Printf("Error occurred while encoding JSON: %v", err)
+		log.Printf("Books list stopped after %d books: %v", written, err)
 		return
 	}
 
 	// Log successful operation
-	log.Printf("Successfully returned %d books to %s", len(books), r.RemoteAddr)
+	logRequest(r, "books", "Successfully returned %d books to %s", written, r.RemoteAddr)
+}
+
+// BookResourceHandler routes /api/books/{id}/{resource} to the handler for that resource
+func BookResourceHandler(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(r.URL.Path, "/") // {"", "api", "books", "123", "similar"}
+	if len(pathParts) == 5 {
+		switch pathParts[4] {
+		case "similar":
+			SimilarBooksHandler(w, r, pathParts[3])
+			return
+		case "rating":
+			RatingHandler(w, r, pathParts[3])
+			return
+		case "shipping":
+			ShippingHandler(w, r, pathParts[3])
+			return
+		case "availability":
+			AvailabilityHandler(w, r, pathParts[3])
+			return
+		}
+	}
+	BookDetailHandler(w, r)
+}
+
+// AdminBookResourceHandler routes /api/admin/books/... to duplicate detection, merging,
+// cost prices, regional overrides, processing state or translations
+func AdminBookResourceHandler(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(r.URL.Path, "/") // {"", "api", "admin", "books", "123", "processing"}
+	if len(pathParts) == 5 && pathParts[4] == "duplicates" {
+		DuplicatesHandler(w, r)
+		return
+	}
+	if (len(pathParts) == 5 && pathParts[4] != "") || (len(pathParts) == 6 && pathParts[4] != "" && pathParts[5] == "") {
+		AdminBookDetailHandler(w, r, pathParts[4])
+		return
+	}
+	if len(pathParts) == 6 && pathParts[4] != "" && pathParts[5] == "lock" {
+		EditLockHandler(w, r, pathParts[4])
+		return
+	}
+	// Changes to a book someone else has locked for editing are refused
+	if len(pathParts) >= 6 && pathParts[4] != "" && r.Method != http.MethodGet && r.Method != http.MethodHead &&
+		!checkEditLock(w, r, pathParts[4]) {
+		return
+	}
+	if len(pathParts) == 6 && pathParts[4] != "" && pathParts[5] == "merge" {
+		MergeHandler(w, r, pathParts[4])
+		return
+	}
+	if len(pathParts) == 6 && pathParts[4] != "" && pathParts[5] == "cost-price" {
+		CostPriceHandler(w, r, pathParts[4])
+		return
+	}
+	if len(pathParts) == 6 && pathParts[4] != "" && pathParts[5] == "regions" {
+		BookRegionsHandler(w, r, pathParts[4])
+		return
+	}
+	if len(pathParts) >= 6 && pathParts[4] != "" && pathParts[5] == "processing" {
+		BookProcessingHandler(w, r, pathParts[4])
+		return
+	}
+	TranslationsHandler(w, r)
 }
 
 // BookDetailHandler handles requests to /api/books/{id}/details with mode selection
@@ -38,130 +139,281 @@ func BookDetailHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Verify URL format
 	if len(pathParts) < 5 || pathParts[4] != "details" {
-		http.Error(w, "Invalid URL Format. Expected /api/books/{id}/details", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/books/{id}/details")
 		return
 	}
 
 	// Extract book ID from URL
 	bookID := pathParts[3]
-	log.Printf("Processing book details request for ID: %s", bookID)
+	logRequest(r, "details", "Processing book details request for ID: %s", bookID)
 
-	// Check query parameter for processing mode (default to sequential)
-	mode := r.URL.Query().Get("mode")
-	if mode == "" {
-		mode = "sequential"
+	mode, ok := detailMode(w, r, bookID)
+	if !ok {
+		return
 	}
 
-	log.Printf("Processing book details request for ID: %s using %s mode", bookID, mode)
+	// Bound the whole request by the route timeout; every stage below derives its deadline from it
+	ctx, cancel := context.WithTimeout(r.Context(), config.DetailRequestTimeout)
+	defer cancel()
+	ctx = withRequestCache(ctx) // Sections needing the same row share one query
+	r = r.WithContext(ctx)
+
+	// A revalidated page is still a view
+	recordBookViewAsync(r, bookID)
+
+	// Mobile clients poll details; skip all the work when nothing they have is stale
+	if checkNotModified(ctx, w, r, bookID) {
+		return
+	}
 
 	// Route to appropriate handler based on mode
+	startTime := time.Now()
 	switch mode {
 	case "sequential":
 		handleSequentialBookDetails(w, r, bookID)
 	case "concurrent":
 		handleConcurrentBookDetails(w, r, bookID)
-	default:
-		http.Error(w, "Invalid mode. Use 'sequential' or 'concurrent'", http.StatusBadRequest)
 	}
+	recordDetailRequest(mode, time.Since(startTime))
 }
 
-// handleSequentialBookDetails processes database queries and external API calls one after another
-func handleSequentialBookDetails(w http.ResponseWriter, r *http.Request, bookID string) {
-	startTime := time.Now()
+// detailMode picks the coordination strategy for a detail request and tags the response with it.
+// It writes a 400 and returns false when the requested mode is invalid.
+func detailMode(w http.ResponseWriter, r *http.Request, bookID string) (string, bool) {
+	// Check query parameter for processing mode; without one, the canary split decides
+	mode := r.URL.Query().Get("mode")
+	assignment := "explicit"
+	if mode == "" {
+		mode = assignCanaryVariant()
+		assignment = "canary"
+	}
 
-	// Get user ID for personalized recommendations (default to demo user)
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		userID = "demo_user"
+	if mode != "sequential" && mode != "concurrent" {
+		writeError(w, r, http.StatusBadRequest, "Invalid mode. Use 'sequential' or 'concurrent'")
+		return "", false
 	}
 
-	// Sequential approach: call each operation one at a time
-	metadata := FetchBookMetadata(bookID)
-	pricing := FetchBookPricing(bookID)
-	inventory := FetchBookInventory(bookID)
-	reviews := FetchBookReviews(bookID)
-	recommendations := FetchPersonalizedRecommendations(bookID, userID) // This one calls external API!
+	logRequest(r, "details", "Processing book details request for ID: %s using %s mode (%s)", bookID, mode, assignment)
 
-	// Build comprehensive response
-	response := BookDetailsResponse{
-		BookID:          bookID,
-		Metadata:        metadata,
-		Pricing:         pricing,
-		Inventory:       inventory,
-		Reviews:         reviews,
-		Recommendations: recommendations,
-		Duration:        time.Since(startTime).Milliseconds(),
+	// Tag the response so clients and logs can tell which strategy served it
+	w.Header().Set("X-Coordination-Variant", mode)
+	w.Header().Set("X-Coordination-Assignment", assignment)
+	return mode, true
+}
+
+// assignCanaryVariant picks the coordination strategy for a request that didn't ask for one,
+// sending ConcurrentCanaryPercent of traffic to concurrent mode and the rest to sequential
+func assignCanaryVariant() string {
+	if rand.Intn(100) < config.ConcurrentCanaryPercent {
+		return "concurrent"
+	}
+	return "sequential"
+}
+
+// detailUserID returns the user for personalized recommendations, see requestUserID. Visitors
+// without a session are "" and share anonymous recommendations. They used to be treated as a
+// "demo_user" account, which put them in the same cache entries as anyone actually using it.
+// A ?user_id= naming someone else gets the anonymous page rather than that user's.
+func detailUserID(r *http.Request) string {
+	userID, err := requestUserID(r)
+	if err != nil {
+		return ""
+	}
+	return userID
+}
+
+// recommendationUserID is detailUserID for users in the personalized recommendations rollout,
+// and "" for everyone else, so they get the anonymous recommendations
+func recommendationUserID(r *http.Request) string {
+	userID := detailUserID(r)
+	if userID == "" || !FeatureEnabledForRequest(r, personalizedRecommendationsFlag) {
+		return ""
 	}
+	return userID
+}
 
-	// Send JSON response with pretty printing
-	w.Header().Set("Content-Type", "application/json")
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
-	encoder.Encode(response)
+// handleSequentialBookDetails processes database queries and external API calls one after another
+func handleSequentialBookDetails(w http.ResponseWriter, r *http.Request, bookID string) {
+	startTime := time.Now()
 
-	log.Printf("Sequential processing completed in %v", time.Since(startTime))
+	details := loadBookDetailsSequential(r.Context(), bookID, recommendationUserID(r))
+	writeBookDetailsV1(w, r, bookID, details, startTime)
+
+	logRequest(r, "details", "Sequential processing completed in %v", time.Since(startTime))
 }
 
 // handleConcurrentBookDetails processes database queries and external API calls concurrently using goroutines
 func handleConcurrentBookDetails(w http.ResponseWriter, r *http.Request, bookID string) {
 	startTime := time.Now()
 
-	// Get user ID for personalized recommendations (default to demo user)
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		userID = "demo_user"
-	}
-
-	// Create channels to receive results from each operation
-	metadataChannel := make(chan map[string]interface{})
-	pricingChannel := make(chan map[string]interface{})
-	inventoryChannel := make(chan map[string]interface{})
-	reviewsChannel := make(chan map[string]interface{})
-	recommendationsChannel := make(chan map[string]interface{})
-
-	// Launch concurrent goroutines for each operation
-	go func() {
-		result := FetchBookMetadata(bookID)
-		metadataChannel <- result
-	}()
-
-	go func() {
-		result := FetchBookPricing(bookID)
-		pricingChannel <- result
-	}()
-
-	go func() {
-		result := FetchBookInventory(bookID)
-		inventoryChannel <- result
-	}()
-
-	go func() {
-		result := FetchBookReviews(bookID)
-		reviewsChannel <- result
-	}()
-
-	go func() {
-		result := FetchPersonalizedRecommendations(bookID, userID) // This one calls external API!
-		recommendationsChannel <- result
-	}()
-
-	// Collect results from all channels (fan-in coordination)
-	// This blocks until all goroutines complete and send their results
+	details := loadBookDetailsConcurrent(r.Context(), bookID, recommendationUserID(r))
+	writeBookDetailsV1(w, r, bookID, details, startTime)
+
+	logRequest(r, "details", "Concurrent processing completed in %v", time.Since(startTime))
+}
+
+// writeBookDetailsV1 sends the original map-based details response
+func writeBookDetailsV1(w http.ResponseWriter, r *http.Request, bookID string, details bookDetails, startTime time.Time) {
+	if !applyRegion(w, r, bookID, &details) {
+		return
+	}
+	setContentLanguage(w, localizeMetadata(r.Context(), r, bookID, &details.Metadata))
+	applyPriceExperiment(w, r, bookID, &details.Pricing)
+
+	// Build comprehensive response
 	response := BookDetailsResponse{
 		BookID:          bookID,
-		Metadata:        <-metadataChannel,
-		Pricing:         <-pricingChannel,
-		Inventory:       <-inventoryChannel,
-		Reviews:         <-reviewsChannel,
-		Recommendations: <-recommendationsChannel,
+		Metadata:        metadataV1(details.Metadata),
+		Pricing:         pricingV1(details.Pricing),
+		Inventory:       inventoryV1(details.Inventory),
+		Reviews:         reviewsV1(details.Reviews),
+		Recommendations: recommendationsV1(details.Recommendations),
 		Duration:        time.Since(startTime).Milliseconds(),
 	}
 
-	// Send JSON response with pretty printing
-	w.Header().Set("Content-Type", "application/json")
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
-	encoder.Encode(response)
+	// The v1 body format is frozen, so partial failures and timing are only reported in headers
+	w.Header().Set("X-Details-Status", details.overallStatus())
+	setServerTiming(w, newDetailTiming(r.Context(), details))
+
+	// Send JSON response (indented only with ?pretty=1)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// The v1 response has always used loosely typed maps with errors mixed into the data.
+// These converters keep its output unchanged on top of the typed fetch results.
+
+// databaseErrorV1 is the v1 error map for a failed database section
+func databaseErrorV1(err error, message string) map[string]interface{} {
+	if errors.Is(err, errDatabaseBusy) {
+		message = "Database busy"
+	}
+	return map[string]interface{}{
+		"error": message,
+	}
+}
+
+// metadataV1 converts the metadata section to its v1 map
+func metadataV1(section sectionResult[BookMetadata]) map[string]interface{} {
+	if section.Err != nil {
+		return databaseErrorV1(section.Err, "Failed to fetch book metadata")
+	}
+	metadata := section.Data
+
+	publishDate := ""
+	if metadata.PublishDate != nil {
+		publishDate = metadata.PublishDate.Format(time.RFC3339Nano)
+	}
+
+	return map[string]interface{}{
+		"title":        metadata.Title,
+		"author":       metadata.Author,
+		"isbn":         stringOrEmpty(metadata.ISBN),
+		"publish_date": publishDate,
+		"description":  stringOrEmpty(metadata.Description),
+	}
+}
+
+// pricingV1 converts the pricing section to its v1 map
+func pricingV1(section sectionResult[BookPricing]) map[string]interface{} {
+	if section.Err != nil {
+		return databaseErrorV1(section.Err, "Failed to fetch pricing information")
+	}
+	pricing := section.Data
 
-	log.Printf("Concurrent processing completed in %v", time.Since(startTime))
-}
\ No newline at end of file
+	salePrice := 0.0
+	if pricing.SalePrice != nil {
+		salePrice = *pricing.SalePrice
+	}
+
+	return map[string]interface{}{
+		"price":      pricing.Price,
+		"currency":   pricing.Currency,
+		"discount":   pricing.Discount,
+		"sale_price": salePrice,
+		"promotion":  stringOrEmpty(pricing.Promotion),
+	}
+}
+
+// inventoryV1 converts the inventory section to its v1 map
+func inventoryV1(section sectionResult[BookInventory]) map[string]interface{} {
+	if section.Err != nil {
+		return databaseErrorV1(section.Err, "Failed to fetch inventory information")
+	}
+	inventory := section.Data
+
+	return map[string]interface{}{
+		"in_stock":      inventory.InStock,
+		"quantity":      inventory.Quantity,
+		"warehouse":     stringOrEmpty(inventory.Warehouse),
+		"shipping_time": stringOrEmpty(inventory.ShippingTime),
+	}
+}
+
+// reviewsV1 converts the reviews section to its v1 map
+func reviewsV1(section sectionResult[BookReviews]) map[string]interface{} {
+	if section.Err != nil {
+		return databaseErrorV1(section.Err, "Failed to fetch reviews")
+	}
+	reviews := section.Data
+
+	averageRating := 0.0
+	if reviews.AverageRating != nil {
+		averageRating = *reviews.AverageRating
+	}
+
+	return map[string]interface{}{
+		"average_rating": averageRating,
+		"total_reviews":  reviews.TotalReviews,
+		"recent_review":  stringOrEmpty(reviews.RecentReview),
+		"rating_breakdown": map[string]int{
+			"5_star": reviews.RatingBreakdown.FiveStar,
+			"4_star": reviews.RatingBreakdown.FourStar,
+			"3_star": reviews.RatingBreakdown.ThreeStar,
+			"2_star": reviews.RatingBreakdown.TwoStar,
+			"1_star": reviews.RatingBreakdown.OneStar,
+		},
+	}
+}
+
+// recommendationsV1 converts the recommendations section to its v1 map
+func recommendationsV1(section sectionResult[Recommendations]) map[string]interface{} {
+	if section.Err != nil {
+		source := "external_api_failed"
+		if errors.Is(section.Err, errUpstreamBudgetExhausted) {
+			source = "external_api_skipped"
+		}
+		return map[string]interface{}{
+			"error":  "Failed to fetch recommendations",
+			"source": source,
+		}
+	}
+	recommendations := section.Data
+
+	items := make([]map[string]interface{}, 0, len(recommendations.Items))
+	for _, item := range recommendations.Items {
+		items = append(items, map[string]interface{}{
+			"title":  item.Title,
+			"source": item.Source,
+		})
+	}
+
+	response := map[string]interface{}{
+		"user_id":         recommendations.UserID,
+		"book_id":         recommendations.BookID,
+		"external_quote":  recommendations.RawQuote, // This is real data from the external API!
+		"recommendations": items,
+		"api_source":      recommendations.APISource,
+	}
+	if section.Stale {
+		response["stale"] = true
+	}
+	return response
+}
+
+// stringOrEmpty dereferences an optional string, using "" for nil
+func stringOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
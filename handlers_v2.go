@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BookDetailV2Handler handles requests to /api/v2/books/{id}/details, returning the typed
+// BookDetailsV2Response. Mode selection, canary routing and the route timeout work as in v1.
+// Responses where some sections failed are sent as 207 Multi-Status with per-section status.
+func BookDetailV2Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Parse URL path to extract book ID
+	pathParts := strings.Split(r.URL.Path, "/") // {"", "api", "v2", "books", "123", "details"}
+	if len(pathParts) != 6 || pathParts[4] == "" || pathParts[5] != "details" {
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/v2/books/{id}/details")
+		return
+	}
+	bookID := pathParts[4]
+
+	mode, ok := detailMode(w, r, bookID)
+	if !ok {
+		return
+	}
+
+	// Bound the whole request by the route timeout; every stage below derives its deadline from it
+	ctx, cancel := context.WithTimeout(r.Context(), config.DetailRequestTimeout)
+	defer cancel()
+	ctx = withRequestCache(ctx) // Sections needing the same row share one query
+
+	// A revalidated page is still a view
+	recordBookViewAsync(r, bookID)
+
+	// Mobile clients poll details; skip all the work when nothing they have is stale
+	if checkNotModified(ctx, w, r, bookID) {
+		return
+	}
+
+	startTime := time.Now()
+	details := loadBookDetails(ctx, mode, bookID, recommendationUserID(r))
+	if !applyRegion(w, r, bookID, &details) {
+		return
+	}
+	setContentLanguage(w, localizeMetadata(ctx, r, bookID, &details.Metadata))
+	applyPriceExperiment(w, r, bookID, &details.Pricing)
+
+	response := BookDetailsV2Response{
+		BookID:          bookID,
+		Status:          details.overallStatus(),
+		Mode:            mode,
+		Metadata:        newDetailSection(details.Metadata),
+		Pricing:         newDetailSection(details.Pricing),
+		Inventory:       newDetailSection(details.Inventory),
+		Reviews:         newDetailSection(details.Reviews),
+		Recommendations: newDetailSection(details.Recommendations),
+		DurationMs:      time.Since(startTime).Milliseconds(),
+	}
+	response.Meta.Timing = newDetailTiming(ctx, details)
+	setServerTiming(w, response.Meta.Timing)
+	if locale, ok := requestDisplayLocale(r); ok {
+		response.Display = newBookDisplay(locale, details)
+	}
+
+	// Partial results are still a useful answer, but say so in the status code as well
+	status := http.StatusOK
+	switch response.Status {
+	case "partial":
+		status = http.StatusMultiStatus
+	case sectionNotFound:
+		status = http.StatusNotFound
+	}
+	w.Header().Set("X-Details-Status", response.Status)
+	writeJSON(w, r, status, response)
+
+	recordDetailRequest(mode, time.Since(startTime))
+	logRequest(r, "details", "v2 %s processing completed in %v", mode, time.Since(startTime))
+}
+
+// newDetailSection converts a loaded section to its v2 form; failed sections get null data
+func newDetailSection[T any](section sectionResult[T]) DetailSection[T] {
+	detail := DetailSection[T]{Status: section.Status(), Source: section.Source}
+	if section.Err == nil {
+		data := section.Data
+		fetchedAt := section.FetchedAt.UTC()
+		detail.Data = &data
+		detail.FetchedAt = &fetchedAt
+	}
+	return detail
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"time"
+)
+
+// Hedged read counters keyed by query class
+var (
+	hedgedReadsIssued = expvar.NewMap("db_hedged_reads_issued")
+	hedgedReadsWon    = expvar.NewMap("db_hedged_reads_won") // The hedge answered before the original
+)
+
+// hedgeResult carries one attempt's outcome back to hedgedRead
+type hedgeResult[T any] struct {
+	value  T
+	err    error
+	hedged bool
+}
+
+// hedgedRead runs read, and if it hasn't finished within the hedge delay configured for the
+// query class, runs an identical second read on another pooled connection and returns whichever
+// succeeds first. The loser is cancelled. Classes without a configured delay just run read once.
+//
+// The caller must already hold a database bulkhead slot for the first attempt. The hedge only
+// goes out if another slot is free right away, so hedging backs off under load instead of
+// doubling it. Each attempt must scan into its own variables since both may run at once.
+func hedgedRead[T any](ctx context.Context, class string, read func(ctx context.Context) (T, error)) (T, error) {
+	delay, ok := config.DatabaseHedgeDelays[class]
+	if !ok {
+		return read(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Cancels whichever attempt is still running
+
+	// Buffered so the losing attempt can always deliver and exit
+	results := make(chan hedgeResult[T], 2)
+	attempt := func(hedged bool) {
+		value, err := read(ctx)
+		results <- hedgeResult[T]{value: value, err: err, hedged: hedged}
+	}
+
+	go attempt(false)
+	pending := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-timer.C:
+			if pending == 0 || !dbBulkhead.TryAcquire() {
+				continue
+			}
+			hedgedReadsIssued.Add(class, 1)
+			pending++
+			go func() {
+				defer dbBulkhead.Release()
+				attempt(true)
+			}()
+
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				if result.hedged {
+					hedgedReadsWon.Add(class, 1)
+				}
+				return result.value, nil
+			}
+			lastErr = result.err
+			if pending == 0 {
+				// Nothing else in flight; a hedge can't go out once we return
+				var zero T
+				return zero, lastErr
+			}
+		}
+	}
+}
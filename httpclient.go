@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Outbound call metrics keyed by upstream host
+var (
+	upstreamRequestsByHost  = expvar.NewMap("upstream_requests_by_host")
+	upstreamErrorsByHost    = expvar.NewMap("upstream_errors_by_host")
+	upstreamLatencyMsByHost = expvar.NewMap("upstream_latency_ms_total_by_host")
+)
+
+// Outbound calls skipped because the request had no time left for them
+var upstreamBudgetExhausted = expvar.NewInt("upstream_budget_exhausted")
+
+// errUpstreamBudgetExhausted means the request deadline leaves no room for an external call
+var errUpstreamBudgetExhausted = errors.New("request deadline leaves no time for upstream call")
+
+// Shared HTTP client for external API calls, rebuilt from the loaded config in main
+var httpClient = NewHTTPClient(config)
+
+// ErrEgressDenied is returned for outbound requests to hosts outside the egress allowlist
+var ErrEgressDenied = errors.New("egress to host not allowed")
+
+// NewHTTPClient builds the outbound client with a tuned transport wrapped in egress checks and instrumentation
+func NewHTTPClient(cfg Config) *http.Client {
+	// An explicit proxy wins; otherwise honor the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY variables.
+	// LoadConfig has already validated the URL.
+	proxy := http.ProxyFromEnvironment
+	if cfg.UpstreamProxyURL != "" {
+		if proxyURL, err := url.Parse(cfg.UpstreamProxyURL); err == nil {
+			proxy = http.ProxyURL(proxyURL)
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.UpstreamDialTimeout,
+			KeepAlive: cfg.UpstreamKeepAlive,
+		}).DialContext,
+		TLSHandshakeTimeout:   cfg.UpstreamTLSHandshakeTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   cfg.UpstreamMaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		DisableKeepAlives:     cfg.UpstreamDisableKeepAlives,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+
+	// Instrumentation sits outermost so denied calls show up in the per-host error counts
+	return &http.Client{
+		Timeout: cfg.UpstreamTimeout,
+		Transport: &instrumentedTransport{
+			next: &egressTransport{next: transport, allowlist: cfg.EgressAllowlist},
+		},
+	}
+}
+
+// egressTransport rejects requests to hosts that aren't allowlisted. Because it runs for every
+// round trip, redirects to other hosts are checked too.
+type egressTransport struct {
+	next      http.RoundTripper
+	allowlist []string // Exact hostnames or "*.example.com" suffix patterns; empty allows all
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *egressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !hostAllowed(req.URL.Hostname(), t.allowlist) {
+		log.Printf("Blocked outbound request to %s: not in egress allowlist", req.URL.Host)
+		return nil, fmt.Errorf("%w: %s", ErrEgressDenied, req.URL.Hostname())
+	}
+	return t.next.RoundTrip(req)
+}
+
+// hostAllowed reports whether host matches an allowlist entry (case-insensitive)
+func hostAllowed(host string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range allowlist {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// instrumentedTransport records per-host latency and errors and propagates the request ID upstream
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request, so tag a clone
+	if requestID := RequestIDFromContext(req.Context()); requestID != "" && req.Header.Get("X-Request-ID") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Request-ID", requestID)
+	}
+
+	host := req.URL.Host
+	startTime := time.Now()
+	response, err := t.next.RoundTrip(req)
+
+	upstreamRequestsByHost.Add(host, 1)
+	upstreamLatencyMsByHost.Add(host, time.Since(startTime).Milliseconds())
+	if err != nil || response.StatusCode >= http.StatusInternalServerError {
+		upstreamErrorsByHost.Add(host, 1)
+	}
+
+	return response, err
+}
+
+// withUpstreamDeadline derives the context for an external call from the request's remaining
+// budget: the parent deadline minus UpstreamSafetyMargin, so the response can still be encoded
+// and sent before the route timeout. Time already spent on database work (all of it in
+// sequential mode) is therefore automatically taken out of the upstream's share. The client's
+// own UpstreamTimeout still applies as an upper bound.
+func withUpstreamDeadline(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		upstreamCtx, cancel := context.WithCancel(ctx)
+		return upstreamCtx, cancel, nil
+	}
+
+	upstreamDeadline := deadline.Add(-config.UpstreamSafetyMargin)
+	if !time.Now().Before(upstreamDeadline) {
+		upstreamBudgetExhausted.Add(1)
+		return nil, nil, errUpstreamBudgetExhausted
+	}
+
+	upstreamCtx, cancel := context.WithDeadline(ctx, upstreamDeadline)
+	return upstreamCtx, cancel, nil
+}
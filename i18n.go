@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BookTranslation is a book's title and description in one language
+type BookTranslation struct {
+	BookID      string    `json:"book_id"`
+	Language    string    `json:"language"`              // Lowercase BCP 47 tag, e.g. "de" or "pt-br"
+	Title       string    `json:"title"`                 // Required
+	Description *string   `json:"description,omitempty"` // When absent, the original description is shown
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Language tags accepted for translations: a 2-3 letter language with optional subtags
+var languageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// normalizeLanguageTag lowercases a tag so "pt-BR" and "pt-br" are the same translation
+func normalizeLanguageTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// requestLanguages turns the Accept-Language header into the ordered list of translations to
+// try: most preferred first, each range followed by its shorter prefixes ("pt-br", then "pt").
+// Nothing matching means the original text is used.
+func requestLanguages(r *http.Request) []string {
+	type weightedRange struct {
+		tag    string
+		weight float64
+	}
+
+	var ranges []weightedRange
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = normalizeLanguageTag(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		weight := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if weight > 0 {
+			ranges = append(ranges, weightedRange{tag: tag, weight: weight})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].weight > ranges[j].weight })
+
+	var languages []string
+	seen := map[string]bool{}
+	for _, weighted := range ranges {
+		for tag := weighted.tag; tag != ""; {
+			if !seen[tag] {
+				seen[tag] = true
+				languages = append(languages, tag)
+			}
+			cut := strings.LastIndex(tag, "-")
+			if cut < 0 {
+				break
+			}
+			tag = tag[:cut]
+		}
+	}
+	return languages
+}
+
+// lookupTranslations returns, for each book, the translation in the first of the given
+// languages that has one. Books with no matching translation are absent from the map.
+func lookupTranslations(ctx context.Context, bookIDs, languages []string) (map[string]BookTranslation, error) {
+	translations := map[string]BookTranslation{}
+	if len(bookIDs) == 0 || len(languages) == 0 {
+		return translations, nil
+	}
+
+	args := make([]interface{}, 0, len(bookIDs)+len(languages))
+	for _, id := range bookIDs {
+		args = append(args, id)
+	}
+	for _, language := range languages {
+		args = append(args, language)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT book_id, language, title, description, updated_at
+		FROM book_translations
+		WHERE book_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(bookIDs)), ",")+`)
+			AND language IN (`+strings.TrimSuffix(strings.Repeat("?,", len(languages)), ",")+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	preference := map[string]int{}
+	for i, language := range languages {
+		preference[language] = i
+	}
+
+	for rows.Next() {
+		var translation BookTranslation
+		var description sql.NullString
+		if err := rows.Scan(&translation.BookID, &translation.Language, &translation.Title, &description, &translation.UpdatedAt); err != nil {
+			return nil, err
+		}
+		translation.Description = nullStringPtr(description)
+
+		current, ok := translations[translation.BookID]
+		if !ok || preference[translation.Language] < preference[current.Language] {
+			translations[translation.BookID] = translation
+		}
+	}
+	return translations, rows.Err()
+}
+
+// localizeMetadata swaps in the best translation for the request's languages and returns the
+// language used, or "" when the original text was kept (including when the lookup fails)
+func localizeMetadata(ctx context.Context, r *http.Request, bookID string, metadata *sectionResult[BookMetadata]) string {
+	languages := requestLanguages(r)
+	if metadata.Err != nil || len(languages) == 0 {
+		return ""
+	}
+
+	translations, err := lookupTranslations(ctx, []string{bookID}, languages)
+	if err != nil {
+		log.Printf("Error loading translations for book %s: %v", bookID, err)
+		return ""
+	}
+	translation, ok := translations[bookID]
+	if !ok {
+		return ""
+	}
+
+	metadata.Data.Title = translation.Title
+	if translation.Description != nil {
+		metadata.Data.Description = translation.Description
+	}
+	metadata.Data.Language = &translation.Language
+	return translation.Language
+}
+
+// setContentLanguage marks a response as varying by Accept-Language and names its language
+func setContentLanguage(w http.ResponseWriter, language string) {
+	w.Header().Add("Vary", "Accept-Language")
+	if language != "" {
+		w.Header().Set("Content-Language", language)
+	}
+}
+
+// TranslationsHandler handles the translation admin endpoints:
+//
+//	GET                /api/admin/books/{id}/translations
+//	GET, PUT, DELETE   /api/admin/books/{id}/translations/{language}
+func TranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(r.URL.Path, "/") // {"", "api", "admin", "books", "1", "translations", "de"}
+	if len(pathParts) < 6 || pathParts[4] == "" || pathParts[5] != "translations" {
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/admin/books/{id}/translations[/{language}]")
+		return
+	}
+	bookID := pathParts[4]
+
+	if len(pathParts) == 6 || (len(pathParts) == 7 && pathParts[6] == "") {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		translations, err := listBookTranslations(r.Context(), bookID)
+		if err != nil {
+			log.Printf("Error listing translations for book %s: %v", bookID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to list translations")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, translations)
+		return
+	}
+
+	language := normalizeLanguageTag(pathParts[6])
+	if len(pathParts) != 7 || !languageTagPattern.MatchString(language) {
+		writeError(w, r, http.StatusBadRequest, "Language must be a tag like 'de' or 'pt-BR'")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		translations, err := lookupTranslations(r.Context(), []string{bookID}, []string{language})
+		if err != nil {
+			log.Printf("Error loading translation %s for book %s: %v", language, bookID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to load translation")
+			return
+		}
+		translation, ok := translations[bookID]
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "Translation not found")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, translation)
+
+	case http.MethodPut:
+		var body struct {
+			Title       string  `json:"title"`
+			Description *string `json:"description"`
+		}
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+		if strings.TrimSpace(body.Title) == "" {
+			writeError(w, r, http.StatusBadRequest, "Translated title is required")
+			return
+		}
+
+		translation, err := saveBookTranslation(r.Context(), bookID, language, body.Title, body.Description)
+		if errors.Is(err, errBookNotFound) {
+			writeError(w, r, http.StatusNotFound, "Book not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Error saving translation %s for book %s: %v", language, bookID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to save translation")
+			return
+		}
+		log.Printf("Saved %s translation for book %s", language, bookID)
+		writeJSON(w, r, http.StatusOK, translation)
+
+	case http.MethodDelete:
+		result, err := db.ExecContext(r.Context(), "DELETE FROM book_translations WHERE book_id = ? AND language = ?", bookID, language)
+		if err != nil {
+			log.Printf("Error deleting translation %s for book %s: %v", language, bookID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to delete translation")
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			writeError(w, r, http.StatusNotFound, "Translation not found")
+			return
+		}
+		// Keep Last-Modified on the book moving forward even though the row is gone
+		db.ExecContext(r.Context(), "UPDATE books SET updated_at = ? WHERE id = ?", dbNow(), bookID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// listBookTranslations returns every translation of a book, by language
+func listBookTranslations(ctx context.Context, bookID string) ([]BookTranslation, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT book_id, language, title, description, updated_at
+		FROM book_translations
+		WHERE book_id = ?
+		ORDER BY language
+	`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []BookTranslation{}
+	for rows.Next() {
+		var translation BookTranslation
+		var description sql.NullString
+		if err := rows.Scan(&translation.BookID, &translation.Language, &translation.Title, &description, &translation.UpdatedAt); err != nil {
+			return nil, err
+		}
+		translation.Description = nullStringPtr(description)
+		translations = append(translations, translation)
+	}
+	return translations, rows.Err()
+}
+
+// saveBookTranslation inserts or replaces one translation of a book
+func saveBookTranslation(ctx context.Context, bookID, language, title string, description *string) (BookTranslation, error) {
+	var exists int
+	if err := db.QueryRowContext(ctx, "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BookTranslation{}, errBookNotFound
+		}
+		return BookTranslation{}, err
+	}
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO book_translations (book_id, language, title, description, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(book_id, language) DO UPDATE SET
+			title = excluded.title,
+			description = excluded.description,
+			updated_at = excluded.updated_at
+	`, bookID, language, strings.TrimSpace(title), description, dbNow())
+	if err != nil {
+		return BookTranslation{}, err
+	}
+
+	translations, err := lookupTranslations(ctx, []string{bookID}, []string{language})
+	if err != nil {
+		return BookTranslation{}, err
+	}
+	return translations[bookID], nil
+}
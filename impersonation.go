@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	impersonationTokenPrefix            = "imp_"
+	impersonationKey         contextKey = "impersonation"
+
+	// Scopes an impersonation token can carry. Read tokens may only GET and HEAD.
+	impersonationScopeRead  = "read"
+	impersonationScopeWrite = "write"
+)
+
+// Paths an impersonation token acts on: what the user can see and do as themselves. Entries
+// ending in "/" cover everything below them; the rest match exactly, so /api/session answers
+// but /api/session/login and /logout don't. Admin endpoints, sign up and login stay out of
+// reach, so a token can't mint another token or take over the account.
+var impersonationPaths = []string{"/api/books/", "/api/v2/books/", "/api/users/me/", "/api/session"}
+
+// newImpersonationToken returns a random bearer token; only its hash is stored
+func newImpersonationToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return impersonationTokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashBearerToken is what impersonation and personal access tokens are stored and looked up by
+func hashBearerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// impersonationRequest is the body of POST /api/admin/impersonations
+type impersonationRequest struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"`
+	Scope  string `json:"scope"` // "read" (default) or "write"
+	TTL    string `json:"ttl"`   // Go duration, default ImpersonationTTL, at most ImpersonationMaxTTL
+}
+
+// createImpersonation starts an impersonation session and returns it with its token
+func createImpersonation(ctx context.Context, adminID string, req impersonationRequest, ttl time.Duration) (Impersonation, string, error) {
+	token, err := newImpersonationToken()
+	if err != nil {
+		return Impersonation{}, "", err
+	}
+	now := clock.Now().UTC().Truncate(time.Second)
+	impersonation := Impersonation{
+		ID:        idGenerator.NewID(),
+		AdminID:   adminID,
+		UserID:    req.UserID,
+		Reason:    req.Reason,
+		Scope:     req.Scope,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO impersonation_sessions (id, token_hash, admin_id, user_id, reason, scope, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, impersonation.ID, hashBearerToken(token), adminID, req.UserID, req.Reason, req.Scope,
+		now.Format(sqliteTimestampLayout), impersonation.ExpiresAt.Format(sqliteTimestampLayout))
+	if err != nil {
+		return Impersonation{}, "", err
+	}
+	return impersonation, token, nil
+}
+
+const impersonationColumns = "id, admin_id, user_id, reason, scope, created_at, expires_at, revoked_at"
+
+func scanImpersonation(row interface{ Scan(...interface{}) error }) (Impersonation, error) {
+	var impersonation Impersonation
+	var revokedAt sql.NullTime
+	err := row.Scan(&impersonation.ID, &impersonation.AdminID, &impersonation.UserID, &impersonation.Reason,
+		&impersonation.Scope, &impersonation.CreatedAt, &impersonation.ExpiresAt, &revokedAt)
+	impersonation.RevokedAt = nullTimePtr(revokedAt)
+	return impersonation, err
+}
+
+// findActiveImpersonation looks up an unexpired, unrevoked session by its token
+func findActiveImpersonation(ctx context.Context, token string) (Impersonation, bool, error) {
+	impersonation, err := scanImpersonation(db.QueryRowContext(ctx, `
+		SELECT `+impersonationColumns+` FROM impersonation_sessions
+		WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?
+	`, hashBearerToken(token), dbNow()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return impersonation, false, nil
+	}
+	return impersonation, err == nil, err
+}
+
+// listActiveImpersonations returns the sessions whose tokens still work, newest first
+func listActiveImpersonations(ctx context.Context) ([]Impersonation, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+impersonationColumns+` FROM impersonation_sessions
+		WHERE revoked_at IS NULL AND expires_at > ?
+		ORDER BY created_at DESC, id
+	`, dbNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	impersonations := []Impersonation{}
+	for rows.Next() {
+		impersonation, err := scanImpersonation(rows)
+		if err != nil {
+			return nil, err
+		}
+		impersonations = append(impersonations, impersonation)
+	}
+	return impersonations, rows.Err()
+}
+
+// revokeImpersonation ends a session early, returning it; false when it is unknown or already over
+func revokeImpersonation(ctx context.Context, id string) (Impersonation, bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE impersonation_sessions SET revoked_at = ?
+		WHERE id = ? AND revoked_at IS NULL AND expires_at > ?
+	`, dbNow(), id, dbNow())
+	if err != nil {
+		return Impersonation{}, false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return Impersonation{}, false, err
+	}
+	impersonation, err := scanImpersonation(db.QueryRowContext(ctx,
+		"SELECT "+impersonationColumns+" FROM impersonation_sessions WHERE id = ?", id))
+	return impersonation, err == nil, err
+}
+
+// impersonationMiddleware lets a request carrying "Authorization: Bearer imp_..." act as the
+// impersonated user. It runs outside sessionMiddleware and puts a session for that user in the
+// context, so every handler's idea of the current user follows without changes. Each request
+// made this way is written to the audit log, reads included, under the admin's name.
+func impersonationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(token, impersonationTokenPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		impersonation, ok, err := findActiveImpersonation(r.Context(), token)
+		if err != nil {
+			log.Printf("Error looking up impersonation token: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to check impersonation token")
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, "Invalid or expired impersonation token")
+			return
+		}
+
+		audit := AuditEntry{
+			Actor:           impersonation.AdminID,
+			Action:          r.Method + " " + r.URL.Path,
+			UserID:          impersonation.UserID,
+			Impersonated:    true,
+			ImpersonationID: impersonation.ID,
+		}
+		var refusal string
+		switch {
+		case !isImpersonationPath(r.URL.Path):
+			refusal = "Impersonation tokens only act on user endpoints"
+		case impersonation.Scope == impersonationScopeRead && r.Method != http.MethodGet && r.Method != http.MethodHead:
+			refusal = "Impersonation token is read-only"
+		}
+		if refusal != "" {
+			audit.Status = http.StatusForbidden
+			audit.Detail = refusal
+			recordAudit(r.Context(), audit)
+			writeError(w, r, http.StatusForbidden, refusal)
+			return
+		}
+
+		session := Session{
+			ID:        "impersonation-" + impersonation.ID,
+			UserID:    impersonation.UserID,
+			ExpiresAt: impersonation.ExpiresAt.Unix(),
+		}
+		ctx := context.WithValue(r.Context(), sessionKey, session)
+		ctx = context.WithValue(ctx, impersonationKey, impersonation)
+
+		recorder := &noStoreRecorder{statusRecorder: statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		audit.Status = recorder.status
+		recordAudit(r.Context(), audit)
+	})
+}
+
+// noStoreRecorder records the status like statusRecorder and marks the response no-store,
+// overriding whatever the handler chose: nothing seen through another user's eyes gets cached
+type noStoreRecorder struct {
+	statusRecorder
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (n *noStoreRecorder) WriteHeader(status int) {
+	if !n.wroteHeader {
+		n.wroteHeader = true
+		n.Header().Set("Cache-Control", "no-store")
+	}
+	n.statusRecorder.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (n *noStoreRecorder) Write(b []byte) (int, error) {
+	if !n.wroteHeader {
+		n.WriteHeader(http.StatusOK)
+	}
+	return n.statusRecorder.Write(b)
+}
+
+// isImpersonationPath reports whether path is one of the impersonationPaths
+func isImpersonationPath(path string) bool {
+	for _, allowed := range impersonationPaths {
+		if path == allowed || strings.HasSuffix(allowed, "/") && strings.HasPrefix(path, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImpersonationFromContext returns the impersonation a request is made under, if any
+func ImpersonationFromContext(ctx context.Context) (Impersonation, bool) {
+	impersonation, ok := ctx.Value(impersonationKey).(Impersonation)
+	return impersonation, ok
+}
+
+// ImpersonationsHandler handles admin impersonation for support debugging:
+//
+//	GET    /api/admin/impersonations        Sessions whose tokens still work
+//	POST   /api/admin/impersonations        Start one; the token is only shown here
+//	DELETE /api/admin/impersonations/{id}   Revoke one early
+//
+// Every one of them needs an admin identity, the admin token or a login to an admin account,
+// which is what the audit log records as the admin. BOOKSTORE_ADMIN_OPEN doesn't stand in for it.
+func ImpersonationsHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := AdminFromContext(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusForbidden, "Impersonation requires the admin token or a login to an admin account")
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/impersonations"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		impersonations, err := listActiveImpersonations(r.Context())
+		if err != nil {
+			log.Printf("Error listing impersonations: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to list impersonations")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, impersonations)
+
+	case id == "" && r.Method == http.MethodPost:
+		handleCreateImpersonation(w, r, adminID)
+
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodDelete:
+		impersonation, ok, err := revokeImpersonation(r.Context(), id)
+		if err != nil {
+			log.Printf("Error revoking impersonation %s: %v", id, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to revoke impersonation")
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "No active impersonation "+id)
+			return
+		}
+		recordAudit(r.Context(), AuditEntry{
+			Actor:           adminID,
+			Action:          "impersonation.revoke",
+			UserID:          impersonation.UserID,
+			ImpersonationID: impersonation.ID,
+			Status:          http.StatusOK,
+		})
+		log.Printf("Impersonation %s of %s by %s revoked by %s", impersonation.ID, impersonation.UserID, impersonation.AdminID, adminID)
+		writeJSON(w, r, http.StatusOK, impersonation)
+
+	case id == "" || !strings.Contains(id, "/"):
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+
+	default:
+		writeError(w, r, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleCreateImpersonation handles POST /api/admin/impersonations with {"user_id", "reason",
+// "scope", "ttl"} for adminID, so every token traces back to the admin token or an admin account
+func handleCreateImpersonation(w http.ResponseWriter, r *http.Request, adminID string) {
+	var req impersonationRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.UserID = strings.TrimSpace(req.UserID)
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.UserID == "" || req.UserID == "me" || strings.Contains(req.UserID, "/") {
+		writeError(w, r, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if req.UserID == adminID {
+		writeError(w, r, http.StatusBadRequest, "Cannot impersonate yourself")
+		return
+	}
+	if req.Reason == "" {
+		writeError(w, r, http.StatusBadRequest, "reason is required, e.g. the support ticket")
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = impersonationScopeRead
+	}
+	if req.Scope != impersonationScopeRead && req.Scope != impersonationScopeWrite {
+		writeError(w, r, http.StatusBadRequest, "scope must be read or write")
+		return
+	}
+	ttl := config.ImpersonationTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 || parsed > config.ImpersonationMaxTTL {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("ttl must be a positive duration of at most %v", config.ImpersonationMaxTTL))
+			return
+		}
+		ttl = parsed
+	}
+
+	impersonation, token, err := createImpersonation(r.Context(), adminID, req, ttl)
+	if err != nil {
+		log.Printf("Error starting impersonation of %s by %s: %v", req.UserID, adminID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to start impersonation")
+		return
+	}
+	recordAudit(r.Context(), AuditEntry{
+		Actor:           adminID,
+		Action:          "impersonation.start",
+		UserID:          impersonation.UserID,
+		ImpersonationID: impersonation.ID,
+		Status:          http.StatusCreated,
+		Detail:          impersonation.Scope + " for " + ttl.String() + ": " + impersonation.Reason,
+	})
+	log.Printf("%s started impersonating %s (%s, %v): %s", adminID, impersonation.UserID, impersonation.Scope, ttl, impersonation.Reason)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusCreated, ImpersonationGrant{Impersonation: impersonation, Token: token})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestImpersonationTokenStaysOnItsAllowlist(t *testing.T) {
+	server := newTestServer(t)
+	if status, body := doRequest(t, newTestClient(t), http.MethodPost, server.URL+"/api/accounts", `{"user_id": "alice", "password": "Correct-Horse-42"}`); status != http.StatusCreated {
+		t.Fatalf("signing up = %d %s", status, body)
+	}
+	status, body := doRequest(t, http.DefaultClient, http.MethodPost, server.URL+"/api/admin/impersonations",
+		`{"user_id": "alice", "reason": "support ticket", "scope": "write"}`, "Authorization", "Bearer "+testAdminToken)
+	var grant ImpersonationGrant
+	if err := json.Unmarshal([]byte(body), &grant); status != http.StatusCreated || err != nil {
+		t.Fatalf("starting an impersonation = %d %s", status, body)
+	}
+	asAlice := []string{"Authorization", "Bearer " + grant.Token}
+
+	status, body = doRequest(t, http.DefaultClient, http.MethodGet, server.URL+"/api/session", "", asAlice...)
+	if status != http.StatusOK || !strings.Contains(body, `"impersonated_by":"admin-token"`) {
+		t.Fatalf("GET /api/session while impersonating = %d %s, want 200 naming the admin", status, body)
+	}
+	if status, body := doRequest(t, http.DefaultClient, http.MethodGet, server.URL+"/api/users/me/lists", "", asAlice...); status != http.StatusOK {
+		t.Fatalf("GET own lists while impersonating = %d %s, want 200", status, body)
+	}
+
+	// Paths that share a prefix with the allowlist, or sit next to it, are refused
+	refused := []struct{ method, path, body string }{
+		{http.MethodPost, "/api/session/login", `{"user_id": "alice", "password": "Correct-Horse-42"}`},
+		{http.MethodPost, "/api/session/logout", ""},
+		{http.MethodPost, "/api/accounts", `{"user_id": "mallory", "password": "Correct-Horse-42"}`},
+		{http.MethodGet, "/api/sessions", ""},
+	}
+	for _, request := range refused {
+		if status, body := doRequest(t, http.DefaultClient, request.method, server.URL+request.path, request.body, asAlice...); status != http.StatusForbidden {
+			t.Errorf("%s %s while impersonating = %d %s, want 403", request.method, request.path, status, body)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Foreign keys are enforced on every connection (SQLite leaves them off by default). Every table
+// keyed by book is owned by the book, so deleting a book cascades to its pricing, inventory,
+// reviews, ratings, translations, embeddings, processing state, restock log, purchase orders,
+// price experiment events, regional prices and restrictions, and reading list entries.
+// catalog_changes deliberately has no foreign key: the log must outlive the rows it describes.
+var cascadingBookTables = []string{
+	"pricing", "inventory", "reviews", "book_embeddings", "book_processing",
+	"reading_list_items", "book_ratings", "book_translations", "restock_events", "purchase_orders",
+	"price_experiment_events", "regional_prices", "regional_restrictions", "recently_viewed",
+}
+
+// Matches the books reference in a stored CREATE TABLE statement, with any existing action
+var booksReferencePattern = regexp.MustCompile(`REFERENCES books\(id\)( ON DELETE [A-Z]+( [A-Z]+)?)?`)
+
+// withForeignKeys adds the go-sqlite3 DSN option that turns on foreign key enforcement
+func withForeignKeys(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_foreign_keys=on"
+}
+
+// ensureCascadingForeignKeys rebuilds book tables whose foreign key predates ON DELETE CASCADE.
+// SQLite can't alter a constraint, so each table is recreated from its stored definition with
+// the action added, its rows copied over and the old table swapped out, following SQLite's
+// documented procedure. Orphan rows are copied as they are; the orphans subcommand removes them.
+func ensureCascadingForeignKeys() error {
+	ctx := context.Background()
+	var stale []string
+	for _, table := range cascadingBookTables {
+		var onDelete string
+		err := db.QueryRowContext(ctx, `SELECT on_delete FROM pragma_foreign_key_list(?) WHERE "table" = 'books'`, table).Scan(&onDelete)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if onDelete != "CASCADE" {
+			stale = append(stale, table)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	// Enforcement has to be off while tables are swapped, and the pragma is per connection
+	// and ignored inside a transaction, so the rebuild holds one connection throughout
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range stale {
+		var definition string
+		if err := tx.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&definition); err != nil {
+			return err
+		}
+		rebuilt := table + "_rebuild"
+		definition = strings.Replace(definition, "CREATE TABLE "+table, "CREATE TABLE "+rebuilt, 1)
+		definition = booksReferencePattern.ReplaceAllString(definition, "REFERENCES books(id) ON DELETE CASCADE")
+
+		for _, statement := range []string{
+			definition,
+			fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", rebuilt, table),
+			fmt.Sprintf("DROP TABLE %s", table),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", rebuilt, table),
+		} {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("rebuilding %s: %w", table, err)
+			}
+		}
+		log.Printf("Rebuilt %s with ON DELETE CASCADE", table)
+	}
+	return tx.Commit()
+}
+
+// orphanCount is how many rows of one table reference a missing parent
+type orphanCount struct {
+	Table  string
+	Parent string
+	Rows   int
+}
+
+// findOrphans lists foreign key violations per table and parent, with the rowids involved
+func findOrphans(ctx context.Context) ([]orphanCount, map[string][]int64, error) {
+	rows, err := db.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var counts []orphanCount
+	index := map[string]int{}
+	rowIDs := map[string][]int64{}
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var foreignKey int
+		if err := rows.Scan(&table, &rowID, &parent, &foreignKey); err != nil {
+			return nil, nil, err
+		}
+		key := table + "->" + parent
+		if _, ok := index[key]; !ok {
+			index[key] = len(counts)
+			counts = append(counts, orphanCount{Table: table, Parent: parent})
+		}
+		counts[index[key]].Rows++
+		if rowID.Valid {
+			rowIDs[table] = append(rowIDs[table], rowID.Int64)
+		}
+	}
+	return counts, rowIDs, rows.Err()
+}
+
+// runOrphans implements the "orphans" subcommand: it reports rows whose book (or list) no
+// longer exists and, with -repair, deletes them in one transaction
+func runOrphans(args []string) int {
+	flags := flag.NewFlagSet("orphans", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "Delete the orphan rows instead of only reporting them")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var err error
+	if config, err = LoadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "orphans: invalid configuration: %v\n", err)
+		return 1
+	}
+	if db, err = OpenDatabase(config.DatabasePath, config.DatabaseBatchConns); err != nil {
+		fmt.Fprintf(os.Stderr, "orphans: %v\n", err)
+		return 1
+	}
+	defer CloseDatabase()
+	if err := createSchema(); err != nil {
+		fmt.Fprintf(os.Stderr, "orphans: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	counts, rowIDs, err := findOrphans(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "orphans: %v\n", err)
+		return 1
+	}
+	if len(counts) == 0 {
+		fmt.Println("No orphan rows")
+		return 0
+	}
+	for _, count := range counts {
+		fmt.Printf("%s: %d rows reference a missing %s row\n", count.Table, count.Rows, count.Parent)
+	}
+	if !*repair {
+		fmt.Println("Run with -repair to delete them")
+		return 1
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "orphans: %v\n", err)
+		return 1
+	}
+	defer tx.Rollback()
+	deleted := 0
+	for table, ids := range rowIDs {
+		for _, id := range ids {
+			// Table names come from SQLite's own foreign key check, not from input
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE rowid = ?", id); err != nil {
+				fmt.Fprintf(os.Stderr, "orphans: deleting from %s: %v\n", table, err)
+				return 1
+			}
+			deleted++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		fmt.Fprintf(os.Stderr, "orphans: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Deleted %d orphan rows\n", deleted)
+	return 0
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Reading list errors surfaced to handlers
+var (
+	errReadingListExists = errors.New("a list with this name already exists")
+	errBookNotFound      = errors.New("book not found")
+)
+
+// ReadingSignals summarizes a user's lists as an input for recommendations
+type ReadingSignals struct {
+	Read     []string // Books the user marked as read
+	Reading  []string // Books on reading lists but not read yet
+	Wishlist []string // Books on wishlists
+}
+
+// ReadingListSignals collects the books a user has read, plans to read and wants
+func ReadingListSignals(ctx context.Context, userID string) (ReadingSignals, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT i.book_id, l.kind, i.read_at IS NOT NULL
+		FROM reading_list_items i
+		JOIN reading_lists l ON l.id = i.list_id
+		WHERE l.user_id = ?
+		ORDER BY i.added_at DESC
+	`, userID)
+	if err != nil {
+		return ReadingSignals{}, err
+	}
+	defer rows.Close()
+
+	var signals ReadingSignals
+	for rows.Next() {
+		var bookID, kind string
+		var read bool
+		if err := rows.Scan(&bookID, &kind, &read); err != nil {
+			return ReadingSignals{}, err
+		}
+		switch {
+		case read:
+			signals.Read = append(signals.Read, bookID)
+		case kind == "wishlist":
+			signals.Wishlist = append(signals.Wishlist, bookID)
+		default:
+			signals.Reading = append(signals.Reading, bookID)
+		}
+	}
+	return signals, rows.Err()
+}
+
+// listReadingLists returns all of a user's lists with their books, oldest list first
+func listReadingLists(ctx context.Context, userID string) ([]ReadingList, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, user_id, name, kind, COALESCE(share_token, ''), created_at, updated_at
+		FROM reading_lists
+		WHERE user_id = ?
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lists := []ReadingList{}
+	for rows.Next() {
+		var list ReadingList
+		if err := rows.Scan(&list.ID, &list.UserID, &list.Name, &list.Kind, &list.ShareToken, &list.CreatedAt, &list.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range lists {
+		if lists[i].Items, err = loadReadingListItems(ctx, lists[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return lists, nil
+}
+
+// getReadingList loads one list by owner and ID (or by share token when userID is empty),
+// returning sql.ErrNoRows when there is no such list
+func getReadingList(ctx context.Context, userID string, listID int64, shareToken string) (ReadingList, error) {
+	var list ReadingList
+	query := `
+		SELECT id, user_id, name, kind, COALESCE(share_token, ''), created_at, updated_at
+		FROM reading_lists
+		WHERE user_id = ? AND id = ?
+	`
+	args := []interface{}{userID, listID}
+	if userID == "" {
+		query = `
+			SELECT id, user_id, name, kind, COALESCE(share_token, ''), created_at, updated_at
+			FROM reading_lists
+			WHERE share_token = ?
+		`
+		args = []interface{}{shareToken}
+	}
+
+	err := db.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.UserID, &list.Name, &list.Kind, &list.ShareToken, &list.CreatedAt, &list.UpdatedAt)
+	if err != nil {
+		return list, err
+	}
+	list.Items, err = loadReadingListItems(ctx, list.ID)
+	return list, err
+}
+
+// loadReadingListItems returns a list's books, most recently added first
+func loadReadingListItems(ctx context.Context, listID int64) ([]ReadingListItem, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT i.book_id, b.title, b.author, i.added_at, i.read_at
+		FROM reading_list_items i
+		JOIN books b ON b.id = i.book_id
+		WHERE i.list_id = ?
+		ORDER BY i.added_at DESC, i.book_id
+	`, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []ReadingListItem{}
+	for rows.Next() {
+		var item ReadingListItem
+		var readAt sql.NullTime
+		if err := rows.Scan(&item.BookID, &item.Title, &item.Author, &item.AddedAt, &readAt); err != nil {
+			return nil, err
+		}
+		item.ReadAt = nullTimePtr(readAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// createReadingList adds an empty list for a user
+func createReadingList(ctx context.Context, userID, name, kind string) (ReadingList, error) {
+	now := dbNow()
+	result, err := db.ExecContext(ctx, "INSERT INTO reading_lists (user_id, name, kind, created_at, updated_at) VALUES (?, ?, ?, ?, ?)", userID, name, kind, now, now)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ReadingList{}, errReadingListExists
+	}
+	if err != nil {
+		return ReadingList{}, err
+	}
+
+	listID, err := result.LastInsertId()
+	if err != nil {
+		return ReadingList{}, err
+	}
+	return getReadingList(ctx, userID, listID, "")
+}
+
+// deleteReadingList removes a list and its items, reporting whether it existed
+func deleteReadingList(ctx context.Context, userID string, listID int64) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM reading_lists WHERE user_id = ? AND id = ?", userID, listID)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM reading_list_items WHERE list_id = ?", listID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// setReadingListItem adds a book to a list if it isn't there yet. A non-nil read marks the
+// book as read (keeping the original read time) or unread.
+func setReadingListItem(ctx context.Context, userID string, listID int64, bookID string, read *bool) error {
+	if _, err := getReadingList(ctx, userID, listID, ""); err != nil {
+		return err
+	}
+
+	var exists int
+	if err := db.QueryRowContext(ctx, "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errBookNotFound
+		}
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := dbNow()
+	if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO reading_list_items (list_id, book_id, added_at) VALUES (?, ?, ?)", listID, bookID, now); err != nil {
+		return err
+	}
+	if read != nil {
+		var readAt interface{} // NULL clears it; marking read again keeps the original time
+		if *read {
+			readAt = now
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE reading_list_items SET read_at = CASE WHEN ? IS NULL THEN NULL ELSE COALESCE(read_at, ?) END WHERE list_id = ? AND book_id = ?", readAt, readAt, listID, bookID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE reading_lists SET updated_at = ? WHERE id = ?", now, listID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeReadingListItem takes a book off a list, reporting whether it was on it
+func removeReadingListItem(ctx context.Context, userID string, listID int64, bookID string) (bool, error) {
+	if _, err := getReadingList(ctx, userID, listID, ""); err != nil {
+		return false, err
+	}
+
+	result, err := db.ExecContext(ctx, "DELETE FROM reading_list_items WHERE list_id = ? AND book_id = ?", listID, bookID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE reading_lists SET updated_at = ? WHERE id = ?", dbNow(), listID)
+	return true, err
+}
+
+// setReadingListShareToken shares a list under a fresh token, or stops sharing it when share is false
+func setReadingListShareToken(ctx context.Context, userID string, listID int64, share bool) (ReadingList, error) {
+	var token interface{}
+	if share {
+		token = idGenerator.NewID()
+	}
+
+	result, err := db.ExecContext(ctx, "UPDATE reading_lists SET share_token = ?, updated_at = ? WHERE user_id = ? AND id = ?", token, dbNow(), userID, listID)
+	if err != nil {
+		return ReadingList{}, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return ReadingList{}, err
+	} else if affected == 0 {
+		return ReadingList{}, sql.ErrNoRows
+	}
+	return getReadingList(ctx, userID, listID, "")
+}
+
+// ReadingListsHandler handles /api/users/{user_id}/lists and everything below it:
+//
+//	GET, POST      /api/users/{user_id}/lists
+//	GET, DELETE    /api/users/{user_id}/lists/{list_id}
+//	POST, DELETE   /api/users/{user_id}/lists/{list_id}/share
+//	PUT, DELETE    /api/users/{user_id}/lists/{list_id}/books/{book_id}
+//	GET            /api/users/{user_id}/recently-viewed
+//
+// The user is "me" or the session's own user ID, including an anonymous session's (see
+// resolveUserID); any other user is refused with 403.
+func ReadingListsHandler(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(r.URL.Path, "/") // {"", "api", "users", "u1", "lists", "7", "books", "3"}
+	if len(pathParts) < 5 || pathParts[3] == "" || (pathParts[4] != "lists" && pathParts[4] != "recently-viewed" && pathParts[4] != "tokens") {
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/users/{user_id}/lists, /api/users/{user_id}/recently-viewed or /api/users/me/tokens")
+		return
+	}
+	if pathParts[4] == "tokens" {
+		handleAccessTokens(w, r, pathParts)
+		return
+	}
+	userID, err := resolveUserID(r, pathParts[3])
+	if errors.Is(err, errForeignUser) {
+		writeError(w, r, http.StatusForbidden, "Only your own lists and history are accessible")
+		return
+	}
+	if userID == "" {
+		writeError(w, r, http.StatusUnauthorized, "No session")
+		return
+	}
+
+	if pathParts[4] == "recently-viewed" {
+		if len(pathParts) != 5 {
+			writeError(w, r, http.StatusNotFound, "Not found")
+			return
+		}
+		handleRecentlyViewed(w, r, userID)
+		return
+	}
+	if len(pathParts) == 5 {
+		handleReadingListCollection(w, r, userID)
+		return
+	}
+
+	listID, err := strconv.ParseInt(pathParts[5], 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "List ID must be a number")
+		return
+	}
+
+	switch {
+	case len(pathParts) == 6:
+		handleReadingList(w, r, userID, listID)
+	case len(pathParts) == 7 && pathParts[6] == "share":
+		handleReadingListShare(w, r, userID, listID)
+	case len(pathParts) == 8 && pathParts[6] == "books" && pathParts[7] != "":
+		handleReadingListItem(w, r, userID, listID, pathParts[7])
+	default:
+		writeError(w, r, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleReadingListCollection lists or creates a user's lists
+func handleReadingListCollection(w http.ResponseWriter, r *http.Request, userID string) {
+	switch r.Method {
+	case http.MethodGet:
+		lists, err := listReadingLists(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error loading reading lists for %s: %v", userID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to load reading lists")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, lists)
+
+	case http.MethodPost:
+		var body struct {
+			Name string `json:"name"`
+			Kind string `json:"kind"`
+		}
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if body.Name == "" {
+			writeError(w, r, http.StatusBadRequest, "List name is required")
+			return
+		}
+		if body.Kind == "" {
+			body.Kind = "reading"
+		}
+		if body.Kind != "reading" && body.Kind != "wishlist" {
+			writeError(w, r, http.StatusBadRequest, "kind must be 'reading' or 'wishlist'")
+			return
+		}
+
+		list, err := createReadingList(r.Context(), userID, body.Name, body.Kind)
+		if errors.Is(err, errReadingListExists) {
+			writeError(w, r, http.StatusConflict, "A list with this name already exists")
+			return
+		}
+		if err != nil {
+			log.Printf("Error creating reading list for %s: %v", userID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to create reading list")
+			return
+		}
+		log.Printf("Created %s list %d for %s", list.Kind, list.ID, userID)
+		writeJSON(w, r, http.StatusCreated, list)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleReadingList returns or deletes one list
+func handleReadingList(w http.ResponseWriter, r *http.Request, userID string, listID int64) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := getReadingList(r.Context(), userID, listID, "")
+		if !readingListFound(w, r, err) {
+			return
+		}
+		writeJSON(w, r, http.StatusOK, list)
+
+	case http.MethodDelete:
+		existed, err := deleteReadingList(r.Context(), userID, listID)
+		if err != nil {
+			log.Printf("Error deleting reading list %d: %v", listID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to delete reading list")
+			return
+		}
+		if !existed {
+			writeError(w, r, http.StatusNotFound, "Reading list not found")
+			return
+		}
+		invalidatePersonalRecommendations(userID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleReadingListShare starts (POST) or stops (DELETE) sharing a list via a public token URL
+func handleReadingListShare(w http.ResponseWriter, r *http.Request, userID string, listID int64) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	list, err := setReadingListShareToken(r.Context(), userID, listID, r.Method == http.MethodPost)
+	if !readingListFound(w, r, err) {
+		return
+	}
+	if list.ShareToken != "" {
+		w.Header().Set("Location", "/api/shared/lists/"+list.ShareToken)
+	}
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+// handleReadingListItem adds or updates (PUT, optional body {"read": bool}) or removes (DELETE) a book
+func handleReadingListItem(w http.ResponseWriter, r *http.Request, userID string, listID int64, bookID string) {
+	switch r.Method {
+	case http.MethodPut:
+		var body struct {
+			Read *bool `json:"read"`
+		}
+		if r.ContentLength != 0 {
+			if !decodeJSONBody(w, r, &body) {
+				return
+			}
+		}
+
+		err := setReadingListItem(r.Context(), userID, listID, bookID, body.Read)
+		if errors.Is(err, errBookNotFound) {
+			writeError(w, r, http.StatusNotFound, "Book not found")
+			return
+		}
+		if !readingListFound(w, r, err) {
+			return
+		}
+		invalidatePersonalRecommendations(userID)
+		list, err := getReadingList(r.Context(), userID, listID, "")
+		if !readingListFound(w, r, err) {
+			return
+		}
+		writeJSON(w, r, http.StatusOK, list)
+
+	case http.MethodDelete:
+		removed, err := removeReadingListItem(r.Context(), userID, listID, bookID)
+		if !readingListFound(w, r, err) {
+			return
+		}
+		if !removed {
+			writeError(w, r, http.StatusNotFound, "Book is not on this list")
+			return
+		}
+		invalidatePersonalRecommendations(userID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// readingListFound writes the error response for a failed list lookup and reports whether to continue
+func readingListFound(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, r, http.StatusNotFound, "Reading list not found")
+	default:
+		log.Printf("Error accessing reading list: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to access reading list")
+	}
+	return false
+}
+
+// SharedReadingListHandler handles GET /api/shared/lists/{token}, the public view of a shared list
+func SharedReadingListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/api/shared/lists/")
+	if token == "" || strings.Contains(token, "/") {
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/shared/lists/{token}")
+		return
+	}
+
+	list, err := getReadingList(r.Context(), "", 0, token)
+	if !readingListFound(w, r, err) {
+		return
+	}
+
+	// Viewers of a share link don't get to learn who owns it or re-share it
+	list.UserID = ""
+	list.ShareToken = ""
+	// Nor see books they couldn't buy where they are
+	country := requestCountry(w, r)
+	items := make([]ReadingListItem, 0, len(list.Items))
+	for _, item := range list.Items {
+		if !bookRestrictedIn(item.BookID, country) {
+			items = append(items, item)
+		}
+	}
+	list.Items = items
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, r, http.StatusOK, list)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// displayLocale holds the conventions for formatting numbers, prices and dates in one locale
+type displayLocale struct {
+	Tag          string
+	Decimal      string // Decimal separator
+	Group        string // Thousands separator; French uses a narrow no-break space
+	SymbolFirst  bool   // "$39.99" rather than "39,99 $"
+	SymbolSpace  bool   // Space between the amount and the currency symbol
+	PercentSpace bool   // "10 %" rather than "10%"
+	Months       [12]string
+	DateLayout   func(day int, month string, year int) string
+}
+
+var (
+	englishMonths    = [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	germanMonths     = [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."}
+	frenchMonths     = [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."}
+	spanishMonths    = [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"}
+	portugueseMonths = [12]string{"jan.", "fev.", "mar.", "abr.", "mai.", "jun.", "jul.", "ago.", "set.", "out.", "nov.", "dez."}
+	italianMonths    = [12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"}
+)
+
+// dayMonthYear formats dates as "16 nov. 2015", the order most European locales use
+func dayMonthYear(day int, month string, year int) string {
+	return fmt.Sprintf("%d %s %d", day, month, year)
+}
+
+// Supported display locales, keyed by lowercase tag. Bare languages map to their most common
+// region so "fr-CA" and "fr" still get French formatting through the Accept-Language fallback.
+var displayLocales = map[string]displayLocale{
+	"en-us": {Tag: "en-US", Decimal: ".", Group: ",", SymbolFirst: true, Months: englishMonths,
+		DateLayout: func(day int, month string, year int) string { return fmt.Sprintf("%s %d, %d", month, day, year) }},
+	"en-gb": {Tag: "en-GB", Decimal: ".", Group: ",", SymbolFirst: true, Months: englishMonths, DateLayout: dayMonthYear},
+	"de-de": {Tag: "de-DE", Decimal: ",", Group: ".", SymbolSpace: true, PercentSpace: true, Months: germanMonths,
+		DateLayout: func(day int, month string, year int) string { return fmt.Sprintf("%d. %s %d", day, month, year) }},
+	"fr-fr": {Tag: "fr-FR", Decimal: ",", Group: "\u202f", SymbolSpace: true, PercentSpace: true, Months: frenchMonths, DateLayout: dayMonthYear},
+	"es-es": {Tag: "es-ES", Decimal: ",", Group: ".", SymbolSpace: true, PercentSpace: true, Months: spanishMonths, DateLayout: dayMonthYear},
+	"it-it": {Tag: "it-IT", Decimal: ",", Group: ".", SymbolSpace: true, Months: italianMonths, DateLayout: dayMonthYear},
+	"pt-br": {Tag: "pt-BR", Decimal: ",", Group: ".", SymbolFirst: true, SymbolSpace: true, Months: portugueseMonths,
+		DateLayout: func(day int, month string, year int) string { return fmt.Sprintf("%d de %s de %d", day, month, year) }},
+}
+
+func init() {
+	for language, tag := range map[string]string{"en": "en-us", "de": "de-de", "fr": "fr-fr", "es": "es-es", "it": "it-it", "pt": "pt-br"} {
+		displayLocales[language] = displayLocales[tag]
+	}
+}
+
+// Currency symbols for display; other currencies are shown by their ISO 4217 code
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"BRL": "R$",
+}
+
+// requestDisplayLocale picks the locale for display strings: ?locale= wins, then Accept-Language.
+// It returns false when the client asked for nothing this service knows how to format.
+func requestDisplayLocale(r *http.Request) (displayLocale, bool) {
+	if tag := normalizeLanguageTag(r.URL.Query().Get("locale")); tag != "" {
+		locale, ok := displayLocales[strings.ReplaceAll(tag, "_", "-")]
+		return locale, ok
+	}
+	for _, tag := range requestLanguages(r) {
+		if locale, ok := displayLocales[tag]; ok {
+			return locale, true
+		}
+	}
+	return displayLocale{}, false
+}
+
+// FormatNumber formats a value with a fixed number of decimals and the locale's separators
+func (l displayLocale) FormatNumber(value float64, decimals int) string {
+	formatted := strconv.FormatFloat(math.Abs(value), 'f', decimals, 64)
+	whole, fraction, _ := strings.Cut(formatted, ".")
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(l.Group)
+		}
+		grouped.WriteRune(digit)
+	}
+	if fraction != "" {
+		grouped.WriteString(l.Decimal)
+		grouped.WriteString(fraction)
+	}
+
+	if value < 0 {
+		return "-" + grouped.String()
+	}
+	return grouped.String()
+}
+
+// FormatPrice formats an amount in the given currency, e.g. "$39.99" or "39,99 €"
+func (l displayLocale) FormatPrice(amount float64, currency string) string {
+	symbol, ok := currencySymbols[currency]
+	if !ok {
+		symbol = currency
+	}
+
+	number := l.FormatNumber(amount, 2)
+	separator := ""
+	if l.SymbolSpace || !ok {
+		separator = "\u00a0" // No-break space, so the symbol never wraps away from the amount
+	}
+	if l.SymbolFirst {
+		return symbol + separator + number
+	}
+	return number + separator + symbol
+}
+
+// FormatDate formats a calendar date, e.g. "Nov 16, 2015" or "16 nov. 2015"
+func (l displayLocale) FormatDate(date time.Time) string {
+	return l.DateLayout(date.Day(), l.Months[date.Month()-1], date.Year())
+}
+
+// FormatPercent formats a fraction as a whole percentage, e.g. 0.1 as "10 %" in French
+func (l displayLocale) FormatPercent(fraction float64) string {
+	if l.PercentSpace {
+		return l.FormatNumber(fraction*100, 0) + "\u00a0%"
+	}
+	return l.FormatNumber(fraction*100, 0) + "%"
+}
+
+// BookDisplay holds display-ready strings for a v2 details response. Fields are omitted when
+// the section they come from didn't load or has no value.
+type BookDisplay struct {
+	Locale        string `json:"locale"`
+	PublishDate   string `json:"publish_date,omitempty"`
+	Price         string `json:"price,omitempty"`
+	SalePrice     string `json:"sale_price,omitempty"`
+	Discount      string `json:"discount,omitempty"`
+	AverageRating string `json:"average_rating,omitempty"`
+	TotalReviews  string `json:"total_reviews,omitempty"`
+	Quantity      string `json:"quantity,omitempty"`
+}
+
+// newBookDisplay formats the loaded sections of a book for a locale
+func newBookDisplay(locale displayLocale, details bookDetails) *BookDisplay {
+	display := &BookDisplay{Locale: locale.Tag}
+
+	if details.Metadata.Err == nil && details.Metadata.Data.PublishDate != nil {
+		display.PublishDate = locale.FormatDate(*details.Metadata.Data.PublishDate)
+	}
+	if details.Pricing.Err == nil {
+		pricing := details.Pricing.Data
+		display.Price = locale.FormatPrice(pricing.Price, pricing.Currency)
+		if pricing.SalePrice != nil {
+			display.SalePrice = locale.FormatPrice(*pricing.SalePrice, pricing.Currency)
+		}
+		if pricing.Discount > 0 {
+			display.Discount = locale.FormatPercent(pricing.Discount)
+		}
+	}
+	if details.Reviews.Err == nil {
+		if details.Reviews.Data.AverageRating != nil {
+			display.AverageRating = locale.FormatNumber(*details.Reviews.Data.AverageRating, 1)
+		}
+		display.TotalReviews = locale.FormatNumber(float64(details.Reviews.Data.TotalReviews), 0)
+	}
+	if details.Inventory.Err == nil {
+		display.Quantity = locale.FormatNumber(float64(details.Inventory.Data.Quantity), 0)
+	}
+	return display
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rotated files are named after the log file plus when they were rotated, e.g.
+// bookstore-20261017T034953.123.log, so they sort oldest first
+const logRotationTimeLayout = "20060102T150405.000"
+
+// rotatingFile is a log file that moves itself aside once it grows past maxSize bytes or gets
+// older than maxAge, compresses what it moved aside, and keeps at most maxBackups old files
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration // 0 never rotates by age
+	maxBackups int           // 0 keeps every old file
+	compress   bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// openRotatingFile opens (or continues) the log file at path with cfg's rotation settings
+func openRotatingFile(cfg Config) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.LogFile), 0o755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{
+		path:       cfg.LogFile,
+		maxSize:    int64(cfg.LogFileMaxSizeMB) << 20,
+		maxAge:     cfg.LogFileMaxAge,
+		maxBackups: cfg.LogFileMaxBackups,
+		compress:   cfg.LogFileCompress,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open appends to the log file, counting what is already in it. A file left by an earlier run
+// is aged from its modification time, the best guess there is for when it was started.
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size, r.openedAt = file, info.Size(), time.Now()
+	if info.Size() > 0 {
+		r.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// Write implements io.Writer. The log package writes one whole line per call, so a line never
+// straddles two files.
+func (r *rotatingFile) Write(line []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tooBig := r.maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxSize
+	tooOld := r.maxAge > 0 && time.Since(r.openedAt) > r.maxAge
+	if tooBig || tooOld {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the old file rather than lose lines
+			fmt.Fprintf(os.Stderr, "Error rotating log file %s: %v\n", r.path, err)
+		}
+	}
+	written, err := r.file.Write(line)
+	r.size += int64(written)
+	return written, err
+}
+
+// rotate moves the current file aside and starts a new one. Compression and pruning happen in
+// the background so logging isn't held up.
+func (r *rotatingFile) rotate() error {
+	extension := filepath.Ext(r.path)
+	rotated := strings.TrimSuffix(r.path, extension) + "-" + time.Now().UTC().Format(logRotationTimeLayout) + extension
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(r.path, rotated)
+	// Reopen either way: after a failed rename this continues the same file
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	go r.finishRotation(rotated)
+	return nil
+}
+
+// finishRotation compresses a rotated file and removes the oldest beyond maxBackups
+func (r *rotatingFile) finishRotation(rotated string) {
+	if r.compress {
+		if err := gzipFile(rotated); err != nil {
+			log.Printf("Error compressing rotated log %s: %v", rotated, err)
+		}
+	}
+	if r.maxBackups <= 0 {
+		return
+	}
+	extension := filepath.Ext(r.path)
+	backups, err := filepath.Glob(strings.TrimSuffix(r.path, extension) + "-*" + extension + "*")
+	if err != nil {
+		return
+	}
+	sort.Strings(backups)
+	for len(backups) > r.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Printf("Error removing old log %s: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// gzipFile replaces path with path.gz
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	compressed := gzip.NewWriter(out)
+	_, err = io.Copy(compressed, in)
+	if err == nil {
+		err = compressed.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// setupLogOutput sends the log to BOOKSTORE_LOG_FILE, with rotation, and to syslog or journald
+// (BOOKSTORE_LOG_SYSTEM), in addition to stderr or instead of it. With neither nothing changes.
+func setupLogOutput(cfg Config) error {
+	var outputs []io.Writer
+	if cfg.LogToStderr || (cfg.LogFile == "" && cfg.LogSystem == "") {
+		outputs = append(outputs, os.Stderr)
+	}
+	if cfg.LogFile != "" {
+		file, err := openRotatingFile(cfg)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, file)
+	}
+	if cfg.LogSystem != "" {
+		system, err := newSystemLogWriter(cfg)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, system)
+	}
+	log.SetOutput(io.MultiWriter(outputs...))
+	return nil
+}
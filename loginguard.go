@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// CaptchaVerifier checks the CAPTCHA response a client sends with a login once too many
+// attempts have failed. Real providers (hCaptcha, reCAPTCHA, Turnstile) verify the response
+// with their API; remoteIP is passed along as they all accept it.
+type CaptchaVerifier interface {
+	Name() string
+	Verify(ctx context.Context, response, remoteIP string) (bool, error)
+}
+
+// Known CAPTCHA verifiers, selectable by name via BOOKSTORE_CAPTCHA_PROVIDER. "none" turns the
+// CAPTCHA step off and leaves only the lockout.
+var captchaVerifierRegistry = map[string]func(Config) CaptchaVerifier{
+	"none":   func(Config) CaptchaVerifier { return nil },
+	"static": func(cfg Config) CaptchaVerifier { return staticCaptcha{token: cfg.CaptchaTestToken} },
+}
+
+// Verifier for login CAPTCHAs, nil when they are off; built from config in NewServer
+var captchaVerifier CaptchaVerifier
+
+// NewCaptchaVerifier instantiates a verifier by name, falling back to none
+func NewCaptchaVerifier(cfg Config) CaptchaVerifier {
+	constructor, ok := captchaVerifierRegistry[cfg.CaptchaProvider]
+	if !ok {
+		log.Printf("Unknown CAPTCHA provider %q, using none", cfg.CaptchaProvider)
+		constructor = captchaVerifierRegistry["none"]
+	}
+	return constructor(cfg)
+}
+
+// staticCaptcha accepts one fixed response, for development and tests
+type staticCaptcha struct {
+	token string
+}
+
+// Name implements CaptchaVerifier
+func (staticCaptcha) Name() string { return "static" }
+
+// Verify implements CaptchaVerifier
+func (c staticCaptcha) Verify(_ context.Context, response, _ string) (bool, error) {
+	return c.token != "" && subtle.ConstantTimeCompare([]byte(response), []byte(c.token)) == 1, nil
+}
+
+// remoteIP is the address the request came from, without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginThrottleKeys are the counters a login attempt is tracked under: the account it names and
+// the address it came from. Either one being locked blocks the attempt, so spraying one
+// password across accounts is caught as well as hammering one account.
+func loginThrottleKeys(userID, ip string) []string {
+	return []string{"account:" + userID, "ip:" + ip}
+}
+
+// loginAttempt is a login that has been counted against its throttle keys before the password
+// is checked. Counting first, in the same statement that checks the lock, means concurrent
+// attempts can't all pass the check before any failure is recorded.
+type loginAttempt struct {
+	keys     []string
+	failures int               // Highest failure count among the keys before this attempt
+	locks    map[string]string // Lock this attempt set on each key, in case it fails
+	lockout  time.Duration     // Longest of those locks
+}
+
+// loginLockedError is returned by reserveLoginAttempt while any of the keys is locked
+type loginLockedError struct {
+	until time.Time
+}
+
+func (e loginLockedError) Error() string { return "login locked until " + e.until.String() }
+
+// reserveLoginAttempt counts an attempt as a failure against every key, refusing it with
+// loginLockedError while any key is locked. A key the attempt takes to LoginLockoutThreshold is
+// locked straight away, so nothing else gets through while the password is checked; the
+// attempt hands its count and locks back through releaseLoginAttempt if it turns out not to
+// be a wrong password. Failure counts older than LoginFailureWindow have lapsed and start
+// again from one.
+func reserveLoginAttempt(ctx context.Context, keys []string) (loginAttempt, error) {
+	attempt := loginAttempt{keys: keys, locks: map[string]string{}}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return attempt, err
+	}
+	defer tx.Rollback()
+
+	now := clock.Now().UTC()
+	nowText := now.Format(sqliteTimestampLayout)
+	lapsed := now.Add(-config.LoginFailureWindow).Format(sqliteTimestampLayout)
+	for _, key := range keys {
+		var failures int
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO login_throttle (key, failures, last_failure_at) VALUES (?, 1, ?)
+			ON CONFLICT(key) DO UPDATE SET
+				failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END,
+				last_failure_at = excluded.last_failure_at
+			WHERE locked_until IS NULL OR locked_until <= excluded.last_failure_at
+			RETURNING failures
+		`, key, nowText, lapsed).Scan(&failures)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The update was skipped, so the key is locked; the transaction rolls the others back
+			var lockedUntil time.Time
+			if err := tx.QueryRowContext(ctx, "SELECT locked_until FROM login_throttle WHERE key = ?", key).Scan(&lockedUntil); err != nil {
+				return attempt, err
+			}
+			return attempt, loginLockedError{until: lockedUntil}
+		}
+		if err != nil {
+			return attempt, err
+		}
+		attempt.failures = max(attempt.failures, failures-1)
+
+		if lockout := lockoutDuration(failures); lockout > 0 {
+			lockedUntil := now.Add(lockout).Format(sqliteTimestampLayout)
+			if _, err := tx.ExecContext(ctx, "UPDATE login_throttle SET locked_until = ? WHERE key = ?", lockedUntil, key); err != nil {
+				return attempt, err
+			}
+			attempt.locks[key] = lockedUntil
+			attempt.lockout = max(attempt.lockout, lockout)
+		}
+	}
+	return attempt, tx.Commit()
+}
+
+// releaseLoginAttempt takes back an attempt that didn't fail on its password: its count comes
+// off every key, and the locks it set are lifted unless something has replaced them
+func releaseLoginAttempt(ctx context.Context, attempt loginAttempt) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range attempt.keys {
+		var lock interface{}
+		if lockedUntil, ok := attempt.locks[key]; ok {
+			lock = lockedUntil
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE login_throttle SET
+				failures = MAX(failures - 1, 0),
+				locked_until = CASE WHEN locked_until = ? THEN NULL ELSE locked_until END
+			WHERE key = ?
+		`, lock, key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lockoutDuration is how long to lock after the given number of consecutive failures: nothing
+// below LoginLockoutThreshold, then LoginLockoutBase doubling with each further failure up to
+// LoginLockoutMax
+func lockoutDuration(failures int) time.Duration {
+	if failures < config.LoginLockoutThreshold {
+		return 0
+	}
+	doublings := float64(failures - config.LoginLockoutThreshold)
+	lockout := float64(config.LoginLockoutBase) * math.Pow(2, doublings)
+	if lockout >= float64(config.LoginLockoutMax) {
+		return config.LoginLockoutMax
+	}
+	return time.Duration(lockout)
+}
+
+// clearLoginFailures forgets an account's failures after it logs in. The address's counter is
+// left alone: one good password must not reset a spray across other accounts.
+func clearLoginFailures(ctx context.Context, userID string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM login_throttle WHERE key = ?", "account:"+userID)
+	return err
+}
+
+// guardLogin runs the brute-force checks before a password is looked at, writing the error
+// response and reporting false when the attempt may not go ahead: while locked out, and
+// without a valid CAPTCHA once LoginCaptchaThreshold attempts have failed. An attempt allowed
+// through is already counted as a failure; the caller reports how it went with
+// recordFailedLogin, or takes it back with releaseLoginAttempt.
+func guardLogin(w http.ResponseWriter, r *http.Request, credentials accountCredentials) (loginAttempt, bool) {
+	ip := remoteIP(r)
+	attempt, err := reserveLoginAttempt(r.Context(), loginThrottleKeys(credentials.UserID, ip))
+	var locked loginLockedError
+	if errors.As(err, &locked) {
+		retryAfter := max(int(math.Ceil(locked.until.Sub(clock.Now()).Seconds())), 1)
+		recordSecurityEvent(r, "login.blocked", credentials.UserID, http.StatusTooManyRequests,
+			fmt.Sprintf("locked for another %ds", retryAfter))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeError(w, r, http.StatusTooManyRequests, fmt.Sprintf("Too many failed logins; try again in %d seconds", retryAfter))
+		return attempt, false
+	}
+	if err != nil {
+		log.Printf("Error reserving login attempt for %s from %s: %v", credentials.UserID, ip, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to log in")
+		return attempt, false
+	}
+
+	if captchaVerifier == nil || attempt.failures < config.LoginCaptchaThreshold {
+		return attempt, true
+	}
+	valid := false
+	if credentials.Captcha != "" {
+		valid, err = captchaVerifier.Verify(r.Context(), credentials.Captcha, ip)
+		if err != nil {
+			log.Printf("Error verifying %s CAPTCHA: %v", captchaVerifier.Name(), err)
+			releaseLoginAttemptOrLog(r, credentials.UserID, attempt)
+			writeError(w, r, http.StatusServiceUnavailable, "CAPTCHA verification is unavailable")
+			return attempt, false
+		}
+		if !valid {
+			recordSecurityEvent(r, "login.captcha_failed", credentials.UserID, http.StatusUnauthorized, captchaVerifier.Name())
+		}
+	}
+	if !valid {
+		releaseLoginAttemptOrLog(r, credentials.UserID, attempt)
+		writeProblem(w, r, problemDetails{
+			Title:   "CAPTCHA required",
+			Status:  http.StatusUnauthorized,
+			Detail:  "Too many failed logins; solve the CAPTCHA and send its response as \"captcha\"",
+			Captcha: captchaVerifier.Name(),
+		})
+		return attempt, false
+	}
+	return attempt, true
+}
+
+// releaseLoginAttemptOrLog is releaseLoginAttempt for handlers, which carry on if it fails
+func releaseLoginAttemptOrLog(r *http.Request, userID string, attempt loginAttempt) {
+	if err := releaseLoginAttempt(r.Context(), attempt); err != nil {
+		log.Printf("Error releasing login attempt for %s: %v", userID, err)
+	}
+}
+
+// recordFailedLogin writes the security events for a wrong password. The attempt was counted,
+// and any lock it earned set, when it was reserved.
+func recordFailedLogin(r *http.Request, userID string, attempt loginAttempt) {
+	recordSecurityEvent(r, "login.failed", userID, http.StatusUnauthorized, "")
+	if attempt.lockout > 0 {
+		log.Printf("Locked logins for %s from %s for %v after repeated failures", userID, remoteIP(r), attempt.lockout)
+		recordSecurityEvent(r, "login.locked", userID, http.StatusUnauthorized, "for "+attempt.lockout.String())
+	}
+}
+
+// recordSecurityEvent audits a login event. The actor is the address, since the claimed user
+// is exactly what is in doubt.
+func recordSecurityEvent(r *http.Request, action, userID string, status int, detail string) {
+	recordAudit(r.Context(), AuditEntry{
+		Actor:  "ip:" + remoteIP(r),
+		Action: action,
+		UserID: userID,
+		Status: status,
+		Detail: detail,
+	})
+}
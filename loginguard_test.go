@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// newLockoutTestServer serves with logins locked after three failures and no CAPTCHA step, and
+// signs up alice and bob
+func newLockoutTestServer(t *testing.T) string {
+	t.Helper()
+	server := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.CaptchaProvider = "none"
+		cfg.LoginLockoutThreshold = 3
+	})
+	for _, user := range []string{"alice", "bob"} {
+		if status, body := doRequest(t, newTestClient(t), http.MethodPost, server.URL+"/api/accounts",
+			`{"user_id": "`+user+`", "password": "Correct-Horse-42"}`); status != http.StatusCreated {
+			t.Fatalf("signing up %s = %d %s", user, status, body)
+		}
+	}
+	return server.URL
+}
+
+// logInStatus posts a login from a fresh client and returns the status
+func logInStatus(t *testing.T, url, userID, password string) int {
+	t.Helper()
+	status, _ := doRequest(t, newTestClient(t), http.MethodPost, url+"/api/session/login",
+		`{"user_id": "`+userID+`", "password": "`+password+`"}`)
+	return status
+}
+
+func TestLoginLocksAfterRepeatedFailures(t *testing.T) {
+	url := newLockoutTestServer(t)
+
+	for i := 1; i <= 3; i++ {
+		if status := logInStatus(t, url, "alice", "wrong-password"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d = %d, want 401", i, status)
+		}
+	}
+	if status := logInStatus(t, url, "alice", "Correct-Horse-42"); status != http.StatusTooManyRequests {
+		t.Fatalf("right password while locked = %d, want 429", status)
+	}
+}
+
+func TestConcurrentLoginsCannotOutrunTheLockout(t *testing.T) {
+	url := newLockoutTestServer(t)
+
+	var mu sync.Mutex
+	statuses := map[int]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := logInStatus(t, url, "alice", "wrong-password")
+			mu.Lock()
+			statuses[status]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Only the attempts up to the threshold may have their password checked
+	if statuses[http.StatusUnauthorized] != 3 || statuses[http.StatusTooManyRequests] != 17 {
+		t.Fatalf("20 concurrent wrong passwords got %v, want 3 checked and 17 locked out", statuses)
+	}
+}
+
+func TestGoodLoginHandsBackItsAttempt(t *testing.T) {
+	url := newLockoutTestServer(t)
+
+	// Two failures from this address, then a good login that would have been the third
+	for i := 0; i < 2; i++ {
+		if status := logInStatus(t, url, "alice", "wrong-password"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong password = %d, want 401", status)
+		}
+	}
+	if status := logInStatus(t, url, "alice", "Correct-Horse-42"); status != http.StatusOK {
+		t.Fatalf("right password = %d, want 200", status)
+	}
+
+	// The address is left at two failures, not locked: bob gets one try before the lock
+	if status := logInStatus(t, url, "bob", "wrong-password"); status != http.StatusUnauthorized {
+		t.Fatalf("bob's wrong password after alice's login = %d, want 401", status)
+	}
+	if status := logInStatus(t, url, "bob", "Correct-Horse-42"); status != http.StatusTooManyRequests {
+		t.Fatalf("bob's login once the address is locked = %d, want 429", status)
+	}
+}
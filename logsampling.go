@@ -0,0 +1,174 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Loggers whose per-request lines can be sampled: the success and progress lines of the busiest
+// endpoints. Errors are logged with log.Printf everywhere and never sampled.
+var sampledLoggers = []string{"books", "details", "search"}
+
+// logSampler is one logger's sampling and rate limit settings, and the state for the limit
+type logSampler struct {
+	every        int // Keep one request in every; 1 keeps all
+	maxPerSecond int // Lines per second at most; 0 for no limit
+	second       int64
+	inSecond     int
+}
+
+// logSamplers holds every logger's settings. They start from config and can be changed at
+// runtime through /api/admin/log-sampling.
+var logSamplers = struct {
+	sync.Mutex
+	byLogger map[string]*logSampler
+}{}
+
+var (
+	logLinesSampledOut  = expvar.NewMap("log_lines_sampled_out")
+	logLinesRateLimited = expvar.NewMap("log_lines_rate_limited")
+)
+
+// ResetLogSampling applies the configured settings, dropping runtime changes
+func ResetLogSampling(cfg Config) {
+	logSamplers.Lock()
+	defer logSamplers.Unlock()
+	logSamplers.byLogger = make(map[string]*logSampler, len(sampledLoggers))
+	for _, logger := range sampledLoggers {
+		sampler := &logSampler{every: 1, maxPerSecond: cfg.LogRateLimits[logger]}
+		if every := cfg.LogSampleEvery[logger]; every > 1 {
+			sampler.every = every
+		}
+		logSamplers.byLogger[logger] = sampler
+	}
+}
+
+// logRequest logs a line about a request through a sampled logger. Whether a request is kept
+// depends on its request ID, so either all of a request's lines appear or none do. Kept lines
+// say they are a sample, so whoever reads them knows to multiply.
+func logRequest(r *http.Request, logger, format string, args ...interface{}) {
+	logSamplers.Lock()
+	sampler := logSamplers.byLogger[logger]
+	if sampler == nil {
+		logSamplers.Unlock()
+		log.Printf(format, args...)
+		return
+	}
+	every := sampler.every
+	if every > 1 {
+		hash := fnv.New32a()
+		hash.Write([]byte(RequestIDFromContext(r.Context())))
+		if hash.Sum32()%uint32(every) != 0 {
+			logSamplers.Unlock()
+			logLinesSampledOut.Add(logger, 1)
+			return
+		}
+	}
+	if sampler.maxPerSecond > 0 {
+		second := clock.Now().Unix()
+		if second != sampler.second {
+			sampler.second, sampler.inSecond = second, 0
+		}
+		if sampler.inSecond >= sampler.maxPerSecond {
+			logSamplers.Unlock()
+			logLinesRateLimited.Add(logger, 1)
+			return
+		}
+		sampler.inSecond++
+	}
+	logSamplers.Unlock()
+
+	if every > 1 {
+		format += fmt.Sprintf(" [sampled 1 in %d]", every)
+	}
+	log.Printf(format, args...)
+}
+
+// logSamplingStatuses reports every sampled logger, sorted by name
+func logSamplingStatuses() []LogSampling {
+	logSamplers.Lock()
+	defer logSamplers.Unlock()
+	statuses := make([]LogSampling, 0, len(logSamplers.byLogger))
+	for logger, sampler := range logSamplers.byLogger {
+		statuses = append(statuses, logSamplingStatus(logger, sampler))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Logger < statuses[j].Logger })
+	return statuses
+}
+
+func logSamplingStatus(logger string, sampler *logSampler) LogSampling {
+	status := LogSampling{Logger: logger, Every: sampler.every, MaxPerSecond: sampler.maxPerSecond}
+	if count, ok := logLinesSampledOut.Get(logger).(*expvar.Int); ok {
+		status.SampledOut = count.Value()
+	}
+	if count, ok := logLinesRateLimited.Get(logger).(*expvar.Int); ok {
+		status.RateLimited = count.Value()
+	}
+	return status
+}
+
+// LogSamplingHandler handles log sampling settings, which take effect immediately and last until
+// the next restart:
+//
+//	GET    /api/admin/log-sampling           Every sampled logger
+//	PUT    /api/admin/log-sampling/{logger}  {"every": 10, "max_per_second": 50}
+//	DELETE /api/admin/log-sampling/{logger}  Log every line again
+func LogSamplingHandler(w http.ResponseWriter, r *http.Request) {
+	logger := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/log-sampling"), "/")
+	if logger == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, logSamplingStatuses())
+		return
+	}
+
+	logSamplers.Lock()
+	sampler := logSamplers.byLogger[logger]
+	logSamplers.Unlock()
+	if sampler == nil {
+		writeError(w, r, http.StatusNotFound, "Unknown logger; expected one of "+strings.Join(sampledLoggers, ", "))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		logSamplers.Lock()
+		status := logSamplingStatus(logger, sampler)
+		logSamplers.Unlock()
+		writeJSON(w, r, http.StatusOK, status)
+
+	case http.MethodPut:
+		var settings LogSampling
+		if !decodeJSONBody(w, r, &settings) {
+			return
+		}
+		if settings.Every < 1 || settings.MaxPerSecond < 0 {
+			writeError(w, r, http.StatusBadRequest, "every must be at least 1 and max_per_second must not be negative")
+			return
+		}
+		logSamplers.Lock()
+		sampler.every, sampler.maxPerSecond = settings.Every, settings.MaxPerSecond
+		status := logSamplingStatus(logger, sampler)
+		logSamplers.Unlock()
+		log.Printf("Log sampling for %s set to 1 in %d, at most %d lines per second", logger, settings.Every, settings.MaxPerSecond)
+		writeJSON(w, r, http.StatusOK, status)
+
+	case http.MethodDelete:
+		logSamplers.Lock()
+		sampler.every, sampler.maxPerSecond = 1, 0
+		logSamplers.Unlock()
+		log.Printf("Log sampling for %s turned off", logger)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog severities (RFC 5424), which journald's PRIORITY field uses too
+const (
+	severityCritical = 2
+	severityError    = 3
+	severityWarning  = 4
+	severityInfo     = 6
+)
+
+// Facility for everything this service logs: daemon
+const syslogFacility = 3
+
+// Where journald listens for its native protocol
+const journaldSocket = "/run/systemd/journal/socket"
+
+// Local syslog sockets, in the order they are tried
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Line prefixes that say how bad a log line is. The log has no levels, but its lines start the
+// same way for the same kind of event; anything else is informational.
+var logSeverityPrefixes = []struct {
+	prefix   string
+	severity int
+}{
+	{"Panic", severityCritical},
+	{"SLO ALERT", severityCritical},
+	{"Error", severityError},
+	{"Failed", severityError},
+	{"Blocked", severityWarning},
+	{"Unknown", severityWarning},
+	{"Warning", severityWarning},
+}
+
+// logSeverity picks the severity of a log message (without its timestamp)
+func logSeverity(message string) int {
+	for _, known := range logSeverityPrefixes {
+		if strings.HasPrefix(message, known.prefix) {
+			return known.severity
+		}
+	}
+	if strings.Contains(message, " is not set") {
+		return severityWarning
+	}
+	return severityInfo
+}
+
+// stripLogTimestamp removes the date and time the log package puts in front of each line: the
+// system log stamps lines itself
+func stripLogTimestamp(line string) string {
+	const layout = "2006/01/02 15:04:05 "
+	if len(line) >= len(layout) {
+		if _, err := time.Parse(layout, line[:len(layout)]); err == nil {
+			return line[len(layout):]
+		}
+	}
+	return line
+}
+
+// syslogWriter sends each log line to a syslog daemon, local or remote
+type syslogWriter struct {
+	network string // "unixgram", "udp" or "tcp"
+	address string
+	tag     string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newSyslogWriter connects to address: "" for the local daemon, or udp://host:port or
+// tcp://host:port
+func newSyslogWriter(address, tag string) (*syslogWriter, error) {
+	w := &syslogWriter{tag: tag}
+	if address == "" {
+		for _, socket := range syslogSockets {
+			if _, err := os.Stat(socket); err == nil {
+				w.network, w.address = "unixgram", socket
+				break
+			}
+		}
+		if w.network == "" {
+			return nil, fmt.Errorf("no local syslog socket found (tried %s)", strings.Join(syslogSockets, ", "))
+		}
+	} else {
+		parsed, err := url.Parse(address)
+		if err != nil || (parsed.Scheme != "udp" && parsed.Scheme != "tcp") || parsed.Host == "" {
+			return nil, fmt.Errorf("syslog address must look like udp://host:514 or tcp://host:514")
+		}
+		w.network, w.address = parsed.Scheme, parsed.Host
+	}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *syslogWriter) connect() error {
+	conn, err := net.DialTimeout(w.network, w.address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+// Write implements io.Writer, one line per call as the log package writes them. A dropped
+// connection is redialled once; if that fails the line is lost rather than blocking logging.
+func (w *syslogWriter) Write(line []byte) (int, error) {
+	message := strings.TrimSuffix(stripLogTimestamp(string(line)), "\n")
+	var packet string
+	if w.network == "unixgram" {
+		// The local daemon fills in the hostname
+		packet = fmt.Sprintf("<%d>%s %s[%d]: %s", syslogFacility*8+logSeverity(message),
+			time.Now().Format(time.Stamp), w.tag, os.Getpid(), message)
+	} else {
+		hostname, _ := os.Hostname()
+		packet = fmt.Sprintf("<%d>1 %s %s %s %d - - %s", syslogFacility*8+logSeverity(message),
+			time.Now().Format(time.RFC3339Nano), hostname, w.tag, os.Getpid(), message)
+	}
+	if w.network == "tcp" {
+		// Octet counting framing, so multi-line messages stay one message
+		packet = strconv.Itoa(len(packet)) + " " + packet
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if err := w.connect(); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := w.conn.Write([]byte(packet)); err == nil {
+			return len(line), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return 0, fmt.Errorf("syslog write to %s failed", w.address)
+}
+
+// journaldWriter sends each log line to journald over its native protocol, which keeps
+// multi-line messages (stack traces) together and carries the severity as PRIORITY
+type journaldWriter struct {
+	tag  string
+	conn *net.UnixConn
+}
+
+func newJournaldWriter(tag string) (*journaldWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldWriter{tag: tag, conn: conn}, nil
+}
+
+// Write implements io.Writer
+func (w *journaldWriter) Write(line []byte) (int, error) {
+	message := strings.TrimSuffix(stripLogTimestamp(string(line)), "\n")
+	var entry bytes.Buffer
+	writeJournalField(&entry, "MESSAGE", message)
+	writeJournalField(&entry, "PRIORITY", strconv.Itoa(logSeverity(message)))
+	writeJournalField(&entry, "SYSLOG_IDENTIFIER", w.tag)
+	writeJournalField(&entry, "SYSLOG_FACILITY", strconv.Itoa(syslogFacility))
+	if _, err := w.conn.Write(entry.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(line), nil
+}
+
+// writeJournalField adds one field to a journald entry: KEY=value on a line, or for values with
+// newlines KEY, a newline, the value's length as a little-endian uint64, and the value
+func writeJournalField(entry *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		entry.WriteString(key + "=" + value + "\n")
+		return
+	}
+	entry.WriteString(key + "\n")
+	binary.Write(entry, binary.LittleEndian, uint64(len(value)))
+	entry.WriteString(value + "\n")
+}
+
+// newSystemLogWriter builds the writer for BOOKSTORE_LOG_SYSTEM: "syslog", or "journald", which
+// falls back to syslog where journald isn't running
+func newSystemLogWriter(cfg Config) (interface{ Write([]byte) (int, error) }, error) {
+	switch cfg.LogSystem {
+	case "journald":
+		if writer, err := newJournaldWriter(cfg.SyslogTag); err == nil {
+			return writer, nil
+		}
+		fmt.Fprintf(os.Stderr, "journald is not available at %s, logging to syslog instead\n", journaldSocket)
+		return newSyslogWriter(cfg.SyslogAddress, cfg.SyslogTag)
+	case "syslog":
+		return newSyslogWriter(cfg.SyslogAddress, cfg.SyslogTag)
+	}
+	return nil, fmt.Errorf("unknown system log %q", cfg.LogSystem)
+}
@@ -1,15 +1,60 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
+// Subcommands run instead of the server: scalable-webservice <name> [flags]
+var subcommands = map[string]func(args []string) int{
+	"bench":   runBench,   // Load test a running instance in both modes
+	"replay":  runReplay,  // Re-issue requests recorded via BOOKSTORE_RECORD_FILE
+	"orphans": runOrphans, // Report rows whose book is gone; -repair deletes them
+	"seed":    runSeed,    // Fabricate a large catalog: seed -generate 100000
+	"migrate": runMigrate, // Schema migration status, backfill and contract
+}
+
 func main() {
-	// Initialize database connection and schema
-	err := InitializeDatabase()
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(subcommand(os.Args[2:]))
+		}
+	}
+
+	memory := flag.Bool("memory", false, "Run on an in-memory database populated from the embedded dataset; nothing is written to disk")
+	flag.Parse()
+
+	// Load configuration from the environment
+	var err error
+	config, err = LoadConfig()
 	if err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		log.Fatal("Invalid configuration:", err)
+	}
+	if err := setupLogOutput(config); err != nil {
+		log.Fatal("Failed to set up log output:", err)
+	}
+
+	// Open the database; NewServer makes sure the schema and seed data are in place. Requests get
+	// the connections background jobs can't take. An in-memory database lives on a single
+	// connection, so batch work shares it and the pool is never resized.
+	var database *sql.DB
+	if *memory {
+		log.Println("Running on an in-memory database; changes are lost on exit")
+		database, err = OpenMemoryDatabase()
+		config.DatabaseAutosize = false
+	} else {
+		database, err = OpenDatabase(config.DatabasePath, config.DatabaseMaxConns-config.DatabaseBatchConns)
+		if err == nil {
+			batchDB, err = OpenDatabase(config.DatabasePath, config.DatabaseBatchConns)
+		}
+	}
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
 	}
 
 	// Ensure database connection closes when application exits
@@ -19,26 +64,97 @@ func main() {
 		}
 	}()
 
-	// Register HTTP route handlers
-	http.HandleFunc("/api/books", BooksHandler)       // Simple books list
-	http.HandleFunc("/api/books/", BookDetailHandler) // Detailed book information
+	handler, err := NewServer(config, database, nil)
+	if err != nil {
+		log.Fatal("Failed to initialize server:", err)
+	}
+	switch {
+	case config.AdminOpen:
+		log.Println("Warning: BOOKSTORE_ADMIN_OPEN is set; /api/admin/ is open to anyone who can reach the service. Never use it outside development")
+	case config.AdminToken == "" && len(config.AdminUsers) == 0:
+		log.Println("Neither BOOKSTORE_ADMIN_TOKEN nor BOOKSTORE_ADMIN_USERS is set; /api/admin/ refuses every request")
+	}
+
+	// External search backends and description embeddings are rebuilt from the catalog in the background,
+	// and review aggregates are checked against the ratings behind them
+	StartSearchIndexer(context.Background(), searchIndex, config.SearchReindexInterval)
+	StartEmbeddingPipeline(context.Background(), embeddingProvider, config.EmbeddingRefreshInterval)
+	StartRatingRecompute(context.Background(), config.RatingRecomputeInterval)
+	StartSLOMonitor(context.Background())
+	StartReplicaHeartbeat(context.Background(), time.Minute)
+	StartWatchdog(context.Background(), config.WatchdogInterval, config.WatchdogSamples, config.WatchdogMinGrowth)
+	if config.DatabaseAutosize {
+		StartPoolAutosizer(context.Background(), database, config)
+	}
 
 	// Start HTTP server
-	log.Println("Starting server on http://localhost:8080")
+	log.Printf("Starting server on %s", config.ListenAddr)
 	log.Println("Available endpoints:")
 	log.Println("  GET /api/books - List all books")
+	log.Println("  GET /api/books/compare?ids=1,2,3 - Side-by-side comparison")
+	log.Printf("  GET /api/books/search?q=clen+code - Typo-tolerant search (%s backend)", searchIndex.Name())
+	log.Printf("  GET /api/books/{id}/similar?limit=5 - More like this (%s embeddings)", embeddingProvider.Name())
+	log.Println("  GET /api/books/{id}/shipping?postal_code=94105 - Delivery estimates")
+	log.Println("  GET /api/books/{id}/availability?wait=30s - Long poll for stock status changes (send If-None-Match)")
+	log.Println("  POST /api/books/{id}/rating - Rate a book 1-5 as the session's user (one rating per user)")
 	log.Println("  GET /api/books/{id}/details?mode=sequential - Sequential operations")
 	log.Println("  GET /api/books/{id}/details?mode=concurrent - Concurrent operations")
-	log.Println("  Optional: &user_id=demo_user for personalized recommendations")
+	log.Printf("  GET /api/books/{id}/details - Canary split, %d%% concurrent", config.ConcurrentCanaryPercent)
+	log.Println("  Recommendations are personalized to the session's user; optional &user_id= for price test variants")
+	log.Println("  Optional: Accept-Language header for translated titles and descriptions")
+	log.Println("  GET /api/v2/books/{id}/details - Typed details schema (same mode options)")
+	log.Println("  Optional: &locale=de-DE (or Accept-Language) for display-formatted prices and dates")
+	log.Println("  GET/POST /api/users/me/lists - Reading lists and wishlists (your own only)")
+	log.Println("  PUT/DELETE /api/users/me/lists/{id}/books/{book_id} - Add, mark read, remove")
+	log.Println("  POST/DELETE /api/users/me/lists/{id}/share, GET /api/shared/lists/{token} - Sharing")
+	log.Println("  GET /api/users/me/recently-viewed?limit=10 - Books the session or user opened, latest first")
+	log.Println("  GET/POST /api/users/me/tokens, DELETE .../tokens/{id} - Personal access tokens (catalog:read, reviews:write)")
+	log.Println("  GET /api/changes?since=0&limit=100 - Catalog changes in sequence order, for incremental sync")
+	log.Println("  GET /api/storefront - Tenant branding, currency, locales and feature toggles (X-Tenant-ID)")
+	log.Println("  GET/POST /api/admin/storefronts, GET/PUT/DELETE /api/admin/storefronts/{tenant} - Manage storefronts")
+	log.Println("  GET/POST /api/admin/cache/version - Show the cache version or bump it to flush every cache")
+	log.Println("  GET/POST /api/admin/impersonations, DELETE .../impersonations/{id} - Act as a user with a scoped, expiring token")
+	log.Println("  POST /api/admin/signed-urls - Expiring signed link to an admin book, export or report")
+	log.Println("  GET /api/admin/audit-log?impersonated=true&user_id=u1 - Audit trail, impersonated requests flagged")
+	log.Println("  GET/POST /api/admin/flags - List or create feature flags")
+	log.Println("  GET/PUT/DELETE /api/admin/flags/{key} - Manage a feature flag")
+	log.Println("  GET /api/admin/data-quality - Catalog anomalies by severity")
+	log.Println("  POST /api/admin/ratings/recompute - Recompute review aggregates and repair drift")
+	log.Println("  GET /api/admin/dependencies - Database and upstream provider health")
+	log.Println("  GET /api/admin/log-sampling, PUT/DELETE .../log-sampling/{logger} - Sample busy endpoints' log lines at runtime")
+	log.Println("  GET /api/admin/slos - Per-route SLO compliance, error budget burn rates and recent alerts")
+	log.Println("  GET /api/admin/books/{id} - Book with internal fields: cost price, supplier, restocks, moderation flags")
+	log.Println("  GET/POST /api/admin/experiments, GET/PUT/DELETE .../experiments/{key} - A/B price tests with results")
+	log.Println("  POST /api/experiments/{key}/conversions - Report a purchase under a price test")
+	log.Println("  GET/POST /api/admin/suppliers, GET/POST /api/admin/purchase-orders?status=outstanding&overdue=1 - Purchasing")
+	log.Println("  POST /api/admin/purchase-orders/low-stock, POST .../purchase-orders/{id}/receive - Reorder and receive stock")
+	log.Println("  PUT/DELETE /api/admin/purchase-orders/{id} - Move expected arrival or cancel")
+	log.Println("  GET /api/admin/export/books?format=ndjson - Stream the whole catalog")
+	log.Println("  POST /api/admin/export/archives?format=json, GET .../archives/{id} - Snapshot the export; resumable download")
+	log.Println("  GET /api/admin/reports/margins, PUT /api/admin/books/{id}/cost-price - Cost prices and margins")
+	log.Println("  GET/PUT /api/admin/books/{id}/regions - Regional prices and availability restrictions")
+	log.Println("  GET /api/admin/books/{id}/translations, GET/PUT/DELETE .../translations/{lang} - Manage translations")
+	log.Println("  GET /api/admin/books/duplicates, POST /api/admin/books/{id}/merge - Find and merge duplicate ISBNs")
+	log.Println("  GET /api/admin/processing?status=failed, POST /api/admin/processing/reprocess - Enrichment pipeline state")
+	log.Println("  GET /api/admin/books/{id}/processing, POST .../processing/reprocess - One book's enrichment state")
+	log.Println("  GET/POST/PUT/DELETE /api/admin/books/{id}/lock - Editing lock: show, acquire, heartbeat, release")
+	log.Println("  GET /feeds/new-releases.xml, /feeds/deals.xml - Atom feeds")
+	log.Println("  GET /sitemap.xml, /sitemaps/books-{n}.xml - Sitemap of book detail URLs")
+	log.Println("  GET /api/admin/migrations, POST .../migrations/{id}/backfill|contract - Expand/contract schema migrations")
+	log.Println("  GET /readyz - Readiness, with the startup integrity report")
+	log.Println("  POST /api/admin/integrity-check[?full=true] - Re-run the integrity check and update readiness")
+	log.Println("  GET /debug/vars - Runtime metrics (admin)")
+	log.Println("  GET /debug/goroutine-diff[?reset=true] - Goroutine stacks grown since startup or the last reset (admin)")
+	log.Println("  Any JSON endpoint: ?pretty=1 for indented output, ?tz=Europe/Paris for local timestamps")
 	log.Println("")
 	log.Println("Operations include:")
 	log.Println("  • Database queries for metadata, pricing, inventory, reviews")
-	log.Println("  • External API call to api.quotable.io for recommendations")
+	log.Printf("  • External API calls to %v for recommendations (first success wins)", config.RecommendationProviders)
 	log.Println("")
 	log.Println("This demonstrates the difference between sequential and concurrent coordination")
 	log.Println("when mixing fast database operations with slower external API calls.")
 
-	err = http.ListenAndServe(":8080", nil)
+	err = http.ListenAndServe(config.ListenAddr, handler)
 	if err != nil {
 		log.Fatal("FATAL: error while starting server:", err)
 	}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// TitleMargin is one book's margin at its current selling price
+type TitleMargin struct {
+	BookID        string   `json:"book_id"`
+	Title         string   `json:"title"`
+	Currency      string   `json:"currency"`
+	SellingPrice  float64  `json:"selling_price"`  // Sale price when on sale, list price otherwise
+	CostPrice     *float64 `json:"cost_price"`     // null when unknown
+	Margin        *float64 `json:"margin"`         // Selling price less cost, null without a cost price
+	MarginPercent *float64 `json:"margin_percent"` // Margin as a share of the selling price, 0-100
+	InStock       int      `json:"in_stock"`       // Copies on hand
+}
+
+// MarginSummary aggregates margins over the stock on hand
+type MarginSummary struct {
+	TitlesWithCost     int      `json:"titles_with_cost"`
+	TitlesMissingCost  int      `json:"titles_missing_cost"`  // Left out of the totals below
+	StockAtCost        float64  `json:"stock_at_cost"`        // Copies on hand valued at cost
+	StockAtPrice       float64  `json:"stock_at_price"`       // The same copies at their selling price
+	GrossMarginPercent *float64 `json:"gross_margin_percent"` // null when no stocked title has a cost
+}
+
+// MarginReport is the body of GET /api/admin/reports/margins
+type MarginReport struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Summary     MarginSummary `json:"summary"`
+	Titles      []TitleMargin `json:"titles"` // Thinnest margin first; titles without a cost last
+}
+
+// BuildMarginReport computes each title's margin from its current pricing row. There is no
+// sales history to draw realized margins from, so the aggregate weighs titles by the copies on
+// hand: what the current stock would earn if it sold at today's prices. Totals add amounts
+// as they are, so a catalog priced in several currencies should be read per title.
+func BuildMarginReport(ctx context.Context) (MarginReport, error) {
+	report := MarginReport{GeneratedAt: clock.Now().UTC(), Titles: []TitleMargin{}}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT b.id, b.title, p.currency, COALESCE(p.sale_price, p.price), p.cost_price, COALESCE(i.quantity, 0)
+		FROM books b
+		JOIN pricing p ON p.book_id = b.id
+		LEFT JOIN inventory i ON i.book_id = b.id
+	`)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var title TitleMargin
+		var costPrice sql.NullFloat64
+		if err := rows.Scan(&title.BookID, &title.Title, &title.Currency, &title.SellingPrice, &costPrice, &title.InStock); err != nil {
+			return report, err
+		}
+		if !costPrice.Valid {
+			report.Summary.TitlesMissingCost++
+			report.Titles = append(report.Titles, title)
+			continue
+		}
+
+		title.CostPrice = &costPrice.Float64
+		margin := roundCents(title.SellingPrice - costPrice.Float64)
+		title.Margin = &margin
+		if title.SellingPrice > 0 {
+			percent := math.Round(margin/title.SellingPrice*1000) / 10
+			title.MarginPercent = &percent
+		}
+		report.Summary.TitlesWithCost++
+		if title.InStock > 0 {
+			report.Summary.StockAtCost += costPrice.Float64 * float64(title.InStock)
+			report.Summary.StockAtPrice += title.SellingPrice * float64(title.InStock)
+		}
+		report.Titles = append(report.Titles, title)
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	report.Summary.StockAtCost = roundCents(report.Summary.StockAtCost)
+	report.Summary.StockAtPrice = roundCents(report.Summary.StockAtPrice)
+	if report.Summary.StockAtPrice > 0 {
+		percent := math.Round((report.Summary.StockAtPrice-report.Summary.StockAtCost)/report.Summary.StockAtPrice*1000) / 10
+		report.Summary.GrossMarginPercent = &percent
+	}
+
+	sort.SliceStable(report.Titles, func(i, j int) bool {
+		a, b := report.Titles[i].MarginPercent, report.Titles[j].MarginPercent
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		if *a != *b {
+			return *a < *b
+		}
+		return report.Titles[i].BookID < report.Titles[j].BookID
+	})
+	return report, nil
+}
+
+// roundCents rounds an amount to two decimal places
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// setCostPrice stores what the store pays per copy of a book; nil clears it
+func setCostPrice(ctx context.Context, bookID string, costPrice *float64) error {
+	result, err := db.ExecContext(ctx, "UPDATE pricing SET cost_price = ?, updated_at = ? WHERE book_id = ?", costPrice, dbNow(), bookID)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// MarginReportHandler handles GET /api/admin/reports/margins
+func MarginReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	report, err := BuildMarginReport(r.Context())
+	if err != nil {
+		log.Printf("Error building margin report: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to build margin report")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}
+
+// CostPriceHandler handles PUT /api/admin/books/{id}/cost-price with body {"cost_price": 12.40}
+// (null clears it). Cost prices are admin-only and never appear in public responses.
+func CostPriceHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	if r.Method != http.MethodPut {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body struct {
+		CostPrice *float64 `json:"cost_price"`
+	}
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if body.CostPrice != nil && *body.CostPrice < 0 {
+		writeError(w, r, http.StatusBadRequest, "cost_price can't be negative")
+		return
+	}
+
+	err := setCostPrice(r.Context(), bookID, body.CostPrice)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "Book has no pricing row")
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting cost price for book %s: %v", bookID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to set cost price")
+		return
+	}
+	log.Printf("Set cost price for book %s", bookID)
+
+	detail, err := LoadAdminBookDetail(r.Context(), bookID)
+	if err != nil {
+		log.Printf("Error loading pricing for book %s: %v", bookID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load pricing")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, detail.Pricing)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics are published through expvar, which serves them as JSON at /debug/vars
+
+// Detail endpoint traffic split by coordination variant (sequential/concurrent)
+var (
+	detailRequestsByVariant  = expvar.NewMap("detail_requests_by_variant")
+	detailLatencyMsByVariant = expvar.NewMap("detail_latency_ms_total_by_variant")
+)
+
+// recordDetailRequest counts a detail request and its latency against the variant that served it
+func recordDetailRequest(variant string, duration time.Duration) {
+	detailRequestsByVariant.Add(variant, 1)
+	detailLatencyMsByVariant.Add(variant, duration.Milliseconds())
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is a private type for values this package stores in request contexts
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+// requestIDMiddleware tags every request with an ID, reusing the caller's X-Request-ID when it
+// looks sane, and echoes it back so a client report can be matched to server and upstream logs
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = idGenerator.NewID()
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the request ID stored by requestIDMiddleware, or "" if there is none
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// validRequestID accepts short IDs made of visible ASCII so client input can't inject into headers or logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SchemaMigration is a schema change rolled out in expand/contract steps, so that replicas on the
+// old and the new code can share the database the whole time:
+//
+//  1. Expand adds the new shape (columns, tables, indexes) next to the old one. It must be
+//     additive, so old code doesn't notice, and idempotent: every instance runs it at startup.
+//  2. Deploy code that writes both shapes while the migration.<id>.dual_write flag is on and
+//     reads the new shape while migration.<id>.read_new is on, then turn dual_write on.
+//  3. Backfill copies existing rows into the new shape in batches, until it reports none left.
+//  4. Turn read_new on. Once every replica runs code that no longer touches the old shape,
+//     Contract removes it.
+//
+// For example, moving pricing.price to integer cents: Expand adds price_cents, writers set both
+// under dual_write, Backfill fills price_cents where it is NULL, readers switch under read_new,
+// and Contract drops price.
+type SchemaMigration struct {
+	ID          string
+	Description string
+	Expand      func(ctx context.Context) error
+	Backfill    func(ctx context.Context, limit int) (int, error) // Rows migrated, 0 once done
+	Contract    func(ctx context.Context) error
+}
+
+// Registered migrations, oldest first. Never remove one before it has contracted everywhere.
+var schemaMigrations = []SchemaMigration{}
+
+// Migration phases, in order. A backfill moves the migration to backfilling while it runs, so
+// only one replica copies rows at a time, and back to expanded if it fails.
+const (
+	migrationExpanded    = "expanded"
+	migrationBackfilling = "backfilling"
+	migrationBackfilled  = "backfilled"
+	migrationContracted  = "contracted"
+)
+
+// instanceID names this process among the replicas sharing the database
+var instanceID = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}()
+
+var errMigrationNotFound = errors.New("migration not found")
+
+// errBackfillRunning means another backfill of the migration is in progress
+var errBackfillRunning = errors.New("a backfill of this migration is already running")
+
+// errBackfillRefused wraps the reasons a migration can't be backfilled yet
+var errBackfillRefused = errors.New("backfill refused")
+
+// errBackfillLost means another runner took a backfill over, so this one must stop
+var errBackfillLost = errors.New("backfill was taken over by another runner")
+
+// findSchemaMigration looks a migration up by ID
+func findSchemaMigration(id string) (SchemaMigration, bool) {
+	for _, migration := range schemaMigrations {
+		if migration.ID == id {
+			return migration, true
+		}
+	}
+	return SchemaMigration{}, false
+}
+
+// migrationFlagOn reports whether a migration's flag is on. Dual writes and read switches are
+// all or nothing, so only an enabled flag at 100% rollout counts; flags are cached for
+// flagCacheTTL, so give replicas that long to follow a change.
+func migrationFlagOn(id, flagName string) bool {
+	flag, ok := getFeatureFlag("migration." + id + "." + flagName)
+	return ok && flag.Enabled && flag.RolloutPercent >= 100
+}
+
+// DualWriteEnabled reports whether writers should write both the old and the new shape of a
+// migration
+func DualWriteEnabled(id string) bool { return migrationFlagOn(id, "dual_write") }
+
+// ReadNewEnabled reports whether readers should use the new shape of a migration
+func ReadNewEnabled(id string) bool { return migrationFlagOn(id, "read_new") }
+
+// expandSchemaMigrations runs every migration's expand step and records the ones seen for the
+// first time. Contracted migrations are skipped: their expanded shape is the schema now.
+func expandSchemaMigrations(ctx context.Context) error {
+	for _, migration := range schemaMigrations {
+		state, err := loadMigrationState(ctx, migration.ID)
+		if err != nil {
+			return err
+		}
+		if state.Phase == migrationContracted {
+			continue
+		}
+		if err := migration.Expand(ctx); err != nil {
+			return fmt.Errorf("expanding migration %s: %w", migration.ID, err)
+		}
+		if state.Phase == "" {
+			log.Printf("Expanded schema migration %s: %s", migration.ID, migration.Description)
+			_, err = db.ExecContext(ctx, `
+				INSERT INTO schema_migrations (id, phase, expanded_at) VALUES (?, ?, ?) ON CONFLICT(id) DO NOTHING
+			`, migration.ID, migrationExpanded, dbNow())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadMigrationState reads a migration's progress; Phase is "" before it has been expanded
+func loadMigrationState(ctx context.Context, id string) (MigrationStatus, error) {
+	state := MigrationStatus{ID: id}
+	var expandedAt, backfilledAt, contractedAt sql.NullTime
+	var lastError sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT phase, rows_backfilled, expanded_at, backfilled_at, contracted_at, last_error FROM schema_migrations WHERE id = ?
+	`, id).Scan(&state.Phase, &state.RowsBackfilled, &expandedAt, &backfilledAt, &contractedAt, &lastError)
+	if errors.Is(err, sql.ErrNoRows) {
+		return state, nil
+	}
+	state.ExpandedAt, state.BackfilledAt, state.ContractedAt = nullTimePtr(expandedAt), nullTimePtr(backfilledAt), nullTimePtr(contractedAt)
+	state.LastError = lastError.String
+	return state, err
+}
+
+// claimBackfill moves an expanded migration to backfilling under a new owner token, in one
+// statement so that of two replicas (or two admin requests) starting a backfill only one wins;
+// the other gets errBackfillRunning. A backfill without progress for MigrationReplicaStaleAfter
+// is taken to have died with its replica and may be claimed again. The owner token goes to
+// runBackfill, whose every write checks it, so a runner that was only slow stops once it
+// notices it was taken over. Reasons the migration isn't ready wrap errBackfillRefused.
+func claimBackfill(ctx context.Context, id string) (string, error) {
+	if _, ok := findSchemaMigration(id); !ok {
+		return "", errMigrationNotFound
+	}
+	// Without dual writes, rows written after their batch would be missed
+	if !DualWriteEnabled(id) {
+		return "", fmt.Errorf("%w: turn on the migration.%s.dual_write flag before backfilling", errBackfillRefused, id)
+	}
+	owner := instanceID + "/" + idGenerator.NewID()
+	now := clock.Now().UTC()
+	result, err := db.ExecContext(ctx, `
+		UPDATE schema_migrations SET phase = ?, backfill_owner = ?, backfill_heartbeat_at = ?, last_error = NULL
+		WHERE id = ? AND (phase = ? OR (phase = ? AND (backfill_heartbeat_at IS NULL OR backfill_heartbeat_at <= ?)))
+	`, migrationBackfilling, owner, now.Format(sqliteTimestampLayout), id, migrationExpanded, migrationBackfilling,
+		now.Add(-config.MigrationReplicaStaleAfter).Format(sqliteTimestampLayout))
+	if err != nil {
+		return "", err
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed > 0 {
+		return owner, err
+	}
+
+	state, err := loadMigrationState(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if state.Phase == migrationBackfilling {
+		return "", errBackfillRunning
+	}
+	return "", fmt.Errorf("%w: migration %s is %q, only an expanded migration can be backfilled", errBackfillRefused, id, state.Phase)
+}
+
+// backfillSchemaMigration claims a migration's backfill and runs it to the end, see runBackfill
+func backfillSchemaMigration(ctx context.Context, id string) (int, error) {
+	owner, err := claimBackfill(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return runBackfill(ctx, id, owner)
+}
+
+// runBackfill runs a backfill claimed as owner to the end, batch by batch with a pause in
+// between so requests keep their share of the database. Progress is saved after every batch, so
+// a backfill cut short resumes where it stopped; the backfill itself must only pick up rows
+// still to migrate. On failure the migration goes back to expanded, ready for another attempt.
+// Once another runner has taken the claim over it stops with errBackfillLost and leaves the
+// migration to that runner.
+func runBackfill(ctx context.Context, id, owner string) (int, error) {
+	migration, ok := findSchemaMigration(id)
+	if !ok {
+		return 0, errMigrationNotFound
+	}
+
+	ctx = withBatchPriority(ctx)
+	total := 0
+	// owned runs an UPDATE of the migration's row that only applies while owner holds the claim
+	owned := func(ctx context.Context, set string, args ...interface{}) error {
+		result, err := db.ExecContext(ctx, "UPDATE schema_migrations SET "+set+" WHERE id = ? AND phase = ? AND backfill_owner = ?",
+			append(args, id, migrationBackfilling, owner)...)
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+			if err == nil {
+				err = errBackfillLost
+			}
+			return err
+		}
+		return nil
+	}
+	fail := func(migrated int, err error) (int, error) {
+		if errors.Is(err, errBackfillLost) {
+			return total, err
+		}
+		if ownErr := owned(context.WithoutCancel(ctx), "phase = ?, rows_backfilled = rows_backfilled + ?, last_error = ?",
+			migrationExpanded, migrated, err.Error()); ownErr != nil {
+			log.Printf("Error recording failed backfill of schema migration %s: %v", id, ownErr)
+		}
+		return total, err
+	}
+	for {
+		migrated, err := migration.Backfill(ctx, config.MigrationBackfillBatch)
+		total += migrated
+		if err != nil {
+			return fail(migrated, err)
+		}
+		if migrated == 0 {
+			break
+		}
+		if err := owned(ctx, "rows_backfilled = rows_backfilled + ?, backfill_heartbeat_at = ?, last_error = NULL", migrated, dbNow()); err != nil {
+			return fail(0, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fail(0, ctx.Err())
+		case <-time.After(config.MigrationBackfillPause):
+		}
+	}
+	if err := owned(ctx, "phase = ?, backfilled_at = ?, last_error = NULL", migrationBackfilled, dbNow()); err != nil {
+		return total, err
+	}
+	log.Printf("Backfilled schema migration %s: %d rows", id, total)
+	return total, nil
+}
+
+// contractSchemaMigration removes a migration's old shape, once nothing can still be using it:
+// the backfill is done, readers have switched, and every live replica runs a build that knows
+// the migration (so its code is past the old shape)
+func contractSchemaMigration(ctx context.Context, id string) error {
+	migration, ok := findSchemaMigration(id)
+	if !ok {
+		return errMigrationNotFound
+	}
+	state, err := loadMigrationState(ctx, id)
+	if err != nil {
+		return err
+	}
+	if state.Phase != migrationBackfilled {
+		return fmt.Errorf("migration %s is %q, only a backfilled migration can be contracted", id, state.Phase)
+	}
+	if !ReadNewEnabled(id) {
+		return fmt.Errorf("turn on the migration.%s.read_new flag before contracting", id)
+	}
+	lagging, err := replicasUnaware(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(lagging) > 0 {
+		return fmt.Errorf("replicas %s run a build without migration %s", strings.Join(lagging, ", "), id)
+	}
+
+	if err := migration.Contract(ctx); err != nil {
+		db.ExecContext(context.WithoutCancel(ctx), "UPDATE schema_migrations SET last_error = ? WHERE id = ?", err.Error(), id)
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE schema_migrations SET phase = ?, contracted_at = ?, last_error = NULL WHERE id = ?",
+		migrationContracted, dbNow(), id)
+	log.Printf("Contracted schema migration %s", id)
+	return err
+}
+
+// announceReplica records that this instance is alive and which migrations its build knows
+func announceReplica(ctx context.Context) error {
+	known := make([]string, 0, len(schemaMigrations))
+	for _, migration := range schemaMigrations {
+		known = append(known, migration.ID)
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO schema_migration_replicas (instance_id, migrations, seen_at) VALUES (?, ?, ?)
+		ON CONFLICT(instance_id) DO UPDATE SET migrations = excluded.migrations, seen_at = excluded.seen_at
+	`, instanceID, strings.Join(known, ","), dbNow())
+	return err
+}
+
+// replicasUnaware lists the replicas seen within MigrationReplicaStaleAfter whose build doesn't
+// know migration id
+func replicasUnaware(ctx context.Context, id string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT instance_id, migrations FROM schema_migration_replicas WHERE seen_at > ? ORDER BY instance_id",
+		clock.Now().Add(-config.MigrationReplicaStaleAfter).UTC().Format(sqliteTimestampLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var lagging []string
+	for rows.Next() {
+		var instance, migrations string
+		if err := rows.Scan(&instance, &migrations); err != nil {
+			return nil, err
+		}
+		if !containsString(strings.Split(migrations, ","), id) {
+			lagging = append(lagging, instance)
+		}
+	}
+	return lagging, rows.Err()
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// StartReplicaHeartbeat announces this instance now and then every interval until ctx is done
+func StartReplicaHeartbeat(ctx context.Context, interval time.Duration) {
+	if err := announceReplica(ctx); err != nil {
+		log.Printf("Error announcing replica %s: %v", instanceID, err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := announceReplica(ctx); err != nil {
+					log.Printf("Error announcing replica %s: %v", instanceID, err)
+				}
+			}
+		}
+	}()
+}
+
+// migrationStatuses reports every registered migration with its flags and lagging replicas
+func migrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	statuses := make([]MigrationStatus, 0, len(schemaMigrations))
+	for _, migration := range schemaMigrations {
+		status, err := loadMigrationState(ctx, migration.ID)
+		if err != nil {
+			return nil, err
+		}
+		status.Description = migration.Description
+		status.DualWrite = DualWriteEnabled(migration.ID)
+		status.ReadNew = ReadNewEnabled(migration.ID)
+		if status.LaggingReplicas, err = replicasUnaware(ctx, migration.ID); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrationsHandler handles expand/contract schema migrations:
+//
+//	GET  /api/admin/migrations                 Every migration's phase, flags and lagging replicas
+//	POST /api/admin/migrations/{id}/backfill   Start the backfill in the background (202), 409 while one runs
+//	POST /api/admin/migrations/{id}/contract   Drop the old shape, or 409 saying what is in the way
+func MigrationsHandler(w http.ResponseWriter, r *http.Request) {
+	// /api/admin/migrations[/{id}/{action}]
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) == 3 {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		statuses, err := migrationStatuses(r.Context())
+		if err != nil {
+			log.Printf("Error listing schema migrations: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to list migrations")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, statuses)
+		return
+	}
+	if len(pathParts) != 5 || (pathParts[4] != "backfill" && pathParts[4] != "contract") {
+		writeError(w, r, http.StatusNotFound, "Invalid URL Format. Expected /api/admin/migrations/{id}/backfill or /contract")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id := pathParts[3]
+	if _, ok := findSchemaMigration(id); !ok {
+		writeError(w, r, http.StatusNotFound, "Migration not found")
+		return
+	}
+
+	if pathParts[4] == "backfill" {
+		// Claim it now so a refusal, a running backfill included, is the response and not a log line
+		owner, err := claimBackfill(r.Context(), id)
+		switch {
+		case errors.Is(err, errBackfillRunning), errors.Is(err, errBackfillRefused):
+			writeError(w, r, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			log.Printf("Error claiming backfill of schema migration %s: %v", id, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to start backfill")
+			return
+		}
+		go func() {
+			if _, err := runBackfill(context.Background(), id, owner); err != nil {
+				log.Printf("Error backfilling schema migration %s: %v", id, err)
+			}
+		}()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if err := contractSchemaMigration(r.Context(), id); err != nil {
+		writeError(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	state, _ := loadMigrationState(r.Context(), id)
+	writeJSON(w, r, http.StatusOK, state)
+}
+
+// runMigrate implements the "migrate" subcommand, the same steps as the admin API for running
+// from a deploy script: migrate status | migrate backfill <id> | migrate contract <id>
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	action, id := flags.Arg(0), flags.Arg(1)
+	if action == "" || (action != "status" && id == "") {
+		fmt.Fprintln(os.Stderr, "usage: migrate status | migrate backfill <id> | migrate contract <id>")
+		return 2
+	}
+
+	var err error
+	if config, err = LoadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: invalid configuration: %v\n", err)
+		return 1
+	}
+	if db, err = OpenDatabase(config.DatabasePath, config.DatabaseBatchConns); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	defer CloseDatabase()
+	ctx := context.Background()
+	if err := createSchema(); err == nil {
+		err = expandSchemaMigrations(ctx)
+	}
+	if err == nil {
+		err = LoadFeatureFlags()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+
+	switch action {
+	case "status":
+		statuses, err := migrationStatuses(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+		if len(statuses) == 0 {
+			fmt.Println("No schema migrations registered")
+		}
+		for _, status := range statuses {
+			fmt.Printf("%s: %s, %d rows backfilled, dual_write=%t read_new=%t", status.ID, status.Phase, status.RowsBackfilled, status.DualWrite, status.ReadNew)
+			if len(status.LaggingReplicas) > 0 {
+				fmt.Printf(", lagging replicas: %s", strings.Join(status.LaggingReplicas, ", "))
+			}
+			fmt.Println()
+		}
+	case "backfill":
+		total, err := backfillSchemaMigration(ctx, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v (after %d rows)\n", err, total)
+			return 1
+		}
+		fmt.Printf("Backfilled %s: %d rows\n", id, total)
+	case "contract":
+		if err := contractSchemaMigration(ctx, id); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Contracted %s\n", id)
+	default:
+		fmt.Fprintf(os.Stderr, "migrate: unknown action %q\n", action)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// registerTestMigration registers a migration whose backfill reports the rows batches returns,
+// one call at a time and then 0, and expands it with dual writes on
+func registerTestMigration(t *testing.T, id string, batches func() int) {
+	t.Helper()
+	previous := schemaMigrations
+	t.Cleanup(func() { schemaMigrations = previous })
+	schemaMigrations = []SchemaMigration{{
+		ID:       id,
+		Expand:   func(context.Context) error { return nil },
+		Backfill: func(context.Context, int) (int, error) { return batches(), nil },
+		Contract: func(context.Context) error { return nil },
+	}}
+	if err := expandSchemaMigrations(context.Background()); err != nil {
+		t.Fatalf("expandSchemaMigrations: %v", err)
+	}
+	if err := saveFeatureFlag(FeatureFlag{Key: "migration." + id + ".dual_write", Enabled: true, RolloutPercent: 100, Tenants: []string{}}); err != nil {
+		t.Fatalf("saveFeatureFlag: %v", err)
+	}
+}
+
+func TestClaimBackfillTakeover(t *testing.T) {
+	server := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.MigrationBackfillPause = time.Millisecond
+	})
+	registerTestMigration(t, "test_takeover", func() int { return 1 })
+	ctx := context.Background()
+
+	slow, err := claimBackfill(ctx, "test_takeover")
+	if err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+	if _, err := claimBackfill(ctx, "test_takeover"); !errors.Is(err, errBackfillRunning) {
+		t.Fatalf("second claim while the first is live = %v, want errBackfillRunning", err)
+	}
+	status, body := doRequest(t, http.DefaultClient, http.MethodPost, server.URL+"/api/admin/migrations/test_takeover/backfill", "",
+		"Authorization", "Bearer "+testAdminToken)
+	if status != http.StatusConflict {
+		t.Fatalf("POST backfill while one runs = %d %s, want 409", status, body)
+	}
+
+	// The first runner goes quiet for longer than a replica may, so the claim is up for grabs
+	stale := clock.Now().Add(-2 * config.MigrationReplicaStaleAfter).UTC().Format(sqliteTimestampLayout)
+	if _, err := db.Exec("UPDATE schema_migrations SET backfill_heartbeat_at = ? WHERE id = ?", stale, "test_takeover"); err != nil {
+		t.Fatal(err)
+	}
+	takeover, err := claimBackfill(ctx, "test_takeover")
+	if err != nil {
+		t.Fatalf("claiming a stale backfill: %v", err)
+	}
+
+	// The first runner was only slow; its next batch must not count, nor hand the phase back
+	if _, err := runBackfill(ctx, "test_takeover", slow); !errors.Is(err, errBackfillLost) {
+		t.Fatalf("runBackfill with the superseded claim = %v, want errBackfillLost", err)
+	}
+	state, err := loadMigrationState(ctx, "test_takeover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Phase != migrationBackfilling || state.RowsBackfilled != 0 {
+		t.Fatalf("after the superseded runner stopped: phase %q with %d rows, want %q with 0", state.Phase, state.RowsBackfilled, migrationBackfilling)
+	}
+
+	// The runner holding the claim finishes the job
+	remaining := 3
+	schemaMigrations[0].Backfill = func(context.Context, int) (int, error) {
+		if remaining == 0 {
+			return 0, nil
+		}
+		remaining--
+		return 1, nil
+	}
+	total, err := runBackfill(ctx, "test_takeover", takeover)
+	if err != nil || total != 3 {
+		t.Fatalf("runBackfill with the current claim = %d, %v, want 3 rows", total, err)
+	}
+	if state, _ = loadMigrationState(ctx, "test_takeover"); state.Phase != migrationBackfilled || state.RowsBackfilled != 3 {
+		t.Fatalf("after the backfill: phase %q with %d rows, want %q with 3", state.Phase, state.RowsBackfilled, migrationBackfilled)
+	}
+	if _, err := claimBackfill(ctx, "test_takeover"); !errors.Is(err, errBackfillRefused) {
+		t.Fatalf("claiming a backfilled migration = %v, want errBackfillRefused", err)
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
+import "time"
+
 // Book represents the basic book structure for the books list endpoint
 type Book struct {
-	ID     string  `json:"id"`
-	Title  string  `json:"title"`
-	Author string  `json:"author"`
-	Price  float64 `json:"price"`
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	Author   string  `json:"author"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency,omitempty"` // Only set when a regional price list applies
 }
 
 // BookDetailsResponse represents the comprehensive book details response
@@ -19,10 +22,448 @@ type BookDetailsResponse struct {
 	Duration        int64                  `json:"duration"`
 }
 
-// In-memory books data for the simple books list endpoint
-var books = []Book{
-	{ID: "1", Title: "The Go Programming Language", Author: "Alan Donovan", Price: 39.99},
-	{ID: "2", Title: "Clean Code", Author: "Robert Martin", Price: 32.50},
-	{ID: "3", Title: "System Design Interview", Author: "Alex Xu", Price: 28.95},
-	{ID: "4", Title: "Dopamine Nation", Author: "Anna Lembke", Price: 20.00},
+// BookMetadata is the typed form of a row in the books table
+type BookMetadata struct {
+	Title       string     `json:"title"`
+	Author      string     `json:"author"`
+	ISBN        *string    `json:"isbn"`                   // null when the book has no ISBN
+	PublishDate *time.Time `json:"publish_date" tz:"date"` // RFC 3339, null when unknown
+	Description *string    `json:"description"`            // null when empty
+	Language    *string    `json:"language,omitempty"`     // Translation served, absent for the original text
+
+	// Admin only
+	ModerationFlags []string   `json:"moderation_flags" visibility:"admin"` // e.g. "hidden", "needs_review"
+	CreatedAt       *time.Time `json:"created_at" visibility:"admin"`
+	UpdatedAt       *time.Time `json:"updated_at" visibility:"admin"` // null for rows older than the column
+}
+
+// BookPricing is the typed form of a row in the pricing table
+type BookPricing struct {
+	Price     float64  `json:"price"`
+	Currency  string   `json:"currency"`   // ISO 4217 code
+	Discount  float64  `json:"discount"`   // Fraction off list price, 0.10 = 10%
+	SalePrice *float64 `json:"sale_price"` // null when the book is not on sale
+	Promotion *string  `json:"promotion"`  // null when no promotion is running
+
+	// Admin only
+	CostPrice *float64   `json:"cost_price" visibility:"admin"` // What the store pays per copy, null when unknown
+	UpdatedAt *time.Time `json:"updated_at" visibility:"admin"`
+}
+
+// BookInventory is the typed form of a row in the inventory table
+type BookInventory struct {
+	InStock      bool    `json:"in_stock"`
+	Quantity     int     `json:"quantity"`
+	Warehouse    *string `json:"warehouse"`     // null when unassigned
+	ShippingTime *string `json:"shipping_time"` // Human-readable estimate, null when unknown
+
+	// Admin only
+	Supplier      *string    `json:"supplier" visibility:"admin"` // null when unassigned
+	LastRestocked *time.Time `json:"last_restocked" visibility:"admin"`
+	UpdatedAt     *time.Time `json:"updated_at" visibility:"admin"`
+}
+
+// BookReviews is the typed form of a row in the reviews table
+type BookReviews struct {
+	AverageRating   *float64        `json:"average_rating"` // null when there are no ratings
+	TotalReviews    int             `json:"total_reviews"`
+	RecentReview    *string         `json:"recent_review"` // null when there are no text reviews
+	RatingBreakdown RatingBreakdown `json:"rating_breakdown"`
+
+	// Admin only
+	UpdatedAt *time.Time `json:"updated_at" visibility:"admin"`
+}
+
+// RatingBreakdown counts ratings per star value
+type RatingBreakdown struct {
+	FiveStar  int `json:"5_star"`
+	FourStar  int `json:"4_star"`
+	ThreeStar int `json:"3_star"`
+	TwoStar   int `json:"2_star"`
+	OneStar   int `json:"1_star"`
+}
+
+// Recommendations is the typed result of a recommendation provider call
+type Recommendations struct {
+	UserID    string               `json:"user_id"`
+	BookID    string               `json:"book_id"`
+	Quote     *Quote               `json:"quote"` // null when the provider returned no usable quote
+	Items     []RecommendationItem `json:"items"`
+	APISource string               `json:"api_source"` // Host of the provider that answered
+
+	// Upstream quote payload exactly as received, kept so v1 responses stay unchanged
+	RawQuote interface{} `json:"-"`
+}
+
+// Quote is an external quote used to enrich recommendations
+type Quote struct {
+	Text   string `json:"text"`
+	Author string `json:"author"`
+}
+
+// RecommendationItem is a single recommended entry
+type RecommendationItem struct {
+	BookID string `json:"book_id"`
+	Title  string `json:"title"`
+	Source string `json:"source"` // "personalized" from reading list history, or "top_rated"
+}
+
+// BookDetailsV2Response is the typed /api/v2/books/{id}/details response. Every section has the
+// same shape: data is null when the section could not be loaded, status says why, and
+// source/fetched_at say where the data came from and when it was read.
+type BookDetailsV2Response struct {
+	BookID          string                         `json:"book_id"`
+	Status          string                         `json:"status"` // "ok", "partial" (HTTP 207) or "not_found" (HTTP 404)
+	Mode            string                         `json:"mode"`   // Coordination strategy that served the request
+	Metadata        DetailSection[BookMetadata]    `json:"metadata"`
+	Pricing         DetailSection[BookPricing]     `json:"pricing"`
+	Inventory       DetailSection[BookInventory]   `json:"inventory"`
+	Reviews         DetailSection[BookReviews]     `json:"reviews"`
+	Recommendations DetailSection[Recommendations] `json:"recommendations"`
+	Display         *BookDisplay                   `json:"display,omitempty"` // Only with ?locale= or a supported Accept-Language
+	DurationMs      int64                          `json:"duration_ms"`
+	Meta            DetailMeta                     `json:"meta"`
+}
+
+// DetailSection wraps one section of a v2 details response
+type DetailSection[T any] struct {
+	Status    string     `json:"status"`     // "ok", "not_found", "timeout" or "degraded" (stale or failed)
+	Data      *T         `json:"data"`       // null when the section failed to load
+	Source    string     `json:"source"`     // "database", "cache", "stale_cache" or the provider's API host
+	FetchedAt *time.Time `json:"fetched_at"` // When the data was read from its source; null when it wasn't
+}
+
+// FeatureFlag represents a runtime toggle that can be rolled out per tenant or by percentage
+type FeatureFlag struct {
+	Key            string    `json:"key"`
+	Description    string    `json:"description"`
+	Enabled        bool      `json:"enabled"`
+	RolloutPercent int       `json:"rollout_percent"` // 0-100, share of subjects that see the flag
+	Tenants        []string  `json:"tenants"`         // Tenants that always see the flag when enabled
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Storefront is one tenant's branding and defaults, managed by admins and read by the front end
+// at boot. Features lists the feature flag keys the front end asks about.
+type Storefront struct {
+	TenantID      string    `json:"tenant_id"`
+	Name          string    `json:"name"`
+	Currency      string    `json:"currency"`       // ISO 4217 code prices are shown in
+	DefaultLocale string    `json:"default_locale"` // e.g. "en-US"
+	Locales       []string  `json:"locales"`        // Locales the store offers, default included
+	LogoURL       string    `json:"logo_url"`
+	PrimaryColor  string    `json:"primary_color"` // CSS hex color, e.g. "#1a73e8"
+	Features      []string  `json:"features"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// StorefrontConfig is the body of GET /api/storefront: the tenant's storefront with each of its
+// feature flags evaluated for the requesting tenant and user
+type StorefrontConfig struct {
+	TenantID      string          `json:"tenant_id"`
+	Name          string          `json:"name"`
+	Currency      string          `json:"currency"`
+	DefaultLocale string          `json:"default_locale"`
+	Locales       []string        `json:"locales"`
+	LogoURL       string          `json:"logo_url"`
+	PrimaryColor  string          `json:"primary_color"`
+	Features      map[string]bool `json:"features"`
+}
+
+// PriceExperiment is an A/B price test: users who view one of its books are split between
+// variants by a stable hash of experiment key and user ID, and see that variant's price
+type PriceExperiment struct {
+	Key         string         `json:"key"`
+	Description string         `json:"description"`
+	Enabled     bool           `json:"enabled"`
+	BookIDs     []string       `json:"book_ids"`
+	Variants    []PriceVariant `json:"variants"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// PriceVariant is one arm of a price experiment
+type PriceVariant struct {
+	Name        string  `json:"name"`
+	PriceFactor float64 `json:"price_factor"` // List and sale prices are multiplied by this; 1 is the control
+	Weight      int     `json:"weight"`       // Percent of users assigned; weights add up to 100
+}
+
+// RegionalPrice is a book's price list in one country, replacing its regular pricing there
+type RegionalPrice struct {
+	Country   string   `json:"country"` // ISO 3166-1 alpha-2, e.g. "DE"
+	Price     float64  `json:"price"`
+	SalePrice *float64 `json:"sale_price"` // null when the book is not on sale in this country
+	Currency  string   `json:"currency"`   // ISO 4217 code
+}
+
+// BookRegions is the body of /api/admin/books/{id}/regions
+type BookRegions struct {
+	BookID     string          `json:"book_id"`
+	Prices     []RegionalPrice `json:"prices"`
+	Restricted []string        `json:"restricted"` // Countries the book may not be sold in
+}
+
+// ReadingList is a user's named list of books; Kind is "reading" or "wishlist"
+type ReadingList struct {
+	ID         int64             `json:"id"`
+	UserID     string            `json:"user_id,omitempty"` // Omitted from the public shared view
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	ShareToken string            `json:"share_token,omitempty"` // Set while the list is shared; omitted from the public view
+	Items      []ReadingListItem `json:"items"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// ReadingListItem is one book on a reading list
+type ReadingListItem struct {
+	BookID  string     `json:"book_id"`
+	Title   string     `json:"title"`
+	Author  string     `json:"author"`
+	AddedAt time.Time  `json:"added_at"`
+	ReadAt  *time.Time `json:"read_at,omitempty"` // Set once the user marks the book as read
+}
+
+// Supplier is a distributor or publisher the store orders stock from
+type Supplier struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	ContactEmail *string   `json:"contact_email"`  // null when unknown
+	LeadTimeDays int       `json:"lead_time_days"` // Default wait between ordering and arrival
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PurchaseOrder is an order of copies of one book from a supplier
+type PurchaseOrder struct {
+	ID               int64      `json:"id"`
+	SupplierID       int64      `json:"supplier_id"`
+	SupplierName     string     `json:"supplier_name"`
+	BookID           string     `json:"book_id"`
+	Title            string     `json:"title"`
+	QuantityOrdered  int        `json:"quantity_ordered"`
+	QuantityReceived int        `json:"quantity_received"`
+	UnitCost         *float64   `json:"unit_cost"`                  // null when unknown
+	Status           string     `json:"status"`                     // "open", "partially_received", "received" or "cancelled"
+	ExpectedArrival  time.Time  `json:"expected_arrival" tz:"date"` // Calendar date the delivery is due
+	Overdue          bool       `json:"overdue"`                    // Still outstanding after the expected arrival date
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	ReceivedAt       *time.Time `json:"received_at"` // Set once every copy has arrived
+}
+
+// RecentlyViewedBook is one entry of a user's recently viewed books
+type RecentlyViewedBook struct {
+	BookID   string    `json:"book_id"`
+	Title    string    `json:"title"`
+	Author   string    `json:"author"`
+	ViewedAt time.Time `json:"viewed_at"` // Latest view; repeat views move the book up rather than repeating it
+}
+
+// Impersonation is a time-limited session in which an admin acts as a user for support
+// debugging. The token itself is only returned once, in an ImpersonationGrant.
+type Impersonation struct {
+	ID        string     `json:"id"`
+	AdminID   string     `json:"admin_id"`
+	UserID    string     `json:"user_id"`
+	Reason    string     `json:"reason"`
+	Scope     string     `json:"scope"` // "read" allows GET and HEAD only; "write" allows changes too
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ImpersonationGrant is the response to starting an impersonation
+type ImpersonationGrant struct {
+	Impersonation
+	Token string `json:"token"` // Send as "Authorization: Bearer <token>"
+}
+
+// PersonalAccessToken is a long-lived token a user minted for scripts and integrations. It acts
+// as the user, but only within its scopes. The token itself is only returned once, in an
+// AccessTokenGrant.
+type PersonalAccessToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"` // e.g. "catalog:read", "reviews:write"
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"` // To the minute
+	ExpiresAt  *time.Time `json:"expires_at"`   // Null for tokens that last until revoked
+}
+
+// AccessTokenGrant is the response to minting a personal access token
+type AccessTokenGrant struct {
+	PersonalAccessToken
+	Token string `json:"token"` // Send as "Authorization: Bearer <token>"
+}
+
+// ExportArchive is a snapshot of the catalog export kept as a file, so a large download can be
+// resumed with a Range request against bytes that don't change underneath it
+type ExportArchive struct {
+	ID        string    `json:"id"`
+	Format    string    `json:"format"` // "json" or "ndjson"
+	Books     int       `json:"books"`
+	Size      int64     `json:"size"`   // Bytes
+	SHA256    string    `json:"sha256"` // Hex; also the download's ETag
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	URL       string    `json:"url"`
+}
+
+// SignedURL is a temporary link to an admin resource that works without the admin token
+type SignedURL struct {
+	URL       string    `json:"url"` // Path and query, to be put behind the service's own origin
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	ID              int64     `json:"id"`
+	OccurredAt      time.Time `json:"occurred_at"`
+	Actor           string    `json:"actor"`   // Who did it; the admin for impersonated requests
+	Action          string    `json:"action"`  // "METHOD /path" for requests, else a dotted event name
+	UserID          string    `json:"user_id"` // Whose data was acted on
+	Impersonated    bool      `json:"impersonated"`
+	ImpersonationID string    `json:"impersonation_id,omitempty"`
+	Status          int       `json:"status"`
+	RequestID       string    `json:"request_id,omitempty"`
+	Detail          string    `json:"detail,omitempty"`
+}
+
+// SLOStatus is one route's standing against its SLO over the tracked window
+type SLOStatus struct {
+	Route           string             `json:"route"`
+	Latency         string             `json:"latency"`   // Requests slower than this count against the SLO
+	Objective       float64            `json:"objective"` // Share of requests that must be good, e.g. 0.995
+	Window          string             `json:"window"`
+	Requests        int64              `json:"requests"`
+	Good            int64              `json:"good"`
+	Compliance      float64            `json:"compliance"`
+	BudgetRemaining float64            `json:"budget_remaining"` // 1 untouched, 0 spent, negative overspent
+	BurnRates       map[string]float64 `json:"burn_rates"`       // By window, e.g. "1h0m0s"
+	Alert           string             `json:"alert,omitempty"`  // Severity firing now
+}
+
+// SLOAlert is a burn rate alert firing or resolving
+type SLOAlert struct {
+	Route    string    `json:"route"`
+	Severity string    `json:"severity"` // "page" for a fast burn, "ticket" for a slow one
+	Resolved bool      `json:"resolved"`
+	BurnRate float64   `json:"burn_rate,omitempty"`
+	Message  string    `json:"message"`
+	At       time.Time `json:"at"`
+}
+
+// SLOReport is the response of GET /api/admin/slos
+type SLOReport struct {
+	Routes []SLOStatus `json:"routes"`
+	Alerts []SLOAlert  `json:"alerts"` // Most recent last
+}
+
+// ErrorEvent is an error report, shaped like a Sentry store API event so any compatible
+// ingestion endpoint accepts it
+type ErrorEvent struct {
+	EventID   string                `json:"event_id"` // 32 hex digits
+	Timestamp time.Time             `json:"timestamp"`
+	Level     string                `json:"level"`  // "error", or "fatal" for panics
+	Logger    string                `json:"logger"` // "http" or "job"
+	Platform  string                `json:"platform"`
+	Message   string                `json:"message"`
+	Exception *ErrorEventExceptions `json:"exception,omitempty"`
+	Request   *ErrorEventRequest    `json:"request,omitempty"`
+	Tags      map[string]string     `json:"tags,omitempty"`
+	Extra     map[string]string     `json:"extra,omitempty"`
+}
+
+// ErrorEventExceptions wraps the exceptions of an event, innermost last
+type ErrorEventExceptions struct {
+	Values []ErrorEventException `json:"values"`
+}
+
+// ErrorEventException is the type and text of one error or panic value
+type ErrorEventException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ErrorEventRequest is the request an event happened in, after scrubbing
+type ErrorEventRequest struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// LogSampling is one logger's sampling settings and what they have held back
+type LogSampling struct {
+	Logger       string `json:"logger"`
+	Every        int    `json:"every"`          // One request's lines kept in every
+	MaxPerSecond int    `json:"max_per_second"` // 0 for no limit
+	SampledOut   int64  `json:"sampled_out"`
+	RateLimited  int64  `json:"rate_limited"`
+}
+
+// GoroutineDiff compares the goroutines running now with a baseline snapshot
+type GoroutineDiff struct {
+	BaselineAt    time.Time            `json:"baseline_at"`
+	BaselineTotal int                  `json:"baseline_total"`
+	TakenAt       time.Time            `json:"taken_at"`
+	Total         int                  `json:"total"`
+	InFlight      int64                `json:"in_flight"` // Requests being served, for scale
+	Grown         []GoroutineStackDiff `json:"grown"`     // Most grown first
+}
+
+// GoroutineStackDiff is one stack with more goroutines than at the baseline
+type GoroutineStackDiff struct {
+	Before int    `json:"before"`
+	Now    int    `json:"now"`
+	Delta  int    `json:"delta"`
+	Stack  string `json:"stack"` // One "function+offset file:line" frame per line, innermost first
+}
+
+// IntegrityReport is the outcome of the database integrity checks that decide readiness
+type IntegrityReport struct {
+	CheckedAt time.Time        `json:"checked_at"`
+	Ready     bool             `json:"ready"`
+	Checks    []IntegrityCheck `json:"checks"`
+}
+
+// IntegrityCheck is one check of an IntegrityReport
+type IntegrityCheck struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Detail     string `json:"detail"` // What was found, or what is wrong
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// MigrationStatus is an expand/contract schema migration's progress
+type MigrationStatus struct {
+	ID              string     `json:"id"`
+	Description     string     `json:"description"`
+	Phase           string     `json:"phase"` // "expanded", "backfilling", "backfilled" or "contracted"
+	RowsBackfilled  int64      `json:"rows_backfilled"`
+	DualWrite       bool       `json:"dual_write"` // migration.{id}.dual_write is on
+	ReadNew         bool       `json:"read_new"`   // migration.{id}.read_new is on
+	LaggingReplicas []string   `json:"lagging_replicas,omitempty"`
+	ExpandedAt      *time.Time `json:"expanded_at,omitempty"`
+	BackfilledAt    *time.Time `json:"backfilled_at,omitempty"`
+	ContractedAt    *time.Time `json:"contracted_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+// EditLock is a staff member's claim to edit a book, held until ExpiresAt unless heartbeats
+// push it on
+type EditLock struct {
+	BookID     string    `json:"book_id"`
+	Holder     string    `json:"holder"` // The logged-in user who took it
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// CacheVersion is the component every cache key and validator carries. SchemaHash changes with
+// the shape of cached responses; Generation changes when an admin flushes caches.
+type CacheVersion struct {
+	SchemaHash string    `json:"schema_hash"`
+	Generation int64     `json:"generation"`
+	ChangedAt  time.Time `json:"changed_at"` // When the generation last moved
 }
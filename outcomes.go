@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Request outcomes. Telling them apart matters for tuning timeouts: a client that hung up says
+// nothing about our deadlines, a server timeout says one of them fired, and an error is a bug or
+// a broken dependency either way.
+const (
+	outcomeOK             = "ok"              // Answered, 4xx included
+	outcomeClientCanceled = "client_canceled" // The client went away before the answer was done
+	outcomeServerTimeout  = "server_timeout"  // A deadline of ours fired, or the status says so
+	outcomeError          = "error"           // Any other 5xx
+)
+
+// Upper bounds of the latency histogram buckets, in milliseconds; slower requests land in +Inf
+var latencyBucketsMs = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// latencyHistogram counts durations into latencyBucketsMs. It is an expvar.Var, shown as
+// {"count": n, "sum_ms": n, "buckets": {"le_5": n, ..., "le_+Inf": n}} with cumulative buckets.
+type latencyHistogram struct {
+	mu      sync.Mutex
+	counts  []int64 // One per bucket, plus +Inf
+	count   int64
+	totalMs int64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]int64, len(latencyBucketsMs)+1)}
+}
+
+// Observe counts one duration
+func (h *latencyHistogram) Observe(duration time.Duration) {
+	ms := duration.Milliseconds()
+	bucket := len(latencyBucketsMs)
+	for i, bound := range latencyBucketsMs {
+		if ms <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.mu.Lock()
+	h.counts[bucket]++
+	h.count++
+	h.totalMs += ms
+	h.mu.Unlock()
+}
+
+// String implements expvar.Var
+func (h *latencyHistogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var buckets []string
+	cumulative := int64(0)
+	for i, count := range h.counts {
+		cumulative += count
+		bound := "+Inf"
+		if i < len(latencyBucketsMs) {
+			bound = fmt.Sprint(latencyBucketsMs[i])
+		}
+		buckets = append(buckets, fmt.Sprintf(`"le_%s": %d`, bound, cumulative))
+	}
+	return fmt.Sprintf(`{"count": %d, "sum_ms": %d, "buckets": {%s}}`, h.count, h.totalMs, strings.Join(buckets, ", "))
+}
+
+var (
+	requestsByOutcome       = expvar.NewMap("requests_by_outcome")
+	requestLatencyByOutcome = expvar.NewMap("request_latency_ms_by_outcome")
+)
+
+func init() {
+	for _, outcome := range []string{outcomeOK, outcomeClientCanceled, outcomeServerTimeout, outcomeError} {
+		requestLatencyByOutcome.Set(outcome, newLatencyHistogram())
+	}
+}
+
+// requestOutcomeKey holds a request's *atomic.Bool, set when one of its deadlines fires
+type requestOutcomeKey struct{}
+
+// noteServerTimeout records that a deadline we set fired while serving the request ctx belongs
+// to. Sections call it, so a 200 with a timed-out section still counts as a server timeout.
+func noteServerTimeout(ctx context.Context) {
+	if timedOut, ok := ctx.Value(requestOutcomeKey{}).(*atomic.Bool); ok {
+		timedOut.Store(true)
+	}
+}
+
+// classifyOutcome decides how a finished request went
+func classifyOutcome(r *http.Request, status int, timedOut bool) string {
+	switch {
+	case errors.Is(r.Context().Err(), context.Canceled):
+		return outcomeClientCanceled
+	case timedOut, status == http.StatusGatewayTimeout:
+		return outcomeServerTimeout
+	case status >= http.StatusInternalServerError:
+		return outcomeError
+	}
+	return outcomeOK
+}
+
+// outcomeMiddleware counts every request by outcome, with its latency, and logs the ones that
+// did not end well
+func outcomeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		timedOut := &atomic.Bool{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestOutcomeKey{}, timedOut)))
+		duration := time.Since(startTime)
+
+		outcome := classifyOutcome(r, recorder.status, timedOut.Load())
+		requestsByOutcome.Add(outcome, 1)
+		requestLatencyByOutcome.Get(outcome).(*latencyHistogram).Observe(duration)
+
+		requestID := RequestIDFromContext(r.Context())
+		switch outcome {
+		case outcomeClientCanceled:
+			log.Printf("Request %s %s (request %s) cancelled by the client after %v", r.Method, r.URL.Path, requestID, duration)
+		case outcomeServerTimeout:
+			log.Printf("Warning: %s %s (request %s) hit a server timeout after %v (status %d)", r.Method, r.URL.Path, requestID, duration, recorder.status)
+		case outcomeError:
+			log.Printf("Error serving %s %s (request %s): failed after %v (status %d)", r.Method, r.URL.Path, requestID, duration, recorder.status)
+		}
+	})
+}
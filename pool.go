@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"log"
+	"time"
+)
+
+// PoolStat is a point-in-time view of one connection pool's pressure, from sql.DBStats
+type PoolStat struct {
+	Name           string  `json:"name"`
+	MaxOpen        int     `json:"max_open"` // Current limit, moved by the autosizer when it is on
+	Open           int     `json:"open"`
+	InUse          int     `json:"in_use"`
+	Idle           int     `json:"idle"`
+	WaitCount      int64   `json:"wait_count"`    // Queries that had to wait for a connection, ever
+	WaitMsTotal    float64 `json:"wait_ms_total"` // Time they spent waiting
+	MaxIdleClosed  int64   `json:"max_idle_closed"`
+	LifetimeClosed int64   `json:"max_lifetime_closed"`
+}
+
+func init() {
+	expvar.Publish("database_pools", expvar.Func(func() interface{} {
+		return PoolStats()
+	}))
+}
+
+// PoolStats returns the request pool's stats and, when batch work has its own, the batch pool's
+func PoolStats() []PoolStat {
+	stats := []PoolStat{}
+	if db != nil {
+		stats = append(stats, newPoolStat("request", db.Stats()))
+	}
+	if batchDB != nil {
+		stats = append(stats, newPoolStat("batch", batchDB.Stats()))
+	}
+	return stats
+}
+
+func newPoolStat(name string, stats sql.DBStats) PoolStat {
+	return PoolStat{
+		Name:           name,
+		MaxOpen:        stats.MaxOpenConnections,
+		Open:           stats.OpenConnections,
+		InUse:          stats.InUse,
+		Idle:           stats.Idle,
+		WaitCount:      stats.WaitCount,
+		WaitMsTotal:    milliseconds(stats.WaitDuration),
+		MaxIdleClosed:  stats.MaxIdleClosed,
+		LifetimeClosed: stats.MaxLifetimeClosed,
+	}
+}
+
+// poolAutosizer moves a pool's connection limit between bounds based on how long queries wait
+// for a connection. A fixed limit is wrong both for small deployments, which hold idle
+// connections they never use, and for large ones, whose requests queue for the pool.
+type poolAutosizer struct {
+	database   *sql.DB
+	min, max   int
+	targetWait time.Duration // Average wait per waiting query above which the pool grows
+
+	size      int
+	lastStats sql.DBStats
+}
+
+// StartPoolAutosizer adjusts the pool every interval until ctx is done
+func StartPoolAutosizer(ctx context.Context, database *sql.DB, cfg Config) {
+	sizer := &poolAutosizer{
+		database:   database,
+		min:        cfg.DatabaseAutosizeMin,
+		max:        cfg.DatabaseAutosizeMax,
+		targetWait: cfg.DatabaseAutosizeTargetWait,
+		size:       database.Stats().MaxOpenConnections,
+		lastStats:  database.Stats(),
+	}
+	sizer.resize(min(max(sizer.size, sizer.min), sizer.max))
+
+	go func() {
+		ticker := time.NewTicker(cfg.DatabaseAutosizeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sizer.adjust()
+			}
+		}
+	}()
+}
+
+// adjust compares the waits since the last tick with the target. Growth is a quarter of the
+// current size so a starved pool catches up in a few ticks; shrinking is one connection at a
+// time and only when nothing waited and at most half the limit is in use.
+func (s *poolAutosizer) adjust() {
+	stats := s.database.Stats()
+	waits := stats.WaitCount - s.lastStats.WaitCount
+	waited := stats.WaitDuration - s.lastStats.WaitDuration
+	s.lastStats = stats
+
+	switch {
+	case waits > 0 && waited/time.Duration(waits) > s.targetWait:
+		s.resize(min(s.size+max(s.size/4, 1), s.max))
+	case waits == 0 && stats.InUse*2 <= s.size:
+		s.resize(max(s.size-1, s.min))
+	}
+}
+
+// resize sets the pool limit, logging changes
+func (s *poolAutosizer) resize(size int) {
+	if size == s.size {
+		return
+	}
+	log.Printf("Database pool limit %d -> %d", s.size, size)
+	s.size = size
+	s.database.SetMaxOpenConns(size)
+	s.database.SetMaxIdleConns(size)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Processing states a book can be in for one enrichment pipeline
+const (
+	processingPending   = "pending"
+	processingSucceeded = "succeeded"
+	processingFailed    = "failed"
+)
+
+// Most books one reprocess request may queue when it names them explicitly
+const maxReprocessBookIDs = 1000
+
+// Wakes the embedding pipeline when books are queued for reprocessing. Buffered so queuing
+// never blocks; several requests before the next run collapse into one run.
+var embeddingRefreshWake = make(chan struct{}, 1)
+
+// BookProcessingState is where one book stands in one enrichment pipeline
+type BookProcessingState struct {
+	BookID    string    `json:"book_id"`
+	Pipeline  string    `json:"pipeline"` // e.g. "embeddings:hashing"
+	Status    string    `json:"status"`   // "pending", "succeeded" or "failed"
+	Failures  int       `json:"failures"` // Failed attempts since the last success
+	LastError *string   `json:"last_error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProcessingResponse is the body of GET /api/admin/processing
+type ProcessingResponse struct {
+	Pipeline string                `json:"pipeline"`
+	Counts   map[string]int        `json:"counts"` // Per status, plus "unprocessed" for books the pipeline hasn't reached
+	States   []BookProcessingState `json:"states"` // Most recently updated first
+}
+
+// ReprocessRequest is the body of POST /api/admin/processing/reprocess. Either list books or
+// filter by status; an empty body is rejected rather than reprocessing the whole catalog.
+type ReprocessRequest struct {
+	Pipeline string   `json:"pipeline"` // Defaults to the active embedding pipeline
+	BookIDs  []string `json:"book_ids"`
+	Status   string   `json:"status"` // e.g. "failed"
+}
+
+// ReprocessResponse reports what was queued
+type ReprocessResponse struct {
+	Pipeline string `json:"pipeline"`
+	Queued   int    `json:"queued"`
+}
+
+// validProcessingStatus reports whether status is one of the processing states
+func validProcessingStatus(status string) bool {
+	return status == processingPending || status == processingSucceeded || status == processingFailed
+}
+
+// recordProcessingResult stores the outcome of processing a book; a nil err is a success
+func recordProcessingResult(ctx context.Context, bookID, pipeline string, err error) error {
+	if err == nil {
+		_, err := dbFor(ctx).ExecContext(ctx, `
+			INSERT INTO book_processing (book_id, pipeline, status, failures, last_error, updated_at)
+			VALUES (?, ?, ?, 0, NULL, ?)
+			ON CONFLICT(book_id, pipeline) DO UPDATE SET
+				status = excluded.status,
+				failures = 0,
+				last_error = NULL,
+				updated_at = excluded.updated_at
+		`, bookID, pipeline, processingSucceeded, dbNow())
+		return err
+	}
+
+	_, dbErr := dbFor(ctx).ExecContext(ctx, `
+		INSERT INTO book_processing (book_id, pipeline, status, failures, last_error, updated_at)
+		VALUES (?, ?, ?, 1, ?, ?)
+		ON CONFLICT(book_id, pipeline) DO UPDATE SET
+			status = excluded.status,
+			failures = book_processing.failures + 1,
+			last_error = excluded.last_error,
+			updated_at = excluded.updated_at
+	`, bookID, pipeline, processingFailed, err.Error(), dbNow())
+	return dbErr
+}
+
+// listProcessingStates returns the states for a pipeline, optionally narrowed to one book or
+// status; an empty pipeline matches every pipeline
+func listProcessingStates(ctx context.Context, pipeline, bookID, status string, limit int) ([]BookProcessingState, error) {
+	query := "SELECT book_id, pipeline, status, failures, last_error, updated_at FROM book_processing WHERE 1 = 1"
+	var args []interface{}
+	if pipeline != "" {
+		query += " AND pipeline = ?"
+		args = append(args, pipeline)
+	}
+	if bookID != "" {
+		query += " AND book_id = ?"
+		args = append(args, bookID)
+	}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY updated_at DESC, book_id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []BookProcessingState{}
+	for rows.Next() {
+		var state BookProcessingState
+		var lastError sql.NullString
+		if err := rows.Scan(&state.BookID, &state.Pipeline, &state.Status, &state.Failures, &lastError, &state.UpdatedAt); err != nil {
+			return nil, err
+		}
+		state.LastError = nullStringPtr(lastError)
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// countProcessingStates counts a pipeline's books per status, including books with no state yet
+func countProcessingStates(ctx context.Context, pipeline string) (map[string]int, error) {
+	counts := map[string]int{processingPending: 0, processingSucceeded: 0, processingFailed: 0}
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(p.status, 'unprocessed'), COUNT(*)
+		FROM books b
+		LEFT JOIN book_processing p ON p.book_id = b.id AND p.pipeline = ?
+		GROUP BY 1
+	`, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// queueReprocessing marks books pending for the embedding pipeline and clears their content
+// hashes, so the next run embeds them again even though their text hasn't changed. Existing
+// vectors stay in place and keep serving similar-book queries until they are replaced.
+// Books are either listed or selected by their current status.
+func queueReprocessing(ctx context.Context, provider EmbeddingProvider, bookIDs []string, status string) (int, error) {
+	pipeline := embeddingPipeline(provider)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if len(bookIDs) == 0 {
+		rows, err := tx.QueryContext(ctx, "SELECT book_id FROM book_processing WHERE pipeline = ? AND status = ?", pipeline, status)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var bookID string
+			if err := rows.Scan(&bookID); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			bookIDs = append(bookIDs, bookID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	now := dbNow()
+	for _, bookID := range bookIDs {
+		var exists int
+		if err := tx.QueryRowContext(ctx, "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, errBookNotFound
+			}
+			return 0, err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE book_embeddings SET content_hash = '' WHERE book_id = ? AND provider = ?", bookID, provider.Name()); err != nil {
+			return 0, err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO book_processing (book_id, pipeline, status, failures, updated_at)
+			VALUES (?, ?, ?, 0, ?)
+			ON CONFLICT(book_id, pipeline) DO UPDATE SET
+				status = excluded.status,
+				updated_at = excluded.updated_at
+		`, bookID, pipeline, processingPending, now)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if len(bookIDs) > 0 {
+		requestEmbeddingRefresh()
+	}
+	return len(bookIDs), nil
+}
+
+// requestEmbeddingRefresh asks the embedding pipeline to run now rather than at its next tick
+func requestEmbeddingRefresh() {
+	select {
+	case embeddingRefreshWake <- struct{}{}:
+	default: // A run is already requested
+	}
+}
+
+// ProcessingHandler handles GET /api/admin/processing?pipeline=&status=&limit=
+func ProcessingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	pipeline := query.Get("pipeline")
+	if pipeline == "" {
+		pipeline = embeddingPipeline(embeddingProvider)
+	}
+	status := query.Get("status")
+	if status != "" && !validProcessingStatus(status) {
+		writeError(w, r, http.StatusBadRequest, "status must be 'pending', 'succeeded' or 'failed'")
+		return
+	}
+	limit := 100
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			writeError(w, r, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = parsed
+	}
+
+	counts, err := countProcessingStates(r.Context(), pipeline)
+	if err != nil {
+		log.Printf("Error counting processing states for %s: %v", pipeline, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load processing state")
+		return
+	}
+	states, err := listProcessingStates(r.Context(), pipeline, "", status, limit)
+	if err != nil {
+		log.Printf("Error listing processing states for %s: %v", pipeline, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load processing state")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, ProcessingResponse{Pipeline: pipeline, Counts: counts, States: states})
+}
+
+// ReprocessHandler handles POST /api/admin/processing/reprocess
+func ReprocessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body ReprocessRequest
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if (len(body.BookIDs) == 0) == (body.Status == "") {
+		writeError(w, r, http.StatusBadRequest, "Give either book_ids or status")
+		return
+	}
+	if body.Status != "" && !validProcessingStatus(body.Status) {
+		writeError(w, r, http.StatusBadRequest, "status must be 'pending', 'succeeded' or 'failed'")
+		return
+	}
+	if len(body.BookIDs) > maxReprocessBookIDs {
+		writeError(w, r, http.StatusBadRequest, "At most 1000 book_ids per request")
+		return
+	}
+	reprocessBooks(w, r, body.Pipeline, body.BookIDs, body.Status)
+}
+
+// BookProcessingHandler handles a single book's processing state:
+//
+//	GET    /api/admin/books/{id}/processing
+//	POST   /api/admin/books/{id}/processing/reprocess
+func BookProcessingHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	pathParts := strings.Split(r.URL.Path, "/") // {"", "api", "admin", "books", "1", "processing", "reprocess"}
+	switch {
+	case len(pathParts) == 6 && r.Method == http.MethodGet:
+		var exists int
+		err := db.QueryRowContext(r.Context(), "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusNotFound, "Book not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading book %s: %v", bookID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to load processing state")
+			return
+		}
+
+		states, err := listProcessingStates(r.Context(), "", bookID, "", maxReprocessBookIDs)
+		if err != nil {
+			log.Printf("Error listing processing states for book %s: %v", bookID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to load processing state")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, states)
+
+	case len(pathParts) == 7 && pathParts[6] == "reprocess" && r.Method == http.MethodPost:
+		reprocessBooks(w, r, r.URL.Query().Get("pipeline"), []string{bookID}, "")
+
+	case len(pathParts) == 6 || (len(pathParts) == 7 && pathParts[6] == "reprocess"):
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+
+	default:
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/admin/books/{id}/processing[/reprocess]")
+	}
+}
+
+// reprocessBooks queues books for a pipeline and answers 202 with how many were queued. Only
+// the running embedding pipeline can be re-triggered; states of other pipelines (such as a
+// previously configured provider) are kept for reference.
+func reprocessBooks(w http.ResponseWriter, r *http.Request, pipeline string, bookIDs []string, status string) {
+	active := embeddingPipeline(embeddingProvider)
+	if pipeline != "" && pipeline != active {
+		writeError(w, r, http.StatusBadRequest, "Only the active pipeline '"+active+"' can be reprocessed")
+		return
+	}
+
+	queued, err := queueReprocessing(r.Context(), embeddingProvider, bookIDs, status)
+	if errors.Is(err, errBookNotFound) {
+		writeError(w, r, http.StatusNotFound, "Book not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error queuing books for %s: %v", active, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to queue reprocessing")
+		return
+	}
+	log.Printf("Queued %d books for %s", queued, active)
+	writeJSON(w, r, http.StatusAccepted, ReprocessResponse{Pipeline: active, Queued: queued})
+}
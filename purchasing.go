@@ -0,0 +1,657 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Purchase order statuses
+const (
+	poOpen              = "open"
+	poPartiallyReceived = "partially_received"
+	poReceived          = "received"
+	poCancelled         = "cancelled"
+)
+
+// Defaults for POST /api/admin/purchase-orders/low-stock
+const (
+	defaultLowStockThreshold = 5
+	defaultReorderQuantity   = 20
+)
+
+// Purchasing errors surfaced to handlers
+var (
+	errSupplierExists      = errors.New("a supplier with this name already exists")
+	errSupplierNotFound    = errors.New("supplier not found")
+	errNoSupplier          = errors.New("book has no supplier")
+	errPurchaseOrderClosed = errors.New("purchase order is no longer open")
+	errOverReceipt         = errors.New("more copies than are outstanding")
+)
+
+// listSuppliers returns every supplier by name
+func listSuppliers(ctx context.Context) ([]Supplier, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, name, contact_email, lead_time_days, created_at FROM suppliers ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppliers := []Supplier{}
+	for rows.Next() {
+		var supplier Supplier
+		var email sql.NullString
+		if err := rows.Scan(&supplier.ID, &supplier.Name, &email, &supplier.LeadTimeDays, &supplier.CreatedAt); err != nil {
+			return nil, err
+		}
+		supplier.ContactEmail = nullStringPtr(email)
+		suppliers = append(suppliers, supplier)
+	}
+	return suppliers, rows.Err()
+}
+
+// createSupplier adds a supplier
+func createSupplier(ctx context.Context, name string, email *string, leadTimeDays int) (Supplier, error) {
+	now := dbNow()
+	result, err := db.ExecContext(ctx, "INSERT INTO suppliers (name, contact_email, lead_time_days, created_at) VALUES (?, ?, ?, ?)",
+		name, email, leadTimeDays, now)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return Supplier{}, errSupplierExists
+	}
+	if err != nil {
+		return Supplier{}, err
+	}
+
+	supplier := Supplier{Name: name, ContactEmail: email, LeadTimeDays: leadTimeDays}
+	supplier.ID, err = result.LastInsertId()
+	if err != nil {
+		return supplier, err
+	}
+	supplier.CreatedAt, err = parseSQLiteTimestamp(now)
+	return supplier, err
+}
+
+// purchaseOrderFilter narrows listPurchaseOrders; empty fields match everything
+type purchaseOrderFilter struct {
+	BookID      string
+	Status      string // One status, or "outstanding" for open and partially received orders
+	OverdueOnly bool
+}
+
+// Columns and joins shared by purchase order reads
+const purchaseOrderSelect = `
+	SELECT po.id, po.supplier_id, s.name, po.book_id, b.title, po.quantity_ordered, po.quantity_received,
+		po.unit_cost, po.status, po.expected_arrival, po.created_at, po.updated_at, po.received_at
+	FROM purchase_orders po
+	JOIN suppliers s ON s.id = po.supplier_id
+	JOIN books b ON b.id = po.book_id
+`
+
+// scanPurchaseOrder reads one row of purchaseOrderSelect
+func scanPurchaseOrder(scanner interface{ Scan(...interface{}) error }) (PurchaseOrder, error) {
+	var order PurchaseOrder
+	var unitCost sql.NullFloat64
+	var receivedAt sql.NullTime
+	err := scanner.Scan(&order.ID, &order.SupplierID, &order.SupplierName, &order.BookID, &order.Title,
+		&order.QuantityOrdered, &order.QuantityReceived, &unitCost, &order.Status, &order.ExpectedArrival,
+		&order.CreatedAt, &order.UpdatedAt, &receivedAt)
+	if err != nil {
+		return order, err
+	}
+	if unitCost.Valid {
+		order.UnitCost = &unitCost.Float64
+	}
+	order.ReceivedAt = nullTimePtr(receivedAt)
+	order.Overdue = purchaseOrderOutstanding(order.Status) && order.ExpectedArrival.Before(today())
+	return order, nil
+}
+
+// purchaseOrderOutstanding reports whether copies are still expected on an order
+func purchaseOrderOutstanding(status string) bool {
+	return status == poOpen || status == poPartiallyReceived
+}
+
+// today is the current UTC calendar date at midnight
+func today() time.Time {
+	now := clock.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// listPurchaseOrders returns matching orders, soonest expected first
+func listPurchaseOrders(ctx context.Context, filter purchaseOrderFilter) ([]PurchaseOrder, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.BookID != "" {
+		conditions = append(conditions, "po.book_id = ?")
+		args = append(args, filter.BookID)
+	}
+	switch {
+	case filter.Status == "outstanding" || filter.OverdueOnly:
+		conditions = append(conditions, "po.status IN (?, ?)")
+		args = append(args, poOpen, poPartiallyReceived)
+	case filter.Status != "":
+		conditions = append(conditions, "po.status = ?")
+		args = append(args, filter.Status)
+	}
+	query := purchaseOrderSelect
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY po.expected_arrival, po.id"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []PurchaseOrder{}
+	for rows.Next() {
+		order, err := scanPurchaseOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		if filter.OverdueOnly && !order.Overdue {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+// getPurchaseOrder returns one order, or sql.ErrNoRows
+func getPurchaseOrder(ctx context.Context, orderID int64) (PurchaseOrder, error) {
+	return scanPurchaseOrder(db.QueryRowContext(ctx, purchaseOrderSelect+" WHERE po.id = ?", orderID))
+}
+
+// newPurchaseOrder is what creating an order needs; zero values take defaults
+type newPurchaseOrder struct {
+	BookID          string
+	SupplierID      int64 // Defaults to the supplier named in the book's inventory row
+	Quantity        int
+	UnitCost        *float64   // Defaults to the book's cost price
+	ExpectedArrival *time.Time // Defaults to today plus the supplier's lead time
+}
+
+// createPurchaseOrder places an order for a book, filling in defaults from its inventory and pricing
+func createPurchaseOrder(ctx context.Context, order newPurchaseOrder) (PurchaseOrder, error) {
+	var supplierName sql.NullString
+	var costPrice sql.NullFloat64
+	err := db.QueryRowContext(ctx, `
+		SELECT i.supplier, p.cost_price
+		FROM books b
+		LEFT JOIN inventory i ON i.book_id = b.id
+		LEFT JOIN pricing p ON p.book_id = b.id
+		WHERE b.id = ?
+	`, order.BookID).Scan(&supplierName, &costPrice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PurchaseOrder{}, errBookNotFound
+	}
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+
+	var leadTimeDays int
+	if order.SupplierID != 0 {
+		err = db.QueryRowContext(ctx, "SELECT lead_time_days FROM suppliers WHERE id = ?", order.SupplierID).Scan(&leadTimeDays)
+		if errors.Is(err, sql.ErrNoRows) {
+			return PurchaseOrder{}, errSupplierNotFound
+		}
+	} else {
+		if supplierName.String == "" {
+			return PurchaseOrder{}, errNoSupplier
+		}
+		err = db.QueryRowContext(ctx, "SELECT id, lead_time_days FROM suppliers WHERE name = ?", supplierName.String).
+			Scan(&order.SupplierID, &leadTimeDays)
+		if errors.Is(err, sql.ErrNoRows) {
+			return PurchaseOrder{}, fmt.Errorf("%w: no supplier is named %q", errSupplierNotFound, supplierName.String)
+		}
+	}
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+
+	if order.UnitCost == nil && costPrice.Valid {
+		order.UnitCost = &costPrice.Float64
+	}
+	expected := today().AddDate(0, 0, leadTimeDays)
+	if order.ExpectedArrival != nil {
+		expected = *order.ExpectedArrival
+	}
+
+	now := dbNow()
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO purchase_orders (supplier_id, book_id, quantity_ordered, unit_cost, status, expected_arrival, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, order.SupplierID, order.BookID, order.Quantity, order.UnitCost, poOpen, expected.Format("2006-01-02"), now, now)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	orderID, err := result.LastInsertId()
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	return getPurchaseOrder(ctx, orderID)
+}
+
+// LowStockResult is the body of POST /api/admin/purchase-orders/low-stock
+type LowStockResult struct {
+	Created []PurchaseOrder   `json:"created"`
+	Skipped map[string]string `json:"skipped"` // Low-stock book ID -> why no order was placed
+}
+
+// reorderLowStock places an order for every book at or below threshold copies that has nothing
+// on order yet. Books without a known supplier are skipped and reported.
+func reorderLowStock(ctx context.Context, threshold, quantity int) (LowStockResult, error) {
+	result := LowStockResult{Created: []PurchaseOrder{}, Skipped: map[string]string{}}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT i.book_id
+		FROM inventory i
+		WHERE i.quantity <= ?
+			AND NOT EXISTS (SELECT 1 FROM purchase_orders po WHERE po.book_id = i.book_id AND po.status IN (?, ?))
+		ORDER BY i.book_id
+	`, threshold, poOpen, poPartiallyReceived)
+	if err != nil {
+		return result, err
+	}
+	var bookIDs []string
+	for rows.Next() {
+		var bookID string
+		if err := rows.Scan(&bookID); err != nil {
+			rows.Close()
+			return result, err
+		}
+		bookIDs = append(bookIDs, bookID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	for _, bookID := range bookIDs {
+		order, err := createPurchaseOrder(ctx, newPurchaseOrder{BookID: bookID, Quantity: quantity})
+		switch {
+		case errors.Is(err, errNoSupplier), errors.Is(err, errSupplierNotFound):
+			result.Skipped[bookID] = err.Error()
+		case err != nil:
+			return result, err
+		default:
+			result.Created = append(result.Created, order)
+		}
+	}
+	return result, nil
+}
+
+// receivePurchaseOrder books a delivery of quantity copies (everything outstanding when zero)
+// into inventory. Raising the quantity writes a restock event through the inventory trigger.
+func receivePurchaseOrder(ctx context.Context, orderID int64, quantity int) (PurchaseOrder, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	defer tx.Rollback()
+
+	var bookID, status string
+	var ordered, received int
+	err = tx.QueryRowContext(ctx, "SELECT book_id, status, quantity_ordered, quantity_received FROM purchase_orders WHERE id = ?", orderID).
+		Scan(&bookID, &status, &ordered, &received)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	if !purchaseOrderOutstanding(status) {
+		return PurchaseOrder{}, errPurchaseOrderClosed
+	}
+	outstanding := ordered - received
+	if quantity == 0 {
+		quantity = outstanding
+	}
+	if quantity > outstanding {
+		return PurchaseOrder{}, fmt.Errorf("%w: %d received, %d outstanding", errOverReceipt, quantity, outstanding)
+	}
+
+	now := dbNow()
+	status, receivedAt := poPartiallyReceived, interface{}(nil)
+	if received+quantity == ordered {
+		status, receivedAt = poReceived, now
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE purchase_orders SET quantity_received = quantity_received + ?, status = ?, received_at = ?, updated_at = ?
+		WHERE id = ?
+	`, quantity, status, receivedAt, now, orderID); err != nil {
+		return PurchaseOrder{}, err
+	}
+
+	// The row is created empty first so the quantity change is an UPDATE the restock trigger sees
+	if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO inventory (book_id, quantity, updated_at) VALUES (?, 0, ?)", bookID, now); err != nil {
+		return PurchaseOrder{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE inventory SET quantity = quantity + ?, in_stock = true, last_restocked = ?, updated_at = ?
+		WHERE book_id = ?
+	`, quantity, now, now, bookID); err != nil {
+		return PurchaseOrder{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return PurchaseOrder{}, err
+	}
+	invalidateBookRepository(bookID, "inventory")
+	return getPurchaseOrder(ctx, orderID)
+}
+
+// updatePurchaseOrder moves an outstanding order's expected arrival date
+func updatePurchaseOrder(ctx context.Context, orderID int64, expected time.Time) (PurchaseOrder, error) {
+	return setOutstandingPurchaseOrder(ctx, orderID, "expected_arrival = ?", expected.Format("2006-01-02"))
+}
+
+// cancelPurchaseOrder stops waiting for the rest of an outstanding order; copies already
+// received stay in stock
+func cancelPurchaseOrder(ctx context.Context, orderID int64) (PurchaseOrder, error) {
+	return setOutstandingPurchaseOrder(ctx, orderID, "status = ?", poCancelled)
+}
+
+// setOutstandingPurchaseOrder applies an assignment to an order that is still outstanding
+func setOutstandingPurchaseOrder(ctx context.Context, orderID int64, assignment string, value interface{}) (PurchaseOrder, error) {
+	order, err := getPurchaseOrder(ctx, orderID)
+	if err != nil {
+		return order, err
+	}
+	if !purchaseOrderOutstanding(order.Status) {
+		return order, errPurchaseOrderClosed
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE purchase_orders SET "+assignment+", updated_at = ? WHERE id = ? AND status IN (?, ?)",
+		value, dbNow(), orderID, poOpen, poPartiallyReceived); err != nil {
+		return order, err
+	}
+	return getPurchaseOrder(ctx, orderID)
+}
+
+// parseArrivalDate parses an expected arrival date in YYYY-MM-DD form
+func parseArrivalDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, errors.New("expected_arrival must be a date like 2024-05-31")
+	}
+	return &parsed, nil
+}
+
+// SuppliersHandler handles GET and POST /api/admin/suppliers
+func SuppliersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		suppliers, err := listSuppliers(r.Context())
+		if err != nil {
+			log.Printf("Error listing suppliers: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to list suppliers")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, suppliers)
+
+	case http.MethodPost:
+		var body struct {
+			Name         string  `json:"name"`
+			ContactEmail *string `json:"contact_email"`
+			LeadTimeDays *int    `json:"lead_time_days"`
+		}
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if body.Name == "" {
+			writeError(w, r, http.StatusBadRequest, "Supplier name is required")
+			return
+		}
+		leadTimeDays := 7
+		if body.LeadTimeDays != nil {
+			leadTimeDays = *body.LeadTimeDays
+		}
+		if leadTimeDays < 0 || leadTimeDays > 365 {
+			writeError(w, r, http.StatusBadRequest, "lead_time_days must be between 0 and 365")
+			return
+		}
+
+		supplier, err := createSupplier(r.Context(), body.Name, body.ContactEmail, leadTimeDays)
+		if errors.Is(err, errSupplierExists) {
+			writeError(w, r, http.StatusConflict, "A supplier with this name already exists")
+			return
+		}
+		if err != nil {
+			log.Printf("Error creating supplier %q: %v", body.Name, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to create supplier")
+			return
+		}
+		log.Printf("Created supplier %d (%s)", supplier.ID, supplier.Name)
+		writeJSON(w, r, http.StatusCreated, supplier)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// PurchaseOrdersHandler routes the purchase order endpoints:
+//
+//	GET, POST         /api/admin/purchase-orders?status=outstanding&overdue=1&book_id=3
+//	POST              /api/admin/purchase-orders/low-stock
+//	GET, PUT, DELETE  /api/admin/purchase-orders/{id}
+//	POST              /api/admin/purchase-orders/{id}/receive
+func PurchaseOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/") // {"", "api", "admin", "purchase-orders", "7", "receive"}
+	if len(pathParts) == 4 {
+		handlePurchaseOrderCollection(w, r)
+		return
+	}
+	if len(pathParts) == 5 && pathParts[4] == "low-stock" {
+		handleLowStock(w, r)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(pathParts[4], 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Purchase order ID must be a number")
+		return
+	}
+	switch {
+	case len(pathParts) == 5:
+		handlePurchaseOrder(w, r, orderID)
+	case len(pathParts) == 6 && pathParts[5] == "receive":
+		handlePurchaseOrderReceipt(w, r, orderID)
+	default:
+		writeError(w, r, http.StatusNotFound, "Not found")
+	}
+}
+
+// handlePurchaseOrderCollection lists orders or places one
+func handlePurchaseOrderCollection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		filter := purchaseOrderFilter{BookID: query.Get("book_id"), Status: query.Get("status"), OverdueOnly: query.Get("overdue") == "1"}
+		switch filter.Status {
+		case "", "outstanding", poOpen, poPartiallyReceived, poReceived, poCancelled:
+		default:
+			writeError(w, r, http.StatusBadRequest, "status must be outstanding, open, partially_received, received or cancelled")
+			return
+		}
+		orders, err := listPurchaseOrders(r.Context(), filter)
+		if err != nil {
+			log.Printf("Error listing purchase orders: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to list purchase orders")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, orders)
+
+	case http.MethodPost:
+		var body struct {
+			BookID          string   `json:"book_id"`
+			SupplierID      int64    `json:"supplier_id"`
+			Quantity        int      `json:"quantity"`
+			UnitCost        *float64 `json:"unit_cost"`
+			ExpectedArrival string   `json:"expected_arrival"`
+		}
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+		if body.BookID == "" || body.Quantity <= 0 {
+			writeError(w, r, http.StatusBadRequest, "book_id and a positive quantity are required")
+			return
+		}
+		if body.UnitCost != nil && *body.UnitCost < 0 {
+			writeError(w, r, http.StatusBadRequest, "unit_cost can't be negative")
+			return
+		}
+		expected, err := parseArrivalDate(body.ExpectedArrival)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		order, err := createPurchaseOrder(r.Context(), newPurchaseOrder{
+			BookID: body.BookID, SupplierID: body.SupplierID, Quantity: body.Quantity, UnitCost: body.UnitCost, ExpectedArrival: expected,
+		})
+		if !purchaseOrderWritten(w, r, err) {
+			return
+		}
+		log.Printf("Created purchase order %d: %d copies of book %s from %s", order.ID, order.QuantityOrdered, order.BookID, order.SupplierName)
+		writeJSON(w, r, http.StatusCreated, order)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleLowStock places orders for low-stock titles; body {"threshold": 5, "quantity": 20} is optional
+func handleLowStock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	body := struct {
+		Threshold int `json:"threshold"`
+		Quantity  int `json:"quantity"`
+	}{Threshold: defaultLowStockThreshold, Quantity: defaultReorderQuantity}
+	if r.ContentLength != 0 {
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+	}
+	if body.Threshold < 0 || body.Quantity <= 0 {
+		writeError(w, r, http.StatusBadRequest, "threshold can't be negative and quantity must be positive")
+		return
+	}
+
+	result, err := reorderLowStock(r.Context(), body.Threshold, body.Quantity)
+	if err != nil {
+		log.Printf("Error reordering low-stock titles: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to reorder low-stock titles")
+		return
+	}
+	log.Printf("Low-stock reorder: %d purchase orders created, %d titles skipped", len(result.Created), len(result.Skipped))
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+// handlePurchaseOrder returns an order, moves its expected arrival (PUT {"expected_arrival": "2024-05-31"})
+// or cancels what is still outstanding (DELETE)
+func handlePurchaseOrder(w http.ResponseWriter, r *http.Request, orderID int64) {
+	var order PurchaseOrder
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		order, err = getPurchaseOrder(r.Context(), orderID)
+
+	case http.MethodPut:
+		var body struct {
+			ExpectedArrival string `json:"expected_arrival"`
+		}
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+		expected, parseErr := parseArrivalDate(body.ExpectedArrival)
+		if parseErr != nil || expected == nil {
+			writeError(w, r, http.StatusBadRequest, "expected_arrival must be a date like 2024-05-31")
+			return
+		}
+		order, err = updatePurchaseOrder(r.Context(), orderID, *expected)
+		if err == nil {
+			log.Printf("Purchase order %d now expected %s", orderID, body.ExpectedArrival)
+		}
+
+	case http.MethodDelete:
+		order, err = cancelPurchaseOrder(r.Context(), orderID)
+		if err == nil {
+			log.Printf("Cancelled purchase order %d with %d of %d copies received", orderID, order.QuantityReceived, order.QuantityOrdered)
+		}
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !purchaseOrderWritten(w, r, err) {
+		return
+	}
+	writeJSON(w, r, http.StatusOK, order)
+}
+
+// handlePurchaseOrderReceipt records a delivery; body {"quantity": 10} is optional and defaults
+// to everything outstanding
+func handlePurchaseOrderReceipt(w http.ResponseWriter, r *http.Request, orderID int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var body struct {
+		Quantity int `json:"quantity"`
+	}
+	if r.ContentLength != 0 {
+		if !decodeJSONBody(w, r, &body) {
+			return
+		}
+	}
+	if body.Quantity < 0 {
+		writeError(w, r, http.StatusBadRequest, "quantity can't be negative")
+		return
+	}
+
+	order, err := receivePurchaseOrder(r.Context(), orderID, body.Quantity)
+	if !purchaseOrderWritten(w, r, err) {
+		return
+	}
+	log.Printf("Received delivery on purchase order %d: %d of %d copies of book %s", orderID, order.QuantityReceived, order.QuantityOrdered, order.BookID)
+	writeJSON(w, r, http.StatusOK, order)
+}
+
+// purchaseOrderWritten writes the error response for a failed purchase order operation and
+// reports whether to continue
+func purchaseOrderWritten(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, r, http.StatusNotFound, "Purchase order not found")
+	case errors.Is(err, errBookNotFound):
+		writeError(w, r, http.StatusNotFound, "Book not found")
+	case errors.Is(err, errNoSupplier):
+		writeError(w, r, http.StatusBadRequest, "Book has no supplier; pass supplier_id")
+	case errors.Is(err, errSupplierNotFound):
+		writeError(w, r, http.StatusBadRequest, "Purchase order not placed: "+err.Error())
+	case errors.Is(err, errPurchaseOrderClosed):
+		writeError(w, r, http.StatusConflict, "Purchase order is no longer open")
+	case errors.Is(err, errOverReceipt):
+		writeError(w, r, http.StatusConflict, "Delivery is larger than the order: "+err.Error())
+	default:
+		log.Printf("Error accessing purchase order: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to access purchase order")
+	}
+	return false
+}
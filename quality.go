@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Findings reported per check; the count always covers all of them
+const qualitySampleSize = 20
+
+// qualityCheck is one data-quality rule. Its query returns (book_id, detail) for every row
+// that breaks the rule.
+type qualityCheck struct {
+	Name        string
+	Severity    string // "error" for data that renders wrong, "warning" for likely mistakes, "info" for gaps
+	Description string
+	Query       string
+}
+
+// Rules the data-quality report runs, roughly in order of how much they hurt
+var qualityChecks = []qualityCheck{
+	{
+		Name: "missing_pricing", Severity: "error",
+		Description: "Book has no pricing row, so details show a pricing error",
+		Query:       "SELECT b.id, b.title FROM books b LEFT JOIN pricing p ON p.book_id = b.id WHERE p.book_id IS NULL",
+	},
+	{
+		Name: "missing_inventory", Severity: "error",
+		Description: "Book has no inventory row, so details show an inventory error",
+		Query:       "SELECT b.id, b.title FROM books b LEFT JOIN inventory i ON i.book_id = b.id WHERE i.book_id IS NULL",
+	},
+	{
+		Name: "negative_quantity", Severity: "error",
+		Description: "Inventory quantity is below zero",
+		Query:       "SELECT book_id, printf('quantity %d', quantity) FROM inventory WHERE quantity < 0",
+	},
+	{
+		Name: "sale_price_above_price", Severity: "error",
+		Description: "Sale price is higher than the list price",
+		Query:       "SELECT book_id, printf('sale_price %.2f > price %.2f', sale_price, price) FROM pricing WHERE sale_price > price",
+	},
+	{
+		Name: "rating_breakdown_mismatch", Severity: "error",
+		Description: "Star counts don't add up to total_reviews",
+		Query: `SELECT book_id, printf('stars sum to %d, total_reviews is %d', five_star + four_star + three_star + two_star + one_star, total_reviews)
+			FROM reviews WHERE five_star + four_star + three_star + two_star + one_star != total_reviews`,
+	},
+	{
+		Name: "average_rating_mismatch", Severity: "warning",
+		Description: "average_rating is more than 0.05 away from the average of the star counts",
+		Query: `SELECT book_id, printf('average_rating %.1f, star counts give %.2f', average_rating,
+				(5 * five_star + 4 * four_star + 3 * three_star + 2 * two_star + one_star) * 1.0 / (five_star + four_star + three_star + two_star + one_star))
+			FROM reviews
+			WHERE five_star + four_star + three_star + two_star + one_star > 0
+				AND ABS(COALESCE(average_rating, 0) - (5 * five_star + 4 * four_star + 3 * three_star + 2 * two_star + one_star) * 1.0
+					/ (five_star + four_star + three_star + two_star + one_star)) > 0.05`,
+	},
+	{
+		Name: "sale_price_mismatch", Severity: "warning",
+		Description: "Sale price doesn't match the list price less the discount",
+		Query: `SELECT book_id, printf('price %.2f less %d%% is %.2f, sale_price is %.2f', price, CAST(ROUND(discount * 100) AS INTEGER), price * (1 - discount), sale_price)
+			FROM pricing WHERE sale_price IS NOT NULL AND ABS(price * (1 - discount) - sale_price) > 0.011`,
+	},
+	{
+		Name: "discount_out_of_range", Severity: "warning",
+		Description: "Discount is not a fraction between 0 and 1",
+		Query:       "SELECT book_id, printf('discount %g', discount) FROM pricing WHERE discount < 0 OR discount >= 1",
+	},
+	{
+		Name: "in_stock_without_quantity", Severity: "warning",
+		Description: "Marked in stock with no copies",
+		Query:       "SELECT book_id, printf('in_stock with quantity %d', quantity) FROM inventory WHERE in_stock AND quantity <= 0",
+	},
+	{
+		Name: "orphan_rows", Severity: "warning",
+		Description: "Pricing, inventory or reviews row for a book that doesn't exist",
+		Query: `SELECT book_id, 'pricing' FROM pricing WHERE book_id NOT IN (SELECT id FROM books)
+			UNION ALL SELECT book_id, 'inventory' FROM inventory WHERE book_id NOT IN (SELECT id FROM books)
+			UNION ALL SELECT book_id, 'reviews' FROM reviews WHERE book_id NOT IN (SELECT id FROM books)`,
+	},
+	{
+		Name: "missing_isbn", Severity: "info",
+		Description: "Book has no ISBN",
+		Query:       "SELECT id, title FROM books WHERE isbn IS NULL OR TRIM(isbn) = ''",
+	},
+}
+
+// QualityFinding is one offending row
+type QualityFinding struct {
+	BookID string `json:"book_id"`
+	Detail string `json:"detail"`
+}
+
+// QualityCheckResult is the outcome of one check
+type QualityCheckResult struct {
+	Name        string           `json:"name"`
+	Severity    string           `json:"severity"`
+	Description string           `json:"description"`
+	Count       int              `json:"count"`
+	Samples     []QualityFinding `json:"samples"` // The first 20 findings
+}
+
+// QualityReport is the body of GET /api/admin/data-quality
+type QualityReport struct {
+	GeneratedAt  time.Time            `json:"generated_at"`
+	BooksScanned int                  `json:"books_scanned"`
+	Summary      map[string]int       `json:"summary"` // Findings per severity
+	Checks       []QualityCheckResult `json:"checks"`  // Every check, including clean ones
+}
+
+// BuildQualityReport runs every data-quality check against the catalog
+func BuildQualityReport(ctx context.Context) (QualityReport, error) {
+	report := QualityReport{
+		GeneratedAt: clock.Now().UTC(),
+		Summary:     map[string]int{"error": 0, "warning": 0, "info": 0},
+	}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM books").Scan(&report.BooksScanned); err != nil {
+		return report, err
+	}
+
+	for _, check := range qualityChecks {
+		result, err := runQualityCheck(ctx, check)
+		if err != nil {
+			return report, fmt.Errorf("check %s: %w", check.Name, err)
+		}
+		report.Checks = append(report.Checks, result)
+		report.Summary[check.Severity] += result.Count
+	}
+
+	// Duplicate ISBNs need normalizing in Go, so they don't fit a single query
+	groups, err := FindDuplicateISBNs(ctx)
+	if err != nil {
+		return report, fmt.Errorf("check duplicate_isbn: %w", err)
+	}
+	duplicates := QualityCheckResult{
+		Name:        "duplicate_isbn",
+		Severity:    "warning",
+		Description: "Several books share an ISBN once hyphens and ISBN-10 forms are normalized; see /api/admin/books/duplicates",
+		Samples:     []QualityFinding{},
+	}
+	for _, group := range groups {
+		for _, book := range group.Books {
+			duplicates.Count++
+			if len(duplicates.Samples) < qualitySampleSize {
+				duplicates.Samples = append(duplicates.Samples, QualityFinding{BookID: book.ID, Detail: "ISBN " + group.ISBN})
+			}
+		}
+	}
+	report.Checks = append(report.Checks, duplicates)
+	report.Summary[duplicates.Severity] += duplicates.Count
+
+	return report, nil
+}
+
+// runQualityCheck counts a check's findings and keeps the first few
+func runQualityCheck(ctx context.Context, check qualityCheck) (QualityCheckResult, error) {
+	result := QualityCheckResult{Name: check.Name, Severity: check.Severity, Description: check.Description, Samples: []QualityFinding{}}
+
+	rows, err := db.QueryContext(ctx, check.Query)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result.Count++
+		if len(result.Samples) >= qualitySampleSize {
+			continue
+		}
+		var finding QualityFinding
+		if err := rows.Scan(&finding.BookID, &finding.Detail); err != nil {
+			return result, err
+		}
+		result.Samples = append(result.Samples, finding)
+	}
+	return result, rows.Err()
+}
+
+// DataQualityHandler handles GET /api/admin/data-quality
+func DataQualityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	report, err := BuildQualityReport(r.Context())
+	if err != nil {
+		log.Printf("Error building data-quality report: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to build data-quality report")
+		return
+	}
+	log.Printf("Data-quality report: %d errors, %d warnings over %d books",
+		report.Summary["error"], report.Summary["warning"], report.BooksScanned)
+	writeJSON(w, r, http.StatusOK, report)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+)
+
+// Star-count column in the reviews table for each rating value
+var ratingColumns = map[int]string{
+	5: "five_star",
+	4: "four_star",
+	3: "three_star",
+	2: "two_star",
+	1: "one_star",
+}
+
+// RatingResponse is the body returned after a rating is saved
+type RatingResponse struct {
+	BookID        string  `json:"book_id"`
+	UserID        string  `json:"user_id"`
+	Rating        int     `json:"rating"`
+	AverageRating float64 `json:"average_rating"` // The book's aggregate after this rating
+	TotalReviews  int     `json:"total_reviews"`
+}
+
+// SaveRating records a user's 1-5 rating for a book, replacing any earlier one, and updates the
+// aggregate in reviews incrementally: the star buckets move by one and the average is recomputed
+// from the buckets rather than carried over. created is false when an existing rating was replaced.
+func SaveRating(ctx context.Context, bookID, userID string, rating int) (RatingResponse, bool, error) {
+	response := RatingResponse{BookID: bookID, UserID: userID, Rating: rating}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return response, false, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, "SELECT 1 FROM books WHERE id = ?", bookID).Scan(&exists); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return response, false, errBookNotFound
+		}
+		return response, false, err
+	}
+
+	previous := 0
+	err = tx.QueryRowContext(ctx, "SELECT rating FROM book_ratings WHERE book_id = ? AND user_id = ?", bookID, userID).Scan(&previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return response, false, err
+	}
+	created := previous == 0
+	now := dbNow()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO book_ratings (book_id, user_id, rating, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(book_id, user_id) DO UPDATE SET
+			rating = excluded.rating,
+			updated_at = excluded.updated_at
+	`, bookID, userID, rating, now, now); err != nil {
+		return response, false, err
+	}
+
+	// Books without any reviews yet start from an empty aggregate
+	if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO reviews (book_id) VALUES (?)", bookID); err != nil {
+		return response, false, err
+	}
+
+	// Column names come from ratingColumns, never from the request
+	update := "UPDATE reviews SET " + ratingColumns[rating] + " = " + ratingColumns[rating] + " + 1"
+	if created {
+		update += ", total_reviews = total_reviews + 1"
+	} else {
+		update += ", " + ratingColumns[previous] + " = MAX(" + ratingColumns[previous] + " - 1, 0)"
+	}
+	if previous != rating {
+		if _, err := tx.ExecContext(ctx, update+" WHERE book_id = ?", bookID); err != nil {
+			return response, false, err
+		}
+	}
+
+	var five, four, three, two, one int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT total_reviews, five_star, four_star, three_star, two_star, one_star
+		FROM reviews
+		WHERE book_id = ?
+	`, bookID).Scan(&response.TotalReviews, &five, &four, &three, &two, &one); err != nil {
+		return response, false, err
+	}
+
+	rated := five + four + three + two + one
+	if rated > 0 {
+		response.AverageRating = math.Round(float64(5*five+4*four+3*three+2*two+one)/float64(rated)*10) / 10
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE reviews SET average_rating = ?, updated_at = ? WHERE book_id = ?
+	`, response.AverageRating, now, bookID); err != nil {
+		return response, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return response, false, err
+	}
+	invalidateBookRepository(bookID, "reviews")
+	return response, created, nil
+}
+
+// UserRatings returns the 1-5 rating a user has given each book they rated, keyed by book ID
+func UserRatings(ctx context.Context, userID string) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT book_id, rating FROM book_ratings WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make(map[string]int)
+	for rows.Next() {
+		var bookID string
+		var rating int
+		if err := rows.Scan(&bookID, &rating); err != nil {
+			return nil, err
+		}
+		ratings[bookID] = rating
+	}
+	return ratings, rows.Err()
+}
+
+// RatingHandler handles POST /api/books/{id}/rating with body {"rating": 1-5}, for the session's
+// user
+func RatingHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := requestUserID(r)
+	if errors.Is(err, errForeignUser) {
+		writeError(w, r, http.StatusForbidden, "Ratings can only be made as the session's own user")
+		return
+	}
+	if userID == "" {
+		writeError(w, r, http.StatusBadRequest, "A session is required")
+		return
+	}
+
+	var body struct {
+		Rating int `json:"rating"`
+	}
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+	if _, ok := ratingColumns[body.Rating]; !ok {
+		writeError(w, r, http.StatusBadRequest, "rating must be an integer between 1 and 5")
+		return
+	}
+
+	response, created, err := SaveRating(r.Context(), bookID, userID, body.Rating)
+	if errors.Is(err, errBookNotFound) {
+		writeError(w, r, http.StatusNotFound, "Book not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving rating for book %s by %s: %v", bookID, userID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to save rating")
+		return
+	}
+
+	invalidatePersonalRecommendations(userID)
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	log.Printf("User %s rated book %s %d stars (average now %.1f)", userID, bookID, body.Rating, response.AverageRating)
+	writeJSON(w, r, status, response)
+}
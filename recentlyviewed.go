@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// recordBookView notes that a user opened a book's detail page. A repeat view moves the book
+// back to the front instead of adding it twice, and the oldest views past
+// RecentlyViewedLimit are dropped. Views of unknown books are ignored.
+func recordBookView(ctx context.Context, userID, bookID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO recently_viewed (user_id, book_id, viewed_at)
+		SELECT ?, id, ? FROM books WHERE id = ?
+		ON CONFLICT(user_id, book_id) DO UPDATE SET viewed_at = excluded.viewed_at
+	`, userID, dbNow(), bookID); err != nil {
+		return err
+	}
+	if err := trimRecentlyViewed(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// trimRecentlyViewed keeps a user's RecentlyViewedLimit latest views
+func trimRecentlyViewed(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM recently_viewed
+		WHERE user_id = ? AND book_id NOT IN (
+			SELECT book_id FROM recently_viewed WHERE user_id = ? ORDER BY viewed_at DESC, book_id LIMIT ?
+		)
+	`, userID, userID, config.RecentlyViewedLimit)
+	return err
+}
+
+// recordBookViewAsync records a view off the request path, for detail handlers. Views made
+// while impersonating are left out of the user's history.
+func recordBookViewAsync(r *http.Request, bookID string) {
+	userID := detailUserID(r)
+	if _, impersonating := ImpersonationFromContext(r.Context()); impersonating || userID == "" {
+		return
+	}
+	go func() {
+		if err := recordBookView(context.Background(), userID, bookID); err != nil {
+			log.Printf("Error recording view of book %s: %v", bookID, err)
+		}
+	}()
+}
+
+// listRecentlyViewed returns a user's viewed books, most recent first
+func listRecentlyViewed(ctx context.Context, userID string, limit int) ([]RecentlyViewedBook, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT v.book_id, b.title, b.author, v.viewed_at
+		FROM recently_viewed v
+		JOIN books b ON b.id = v.book_id
+		WHERE v.user_id = ?
+		ORDER BY v.viewed_at DESC, v.book_id
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	viewed := []RecentlyViewedBook{}
+	for rows.Next() {
+		var book RecentlyViewedBook
+		if err := rows.Scan(&book.BookID, &book.Title, &book.Author, &book.ViewedAt); err != nil {
+			return nil, err
+		}
+		viewed = append(viewed, book)
+	}
+	return viewed, rows.Err()
+}
+
+// handleRecentlyViewed handles GET /api/users/{user_id}/recently-viewed?limit=N, usually with
+// the user "me"
+func handleRecentlyViewed(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit := config.RecentlyViewedLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			writeError(w, r, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(parsed, config.RecentlyViewedLimit)
+	}
+
+	viewed, err := listRecentlyViewed(r.Context(), userID, limit)
+	if err != nil {
+		log.Printf("Error loading recently viewed books for %s: %v", userID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to load recently viewed books")
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+	writeJSON(w, r, http.StatusOK, viewed)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RecommendationProvider fetches the external content (currently a quote) that accompanies recommendations.
+// Implementations must honor ctx cancellation: when several providers race, the losers are
+// cancelled as soon as one succeeds.
+type RecommendationProvider interface {
+	Name() string
+	Fetch(ctx context.Context, bookID, userID string) (Recommendations, error)
+}
+
+// Known providers, selectable by name via BOOKSTORE_RECOMMENDATION_PROVIDERS
+var recommendationProviderRegistry = map[string]func() RecommendationProvider{
+	"zenquotes": func() RecommendationProvider { return &zenQuotesProvider{} },
+	"quotable":  func() RecommendationProvider { return &quotableProvider{} },
+	"static":    func() RecommendationProvider { return staticRecommendationProvider{} },
+}
+
+// Providers queried for every recommendations fetch, built from config in main
+var recommendationProviders = NewRecommendationProviders(config.RecommendationProviders)
+
+// NewRecommendationProviders instantiates providers by name, skipping unknown names
+func NewRecommendationProviders(names []string) []RecommendationProvider {
+	var providers []RecommendationProvider
+	for _, name := range names {
+		constructor, ok := recommendationProviderRegistry[name]
+		if !ok {
+			log.Printf("Unknown recommendation provider %q, ignoring", name)
+			continue
+		}
+		providers = append(providers, constructor())
+	}
+	return providers
+}
+
+// FetchPersonalizedRecommendations returns recommendations for a user, reusing a cached
+// response while it is fresh and falling back to a stale one when the upstream fails. Anonymous
+// and personalized responses are cached apart, see recommendationCacheFor.
+func FetchPersonalizedRecommendations(ctx context.Context, bookID string, userID string) sectionResult[Recommendations] {
+	cache, key := recommendationCacheFor(userID), recommendationCacheKey(bookID, userID)
+	freshTTL, _ := cache.ttls()
+	started := cache.Sequence()
+
+	// Fresh cache hit: skip the external call entirely
+	if cached, age, ok := cache.Get(key); ok && age <= freshTTL {
+		return sectionResult[Recommendations]{Data: cached.value, Source: "cache", FetchedAt: cached.storedAt}
+	}
+
+	// The upstream only gets whatever is left of the request's budget
+	upstreamCtx, cancel, err := withUpstreamDeadline(ctx)
+	var recommendations Recommendations
+	if err == nil {
+		recommendations, err = fetchFirstRecommendation(upstreamCtx, recommendationProviders, bookID, userID)
+		cancel()
+	} else {
+		log.Printf("Skipping external API call for book %s: %v", bookID, err)
+	}
+	if err != nil {
+		// Upstream is slow or down: an old answer beats an error
+		if cached, age, ok := cache.Get(key); ok {
+			log.Printf("Serving stale recommendations for book %s (age %v): %v", bookID, age.Round(time.Second), err)
+			return sectionResult[Recommendations]{Data: cached.value, Source: "stale_cache", FetchedAt: cached.storedAt, Stale: true}
+		}
+		return sectionResult[Recommendations]{Err: err, Source: "external_api"}
+	}
+
+	// Providers only supply the quote; the books themselves come from our own history-based recommender
+	recommendations.Items, err = RecommendBooks(ctx, bookID, userID)
+	if err != nil {
+		log.Printf("Error computing recommended books for book %s, user %s: %v", bookID, userID, err)
+		recommendations.Items = []RecommendationItem{}
+	}
+
+	fetchedAt := clock.Now()
+	cache.Set(key, userID, recommendations, fetchedAt, started)
+	return sectionResult[Recommendations]{Data: recommendations, Source: recommendations.APISource, FetchedAt: fetchedAt}
+}
+
+// providerResult carries one provider's outcome back to the fan-in loop
+type providerResult struct {
+	provider        string
+	recommendations Recommendations
+	err             error
+}
+
+// fetchFirstRecommendation queries all providers concurrently and returns the first
+// successful payload, cancelling the rest. It only fails when every provider fails.
+func fetchFirstRecommendation(ctx context.Context, providers []RecommendationProvider, bookID, userID string) (Recommendations, error) {
+	if len(providers) == 0 {
+		return Recommendations{}, errors.New("no recommendation providers configured")
+	}
+
+	raceCtx, cancelRace := context.WithCancel(ctx)
+	defer cancelRace() // Stops the losing providers once we return
+
+	// Buffered so providers that finish after we've returned never block
+	results := make(chan providerResult, len(providers))
+
+	for _, provider := range providers {
+		go func(provider RecommendationProvider) {
+			// External calls get their own bulkhead so they can't starve database work
+			if err := externalBulkhead.Acquire(raceCtx); err != nil {
+				results <- providerResult{provider: provider.Name(), err: fmt.Errorf("external API bulkhead: %w", err)}
+				return
+			}
+			defer externalBulkhead.Release()
+
+			startTime := time.Now()
+			recommendations, err := provider.Fetch(raceCtx, bookID, userID)
+
+			// A provider cut off because another one won is not unhealthy
+			lostRace := err != nil && raceCtx.Err() != nil && ctx.Err() == nil
+			recordProviderResult(provider.Name(), time.Since(startTime), err, lostRace)
+
+			results <- providerResult{provider: provider.Name(), recommendations: recommendations, err: err}
+		}(provider)
+	}
+
+	var errs []error
+	for range providers {
+		result := <-results
+		if result.err == nil {
+			return result.recommendations, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", result.provider, result.err))
+	}
+	return Recommendations{}, errors.Join(errs...)
+}
+
+// Per-provider call metrics
+var (
+	providerSuccesses      = expvar.NewMap("recommendation_provider_successes")
+	providerFailures       = expvar.NewMap("recommendation_provider_failures")
+	providerCancelled      = expvar.NewMap("recommendation_provider_cancelled")
+	providerLatencyMsTotal = expvar.NewMap("recommendation_provider_latency_ms_total")
+)
+
+// ProviderHealth summarizes the recent behaviour of one recommendation provider
+type ProviderHealth struct {
+	Name                string     `json:"name"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// A provider (or any other tracked dependency) is reported unhealthy after this many failures in a row
+const providerUnhealthyThreshold = 3
+
+// providerHealth tracks call outcomes for every provider that has been called
+var providerHealth = struct {
+	sync.Mutex
+	byName map[string]*healthTracker
+}{byName: make(map[string]*healthTracker)}
+
+func init() {
+	expvar.Publish("recommendation_provider_health", expvar.Func(func() interface{} {
+		return RecommendationProviderHealth()
+	}))
+}
+
+// recordProviderResult updates metrics and health for a completed provider call
+func recordProviderResult(name string, duration time.Duration, err error, lostRace bool) {
+	providerLatencyMsTotal.Add(name, duration.Milliseconds())
+
+	if lostRace {
+		providerCancelled.Add(name, 1)
+		return
+	}
+
+	providerHealth.Lock()
+	defer providerHealth.Unlock()
+
+	tracker, ok := providerHealth.byName[name]
+	if !ok {
+		tracker = &healthTracker{}
+		providerHealth.byName[name] = tracker
+	}
+	if err != nil {
+		providerFailures.Add(name, 1)
+	} else {
+		providerSuccesses.Add(name, 1)
+	}
+	tracker.record(err)
+}
+
+// RecommendationProviderHealth returns a snapshot of provider health sorted by name
+func RecommendationProviderHealth() []ProviderHealth {
+	providerHealth.Lock()
+	defer providerHealth.Unlock()
+
+	snapshot := make([]ProviderHealth, 0, len(providerHealth.byName))
+	for name, tracker := range providerHealth.byName {
+		snapshot = append(snapshot, ProviderHealth{
+			Name:                name,
+			Healthy:             tracker.healthy(),
+			ConsecutiveFailures: tracker.consecutiveFailures,
+			LastSuccess:         tracker.lastSuccess,
+			LastFailure:         tracker.lastFailure,
+			LastError:           tracker.lastError,
+		})
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Name < snapshot[j].Name })
+	return snapshot
+}
+
+// getUpstreamJSON performs a GET against an external API and decodes a 200 JSON response into target
+func getUpstreamJSON(ctx context.Context, url string, target interface{}) error {
+	// The request carries the caller's context so cancellation and the request ID reach the upstream
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		log.Printf("Error calling external API: %v", err)
+		return err
+	}
+	defer response.Body.Close() // Always close the response body!
+
+	// Rate limiting and upstream errors must not be cached as a successful answer
+	if response.StatusCode != http.StatusOK {
+		log.Printf("External API %s returned status %d", url, response.StatusCode)
+		return fmt.Errorf("external API returned status %d", response.StatusCode)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(target); err != nil {
+		log.Printf("Error parsing API response from %s: %v", url, err)
+		return err
+	}
+	return nil
+}
+
+// zenQuotesProvider enriches recommendations with a random quote from zenquotes.io
+type zenQuotesProvider struct{}
+
+// Name implements RecommendationProvider
+func (p *zenQuotesProvider) Name() string { return "zenquotes" }
+
+// Fetch implements RecommendationProvider
+func (p *zenQuotesProvider) Fetch(ctx context.Context, bookID, userID string) (Recommendations, error) {
+	// zenquotes answers with a one-element array: [{"q": "...", "a": "...", "h": "..."}]
+	var quoteData []map[string]interface{}
+	if err := getUpstreamJSON(ctx, "https://zenquotes.io/api/random", &quoteData); err != nil {
+		return Recommendations{}, err
+	}
+
+	var quote *Quote
+	if len(quoteData) > 0 {
+		text, _ := quoteData[0]["q"].(string)
+		author, _ := quoteData[0]["a"].(string)
+		if text != "" {
+			quote = &Quote{Text: text, Author: author}
+		}
+	}
+
+	return Recommendations{
+		UserID:    userID,
+		BookID:    bookID,
+		Quote:     quote,
+		APISource: "zenquotes.io",
+		RawQuote:  quoteData, // This is real data from the external API!
+	}, nil
+}
+
+// quotableProvider enriches recommendations with a random quote from api.quotable.io
+type quotableProvider struct{}
+
+// Name implements RecommendationProvider
+func (p *quotableProvider) Name() string { return "quotable" }
+
+// Fetch implements RecommendationProvider
+func (p *quotableProvider) Fetch(ctx context.Context, bookID, userID string) (Recommendations, error) {
+	// quotable answers with a single object: {"content": "...", "author": "...", ...}
+	var quoteData map[string]interface{}
+	if err := getUpstreamJSON(ctx, "https://api.quotable.io/random", &quoteData); err != nil {
+		return Recommendations{}, err
+	}
+
+	var quote *Quote
+	if text, _ := quoteData["content"].(string); text != "" {
+		author, _ := quoteData["author"].(string)
+		quote = &Quote{Text: text, Author: author}
+	}
+
+	return Recommendations{
+		UserID:    userID,
+		BookID:    bookID,
+		Quote:     quote,
+		APISource: "api.quotable.io",
+		RawQuote:  quoteData,
+	}, nil
+}
+
+// staticRecommendationProvider answers instantly with a fixed quote and never touches the
+// network. It stands in for the real providers in tests and offline development.
+type staticRecommendationProvider struct{}
+
+// Name implements RecommendationProvider
+func (p staticRecommendationProvider) Name() string { return "static" }
+
+// Fetch implements RecommendationProvider
+func (p staticRecommendationProvider) Fetch(ctx context.Context, bookID, userID string) (Recommendations, error) {
+	text, author := "A reader lives a thousand lives before he dies.", "George R.R. Martin"
+	return Recommendations{
+		UserID:    userID,
+		BookID:    bookID,
+		Quote:     &Quote{Text: text, Author: author},
+		APISource: "static",
+		RawQuote:  []interface{}{map[string]interface{}{"q": text, "a": author}}, // Same shape as zenquotes
+	}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// Number of books recommended per request
+const recommendationLimit = 3
+
+// How much each kind of reading list signal pulls recommendations toward similar books.
+// A wishlist says the most about what the user wants next; a finished book, the least.
+// Rated is the pull of a five-star rating: a three-star rating is neutral, and one or two
+// stars push recommendations away from similar books.
+var recommendationSignalWeights = struct {
+	Wishlist, Reading, Read, Rated float64
+}{Wishlist: 1.0, Reading: 0.8, Read: 0.6, Rated: 1.2}
+
+// Share of a candidate's score that comes from its average rating, so among equally
+// similar books the better-reviewed one wins
+const recommendationRatingWeight = 0.1
+
+// RecommendBooks picks books for a user viewing bookID. Users with reading list or rating
+// history get books similar to what they read, want and liked, ranked by embedding
+// similarity; everyone else gets the top-rated titles. Books the user already has on a list
+// or has rated are never recommended.
+func RecommendBooks(ctx context.Context, bookID, userID string) ([]RecommendationItem, error) {
+	signals, err := ReadingListSignals(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ratings, err := UserRatings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := map[string]bool{bookID: true}
+	for _, ids := range [][]string{signals.Read, signals.Reading, signals.Wishlist} {
+		for _, id := range ids {
+			exclude[id] = true
+		}
+	}
+	for id := range ratings {
+		exclude[id] = true
+	}
+
+	candidates, err := loadRecommendationCandidates(ctx, embeddingProvider.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	seeds := map[string]float64{}
+	for _, id := range signals.Read {
+		seeds[id] += recommendationSignalWeights.Read
+	}
+	for _, id := range signals.Reading {
+		seeds[id] += recommendationSignalWeights.Reading
+	}
+	for _, id := range signals.Wishlist {
+		seeds[id] += recommendationSignalWeights.Wishlist
+	}
+	for id, rating := range ratings {
+		seeds[id] += recommendationSignalWeights.Rated * float64(rating-3) / 2
+	}
+
+	var ranked []recommendationCandidate
+	source := "personalized"
+	for _, candidate := range candidates {
+		if exclude[candidate.ID] {
+			continue
+		}
+		for seedID, weight := range seeds {
+			if seed, ok := candidates[seedID]; ok && seed.Vector != nil && candidate.Vector != nil {
+				candidate.Score += weight * cosineSimilarity(seed.Vector, candidate.Vector)
+			}
+		}
+		candidate.Score += recommendationRatingWeight * candidate.Rating / 5
+		ranked = append(ranked, candidate)
+	}
+
+	// No history (anonymous users included): fall back to the best-reviewed titles
+	if len(seeds) == 0 {
+		source = "top_rated"
+		for i := range ranked {
+			ranked[i].Score = ranked[i].Rating
+		}
+	}
+
+	// Ties broken by review count, then ID, so the same inputs always give the same answer
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		if ranked[i].Reviews != ranked[j].Reviews {
+			return ranked[i].Reviews > ranked[j].Reviews
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+
+	items := []RecommendationItem{}
+	for _, candidate := range ranked[:min(recommendationLimit, len(ranked))] {
+		items = append(items, RecommendationItem{BookID: candidate.ID, Title: candidate.Title, Source: source})
+	}
+	return items, nil
+}
+
+// recommendationCandidate is a book with what the recommender needs to score it
+type recommendationCandidate struct {
+	ID      string
+	Title   string
+	Rating  float64
+	Reviews int
+	Vector  []float32 // nil until the embedding pipeline has reached the book
+	Score   float64
+}
+
+// loadRecommendationCandidates reads every book with its rating and description vector, keyed by ID
+func loadRecommendationCandidates(ctx context.Context, provider string) (map[string]recommendationCandidate, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT b.id, b.title, COALESCE(r.average_rating, 0), COALESCE(r.total_reviews, 0), e.vector
+		FROM books b
+		LEFT JOIN reviews r ON r.book_id = b.id
+		LEFT JOIN book_embeddings e ON e.book_id = b.id AND e.provider = ?
+	`, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make(map[string]recommendationCandidate)
+	for rows.Next() {
+		var candidate recommendationCandidate
+		var encoded []byte
+		if err := rows.Scan(&candidate.ID, &candidate.Title, &candidate.Rating, &candidate.Reviews, &encoded); err != nil {
+			return nil, err
+		}
+		if encoded != nil {
+			candidate.Vector = decodeVector(encoded)
+		}
+		candidates[candidate.ID] = candidate
+	}
+	return candidates, rows.Err()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHighRatingPullsSimilarBooksUp(t *testing.T) {
+	newTestServer(t)
+	ctx := context.Background()
+
+	// Book 3 reads like book 1; book 2 is the best reviewed but unlike either
+	vectors := map[string][]float32{"1": {1, 0, 0}, "2": {0, 1, 0}, "3": {0.9, 0.1, 0}, "4": {0, 0, 1}}
+	for id, vector := range vectors {
+		if err := saveEmbedding(ctx, id, embeddingProvider.Name(), "test", vector); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("UPDATE reviews SET average_rating = CASE book_id WHEN '2' THEN 4.8 ELSE 4.0 END"); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := RecommendBooks(ctx, "4", "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) == 0 || before[0].BookID != "2" || before[0].Source != "top_rated" {
+		t.Fatalf("recommendations before rating = %+v, want top-rated book 2 first", before)
+	}
+
+	if _, _, err := SaveRating(ctx, "1", "reader", 5); err != nil {
+		t.Fatal(err)
+	}
+	after, err := RecommendBooks(ctx, "4", "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) == 0 || after[0].BookID != "3" || after[0].Source != "personalized" {
+		t.Fatalf("recommendations after rating book 1 five stars = %+v, want similar book 3 first", after)
+	}
+	for _, item := range after {
+		if item.BookID == "1" {
+			t.Errorf("recommended book 1, which the reader has already rated")
+		}
+	}
+
+	// A one-star rating pushes the similar book back below the unrelated one
+	if _, _, err := SaveRating(ctx, "1", "reader", 1); err != nil {
+		t.Fatal(err)
+	}
+	after, err = RecommendBooks(ctx, "4", "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) < 2 || after[0].BookID != "2" || after[len(after)-1].BookID != "3" {
+		t.Fatalf("recommendations after rating book 1 one star = %+v, want book 2 first and book 3 last", after)
+	}
+}
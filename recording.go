@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// recordedRequest is one line of a recording file
+type recordedRequest struct {
+	Time    time.Time         `json:"time"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"` // Path and sanitized query
+	Headers map[string]string `json:"headers,omitempty"`
+	Status  int               `json:"status"` // What this instance answered
+}
+
+// Only these request headers are recorded; they change the response format, while anything
+// else (cookies, credentials, tracing) is either sensitive or irrelevant to a replay
+var recordedHeaders = []string{"Accept", "Accept-Language", "If-Modified-Since"}
+
+// Query parameters that may carry secrets; their values are replaced before recording
+var redactedQueryParams = map[string]bool{
+	"token": true, "access_token": true, "api_key": true, "key": true,
+	"password": true, "secret": true, "signature": true,
+}
+
+// Share tokens are bearer credentials embedded in the path
+const sharedListPrefix = "/api/shared/lists/"
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController, so streamed responses can
+// still be flushed while recording
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// newRecordingMiddleware appends every GET and HEAD request to a JSON-lines file. Writes are
+// left out so a replay can never change the target's data.
+func newRecordingMiddleware(path string) (func(http.Handler) http.Handler, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Recording requests to %s", path)
+
+	var mu sync.Mutex
+	encoder := json.NewEncoder(file)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			record := sanitizedRequest(r)
+			next.ServeHTTP(recorder, r)
+			record.Status = recorder.status
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err := encoder.Encode(record); err != nil {
+				log.Printf("Error recording request: %v", err)
+			}
+		})
+	}, nil
+}
+
+// sanitizedRequest captures what a replay needs, minus credentials
+func sanitizedRequest(r *http.Request) recordedRequest {
+	record := recordedRequest{Time: clock.Now().UTC(), Method: r.Method, Path: r.URL.Path}
+	if strings.HasPrefix(record.Path, sharedListPrefix) && len(record.Path) > len(sharedListPrefix) {
+		record.Path = sharedListPrefix + "REDACTED"
+	}
+
+	query := r.URL.Query()
+	for name := range query {
+		if redactedQueryParams[strings.ToLower(name)] {
+			query.Set(name, "REDACTED")
+		}
+	}
+	if encoded := query.Encode(); encoded != "" {
+		record.Path += "?" + encoded
+	}
+
+	for _, name := range recordedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			if record.Headers == nil {
+				record.Headers = map[string]string{}
+			}
+			record.Headers[name] = value
+		}
+	}
+	return record
+}
+
+// runReplay implements the "replay" subcommand: it re-issues a recording against another
+// instance, keeping the original gaps between requests (divided by -speed), and reports
+// how many answers came back with a different status than when recorded
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := flags.String("file", "", "Recording written via BOOKSTORE_RECORD_FILE")
+	target := flags.String("url", "http://localhost:8080", "Base URL of the instance to replay against")
+	speed := flags.Float64("speed", 1, "Pacing multiplier: 1 keeps the original timing, 10 is ten times faster, 0 sends back to back")
+	timeout := flags.Duration("timeout", 10*time.Second, "Per-request timeout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" || *speed < 0 {
+		fmt.Fprintln(os.Stderr, "replay: -file is required and -speed must not be negative")
+		return 2
+	}
+	base, err := url.Parse(strings.TrimSuffix(*target, "/"))
+	if err != nil || base.Host == "" {
+		fmt.Fprintf(os.Stderr, "replay: invalid -url %q\n", *target)
+		return 2
+	}
+
+	// Read the whole recording first: the target may be the recording instance itself, which
+	// would otherwise keep appending the replayed requests to the file being replayed
+	records, err := readRecording(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	statuses := map[string]int{} // "recorded -> replayed" transitions
+	replayed, mismatched, failed := 0, 0, 0
+	startTime := time.Now()
+
+	for _, record := range records {
+		// Wait until this request's original offset, scaled by speed
+		if *speed > 0 {
+			due := startTime.Add(time.Duration(float64(record.Time.Sub(records[0].Time)) / *speed))
+			time.Sleep(time.Until(due))
+		}
+
+		status, err := replayRequest(client, base.String(), record)
+		replayed++
+		if err != nil {
+			failed++
+			statuses[fmt.Sprintf("%d -> error", record.Status)]++
+			continue
+		}
+		if status != record.Status {
+			mismatched++
+		}
+		statuses[fmt.Sprintf("%d -> %d", record.Status, status)]++
+	}
+
+	fmt.Printf("Replayed %d requests against %s in %v: %d status mismatches, %d errors\n\n",
+		replayed, base, time.Since(startTime).Round(time.Millisecond), mismatched, failed)
+	printReplayStatuses(os.Stdout, statuses)
+	if mismatched > 0 || failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readRecording loads every request in a recording file, skipping malformed lines
+func readRecording(path string) ([]recordedRequest, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	var records []recordedRequest
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		var record recordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			fmt.Fprintf(os.Stderr, "replay: skipping malformed line: %v\n", err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// replayRequest sends one recorded request and returns the status it got
+func replayRequest(client *http.Client, base string, record recordedRequest) (int, error) {
+	request, err := http.NewRequest(record.Method, base+record.Path, nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range record.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	return response.StatusCode, nil
+}
+
+// printReplayStatuses writes the recorded-to-replayed status counts
+func printReplayStatuses(out io.Writer, statuses map[string]int) {
+	transitions := make([]string, 0, len(statuses))
+	for transition := range statuses {
+		transitions = append(transitions, transition)
+	}
+	sort.Strings(transitions)
+
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "recorded -> replayed\tcount")
+	for _, transition := range transitions {
+		fmt.Fprintf(table, "%s\t%d\n", transition, statuses[transition])
+	}
+	table.Flush()
+}
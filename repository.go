@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// The entities a book's stored data is split into, each with its own table and cache TTL
+var repositoryEntities = []string{"metadata", "pricing", "inventory", "reviews"}
+
+// Once the cache holds this many entries, inserts sweep out expired ones
+const maxRepositoryCacheEntries = 50000
+
+var (
+	repositoryCacheHits          = expvar.NewMap("repository_cache_hits")
+	repositoryCacheMisses        = expvar.NewMap("repository_cache_misses")
+	repositoryCacheInvalidations = expvar.NewInt("repository_cache_invalidations")
+	repositoryCacheBypasses      = expvar.NewMap("repository_cache_bypasses")
+)
+
+// Longest inventory TTL allowed: stock moves with every sale, so a cached quantity must be
+// gone before anyone could act on it
+const maxInventoryCacheTTL = time.Second
+
+// repositoryBypassKey marks a context whose reads skip the repository cache
+type repositoryBypassKey struct{}
+
+// withoutRepositoryCache returns a context whose book reads go to the database, for reads that
+// must see the stock as it is right now, such as checkout or an operator about to restock. What
+// they read still replaces the cached entry.
+func withoutRepositoryCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, repositoryBypassKey{}, true)
+}
+
+// BookRepository reads the stored sections of a book
+type BookRepository interface {
+	Metadata(ctx context.Context, bookID string) (BookMetadata, error)
+	Pricing(ctx context.Context, bookID string) (BookPricing, error)
+	Inventory(ctx context.Context, bookID string) (BookInventory, error)
+	Reviews(ctx context.Context, bookID string) (BookReviews, error)
+}
+
+// bookRepository is what the FetchBook* functions read through. NewServer wraps the database in
+// a cache; subcommands read the database directly.
+var bookRepository BookRepository = sqliteBookRepository{}
+
+// sqliteBookRepository reads each entity from its table
+type sqliteBookRepository struct{}
+
+func (sqliteBookRepository) Metadata(ctx context.Context, bookID string) (BookMetadata, error) {
+	return readBookMetadata(ctx, bookID)
+}
+
+func (sqliteBookRepository) Pricing(ctx context.Context, bookID string) (BookPricing, error) {
+	return readBookPricing(ctx, bookID)
+}
+
+func (sqliteBookRepository) Inventory(ctx context.Context, bookID string) (BookInventory, error) {
+	return readBookInventory(ctx, bookID)
+}
+
+func (sqliteBookRepository) Reviews(ctx context.Context, bookID string) (BookReviews, error) {
+	return readBookReviews(ctx, bookID)
+}
+
+// repositoryCacheEntry is an entity as read from the database and when
+type repositoryCacheEntry struct {
+	value    interface{}
+	storedAt time.Time
+}
+
+// cachedBookRepository keeps what another repository returned for a TTL chosen per entity, so a
+// slow aggregate that rarely changes (reviews) can be reused for minutes while stock, which moves
+// with every sale, is reused for under a second or not at all. Writes in this process drop the
+// entries they affect through invalidateBookRepository as they commit. Writes by other instances
+// are only seen when the change hub next polls the change log, so they can be served stale for up
+// to catalogHubPollInterval (500ms) plus the poll itself, and for the whole TTL if the hub is
+// stopped or falling behind. Keys go through cacheKey, so a cache generation bump flushes it
+// along with the others.
+type cachedBookRepository struct {
+	next BookRepository
+	ttls func() map[string]time.Duration // Read from config on use, so reloads apply
+
+	mu          sync.Mutex
+	entries     map[string]repositoryCacheEntry
+	seq         uint64            // Moves on with every invalidation
+	invalidated map[string]uint64 // seq of each book's last invalidation, so a read that raced one isn't stored
+	floor       uint64            // invalidated forgets everything up to this; reads started before it aren't stored
+}
+
+// newCachedBookRepository wraps next in a cache with the TTLs ttls returns
+func newCachedBookRepository(next BookRepository, ttls func() map[string]time.Duration) *cachedBookRepository {
+	return &cachedBookRepository{next: next, ttls: ttls, entries: map[string]repositoryCacheEntry{}, invalidated: map[string]uint64{}}
+}
+
+func (c *cachedBookRepository) Metadata(ctx context.Context, bookID string) (BookMetadata, error) {
+	return readThrough(c, ctx, "metadata", bookID, c.next.Metadata)
+}
+
+func (c *cachedBookRepository) Pricing(ctx context.Context, bookID string) (BookPricing, error) {
+	return readThrough(c, ctx, "pricing", bookID, c.next.Pricing)
+}
+
+func (c *cachedBookRepository) Inventory(ctx context.Context, bookID string) (BookInventory, error) {
+	return readThrough(c, ctx, "inventory", bookID, c.next.Inventory)
+}
+
+func (c *cachedBookRepository) Reviews(ctx context.Context, bookID string) (BookReviews, error) {
+	return readThrough(c, ctx, "reviews", bookID, c.next.Reviews)
+}
+
+// readThrough answers from the cache while the entry is younger than the entity's TTL, unless
+// ctx bypasses it, and otherwise reads and stores the result. Failures, a missing row included,
+// are never stored.
+func readThrough[T any](c *cachedBookRepository, ctx context.Context, entity, bookID string, read func(context.Context, string) (T, error)) (T, error) {
+	ttl := c.ttls()[entity]
+	if ttl <= 0 {
+		return read(ctx, bookID)
+	}
+	key := cacheKey("repository", entity, bookID)
+	bypass, _ := ctx.Value(repositoryBypassKey{}).(bool)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	started := c.seq
+	c.mu.Unlock()
+	switch {
+	case bypass:
+		repositoryCacheBypasses.Add(entity, 1)
+	case ok && clock.Now().Sub(entry.storedAt) < ttl:
+		repositoryCacheHits.Add(entity, 1)
+		value, _ := entry.value.(T)
+		return value, nil
+	default:
+		repositoryCacheMisses.Add(entity, 1)
+	}
+	storedAt := clock.Now()
+	value, err := read(ctx, bookID)
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if started < c.floor || c.invalidated[bookID] > started {
+		// The book was written while this read ran; the value may predate it
+		return value, nil
+	}
+	if len(c.entries) >= maxRepositoryCacheEntries {
+		c.sweep()
+	}
+	c.entries[key] = repositoryCacheEntry{value: value, storedAt: storedAt}
+	return value, nil
+}
+
+// sweep drops expired entries, and arbitrary ones if that isn't enough to stay bounded. The
+// caller holds c.mu.
+func (c *cachedBookRepository) sweep() {
+	longest := maxTTL(c.ttls())
+	for key, entry := range c.entries {
+		if clock.Now().Sub(entry.storedAt) >= longest {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < maxRepositoryCacheEntries {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
+
+// Invalidate drops a book's cached entities, all of them when none are named
+func (c *cachedBookRepository) Invalidate(bookID string, entities ...string) {
+	if len(entities) == 0 {
+		entities = repositoryEntities
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	if len(c.invalidated) >= maxRepositoryCacheEntries {
+		c.invalidated = map[string]uint64{}
+		c.floor = c.seq
+	}
+	c.invalidated[bookID] = c.seq
+	for _, entity := range entities {
+		delete(c.entries, cacheKey("repository", entity, bookID))
+	}
+	repositoryCacheInvalidations.Add(1)
+}
+
+// invalidateBookRepository is called after a write to a book's tables commits, so the next read
+// in this process sees it, and by the change hub for writes by anyone. Name the entities
+// written, or none for all of them.
+func invalidateBookRepository(bookID string, entities ...string) {
+	if cached, ok := bookRepository.(*cachedBookRepository); ok {
+		cached.Invalidate(bookID, entities...)
+	}
+}
+
+// repositoryCacheWindow is how long another instance may go on serving an entity after it
+// changed: the longest TTL in use
+func repositoryCacheWindow() time.Duration {
+	if _, ok := bookRepository.(*cachedBookRepository); !ok {
+		return 0
+	}
+	return maxTTL(config.RepositoryCacheTTLs)
+}
+
+// repositoryEntityForChange maps a change log entity to the repository entity it alters, if any
+func repositoryEntityForChange(changeEntity string) (string, bool) {
+	switch changeEntity {
+	case "book":
+		return "metadata", true
+	case "pricing", "inventory", "reviews":
+		return changeEntity, true
+	}
+	return "", false
+}
+
+// maxTTL returns the longest of ttls
+func maxTTL(ttls map[string]time.Duration) time.Duration {
+	longest := time.Duration(0)
+	for _, ttl := range ttls {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
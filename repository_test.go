@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowPricingRepository answers Pricing with the current price, holding the first read until
+// release is closed
+type slowPricingRepository struct {
+	BookRepository
+	mu      sync.Mutex
+	price   float64
+	reads   int
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (r *slowPricingRepository) Pricing(ctx context.Context, bookID string) (BookPricing, error) {
+	r.mu.Lock()
+	r.reads++
+	first := r.reads == 1
+	price := r.price
+	r.mu.Unlock()
+	if first {
+		close(r.entered)
+		<-r.release
+	}
+	return BookPricing{Price: price, Currency: "USD"}, nil
+}
+
+func TestRepositoryReadRacingInvalidationIsNotCached(t *testing.T) {
+	newTestServer(t)
+	slow := &slowPricingRepository{price: 10, entered: make(chan struct{}), release: make(chan struct{})}
+	cached := newCachedBookRepository(slow, func() map[string]time.Duration {
+		return map[string]time.Duration{"pricing": time.Minute}
+	})
+	previous := bookRepository
+	bookRepository = cached
+	t.Cleanup(func() { bookRepository = previous })
+	ctx := context.Background()
+
+	// The load reads the old price, then the write commits and invalidates before it finishes
+	loaded := make(chan BookPricing)
+	go func() {
+		pricing, err := cached.Pricing(ctx, "1")
+		if err != nil {
+			t.Errorf("racing read: %v", err)
+		}
+		loaded <- pricing
+	}()
+	<-slow.entered
+	slow.mu.Lock()
+	slow.price = 12
+	slow.mu.Unlock()
+	invalidateBookRepository("1", "pricing")
+	close(slow.release)
+	if pricing := <-loaded; pricing.Price != 10 {
+		t.Fatalf("racing read returned %v, want the 10 it read", pricing.Price)
+	}
+
+	pricing, err := cached.Pricing(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pricing.Price != 12 || slow.reads != 2 {
+		t.Fatalf("read after the invalidation = %v from %d reads, want 12 read fresh", pricing.Price, slow.reads)
+	}
+
+	// With nothing racing it, that read is cached
+	if pricing, err := cached.Pricing(ctx, "1"); err != nil || pricing.Price != 12 || slow.reads != 2 {
+		t.Fatalf("repeat read = %v from %d reads (%v), want 12 from the cache", pricing.Price, slow.reads, err)
+	}
+}
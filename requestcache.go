@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"sync"
+)
+
+const requestCacheKey contextKey = "request_cache"
+
+// Reads answered from the request cache instead of the database, by read class
+var requestCacheHits = expvar.NewMap("request_cache_hits")
+
+// requestCache memoizes database reads for the lifetime of one request, so sections that
+// need the same row share a single query. Concurrent callers of the same read wait for the
+// first one rather than issuing their own.
+type requestCache struct {
+	mu      sync.Mutex
+	entries map[string]*requestCacheEntry
+}
+
+type requestCacheEntry struct {
+	done  chan struct{} // Closed once value and err are set
+	value interface{}
+	err   error
+}
+
+// withRequestCache returns a context whose reads are memoized until the request ends. Handlers
+// opt in; long polls such as availability re-read on purpose and must not use it.
+func withRequestCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestCacheKey, &requestCache{entries: map[string]*requestCacheEntry{}})
+}
+
+// requestCached runs read once per class and book within a request carrying a request cache,
+// and on every call otherwise. Failures other than a missing row aren't kept, so a later
+// caller tries again; callers already waiting get the failure.
+func requestCached[T any](ctx context.Context, class, bookID string, read func() (T, error)) (T, error) {
+	cache, ok := ctx.Value(requestCacheKey).(*requestCache)
+	if !ok {
+		return read()
+	}
+	key := class + ":" + bookID
+
+	cache.mu.Lock()
+	if entry, ok := cache.entries[key]; ok {
+		cache.mu.Unlock()
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+		requestCacheHits.Add(class, 1)
+		value, _ := entry.value.(T)
+		return value, entry.err
+	}
+	entry := &requestCacheEntry{done: make(chan struct{})}
+	cache.entries[key] = entry
+	cache.mu.Unlock()
+
+	value, err := read()
+	entry.value, entry.err = value, err
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		cache.mu.Lock()
+		delete(cache.entries, key)
+		cache.mu.Unlock()
+	}
+	close(entry.done)
+	return value, err
+}
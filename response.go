@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Clients can ask for the standard envelope per request with this Accept media type
+const envelopeMediaType = "application/vnd.bookstore.envelope+json"
+
+// responseEnvelope is the optional standard wrapper for JSON responses
+type responseEnvelope struct {
+	Data  interface{}    `json:"data"`  // null on errors
+	Error *envelopeError `json:"error"` // null on success
+	Meta  envelopeMeta   `json:"meta"`
+}
+
+// envelopeError describes a failed request inside the envelope
+type envelopeError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// envelopeMeta carries request-level information inside the envelope
+type envelopeMeta struct {
+	RequestID   string    `json:"request_id,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// wantsPretty reports whether the client opted into indented JSON with ?pretty=1
+func wantsPretty(r *http.Request) bool {
+	switch r.URL.Query().Get("pretty") {
+	case "1", "true":
+		return true
+	}
+	return false
+}
+
+// wantsEnvelope reports whether the response should use the standard envelope, either because
+// it is enabled service-wide or because the client asked for it via Accept
+func wantsEnvelope(r *http.Request) bool {
+	return config.ResponseEnvelope || strings.Contains(r.Header.Get("Accept"), envelopeMediaType)
+}
+
+// writeJSON sends a value as a JSON response with the given status code, compact unless the
+// client asked for ?pretty=1 and wrapped in the envelope when negotiated
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}) {
+	if wantsEnvelope(r) {
+		value = responseEnvelope{Data: value, Meta: newEnvelopeMeta(r)}
+	}
+	encodeJSON(w, r, status, value)
+}
+
+// writeError sends an error response: plain text as before, or an envelope with a null data
+// field when the envelope is negotiated
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if !wantsEnvelope(r) {
+		http.Error(w, message, status)
+		return
+	}
+	encodeJSON(w, r, status, responseEnvelope{
+		Error: &envelopeError{Status: status, Message: message},
+		Meta:  newEnvelopeMeta(r),
+	})
+}
+
+// problemDetails is an RFC 9457 application/problem+json body, used where a plain-text error
+// can't say enough
+type problemDetails struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Status     int          `json:"status"`
+	Detail     string       `json:"detail"`
+	Instance   string       `json:"instance,omitempty"`
+	RequestID  string       `json:"request_id,omitempty"`
+	LimitBytes int64        `json:"limit_bytes,omitempty"` // Body size limits
+	Errors     []FieldError `json:"errors,omitempty"`      // Request validation
+	Captcha    string       `json:"captcha,omitempty"`     // CAPTCHA provider to solve before retrying a login
+}
+
+// FieldError points at one invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"` // Dotted path, e.g. "rating" or "items.0.book_id"; empty for the body as a whole
+	Message string `json:"message"`
+}
+
+// writeProblem sends a problem+json error, or an envelope error carrying the detail when the
+// envelope is negotiated
+func writeProblem(w http.ResponseWriter, r *http.Request, problem problemDetails) {
+	if wantsEnvelope(r) {
+		writeError(w, r, problem.Status, problem.Detail)
+		return
+	}
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	problem.Instance = r.URL.Path
+	problem.RequestID = RequestIDFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	writeEncoded(w, r, problem.Status, func(out io.Writer) error {
+		return json.NewEncoder(out).Encode(problem)
+	})
+}
+
+// encodeJSON writes the headers and the encoded value, with timestamps in the ?tz= zone. The
+// status is only sent once the value has encoded, see writeEncoded.
+func encodeJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept") // The envelope depends on Accept
+
+	writeEncoded(w, r, status, func(out io.Writer) error {
+		value = visibleTo(value, audienceFor(r))
+		value = inTimeZone(value, TimeZoneFromContext(r.Context()))
+
+		encoder := json.NewEncoder(out)
+		if wantsPretty(r) {
+			encoder.SetIndent("", "  ")
+		}
+		return encoder.Encode(value)
+	})
+}
+
+// newEnvelopeMeta builds the meta block for the current request
+func newEnvelopeMeta(r *http.Request) envelopeMeta {
+	return envelopeMeta{
+		RequestID:   RequestIDFromContext(r.Context()),
+		GeneratedAt: clock.Now().UTC(),
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Search tuning. A query word "matches" a field word when their similarity reaches
+// searchTokenThreshold; a book is returned when its best weighted field score reaches
+// searchMinScore.
+const (
+	searchTokenThreshold = 0.7
+	searchMinScore       = 0.5
+	searchDefaultLimit   = 20
+	searchMaxLimit       = 100
+)
+
+// Relative weight of a match in each searchable field
+var searchFieldWeights = map[string]float64{
+	"title":       1.0,
+	"author":      0.8,
+	"description": 0.5,
+}
+
+// SearchResult is one ranked hit from the search endpoint
+type SearchResult struct {
+	Book
+	Score      float64           `json:"score"`      // Relevance in (0, 1], higher is better
+	Highlights map[string]string `json:"highlights"` // Matched fields with matching words wrapped in <em>, HTML-escaped
+}
+
+// SearchResponse is the body of GET /api/books/search
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Total   int            `json:"total"`
+	Results []SearchResult `json:"results"`
+	Facets  *SearchFacets  `json:"facets,omitempty"` // Counts over all matches, not just the returned page; omitted if aggregation failed
+}
+
+// searchDocument is a book's searchable text
+type searchDocument struct {
+	Book
+	Description string
+}
+
+// SearchHandler handles GET /api/books/search?q=...&limit=N with typo-tolerant matching
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, r, http.StatusBadRequest, "Query parameter 'q' is required")
+		return
+	}
+
+	limit := searchDefaultLimit
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 || parsed > searchMaxLimit {
+			writeError(w, r, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := searchIndex.Search(r.Context(), query)
+	if err != nil && searchIndex.Name() != "sqlite" {
+		// An external index being down shouldn't take search with it
+		log.Printf("Error searching %s index for %q, falling back to sqlite: %v", searchIndex.Name(), query, err)
+		results, err = SearchBooks(r.Context(), query)
+	}
+	if err != nil {
+		log.Printf("Error searching books for %q: %v", query, err)
+		writeError(w, r, http.StatusInternalServerError, "Search failed")
+		return
+	}
+
+	// Restricted books are left out before anything is counted, so facets don't give them away
+	country := requestCountry(w, r)
+	visible := make([]SearchResult, 0, len(results))
+	for _, result := range results {
+		if localizeBook(&result.Book, country) {
+			visible = append(visible, result)
+		}
+	}
+	results = visible
+
+	response := SearchResponse{Query: query, Total: len(results)}
+
+	// Facets describe every match so the UI's filter counts don't change with the page size
+	bookIDs := make([]string, len(results))
+	for i, result := range results {
+		bookIDs[i] = result.ID
+	}
+	if facets, err := ComputeSearchFacets(r.Context(), bookIDs); err != nil {
+		log.Printf("Error computing search facets for %q: %v", query, err)
+	} else {
+		response.Facets = &facets
+	}
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	response.Results = results
+
+	writeJSON(w, r, http.StatusOK, response)
+	logRequest(r, "search", "Search for %q returned %d of %d results", query, len(results), response.Total)
+}
+
+// SearchBooks ranks every book against the query, tolerating misspellings, best match first
+func SearchBooks(ctx context.Context, query string) ([]SearchResult, error) {
+	documents, err := loadSearchDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queryTokens := tokenize(query)
+	results := []SearchResult{}
+	for _, document := range documents {
+		if result, ok := scoreDocument(document, queryTokens); ok {
+			results = append(results, result)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+	return results, nil
+}
+
+// loadSearchDocuments reads the searchable fields for every book
+func loadSearchDocuments(ctx context.Context) ([]searchDocument, error) {
+	rows, err := dbFor(ctx).QueryContext(ctx, `
+		SELECT b.id, b.title, b.author, COALESCE(b.description, ''), COALESCE(p.price, 0)
+		FROM books b
+		LEFT JOIN pricing p ON p.book_id = b.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var documents []searchDocument
+	for rows.Next() {
+		var document searchDocument
+		if err := rows.Scan(&document.ID, &document.Title, &document.Author, &document.Description, &document.Price); err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	return documents, rows.Err()
+}
+
+// scoreDocument computes a document's relevance and highlights; ok is false when it doesn't match
+func scoreDocument(document searchDocument, queryTokens []string) (SearchResult, bool) {
+	fields := map[string]string{
+		"title":       document.Title,
+		"author":      document.Author,
+		"description": document.Description,
+	}
+
+	result := SearchResult{Book: document.Book, Highlights: map[string]string{}}
+	for name, text := range fields {
+		score, matched := scoreField(queryTokens, text)
+		if len(matched) == 0 {
+			continue
+		}
+		result.Highlights[name] = highlight(text, matched)
+		if weighted := score * searchFieldWeights[name]; weighted > result.Score {
+			result.Score = weighted
+		}
+	}
+
+	result.Score = math.Round(result.Score*1000) / 1000
+	return result, result.Score >= searchMinScore
+}
+
+// scoreField averages, over the query words, the similarity of the closest word in the field.
+// It also returns the field words that matched, for highlighting.
+func scoreField(queryTokens []string, text string) (float64, map[string]bool) {
+	fieldTokens := tokenize(text)
+	matched := map[string]bool{}
+	if len(queryTokens) == 0 || len(fieldTokens) == 0 {
+		return 0, matched
+	}
+
+	total := 0.0
+	for _, queryToken := range queryTokens {
+		best, bestToken := 0.0, ""
+		for _, fieldToken := range fieldTokens {
+			if similarity := tokenSimilarity(queryToken, fieldToken); similarity > best {
+				best, bestToken = similarity, fieldToken
+			}
+		}
+		if best >= searchTokenThreshold {
+			total += best
+			matched[bestToken] = true
+		}
+	}
+	return total / float64(len(queryTokens)), matched
+}
+
+// tokenSimilarity scores two lowercase words in [0, 1] using edit distance, treating a
+// query word that is a prefix of the field word (search-as-you-type) as a strong match
+func tokenSimilarity(queryToken, fieldToken string) float64 {
+	if queryToken == fieldToken {
+		return 1
+	}
+	if len([]rune(queryToken)) >= 3 && strings.HasPrefix(fieldToken, queryToken) {
+		return 0.9
+	}
+
+	a, b := []rune(queryToken), []rune(fieldToken)
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// levenshtein returns the edit distance between two words, counting a swap of adjacent
+// letters ("desing" for "design") as a single edit (optimal string alignment distance)
+func levenshtein(a, b []rune) int {
+	beforePrevious := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = min(current[j], beforePrevious[j-2]+1)
+			}
+		}
+		beforePrevious, previous, current = previous, current, beforePrevious
+	}
+	return previous[len(b)]
+}
+
+// tokenize lowercases text and splits it into words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// highlight HTML-escapes text and wraps the matched words in <em> tags
+func highlight(text string, matched map[string]bool) string {
+	var builder strings.Builder
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			builder.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+			end++
+		}
+		word := string(runes[i:end])
+		if matched[strings.ToLower(word)] {
+			builder.WriteString("<em>" + html.EscapeString(word) + "</em>")
+		} else {
+			builder.WriteString(html.EscapeString(word))
+		}
+		i = end
+	}
+	return builder.String()
+}
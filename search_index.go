@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// SearchIndex answers catalog searches. Results come back ranked best first with
+// scores normalized to (0, 1] and highlights HTML-escaped with matches in <em>.
+type SearchIndex interface {
+	Name() string
+	Search(ctx context.Context, query string) ([]SearchResult, error)
+	// Reindex rebuilds the index from the catalog; a no-op for backends that read it directly
+	Reindex(ctx context.Context) error
+}
+
+// Known search backends, selectable by name via BOOKSTORE_SEARCH_BACKEND
+var searchIndexRegistry = map[string]func(cfg Config) SearchIndex{
+	"sqlite": func(cfg Config) SearchIndex { return sqliteSearchIndex{} },
+	"elasticsearch": func(cfg Config) SearchIndex {
+		return &elasticsearchIndex{baseURL: cfg.ElasticsearchURL, index: cfg.ElasticsearchIndex}
+	},
+}
+
+// Backend used by the search endpoint, built from config in main
+var searchIndex = NewSearchIndex(config)
+
+// NewSearchIndex instantiates the configured backend, falling back to sqlite for unknown names
+func NewSearchIndex(cfg Config) SearchIndex {
+	constructor, ok := searchIndexRegistry[cfg.SearchBackend]
+	if !ok {
+		log.Printf("Unknown search backend %q, using sqlite", cfg.SearchBackend)
+		constructor = searchIndexRegistry["sqlite"]
+	}
+	return constructor(cfg)
+}
+
+// StartSearchIndexer builds the index once and then rebuilds it every interval until ctx is done.
+// Catalog writes don't publish change events, so a periodic rebuild is what keeps an external
+// index in sync. Backends that read the catalog directly need no indexer.
+func StartSearchIndexer(ctx context.Context, index SearchIndex, interval time.Duration) {
+	if _, direct := index.(sqliteSearchIndex); direct {
+		return
+	}
+
+	runPeriodically(ctx, index.Name()+" search index rebuild", interval, index.Reindex)
+}
+
+// sqliteSearchIndex scores every book in process straight from the database.
+// It needs no indexing and is fine for catalogs of a few thousand books.
+type sqliteSearchIndex struct{}
+
+// Name implements SearchIndex
+func (sqliteSearchIndex) Name() string { return "sqlite" }
+
+// Search implements SearchIndex
+func (sqliteSearchIndex) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	return SearchBooks(ctx, query)
+}
+
+// Reindex implements SearchIndex
+func (sqliteSearchIndex) Reindex(ctx context.Context) error { return nil }
+
+// elasticsearchIndex searches an Elasticsearch (or OpenSearch) index over its REST API
+type elasticsearchIndex struct {
+	baseURL string // e.g. http://localhost:9200
+	index   string
+}
+
+// Name implements SearchIndex
+func (e *elasticsearchIndex) Name() string { return "elasticsearch" }
+
+// elasticsearchDocument is the indexed form of a book
+type elasticsearchDocument struct {
+	Title       string  `json:"title"`
+	Author      string  `json:"author"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+// Reindex implements SearchIndex by bulk-upserting every book
+func (e *elasticsearchIndex) Reindex(ctx context.Context) error {
+	documents, err := loadSearchDocuments(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The bulk API takes newline-delimited action/document pairs
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, document := range documents {
+		action := map[string]interface{}{"index": map[string]string{"_index": e.index, "_id": document.ID}}
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(elasticsearchDocument{
+			Title:       document.Title,
+			Author:      document.Author,
+			Description: document.Description,
+			Price:       document.Price,
+		}); err != nil {
+			return err
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	var response struct {
+		Errors bool `json:"errors"`
+	}
+	if err := e.post(ctx, "/_bulk?refresh=true", "application/x-ndjson", &body, &response); err != nil {
+		return err
+	}
+	if response.Errors {
+		return fmt.Errorf("elasticsearch rejected some documents")
+	}
+	return nil
+}
+
+// Search implements SearchIndex with a fuzzy multi-field query weighted like the sqlite backend
+func (e *elasticsearchIndex) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	request := map[string]interface{}{
+		"size": searchMaxLimit,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":     query,
+				"fields":    []string{"title^2", "author^1.6", "description"},
+				"fuzziness": "AUTO",
+			},
+		},
+		"highlight": map[string]interface{}{
+			"encoder":             "html",
+			"pre_tags":            []string{"<em>"},
+			"post_tags":           []string{"</em>"},
+			"number_of_fragments": 0, // Highlight the whole field, like the sqlite backend
+			"fields": map[string]interface{}{
+				"title": map[string]interface{}{}, "author": map[string]interface{}{}, "description": map[string]interface{}{},
+			},
+		},
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Hits struct {
+			MaxScore float64 `json:"max_score"`
+			Hits     []struct {
+				ID        string                `json:"_id"`
+				Score     float64               `json:"_score"`
+				Source    elasticsearchDocument `json:"_source"`
+				Highlight map[string][]string   `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := e.post(ctx, "/"+e.index+"/_search", "application/json", bytes.NewReader(payload), &response); err != nil {
+		return nil, err
+	}
+
+	results := []SearchResult{}
+	for _, hit := range response.Hits.Hits {
+		result := SearchResult{
+			Book:       Book{ID: hit.ID, Title: hit.Source.Title, Author: hit.Source.Author, Price: hit.Source.Price},
+			Highlights: map[string]string{},
+		}
+		// Elasticsearch scores are unbounded; scale them relative to the best hit
+		if response.Hits.MaxScore > 0 {
+			result.Score = math.Round(hit.Score/response.Hits.MaxScore*1000) / 1000
+		}
+		for field, fragments := range hit.Highlight {
+			if len(fragments) > 0 {
+				result.Highlights[field] = fragments[0]
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// post sends a request to Elasticsearch and decodes a successful JSON response into target
+func (e *elasticsearchIndex) post(ctx context.Context, path, contentType string, body io.Reader, target interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("elasticsearch %s returned status %d", path, response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Rows inserted per transaction when generating a catalog
+const seedBatchSize = 5000
+
+// Word lists the generator draws from
+var (
+	seedFirstNames = []string{"Ada", "Alan", "Amara", "Ben", "Carla", "Chen", "Daniel", "Elena", "Farah", "Grace",
+		"Hiro", "Imani", "Jonas", "Karin", "Liam", "Maya", "Nikhil", "Olga", "Priya", "Quinn",
+		"Rosa", "Samuel", "Tariq", "Uma", "Victor", "Wen", "Ximena", "Yusuf", "Zara", "Mateo"}
+	seedLastNames = []string{"Adams", "Baker", "Castillo", "Dubois", "Eriksen", "Fischer", "Garcia", "Hughes", "Ito", "Jensen",
+		"Kowalski", "Larsen", "Moreau", "Nakamura", "Okafor", "Patel", "Quint", "Rossi", "Schmidt", "Tanaka",
+		"Ueda", "Varga", "Walsh", "Xu", "Yilmaz", "Zhang", "Novak", "Silva", "Murphy", "Lindqvist"}
+	seedAdjectives = []string{"Quiet", "Hidden", "Practical", "Last", "Infinite", "Broken", "Modern", "Silent", "Wild", "Golden",
+		"Forgotten", "Distributed", "Gentle", "Restless", "Essential", "Burning", "Lost", "Clever", "Patient", "Northern"}
+	seedNouns = []string{"River", "Algorithm", "Garden", "Empire", "Machine", "Ocean", "Compiler", "Harvest", "Signal", "City",
+		"Mountain", "Protocol", "Memory", "Kingdom", "Network", "Orchard", "Lighthouse", "Archive", "Market", "Horizon"}
+	seedTopics = []string{"Concurrency", "Leadership", "Cooking", "Economics", "Gardening", "Philosophy", "Databases", "Sleep",
+		"Negotiation", "Astronomy", "Design", "Statistics", "Running", "Writing", "Habits", "Photography"}
+	seedGenres     = []string{"novel", "memoir", "field guide", "history", "handbook", "thriller", "essay collection", "textbook"}
+	seedPromotions = []string{"Holiday Sale", "Member Discount", "Limited Time", "Clearance", "Staff Pick", "Back to School"}
+	seedWarehouses = []string{"East Coast DC", "Central DC", "West Coast DC"}
+	seedReviews    = []string{"Couldn't put it down", "Solid and well researched", "A bit long in the middle",
+		"Exactly what I needed", "Beautifully written", "Not what I expected", "Recommended it to the whole team",
+		"Clear explanations throughout", "Great gift", "Dense but rewarding"}
+)
+
+// generatedBook is one fabricated catalog entry across the four catalog tables
+type generatedBook struct {
+	ID, Title, Author, ISBN, PublishDate, Description string
+
+	Price, Discount, SalePrice float64
+	Promotion                  string
+
+	InStock   bool
+	Quantity  int
+	Warehouse string
+
+	TotalReviews  int
+	Stars         [5]int // Index 0 is one star
+	AverageRating *float64
+	RecentReview  *string
+}
+
+// runSeed implements the "seed" subcommand. With -generate N it appends N fabricated books,
+// with pricing, inventory and reviews, to the configured database, numbering them after the
+// highest existing ID.
+func runSeed(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	generate := flags.Int("generate", 0, "Number of books to fabricate")
+	seed := flags.Int64("seed", 1, "Random seed; the same seed and starting ID produce the same catalog")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *generate <= 0 {
+		fmt.Fprintln(os.Stderr, "seed: -generate must be a positive number of books")
+		return 2
+	}
+
+	var err error
+	if config, err = LoadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "seed: invalid configuration: %v\n", err)
+		return 1
+	}
+	// A bulk import only gets the batch share of connections, leaving the rest to a running server
+	if db, err = OpenDatabase(config.DatabasePath, config.DatabaseBatchConns); err != nil {
+		fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+		return 1
+	}
+	defer CloseDatabase()
+	if err := initializeDatabaseIfNeeded(); err != nil {
+		fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+		return 1
+	}
+
+	startTime := time.Now()
+	if err := generateCatalog(*generate, rand.New(rand.NewSource(*seed))); err != nil {
+		fmt.Fprintf(os.Stderr, "seed: %v\n", err)
+		return 1
+	}
+	log.Printf("Generated %d books in %v", *generate, time.Since(startTime).Round(time.Millisecond))
+	return 0
+}
+
+// generateCatalog inserts count books in batches, each batch in one transaction
+func generateCatalog(count int, rng *rand.Rand) error {
+	var lastID int
+	if err := db.QueryRow("SELECT COALESCE(MAX(CAST(id AS INTEGER)), 0) FROM books").Scan(&lastID); err != nil {
+		return err
+	}
+
+	for inserted := 0; inserted < count; {
+		batch := make([]generatedBook, 0, min(seedBatchSize, count-inserted))
+		for len(batch) < cap(batch) {
+			lastID++
+			batch = append(batch, fabricateBook(lastID, rng))
+		}
+		if err := insertGeneratedBooks(batch); err != nil {
+			return err
+		}
+		inserted += len(batch)
+		log.Printf("Inserted %d/%d books", inserted, count)
+	}
+	return nil
+}
+
+// insertGeneratedBooks writes one batch with prepared statements inside a transaction
+func insertGeneratedBooks(batch []generatedBook) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := map[string]string{
+		"books":     "INSERT INTO books (id, title, author, isbn, publish_date, description) VALUES (?, ?, ?, ?, ?, ?)",
+		"pricing":   "INSERT INTO pricing (book_id, price, discount, sale_price, promotion) VALUES (?, ?, ?, ?, ?)",
+		"inventory": "INSERT INTO inventory (book_id, in_stock, quantity, warehouse, shipping_time) VALUES (?, ?, ?, ?, ?)",
+		"reviews": `INSERT INTO reviews (book_id, average_rating, total_reviews, recent_review, five_star, four_star, three_star, two_star, one_star)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	}
+	prepared := map[string]*sql.Stmt{}
+	for table, query := range statements {
+		statement, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+		prepared[table] = statement
+	}
+
+	for _, book := range batch {
+		if _, err := prepared["books"].Exec(book.ID, book.Title, book.Author, book.ISBN, book.PublishDate, book.Description); err != nil {
+			return fmt.Errorf("book %s: %w", book.ID, err)
+		}
+		if _, err := prepared["pricing"].Exec(book.ID, book.Price, book.Discount, book.SalePrice, book.Promotion); err != nil {
+			return fmt.Errorf("pricing for book %s: %w", book.ID, err)
+		}
+		shippingTime := ShippingTimeSummary(book.Warehouse, !book.InStock)
+		if _, err := prepared["inventory"].Exec(book.ID, book.InStock, book.Quantity, book.Warehouse, shippingTime); err != nil {
+			return fmt.Errorf("inventory for book %s: %w", book.ID, err)
+		}
+		stars := book.Stars
+		if _, err := prepared["reviews"].Exec(book.ID, book.AverageRating, book.TotalReviews, book.RecentReview,
+			stars[4], stars[3], stars[2], stars[1], stars[0]); err != nil {
+			return fmt.Errorf("reviews for book %s: %w", book.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// fabricateBook makes up one book with plausible distributions: log-normal prices with
+// occasional sales, mostly in-stock inventory, heavy-tailed review counts, and ratings that
+// cluster around four stars
+func fabricateBook(id int, rng *rand.Rand) generatedBook {
+	pick := func(words []string) string { return words[rng.Intn(len(words))] }
+
+	book := generatedBook{ID: strconv.Itoa(id), Author: pick(seedFirstNames) + " " + pick(seedLastNames)}
+	switch rng.Intn(4) {
+	case 0:
+		book.Title = "The " + pick(seedAdjectives) + " " + pick(seedNouns)
+	case 1:
+		book.Title = pick(seedNouns) + " of the " + pick(seedAdjectives) + " " + pick(seedNouns)
+	case 2:
+		book.Title = "A Short Guide to " + pick(seedTopics)
+	default:
+		book.Title = pick(seedTopics) + " and the " + pick(seedNouns)
+	}
+	book.Description = fmt.Sprintf("A %s %s about %s, %s and the %s.",
+		lowerFirst(pick(seedAdjectives)), pick(seedGenres), lowerFirst(pick(seedTopics)), lowerFirst(pick(seedTopics)), lowerFirst(pick(seedNouns)))
+	book.ISBN = fabricateISBN(id)
+
+	// Most books are recent; the tail reaches back to 1950
+	age := min(int(rng.ExpFloat64()*8), 75)
+	published := time.Date(2025-age, time.Month(1+rng.Intn(12)), 1+rng.Intn(28), 0, 0, 0, 0, time.UTC)
+	book.PublishDate = published.Format("2006-01-02")
+
+	// Prices center around $22, always ending in .99
+	book.Price = math.Floor(min(max(math.Exp(rng.NormFloat64()*0.45+math.Log(22)), 4), 149)) + 0.99
+	book.SalePrice = book.Price
+	if rng.Float64() < 0.3 {
+		book.Discount = []float64{0.05, 0.10, 0.15, 0.20, 0.25, 0.30, 0.40}[rng.Intn(7)]
+		book.SalePrice = math.Round(book.Price*(1-book.Discount)*100) / 100
+		book.Promotion = pick(seedPromotions)
+	}
+
+	book.InStock = rng.Float64() < 0.85
+	if book.InStock {
+		book.Quantity = 1 + int(rng.ExpFloat64()*30)
+		book.Warehouse = pick(seedWarehouses)
+	} else {
+		book.Warehouse = "Back Order"
+	}
+
+	// A few bestsellers collect thousands of reviews; most books get a handful
+	book.TotalReviews = min(int(5*math.Pow(1-rng.Float64(), -1.2))-5, 20000)
+	if book.TotalReviews > 0 {
+		quality := min(max(rng.NormFloat64()*0.4+4.0, 1), 5)
+		book.Stars = starCounts(book.TotalReviews, quality)
+		sum := 0
+		for star, count := range book.Stars {
+			sum += (star + 1) * count
+		}
+		average := math.Round(float64(sum)/float64(book.TotalReviews)*10) / 10
+		recent := pick(seedReviews)
+		book.AverageRating, book.RecentReview = &average, &recent
+	}
+	return book
+}
+
+// starCounts spreads total ratings over one to five stars around a book's quality, keeping
+// the exact total
+func starCounts(total int, quality float64) [5]int {
+	var weights [5]float64
+	weightSum := 0.0
+	for star := range weights {
+		distance := float64(star+1) - quality
+		weights[star] = math.Exp(-distance * distance / 1.6)
+		weightSum += weights[star]
+	}
+
+	var counts [5]int
+	assigned := 0
+	for star := range counts {
+		counts[star] = int(float64(total) * weights[star] / weightSum)
+		assigned += counts[star]
+	}
+	// Rounding leftovers go to the most likely rating
+	best := 0
+	for star := range weights {
+		if weights[star] > weights[best] {
+			best = star
+		}
+	}
+	counts[best] += total - assigned
+	return counts
+}
+
+// fabricateISBN builds a valid, unique ISBN-13 from the book ID in the 979-8 range, formatted
+// like the seed data (prefix, hyphen, remaining ten digits)
+func fabricateISBN(id int) string {
+	digits := fmt.Sprintf("9798%08d", id%100000000)
+	sum := 0
+	for i, digit := range digits {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(digit-'0') * weight
+	}
+	return fmt.Sprintf("%s-%s%d", digits[:3], digits[3:], (10-sum%10)%10)
+}
+
+// lowerFirst lowercases the first letter of an ASCII word
+func lowerFirst(word string) string {
+	if word == "" || word[0] < 'A' || word[0] > 'Z' {
+		return word
+	}
+	return string(word[0]+'a'-'A') + word[1:]
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"net/http"
+	"time"
+)
+
+// NewServer wires the whole API around a configuration, an open database and the recommendation
+// providers to race, and returns it as a handler, so an integration test can serve it with
+// httptest.NewServer against OpenMemoryDatabase and a fake provider. A nil providers slice
+// builds them from cfg.RecommendationProviders.
+//
+// Handlers read their dependencies from package globals, which NewServer (re)assigns: build
+// one server per process, or one at a time in tests. Background jobs (search reindexing,
+// embeddings) are not started; main starts them separately.
+func NewServer(cfg Config, database *sql.DB, providers []RecommendationProvider) (http.Handler, error) {
+	config = cfg
+	if providers == nil {
+		providers = NewRecommendationProviders(cfg.RecommendationProviders)
+	}
+	recommendationProviders = providers
+
+	// Rebuild everything derived from config
+	httpClient = NewHTTPClient(cfg)
+	dbBulkhead = NewBulkhead("database", cfg.DatabaseBulkheadSize)
+	externalBulkhead = NewBulkhead("external_api", cfg.ExternalBulkheadSize)
+	searchIndex = NewSearchIndex(cfg)
+	embeddingProvider = NewEmbeddingProvider(cfg)
+	shippingProvider = NewShippingProvider(cfg.ShippingProvider)
+	resolver, err := NewCountryResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	countryResolver = resolver
+	sessionSigningKey = newSessionSigningKey(cfg.SessionSecret)
+	captchaVerifier = NewCaptchaVerifier(cfg)
+	bookRepository = newCachedBookRepository(sqliteBookRepository{}, func() map[string]time.Duration {
+		return config.RepositoryCacheTTLs
+	})
+	ResetLogSampling(cfg)
+	reporter, err := NewErrorReporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	errorReporter = reporter
+
+	// Make sure the schema and seed data exist, then warm the feature flag, price experiment,
+	// regional pricing and storefront caches so the first requests don't hit the database
+	db = database
+	if err := initializeDatabaseIfNeeded(); err != nil {
+		return nil, err
+	}
+	if err := expandSchemaMigrations(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := RunStartupCheck(context.Background(), cfg); err != nil {
+		return nil, err
+	}
+	if err := LoadFeatureFlags(); err != nil {
+		return nil, err
+	}
+	if err := LoadPriceExperiments(); err != nil {
+		return nil, err
+	}
+	if err := LoadRegionalOverrides(); err != nil {
+		return nil, err
+	}
+	if err := LoadStorefronts(); err != nil {
+		return nil, err
+	}
+	if err := SyncCacheVersion(); err != nil {
+		return nil, err
+	}
+	// The repository cache hears of writes by other processes through the change log
+	changeHub.Start()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/books", BooksHandler)                             // Simple books list
+	mux.HandleFunc("/api/books/", BookResourceHandler)                     // Book details, similar books, ratings, shipping
+	mux.HandleFunc("/api/books/compare", CompareHandler)                   // Side-by-side comparison
+	mux.HandleFunc("/api/books/search", SearchHandler)                     // Typo-tolerant search
+	mux.HandleFunc("/api/v2/books/", BookDetailV2Handler)                  // Typed book details
+	mux.HandleFunc("/api/users/", ReadingListsHandler)                     // Reading lists, wishlists, recently viewed, access tokens
+	mux.HandleFunc("/api/shared/lists/", SharedReadingListHandler)         // Public view of a shared list
+	mux.HandleFunc("/api/accounts", AccountsHandler)                       // Sign up
+	mux.HandleFunc("/api/session", SessionHandler)                         // Current session
+	mux.HandleFunc("/api/session/", SessionHandler)                        // Log in and out
+	mux.HandleFunc("/api/changes", ChangesHandler)                         // Catalog change feed for incremental sync
+	mux.HandleFunc("/feeds/", FeedHandler)                                 // Atom feeds of the catalog
+	mux.HandleFunc("/sitemap.xml", SitemapIndexHandler)                    // Sitemap index
+	mux.HandleFunc("/sitemaps/", SitemapPageHandler)                       // Sitemap pages
+	mux.HandleFunc("/api/storefront", StorefrontHandler)                   // Tenant branding and defaults for the front end
+	mux.HandleFunc("/api/admin/storefronts", StorefrontsHandler)           // Storefront list and create
+	mux.HandleFunc("/api/admin/storefronts/", AdminStorefrontHandler)      // Single storefront CRUD
+	mux.HandleFunc("/api/admin/cache/version", CacheVersionHandler)        // Cache version; POST flushes every cache
+	mux.HandleFunc("/api/admin/impersonations", ImpersonationsHandler)     // Active impersonations and start one
+	mux.HandleFunc("/api/admin/impersonations/", ImpersonationsHandler)    // Revoke an impersonation
+	mux.HandleFunc("/api/admin/signed-urls", SignedURLsHandler)            // Expiring links to single admin resources
+	mux.HandleFunc("/api/admin/audit-log", AuditLogHandler)                // Audit trail, impersonated actions flagged
+	mux.HandleFunc("/api/admin/flags", FlagsHandler)                       // Feature flag list and create
+	mux.HandleFunc("/api/admin/flags/", FlagHandler)                       // Single feature flag CRUD
+	mux.HandleFunc("/api/admin/books/", AdminBookResourceHandler)          // Internal view, translations, processing state, duplicates, editing locks
+	mux.HandleFunc("/api/admin/experiments", PriceExperimentsHandler)      // Price experiment list and create
+	mux.HandleFunc("/api/admin/experiments/", PriceExperimentHandler)      // Price experiment results and CRUD
+	mux.HandleFunc("/api/experiments/", ExperimentConversionHandler)       // Storefront conversion reports
+	mux.HandleFunc("/api/admin/suppliers", SuppliersHandler)               // Supplier list and create
+	mux.HandleFunc("/api/admin/purchase-orders", PurchaseOrdersHandler)    // Purchase order list and create
+	mux.HandleFunc("/api/admin/purchase-orders/", PurchaseOrdersHandler)   // Low-stock reorder, receive, reschedule, cancel
+	mux.HandleFunc("/api/admin/export/books", CatalogExportHandler)        // Streamed catalog export (JSON or NDJSON)
+	mux.HandleFunc("/api/admin/export/archives", ExportArchivesHandler)    // Export snapshots, list and create
+	mux.HandleFunc("/api/admin/export/archives/", ExportArchivesHandler)   // Resumable (Range) download and delete
+	mux.HandleFunc("/api/admin/reports/margins", MarginReportHandler)      // Per-title and stock-weighted margins
+	mux.HandleFunc("/api/admin/processing", ProcessingHandler)             // Enrichment pipeline state
+	mux.HandleFunc("/api/admin/processing/reprocess", ReprocessHandler)    // Re-run enrichment for a filtered set
+	mux.HandleFunc("/api/admin/data-quality", DataQualityHandler)          // Catalog anomaly report
+	mux.HandleFunc("/api/admin/ratings/recompute", RatingRecomputeHandler) // Repair review aggregate drift now
+	mux.HandleFunc("/api/admin/dependencies", DependenciesHandler)         // Database and upstream health
+	mux.HandleFunc("/api/admin/slos", SLOsHandler)                         // SLO compliance, burn rates and alerts
+	mux.HandleFunc("/api/admin/log-sampling", LogSamplingHandler)          // Log sampling per logger
+	mux.HandleFunc("/api/admin/log-sampling/", LogSamplingHandler)         // Change one logger's sampling at runtime
+	mux.HandleFunc("/api/admin/migrations", MigrationsHandler)             // Expand/contract migration progress
+	mux.HandleFunc("/api/admin/migrations/", MigrationsHandler)            // Backfill and contract a migration
+	mux.HandleFunc("/api/admin/integrity-check", IntegrityCheckHandler)    // Re-run the startup integrity check
+	mux.HandleFunc("/readyz", ReadinessHandler)                            // Readiness, with the integrity report
+	mux.Handle("/debug/vars", expvar.Handler())                            // Runtime metrics
+	mux.HandleFunc("/debug/goroutine-diff", GoroutineDiffHandler)          // Goroutines grown since a baseline
+
+	handler := adminAuthMiddleware(impersonationMiddleware(accessTokenMiddleware(sessionMiddleware(timeZoneMiddleware(bodyLimitMiddleware(readinessMiddleware(mux)))))))
+	if cfg.RecordFile != "" {
+		record, err := newRecordingMiddleware(cfg.RecordFile)
+		if err != nil {
+			return nil, err
+		}
+		handler = record(handler)
+	}
+	ResetSLOs(cfg.SLOs)
+	return requestIDMiddleware(inFlightMiddleware(outcomeMiddleware(sloMiddleware(mux, recoveryMiddleware(handler))))), nil
+}
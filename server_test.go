@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeRecommendationProvider answers every book with the same quote, without the network
+type fakeRecommendationProvider struct{}
+
+func (fakeRecommendationProvider) Name() string { return "fake" }
+
+func (fakeRecommendationProvider) Fetch(ctx context.Context, bookID, userID string) (Recommendations, error) {
+	return Recommendations{
+		UserID:    userID,
+		BookID:    bookID,
+		Quote:     &Quote{Text: "A room without books is like a body without a soul.", Author: "Cicero"},
+		APISource: "fake",
+	}, nil
+}
+
+// The admin token test servers are configured with
+const testAdminToken = "test-admin-token"
+
+// newTestServer serves NewServer over an in-memory database seeded with the sample data. The
+// server sets package globals, so tests using it must not run in parallel.
+func newTestServer(t *testing.T, providers ...RecommendationProvider) *httptest.Server {
+	t.Helper()
+	return newTestServerWithConfig(t, nil, providers...)
+}
+
+// newTestServerWithConfig is newTestServer with configure, if not nil, applied to the
+// configuration first
+func newTestServerWithConfig(t *testing.T, configure func(*Config), providers ...RecommendationProvider) *httptest.Server {
+	t.Helper()
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	cfg.AdminToken = testAdminToken
+	if configure != nil {
+		configure(&cfg)
+	}
+	database, err := OpenMemoryDatabase()
+	if err != nil {
+		t.Fatalf("OpenMemoryDatabase: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if len(providers) == 0 {
+		providers = []RecommendationProvider{fakeRecommendationProvider{}}
+	}
+	handler, err := NewServer(cfg, database, providers)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestClient returns a client that keeps the session cookies it is given
+func newTestClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New: %v", err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// doRequest sends a request with an optional JSON body and headers given as name, value pairs,
+// and returns the status code and body
+func doRequest(t *testing.T, client *http.Client, method, url, body string, headers ...string) (int, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest %s %s: %v", method, url, err)
+	}
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("reading %s %s: %v", method, url, err)
+	}
+	return response.StatusCode, string(data)
+}
+
+func TestServerSmoke(t *testing.T) {
+	server := newTestServer(t)
+
+	response, err := http.Get(server.URL + "/api/books")
+	if err != nil {
+		t.Fatalf("GET /api/books: %v", err)
+	}
+	var books []json.RawMessage
+	err = json.NewDecoder(response.Body).Decode(&books)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || err != nil || len(books) == 0 {
+		t.Fatalf("GET /api/books = %d with %d books (%v), want 200 with the seed data", response.StatusCode, len(books), err)
+	}
+
+	response, err = http.Get(server.URL + "/api/books/1/details?mode=concurrent")
+	if err != nil {
+		t.Fatalf("GET details: %v", err)
+	}
+	var details struct {
+		Recommendations Recommendations `json:"recommendations"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&details)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("GET details = %d (%v), want 200", response.StatusCode, err)
+	}
+	if details.Recommendations.APISource != "fake" {
+		t.Errorf("recommendations came from %q, want the fake provider", details.Recommendations.APISource)
+	}
+
+	response, err = http.Get(server.URL + "/api/admin/flags")
+	if err != nil {
+		t.Fatalf("GET /api/admin/flags: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /api/admin/flags without a token = %d, want 401", response.StatusCode)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName            = "bookstore_session"
+	sessionKey        contextKey = "session"
+
+	// Anonymous sessions act as users named anonymousUserPrefix + session ID, so reading lists
+	// and ratings made before login can be moved to the account afterwards
+	anonymousUserPrefix = "anon:"
+)
+
+// Paths whose responses depend on who is asking. Only these get a session cookie issued, which
+// keeps Set-Cookie off publicly cacheable responses such as feeds and shared lists.
+var sessionPaths = []string{"/api/books/", "/api/v2/books/", "/api/users/", "/api/session", "/api/accounts", "/api/admin/books/"}
+
+// Key for signing session cookies, set by NewServer from config
+var sessionSigningKey []byte
+
+// Session is the content of the signed session cookie. Nothing about it is stored server side.
+type Session struct {
+	ID        string `json:"sid"`
+	UserID    string `json:"uid,omitempty"` // Empty until the visitor logs in
+	ExpiresAt int64  `json:"exp"`           // Unix seconds
+}
+
+// Anonymous reports whether nobody has logged in under the session
+func (s Session) Anonymous() bool {
+	return s.UserID == ""
+}
+
+// actingUserID is the user the session acts as: the account after login, else its anonymous user
+func (s Session) actingUserID() string {
+	if s.Anonymous() {
+		return anonymousUserPrefix + s.ID
+	}
+	return s.UserID
+}
+
+// newSessionSigningKey returns the configured secret, or a random key when there is none
+func newSessionSigningKey(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	log.Println("BOOKSTORE_SESSION_SECRET is not set; sessions will not survive a restart or work across instances")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Printf("Error generating session key: %v", err)
+	}
+	return key
+}
+
+// newSession starts a session for userID ("" for an anonymous visitor)
+func newSession(userID string) Session {
+	return Session{
+		ID:        idGenerator.NewID(),
+		UserID:    userID,
+		ExpiresAt: clock.Now().Add(config.SessionMaxAge).Unix(),
+	}
+}
+
+// signSession encodes a session as base64(payload).base64(HMAC-SHA256(payload))
+func signSession(session Session) string {
+	payload, _ := json.Marshal(session)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sessionMAC(encoded))
+}
+
+// verifySession decodes a cookie value, rejecting bad signatures and expired sessions
+func verifySession(value string) (Session, bool) {
+	var session Session
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return session, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, sessionMAC(encoded)) {
+		return session, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(payload, &session) != nil || session.ID == "" {
+		return session, false
+	}
+	return session, clock.Now().Unix() < session.ExpiresAt
+}
+
+func sessionMAC(encoded string) []byte {
+	mac := hmac.New(sha256.New, sessionSigningKey)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// setSessionCookie sends the session to the browser
+func setSessionCookie(w http.ResponseWriter, session Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    signSession(session),
+		Path:     "/",
+		Expires:  time.Unix(session.ExpiresAt, 0),
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookie tells the browser to drop its session
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionMiddleware reads the session cookie into the request context. Visitors to a session
+// path without a valid cookie get a new anonymous session. A session already set from an impersonation or access token is left alone.
+func sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, authenticated := SessionFromContext(r.Context()); authenticated || !usesSession(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var session Session
+		var ok bool
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			session, ok = verifySession(cookie.Value)
+		}
+		if !ok {
+			session, ok = newSession(""), true
+			setSessionCookie(w, session)
+		}
+		if ok {
+			// Responses may be personalized to the session
+			w.Header().Add("Vary", "Cookie")
+			r = r.WithContext(context.WithValue(r.Context(), sessionKey, session))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// usesSession reports whether path is one of the sessionPaths
+func usesSession(path string) bool {
+	for _, prefix := range sessionPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionFromContext returns the session sessionMiddleware found or issued, if any
+func SessionFromContext(ctx context.Context) (Session, bool) {
+	session, ok := ctx.Value(sessionKey).(Session)
+	return session, ok
+}
+
+// errForeignUser means a request named a user other than the one its session acts for
+var errForeignUser = errors.New("request names a user other than its own")
+
+// requestUserID picks the user a request acts for: its session's, logged in or anonymous.
+// ?user_id= may repeat that user or say "me", but naming anyone else fails with errForeignUser.
+// It is "" when there is no session.
+func requestUserID(r *http.Request) (string, error) {
+	return resolveUserID(r, strings.TrimSpace(r.URL.Query().Get("user_id")))
+}
+
+// resolveUserID checks a user named by a request, in its path or ?user_id=, against its
+// session. "", "me" and the session's own user resolve to the session's user; anyone else,
+// registered account or not, fails with errForeignUser, so one user's lists, ratings and
+// history can't be read or written by another.
+func resolveUserID(r *http.Request, named string) (string, error) {
+	acting := ""
+	if session, ok := SessionFromContext(r.Context()); ok {
+		acting = session.actingUserID()
+	}
+	if named == "" || named == "me" || named == acting {
+		return acting, nil
+	}
+	return "", errForeignUser
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// ShippingOption is one way to get a book to a postal code
+type ShippingOption struct {
+	Service               string  `json:"service"` // e.g. "ground", "expedited"
+	Carrier               string  `json:"carrier"`
+	Cost                  float64 `json:"cost"`
+	Currency              string  `json:"currency"`
+	MinBusinessDays       int     `json:"min_business_days"` // Including handling and any backorder lead time
+	MaxBusinessDays       int     `json:"max_business_days"`
+	EstimatedDeliveryFrom string  `json:"estimated_delivery_from"` // YYYY-MM-DD
+	EstimatedDeliveryTo   string  `json:"estimated_delivery_to"`
+}
+
+// ShippingProvider quotes delivery options from a warehouse to a destination postal code.
+// Quotes cover transit only; handling and backorder lead time are added by the caller.
+type ShippingProvider interface {
+	Name() string
+	Quote(ctx context.Context, origin WarehouseLocation, postalCode string) ([]ShippingOption, error)
+}
+
+// Known shipping providers, selectable by name via BOOKSTORE_SHIPPING_PROVIDER
+var shippingProviderRegistry = map[string]func() ShippingProvider{
+	"static": func() ShippingProvider { return staticRateTable{} },
+}
+
+// Provider used for shipping estimates, built from config in main
+var shippingProvider = NewShippingProvider(config.ShippingProvider)
+
+// NewShippingProvider instantiates a provider by name, falling back to the static rate table
+func NewShippingProvider(name string) ShippingProvider {
+	constructor, ok := shippingProviderRegistry[name]
+	if !ok {
+		log.Printf("Unknown shipping provider %q, using static", name)
+		constructor = shippingProviderRegistry["static"]
+	}
+	return constructor()
+}
+
+// WarehouseLocation is where a warehouse ships from
+type WarehouseLocation struct {
+	Name       string
+	PostalCode string
+}
+
+// Locations of the warehouses named in the inventory table. Books without a known
+// warehouse (e.g. "Back Order") ship from defaultWarehouse once restocked.
+var warehouseLocations = map[string]WarehouseLocation{
+	"East Coast DC": {Name: "East Coast DC", PostalCode: "08810"},
+	"Central DC":    {Name: "Central DC", PostalCode: "66101"},
+	"West Coast DC": {Name: "West Coast DC", PostalCode: "94520"},
+}
+
+const defaultWarehouse = "Central DC"
+
+// Business days added on top of carrier transit: picking and packing for books in stock,
+// and restocking for books that aren't
+const (
+	shippingHandlingDays  = 1
+	shippingBackorderDays = 10
+)
+
+// US ZIP codes, with or without the +4 suffix
+var postalCodePattern = regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`)
+
+// ShippingEstimateResponse is the body of GET /api/books/{id}/shipping
+type ShippingEstimateResponse struct {
+	BookID      string           `json:"book_id"`
+	PostalCode  string           `json:"postal_code"`
+	Warehouse   string           `json:"warehouse"`
+	Backordered bool             `json:"backordered"`
+	Provider    string           `json:"provider"`
+	Options     []ShippingOption `json:"options"`
+}
+
+// EstimateShipping quotes every shipping option for a book to a postal code, with delivery dates
+// counted in business days from now
+func EstimateShipping(ctx context.Context, bookID, postalCode string) (ShippingEstimateResponse, error) {
+	response := ShippingEstimateResponse{BookID: bookID, PostalCode: postalCode, Provider: shippingProvider.Name()}
+
+	var warehouse sql.NullString
+	var inStock bool
+	var quantity int
+	err := db.QueryRowContext(ctx, "SELECT in_stock, quantity, warehouse FROM inventory WHERE book_id = ?", bookID).
+		Scan(&inStock, &quantity, &warehouse)
+	if err != nil {
+		return response, err
+	}
+
+	origin := shippingOrigin(warehouse.String)
+	response.Warehouse = origin.Name
+	response.Backordered = !inStock || quantity <= 0
+
+	options, err := shippingProvider.Quote(ctx, origin, postalCode)
+	if err != nil {
+		return response, err
+	}
+
+	leadDays := shippingLeadDays(response.Backordered)
+	now := clock.Now()
+	for i := range options {
+		options[i].MinBusinessDays += leadDays
+		options[i].MaxBusinessDays += leadDays
+		options[i].EstimatedDeliveryFrom = addBusinessDays(now, options[i].MinBusinessDays).Format("2006-01-02")
+		options[i].EstimatedDeliveryTo = addBusinessDays(now, options[i].MaxBusinessDays).Format("2006-01-02")
+	}
+	response.Options = options
+	return response, nil
+}
+
+// ShippingTimeSummary is the generic ground estimate shown with inventory when no destination is
+// known: the range of business days over every destination the warehouse ships to
+func ShippingTimeSummary(warehouse string, backordered bool) string {
+	origin := shippingOrigin(warehouse)
+	minDays, maxDays := 0, 0
+	for zone := 0; zone <= 9; zone++ {
+		transitMin, transitMax := groundTransitDays(zoneDistance(origin.PostalCode, fmt.Sprintf("%d0000", zone)))
+		if minDays == 0 || transitMin < minDays {
+			minDays = transitMin
+		}
+		maxDays = max(maxDays, transitMax)
+	}
+
+	leadDays := shippingLeadDays(backordered)
+	return fmt.Sprintf("%d-%d business days", minDays+leadDays, maxDays+leadDays)
+}
+
+// shippingOrigin resolves a warehouse name to its location, using the default for unknown ones
+func shippingOrigin(warehouse string) WarehouseLocation {
+	if location, ok := warehouseLocations[warehouse]; ok {
+		return location
+	}
+	return warehouseLocations[defaultWarehouse]
+}
+
+// shippingLeadDays is the time before a book leaves the warehouse
+func shippingLeadDays(backordered bool) int {
+	if backordered {
+		return shippingHandlingDays + shippingBackorderDays
+	}
+	return shippingHandlingDays
+}
+
+// addBusinessDays moves forward the given number of weekdays
+func addBusinessDays(from time.Time, days int) time.Time {
+	date := from
+	for days > 0 {
+		date = date.AddDate(0, 0, 1)
+		if date.Weekday() != time.Saturday && date.Weekday() != time.Sunday {
+			days--
+		}
+	}
+	return date
+}
+
+// zoneDistance approximates distance by how far apart the ZIP codes' national areas
+// (first digit, 0 in the northeast through 9 on the west coast) are
+func zoneDistance(originPostalCode, destinationPostalCode string) int {
+	distance := int(originPostalCode[0]) - int(destinationPostalCode[0])
+	if distance < 0 {
+		distance = -distance
+	}
+	return distance
+}
+
+// groundTransitDays is the static ground transit range for a zone distance
+func groundTransitDays(distance int) (int, int) {
+	switch {
+	case distance <= 2:
+		return 1, 2
+	case distance <= 5:
+		return 2, 3
+	default:
+		return 3, 5
+	}
+}
+
+// staticRateTable quotes from fixed per-zone rates and transit times
+type staticRateTable struct{}
+
+// Name implements ShippingProvider
+func (staticRateTable) Name() string { return "static" }
+
+// Quote implements ShippingProvider
+func (staticRateTable) Quote(ctx context.Context, origin WarehouseLocation, postalCode string) ([]ShippingOption, error) {
+	distance := zoneDistance(origin.PostalCode, postalCode)
+
+	groundMin, groundMax := groundTransitDays(distance)
+	expeditedMax := 1
+	if distance > 5 {
+		expeditedMax = 2
+	}
+
+	return []ShippingOption{
+		{
+			Service:         "ground",
+			Carrier:         "standard",
+			Cost:            math.Round((4.99+0.50*float64(distance))*100) / 100,
+			Currency:        "USD",
+			MinBusinessDays: groundMin,
+			MaxBusinessDays: groundMax,
+		},
+		{
+			Service:         "expedited",
+			Carrier:         "standard",
+			Cost:            math.Round((12.99+1.00*float64(distance))*100) / 100,
+			Currency:        "USD",
+			MinBusinessDays: 1,
+			MaxBusinessDays: expeditedMax,
+		},
+	}, nil
+}
+
+// ShippingHandler handles GET /api/books/{id}/shipping?postal_code=12345
+func ShippingHandler(w http.ResponseWriter, r *http.Request, bookID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	postalCode := r.URL.Query().Get("postal_code")
+	if !postalCodePattern.MatchString(postalCode) {
+		writeError(w, r, http.StatusBadRequest, "Query parameter 'postal_code' must be a US ZIP code like 94105")
+		return
+	}
+	// No point quoting delivery of a book that can't be sold there
+	if !checkRegion(w, r, bookID) {
+		return
+	}
+
+	estimate, err := EstimateShipping(r.Context(), bookID, postalCode)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "Book not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error estimating shipping for book %s to %s: %v", bookID, postalCode, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to estimate shipping")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, estimate)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signedURLExpiresParam   = "expires"
+	signedURLSignatureParam = "sig"
+
+	adminKey contextKey = "admin"
+
+	// The admin identity of requests made with the shared admin token, which names nobody
+	adminTokenIdentity = "admin-token"
+)
+
+// Admin resources a signed URL may be made for: unreleased books' internal view, private
+// exports and export archives, and reports. Anything else under /api/admin/ still needs the
+// admin token.
+var signableAdminPaths = []string{"/api/admin/books/", "/api/admin/export/books", "/api/admin/export/archives/", "/api/admin/reports/margins"}
+
+// signedURLSigningKey is derived from the session key, so instances sharing
+// BOOKSTORE_SESSION_SECRET accept each other's links without another secret to configure
+func signedURLSigningKey() []byte {
+	mac := hmac.New(sha256.New, sessionSigningKey)
+	mac.Write([]byte("signed urls"))
+	return mac.Sum(nil)
+}
+
+// signedURLMAC signs a path and its query (without the signature) for GET
+func signedURLMAC(path string, query url.Values) []byte {
+	mac := hmac.New(sha256.New, signedURLSigningKey())
+	// Encode sorts by key, so the same link always signs the same way
+	mac.Write([]byte(http.MethodGet + "\n" + path + "\n" + query.Encode()))
+	return mac.Sum(nil)
+}
+
+// signURL returns path plus query with an expiry and signature added. Changing anything in the
+// link, the query included, breaks the signature.
+func signURL(path string, query url.Values, expires time.Time) string {
+	signed := url.Values{}
+	for key, values := range query {
+		signed[key] = values
+	}
+	signed.Set(signedURLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	signed.Set(signedURLSignatureParam, base64.RawURLEncoding.EncodeToString(signedURLMAC(path, signed)))
+	return path + "?" + signed.Encode()
+}
+
+// verifySignedURL checks a request's signature and expiry
+func verifySignedURL(r *http.Request) bool {
+	query := r.URL.Query()
+	signature, err := base64.RawURLEncoding.DecodeString(query.Get(signedURLSignatureParam))
+	if err != nil {
+		return false
+	}
+	query.Del(signedURLSignatureParam)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || !hmac.Equal(signature, signedURLMAC(r.URL.Path, query)) {
+		return false
+	}
+	expires, err := strconv.ParseInt(query.Get(signedURLExpiresParam), 10, 64)
+	return err == nil && clock.Now().Unix() < expires
+}
+
+// isSignablePath reports whether path is one of the signableAdminPaths
+func isSignablePath(path string) bool {
+	for _, prefix := range signableAdminPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Paths adminAuthMiddleware guards: the admin API, and the debug endpoints, whose metrics and
+// goroutine stacks are for operators only
+var adminPaths = []string{"/api/admin/", "/debug/"}
+
+// adminIdentity works out which admin a request comes from: the admin token, sent as
+// "Authorization: Bearer <token>", or a login to one of the BOOKSTORE_ADMIN_USERS accounts,
+// named by its user ID. Any other Authorization header rules the cookie out, so a user's
+// impersonation or access token can't ride on an admin's session.
+func adminIdentity(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, _ := strings.CutPrefix(header, "Bearer ")
+		if config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1 {
+			return adminTokenIdentity, true
+		}
+		return "", false
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if session, ok := verifySession(cookie.Value); ok && !session.Anonymous() && containsString(config.AdminUsers, session.UserID) {
+			return session.UserID, true
+		}
+	}
+	return "", false
+}
+
+// AdminFromContext returns the admin identity adminAuthMiddleware found. There is none for
+// signed URLs, nor with BOOKSTORE_ADMIN_OPEN when the request carries no admin credentials.
+func AdminFromContext(ctx context.Context) (string, bool) {
+	admin, ok := ctx.Value(adminKey).(string)
+	return admin, ok
+}
+
+// adminAuthMiddleware guards adminPaths with an admin identity (see adminIdentity), which it
+// puts in the request context. A GET with a valid signed URL for a signable path gets in
+// without one, which is how temporary access is handed out without an account. Everything else
+// is refused, unless BOOKSTORE_ADMIN_OPEN opts out for development.
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		guarded := false
+		for _, prefix := range adminPaths {
+			guarded = guarded || strings.HasPrefix(r.URL.Path, prefix)
+		}
+		if !guarded {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.URL.Query().Has(signedURLSignatureParam) {
+			if !verifySignedURL(r) || !isSignablePath(r.URL.Path) {
+				writeError(w, r, http.StatusForbidden, "Invalid or expired signed URL")
+				return
+			}
+			recordAudit(r.Context(), AuditEntry{
+				Actor:  "ip:" + remoteIP(r),
+				Action: "signed_url.use",
+				Status: http.StatusOK,
+				Detail: r.URL.Path,
+			})
+			// The link is the credential; don't let anything in between keep the response
+			w.Header().Set("Cache-Control", "private, no-store")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		admin, ok := adminIdentity(r)
+		switch {
+		case ok:
+			r = r.WithContext(context.WithValue(r.Context(), adminKey, admin))
+		case config.AdminOpen:
+		case config.AdminToken == "" && len(config.AdminUsers) == 0:
+			// Fail closed: a missing token must not leave the admin API open
+			writeError(w, r, http.StatusUnauthorized, "Admin API disabled; set BOOKSTORE_ADMIN_TOKEN")
+			return
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, r, http.StatusUnauthorized, "Admin token or a login to an admin account required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// signedURLRequest is the body of POST /api/admin/signed-urls
+type signedURLRequest struct {
+	Path string `json:"path"` // May carry a query, e.g. "/api/admin/export/books?format=ndjson"
+	TTL  string `json:"ttl"`  // Go duration, default SignedURLTTL, at most SignedURLMaxTTL
+}
+
+// SignedURLsHandler handles POST /api/admin/signed-urls, returning a link to one admin resource
+// that works without the admin token until it expires. A signed URL can't be used to make
+// another, and the admin making one is audited, so it takes an admin identity even when
+// BOOKSTORE_ADMIN_OPEN is set.
+func SignedURLsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	adminID, ok := AdminFromContext(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusForbidden, "Signed URLs require the admin token or a login to an admin account")
+		return
+	}
+	var req signedURLRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	target, err := url.Parse(req.Path)
+	if err != nil || target.IsAbs() || target.Host != "" || !isSignablePath(target.Path) ||
+		strings.Contains(target.Path, "/.") || strings.Contains(target.Path, "//") {
+		writeError(w, r, http.StatusBadRequest, "path must be one of "+strings.Join(signableAdminPaths, ", ")+" (prefixes)")
+		return
+	}
+	query := target.Query()
+	if query.Has(signedURLExpiresParam) || query.Has(signedURLSignatureParam) {
+		writeError(w, r, http.StatusBadRequest, "path must not already be signed")
+		return
+	}
+	ttl := config.SignedURLTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 || parsed > config.SignedURLMaxTTL {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("ttl must be a positive duration of at most %v", config.SignedURLMaxTTL))
+			return
+		}
+		ttl = parsed
+	}
+
+	expires := clock.Now().Add(ttl).Truncate(time.Second)
+	link := SignedURL{URL: signURL(target.Path, query, expires), ExpiresAt: expires.UTC()}
+	recordAudit(r.Context(), AuditEntry{
+		Actor:  adminID,
+		Action: "signed_url.create",
+		Status: http.StatusCreated,
+		Detail: target.Path + " for " + ttl.String(),
+	})
+	log.Printf("Signed URL for %s issued by %s, valid for %v", target.Path, adminID, ttl)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusCreated, link)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminUserIDCannotBeRegistered(t *testing.T) {
+	server := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.AdminUsers = []string{"alice"}
+	})
+	client := newTestClient(t)
+
+	status, body := doRequest(t, client, http.MethodPost, server.URL+"/api/accounts", `{"user_id": "alice", "password": "Correct-Horse-42"}`)
+	if status != http.StatusConflict {
+		t.Fatalf("signing up as a listed admin = %d %s, want 409", status, body)
+	}
+	if status, _ := doRequest(t, client, http.MethodGet, server.URL+"/api/admin/flags", ""); status != http.StatusUnauthorized {
+		t.Fatalf("admin API after the refused sign-up = %d, want 401", status)
+	}
+
+	// The intended way round: the account exists before it is listed
+	status, body = doRequest(t, client, http.MethodPost, server.URL+"/api/accounts", `{"user_id": "carol", "password": "Correct-Horse-42"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("signing up as carol = %d %s, want 201", status, body)
+	}
+	if status, _ := doRequest(t, client, http.MethodGet, server.URL+"/api/admin/flags", ""); status != http.StatusUnauthorized {
+		t.Fatalf("admin API as unlisted carol = %d, want 401", status)
+	}
+	config.AdminUsers = append(config.AdminUsers, "carol")
+	if status, _ := doRequest(t, client, http.MethodGet, server.URL+"/api/admin/flags", ""); status != http.StatusOK {
+		t.Fatalf("admin API as listed carol = %d, want 200", status)
+	}
+}
+
+func TestSignedURLVerificationAndExpiry(t *testing.T) {
+	server := newTestServer(t)
+	fixed := newFixedClock(time.Now())
+	previous := clock
+	clock = fixed
+	t.Cleanup(func() { clock = previous })
+
+	// carol signs up, then is listed as an admin and signs a link from her session
+	carol := newTestClient(t)
+	if status, body := doRequest(t, carol, http.MethodPost, server.URL+"/api/accounts", `{"user_id": "carol", "password": "Correct-Horse-42"}`); status != http.StatusCreated {
+		t.Fatalf("signing up carol = %d %s", status, body)
+	}
+	config.AdminUsers = []string{"carol"}
+	status, body := doRequest(t, carol, http.MethodPost, server.URL+"/api/admin/signed-urls", `{"path": "/api/admin/reports/margins?limit=2", "ttl": "1h"}`)
+	var link SignedURL
+	if err := json.Unmarshal([]byte(body), &link); status != http.StatusCreated || err != nil {
+		t.Fatalf("signing a link = %d %s", status, body)
+	}
+
+	anyone := http.DefaultClient
+	if status, body := doRequest(t, anyone, http.MethodGet, server.URL+link.URL, ""); status != http.StatusOK {
+		t.Fatalf("GET with the signed link = %d %s, want 200", status, body)
+	}
+	tampered := map[string]string{
+		"query changed":     strings.Replace(link.URL, "limit=2", "limit=200", 1),
+		"path changed":      strings.Replace(link.URL, "/reports/margins", "/export/books", 1),
+		"expiry pushed out": strings.Replace(link.URL, "expires=", "expires=9", 1),
+		"signature dropped": link.URL[:strings.Index(link.URL, "sig=")] + "sig=",
+	}
+	for name, url := range tampered {
+		if status, _ := doRequest(t, anyone, http.MethodGet, server.URL+url, ""); status != http.StatusForbidden {
+			t.Errorf("GET with the link's %s = %d, want 403", name, status)
+		}
+	}
+	if status, _ := doRequest(t, anyone, http.MethodPost, server.URL+link.URL, ""); status != http.StatusForbidden {
+		t.Errorf("POST with the signed link = %d, want 403", status)
+	}
+
+	fixed.Advance(time.Hour)
+	if status, _ := doRequest(t, anyone, http.MethodGet, server.URL+link.URL, ""); status != http.StatusForbidden {
+		t.Errorf("GET with the link once expired = %d, want 403", status)
+	}
+
+	// The audit trail names the admin who signed it
+	status, body = doRequest(t, anyone, http.MethodGet, server.URL+"/api/admin/audit-log?actor=carol", "", "Authorization", "Bearer "+testAdminToken)
+	if status != http.StatusOK || !strings.Contains(body, `"signed_url.create"`) {
+		t.Errorf("audit log for carol = %d %s, want the signed_url.create entry", status, body)
+	}
+}
+
+func TestSignedURLsNeedAnAdminIdentity(t *testing.T) {
+	server := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.AdminOpen = true
+	})
+	status, body := doRequest(t, http.DefaultClient, http.MethodPost, server.URL+"/api/admin/signed-urls", `{"path": "/api/admin/reports/margins"}`)
+	if status != http.StatusForbidden {
+		t.Fatalf("signing a link on an open admin API without credentials = %d %s, want 403", status, body)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The sitemap protocol caps a single sitemap file at 50,000 URLs
+const sitemapMaxPageSize = 50000
+
+// sitemapURLSet is one sitemap page
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapURL is one book detail URL in a sitemap page
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapIndex lists every sitemap page
+type sitemapIndex struct {
+	XMLName  xml.Name         `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapPageRef `xml:"sitemap"`
+}
+
+// sitemapPageRef points at one sitemap page
+type sitemapPageRef struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapCache holds the generated pages until the catalog changes. The fingerprint is a
+// cheap aggregate over the catalog tables, so each request only pays for a full rebuild
+// when something has actually changed.
+var sitemapCache = struct {
+	sync.Mutex
+	fingerprint string
+	pages       []sitemapURLSet
+	lastMods    []time.Time // Newest lastmod on each page
+}{}
+
+// loadSitemapPages returns the sitemap pages, regenerating them if the catalog changed
+func loadSitemapPages(ctx context.Context) ([]sitemapURLSet, []time.Time, error) {
+	var fingerprint string
+	err := db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM books) || '|' ||
+			COALESCE((SELECT MAX(COALESCE(updated_at, created_at)) FROM books), '') || '|' ||
+			COALESCE((SELECT MAX(updated_at) FROM pricing), '') || '|' ||
+			COALESCE((SELECT MAX(COALESCE(updated_at, last_restocked)) FROM inventory), '') || '|' ||
+			COALESCE((SELECT MAX(updated_at) FROM reviews), '')
+	`).Scan(&fingerprint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sitemapCache.Lock()
+	defer sitemapCache.Unlock()
+	if fingerprint == sitemapCache.fingerprint {
+		return sitemapCache.pages, sitemapCache.lastMods, nil
+	}
+
+	pages, lastMods, err := buildSitemapPages(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sitemapCache.fingerprint = fingerprint
+	sitemapCache.pages = pages
+	sitemapCache.lastMods = lastMods
+	log.Printf("Regenerated sitemap: %d pages", len(pages))
+	return pages, lastMods, nil
+}
+
+// buildSitemapPages lists every book's detail URL with the time any of its data last changed
+func buildSitemapPages(ctx context.Context) ([]sitemapURLSet, []time.Time, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT b.id, MAX(
+			COALESCE(b.updated_at, b.created_at),
+			COALESCE(p.updated_at, b.created_at),
+			COALESCE(i.updated_at, i.last_restocked, b.created_at),
+			COALESCE(r.updated_at, b.created_at)
+		)
+		FROM books b
+		LEFT JOIN pricing p ON p.book_id = b.id
+		LEFT JOIN inventory i ON i.book_id = b.id
+		LEFT JOIN reviews r ON r.book_id = b.id
+		ORDER BY b.id
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var pages []sitemapURLSet
+	var lastMods []time.Time
+	for rows.Next() {
+		var id, modifiedAt string
+		if err := rows.Scan(&id, &modifiedAt); err != nil {
+			return nil, nil, err
+		}
+		modified, err := parseSQLiteTimestamp(modifiedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(pages) == 0 || len(pages[len(pages)-1].URLs) >= config.SitemapPageSize {
+			pages = append(pages, sitemapURLSet{})
+			lastMods = append(lastMods, time.Time{})
+		}
+		page := len(pages) - 1
+		pages[page].URLs = append(pages[page].URLs, sitemapURL{
+			Loc:     fmt.Sprintf("%s/api/books/%s/details", config.PublicBaseURL, id),
+			LastMod: modified.Format(time.RFC3339),
+		})
+		if modified.After(lastMods[page]) {
+			lastMods[page] = modified
+		}
+	}
+	return pages, lastMods, rows.Err()
+}
+
+// SitemapIndexHandler handles GET /sitemap.xml, the index of all sitemap pages
+func SitemapIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	_, lastMods, err := loadSitemapPages(r.Context())
+	if err != nil {
+		log.Printf("Error generating sitemap: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to generate sitemap")
+		return
+	}
+
+	index := sitemapIndex{}
+	var newest time.Time
+	for i, lastMod := range lastMods {
+		index.Sitemaps = append(index.Sitemaps, sitemapPageRef{
+			Loc:     fmt.Sprintf("%s/sitemaps/books-%d.xml", config.PublicBaseURL, i+1),
+			LastMod: lastMod.Format(time.RFC3339),
+		})
+		if lastMod.After(newest) {
+			newest = lastMod
+		}
+	}
+	writeSitemapXML(w, r, index, newest)
+}
+
+// SitemapPageHandler handles GET /sitemaps/books-{n}.xml, pages numbered from 1
+func SitemapPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/sitemaps/")
+	number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "books-"), ".xml"))
+	if !strings.HasPrefix(name, "books-") || !strings.HasSuffix(name, ".xml") || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	pages, lastMods, err := loadSitemapPages(r.Context())
+	if err != nil {
+		log.Printf("Error generating sitemap: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to generate sitemap")
+		return
+	}
+	if number < 1 || number > len(pages) {
+		http.NotFound(w, r)
+		return
+	}
+	writeSitemapXML(w, r, pages[number-1], lastMods[number-1])
+}
+
+// writeSitemapXML sends a sitemap document with caching headers
+func writeSitemapXML(w http.ResponseWriter, r *http.Request, document interface{}, lastModified time.Time) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if !lastModified.IsZero() && writeNotModified(w, r, lastModified) {
+		return
+	}
+
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding sitemap: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to generate sitemap")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SLOTarget is a service level objective for one route: at least Objective of its requests
+// should succeed (status below 500) within Latency
+type SLOTarget struct {
+	Latency   time.Duration
+	Objective float64 // e.g. 0.995
+}
+
+// Requests are counted per minute, over the longest window burn rates are computed for
+const (
+	sloBucketWidth = time.Minute
+	sloWindow      = 6 * time.Hour
+	sloBuckets     = int(sloWindow / sloBucketWidth)
+
+	// Fewer requests than this in the short window never alert, so a single slow request at
+	// night doesn't page anyone
+	sloMinAlertRequests = 10
+
+	// Alerts kept for the admin endpoint
+	sloRecentAlerts = 50
+)
+
+// sloAlertRule is a multi-window burn rate alert: it fires when the error budget is being spent
+// Threshold times faster than sustainable over both windows. The long window makes it
+// significant, the short one makes it stop soon after the problem does.
+type sloAlertRule struct {
+	Severity  string
+	Long      time.Duration
+	Short     time.Duration
+	Threshold float64
+}
+
+// The usual pair: a fast burn would spend 2% of a 30-day budget in an hour, a slow one 5% in
+// six hours
+var sloAlertRules = []sloAlertRule{
+	{Severity: "page", Long: time.Hour, Short: 5 * time.Minute, Threshold: 14.4},
+	{Severity: "ticket", Long: 6 * time.Hour, Short: 30 * time.Minute, Threshold: 6},
+}
+
+// Windows burn rates are reported for
+var sloReportWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, sloWindow}
+
+// sloBucket counts one minute of a route's requests
+type sloBucket struct {
+	minute int64 // Unix minute the counts belong to
+	total  int64
+	good   int64
+}
+
+// sloTracker keeps a ring of per-minute buckets for one route
+type sloTracker struct {
+	route   string
+	target  SLOTarget
+	buckets [sloBuckets]sloBucket
+	alert   string // Severity currently firing, "" when none
+}
+
+// sloState holds every tracked route, rebuilt from config by NewServer
+var sloState = struct {
+	sync.Mutex
+	trackers map[string]*sloTracker
+	alerts   []SLOAlert
+}{}
+
+var sloAlertsFired = expvar.NewMap("slo_alerts_fired")
+
+func init() {
+	expvar.Publish("slo", expvar.Func(func() interface{} { return SLOStatuses() }))
+}
+
+// ResetSLOs starts tracking the configured routes from scratch
+func ResetSLOs(targets map[string]SLOTarget) {
+	sloState.Lock()
+	defer sloState.Unlock()
+	sloState.trackers = make(map[string]*sloTracker, len(targets))
+	for route, target := range targets {
+		sloState.trackers[route] = &sloTracker{route: route, target: target}
+	}
+	sloState.alerts = nil
+}
+
+// record counts one request in the current minute
+func (t *sloTracker) record(now time.Time, good bool) {
+	minute := now.Unix() / int64(sloBucketWidth/time.Second)
+	bucket := &t.buckets[minute%int64(sloBuckets)]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+	bucket.total++
+	if good {
+		bucket.good++
+	}
+}
+
+// counts sums the requests of the last window
+func (t *sloTracker) counts(now time.Time, window time.Duration) (total, good int64) {
+	current := now.Unix() / int64(sloBucketWidth/time.Second)
+	oldest := current - int64(window/sloBucketWidth) + 1
+	for _, bucket := range t.buckets {
+		if bucket.minute >= oldest && bucket.minute <= current {
+			total += bucket.total
+			good += bucket.good
+		}
+	}
+	return total, good
+}
+
+// burnRate is how fast the window spent error budget: 1 spends it exactly over the SLO period,
+// above 1 spends it early. No traffic burns nothing.
+func (t *sloTracker) burnRate(now time.Time, window time.Duration) float64 {
+	total, good := t.counts(now, window)
+	if total == 0 {
+		return 0
+	}
+	errorRate := float64(total-good) / float64(total)
+	return errorRate / (1 - t.target.Objective)
+}
+
+// sloMiddleware counts each request against its route's SLO, if it has one. The route is the
+// mux pattern that serves the request, e.g. "/api/books/".
+func sloMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		sloState.Lock()
+		tracker := sloState.trackers[route]
+		sloState.Unlock()
+		if tracker == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		startTime := clock.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		now := clock.Now()
+		good := recorder.status < http.StatusInternalServerError && now.Sub(startTime) <= tracker.target.Latency
+
+		sloState.Lock()
+		tracker.record(now, good)
+		sloState.Unlock()
+	})
+}
+
+// evaluateSLOAlerts checks every route against the alert rules and emits an alert event when a
+// route starts or stops burning its budget too fast
+func evaluateSLOAlerts() {
+	now := clock.Now()
+	sloState.Lock()
+	defer sloState.Unlock()
+
+	for _, tracker := range sloState.trackers {
+		severity := ""
+		var fired sloAlertRule
+		for _, rule := range sloAlertRules {
+			if total, _ := tracker.counts(now, rule.Short); total < sloMinAlertRequests {
+				continue
+			}
+			if tracker.burnRate(now, rule.Long) > rule.Threshold && tracker.burnRate(now, rule.Short) > rule.Threshold {
+				severity, fired = rule.Severity, rule
+				break // Rules are ordered most urgent first
+			}
+		}
+		if severity == tracker.alert {
+			continue
+		}
+
+		alert := SLOAlert{Route: tracker.route, At: now.UTC(), Severity: severity}
+		if severity == "" {
+			alert.Severity = tracker.alert
+			alert.Resolved = true
+			alert.Message = fmt.Sprintf("%s is back within its error budget", tracker.route)
+			log.Printf("SLO alert resolved: %s", alert.Message)
+		} else {
+			alert.BurnRate = tracker.burnRate(now, fired.Long)
+			alert.Message = fmt.Sprintf("%s is burning its error budget %.1fx too fast over %v (%.2f%% of requests within %v expected)",
+				tracker.route, alert.BurnRate, fired.Long, tracker.target.Objective*100, tracker.target.Latency)
+			sloAlertsFired.Add(severity, 1)
+			log.Printf("SLO ALERT [%s]: %s", severity, alert.Message)
+		}
+		tracker.alert = severity
+		sloState.alerts = append(sloState.alerts, alert)
+		if len(sloState.alerts) > sloRecentAlerts {
+			sloState.alerts = sloState.alerts[len(sloState.alerts)-sloRecentAlerts:]
+		}
+	}
+}
+
+// StartSLOMonitor evaluates the alert rules every minute until ctx is done
+func StartSLOMonitor(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(sloBucketWidth)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				evaluateSLOAlerts()
+			}
+		}
+	}()
+}
+
+// SLOStatuses reports every tracked route, sorted by route
+func SLOStatuses() []SLOStatus {
+	now := clock.Now()
+	sloState.Lock()
+	defer sloState.Unlock()
+
+	statuses := make([]SLOStatus, 0, len(sloState.trackers))
+	for _, tracker := range sloState.trackers {
+		total, good := tracker.counts(now, sloWindow)
+		status := SLOStatus{
+			Route:      tracker.route,
+			Latency:    tracker.target.Latency.String(),
+			Objective:  tracker.target.Objective,
+			Window:     sloWindow.String(),
+			Requests:   total,
+			Good:       good,
+			Compliance: 1,
+			BurnRates:  map[string]float64{},
+			Alert:      tracker.alert,
+		}
+		if total > 0 {
+			status.Compliance = float64(good) / float64(total)
+		}
+		// Share of the window's budget left: 1 untouched, 0 spent, negative overspent
+		status.BudgetRemaining = 1 - tracker.burnRate(now, sloWindow)
+		for _, window := range sloReportWindows {
+			status.BurnRates[window.String()] = tracker.burnRate(now, window)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Route < statuses[j].Route })
+	return statuses
+}
+
+// SLOsHandler handles GET /api/admin/slos: compliance and burn rate per route, and the most
+// recent alert events
+func SLOsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	statuses := SLOStatuses()
+	sloState.Lock()
+	alerts := append([]SLOAlert{}, sloState.alerts...)
+	sloState.Unlock()
+	writeJSON(w, r, http.StatusOK, SLOReport{Routes: statuses, Alerts: alerts})
+}
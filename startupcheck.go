@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// schemaVersion is stamped into PRAGMA user_version once createSchema has brought a database up
+// to date. A database stamped with a higher version was last opened by a newer build, whose
+// schema this one may not understand.
+const schemaVersion = 1
+
+// How many offending rows a check lists before just counting
+const integritySampleLimit = 10
+
+// startupCheck is the outcome of the last integrity check, which decides readiness
+var startupCheck = struct {
+	sync.Mutex
+	report IntegrityReport
+}{}
+
+// readSchemaVersion returns the version the database was last stamped with, 0 before versioning
+func readSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// stampSchemaVersion records that the schema is at schemaVersion, unless a newer build has been
+// here: the stamp never goes backwards
+func stampSchemaVersion(ctx context.Context) error {
+	version, err := readSchemaVersion(ctx)
+	if err != nil || version >= schemaVersion {
+		return err
+	}
+	// PRAGMA takes no parameters; the version is a constant
+	_, err = db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", schemaVersion))
+	return err
+}
+
+// checkSchemaVersion fails for a database from a newer build
+func checkSchemaVersion(ctx context.Context) (string, error) {
+	version, err := readSchemaVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	if version > schemaVersion {
+		return "", fmt.Errorf("database schema version %d is newer than this build's %d", version, schemaVersion)
+	}
+	return fmt.Sprintf("schema version %d", version), nil
+}
+
+// checkSQLiteIntegrity runs PRAGMA quick_check, or the slower integrity_check that also checks
+// indexes against their tables, and fails with whatever SQLite found
+func checkSQLiteIntegrity(ctx context.Context, full bool) (string, error) {
+	pragma := "quick_check"
+	if full {
+		pragma = "integrity_check"
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA %s(%d)", pragma, integritySampleLimit))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(problems) > 0 {
+		return "", fmt.Errorf("%s found: %s", pragma, strings.Join(problems, "; "))
+	}
+	return pragma + " ok", nil
+}
+
+// checkBooksHave fails when books lack a row in table, naming a few of them
+func checkBooksHave(table string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		rows, err := db.QueryContext(ctx,
+			"SELECT b.id FROM books b LEFT JOIN "+table+" t ON t.book_id = b.id WHERE t.book_id IS NULL ORDER BY b.id")
+		if err != nil {
+			return "", err
+		}
+		defer rows.Close()
+		var missing []string
+		count := 0
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return "", err
+			}
+			if count < integritySampleLimit {
+				missing = append(missing, id)
+			}
+			count++
+		}
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return "", fmt.Errorf("%d books have no %s row, e.g. %s", count, table, strings.Join(missing, ", "))
+		}
+		return "no book is missing a " + table + " row", nil
+	}
+}
+
+// runIntegrityChecks runs every check, failed ones included, so the report shows all that is
+// wrong at once
+func runIntegrityChecks(ctx context.Context, full bool) IntegrityReport {
+	checks := []struct {
+		name string
+		run  func(ctx context.Context) (string, error)
+	}{
+		{"schema_version", checkSchemaVersion},
+		{"sqlite_integrity", func(ctx context.Context) (string, error) { return checkSQLiteIntegrity(ctx, full) }},
+		{"books_have_pricing", checkBooksHave("pricing")},
+		{"books_have_inventory", checkBooksHave("inventory")},
+	}
+
+	report := IntegrityReport{CheckedAt: clock.Now().UTC(), Ready: true}
+	for _, check := range checks {
+		startTime := time.Now()
+		detail, err := check.run(ctx)
+		result := IntegrityCheck{Name: check.name, OK: err == nil, Detail: detail, DurationMs: time.Since(startTime).Milliseconds()}
+		if err != nil {
+			result.Detail = err.Error()
+			report.Ready = false
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
+
+// RunStartupCheck checks the database per BOOKSTORE_STARTUP_CHECK: "off" skips it, "fail" stops
+// startup on a problem, and "degrade" starts anyway but reports not ready and refuses to serve
+// catalog data until the problem is fixed and the check re-run
+func RunStartupCheck(ctx context.Context, cfg Config) error {
+	startupCheck.Lock()
+	defer startupCheck.Unlock()
+	if cfg.StartupCheck == "off" {
+		startupCheck.report = IntegrityReport{Ready: true}
+		return nil
+	}
+
+	report := runIntegrityChecks(ctx, cfg.StartupCheckFull)
+	startupCheck.report = report
+	for _, check := range report.Checks {
+		if !check.OK {
+			log.Printf("Error: startup check %s failed: %s", check.Name, check.Detail)
+		}
+	}
+	if report.Ready {
+		log.Printf("Startup integrity check passed")
+		return nil
+	}
+	if cfg.StartupCheck == "fail" {
+		return fmt.Errorf("startup integrity check failed; see the errors above")
+	}
+	log.Printf("Warning: starting without readiness; /readyz has the report, POST /api/admin/integrity-check re-runs it")
+	return nil
+}
+
+// isReady reports whether the last integrity check passed
+func isReady() bool {
+	startupCheck.Lock()
+	defer startupCheck.Unlock()
+	return startupCheck.report.Ready
+}
+
+// Paths served while not ready: readiness itself, metrics, and the admin API to repair with
+var unreadyPaths = []string{"/readyz", "/debug/", "/api/admin/"}
+
+// readinessMiddleware refuses requests with 503 while the database failed its integrity check,
+// rather than serve data that may be corrupt
+func readinessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isReady() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range unreadyPaths {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("Retry-After", "30")
+		writeProblem(w, r, problemDetails{
+			Title:  "Service not ready",
+			Status: http.StatusServiceUnavailable,
+			Detail: "The database failed its integrity check; see /readyz",
+		})
+	})
+}
+
+// ReadinessHandler handles GET /readyz: 200 once the integrity check has passed, 503 with the
+// failed checks otherwise, for load balancers and orchestrators to hold traffic back
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	startupCheck.Lock()
+	report := startupCheck.report
+	startupCheck.Unlock()
+
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, status, report)
+}
+
+// IntegrityCheckHandler handles POST /api/admin/integrity-check, re-running the checks now
+// (e.g. after a repair) and updating readiness with the result. ?full=true runs the full
+// integrity_check whatever the startup configuration.
+func IntegrityCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	full := config.StartupCheckFull || r.URL.Query().Get("full") == "true"
+	report := runIntegrityChecks(r.Context(), full)
+
+	startupCheck.Lock()
+	wasReady := startupCheck.report.Ready
+	startupCheck.report = report
+	startupCheck.Unlock()
+	if report.Ready != wasReady {
+		log.Printf("Integrity check re-run: ready went from %t to %t", wasReady, report.Ready)
+	}
+	writeJSON(w, r, http.StatusOK, report)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tenant whose storefront is served to requests without one of their own
+const defaultStorefrontTenant = "default"
+
+// builtinStorefront is served when not even the default tenant has a storefront row
+var builtinStorefront = Storefront{
+	TenantID:      defaultStorefrontTenant,
+	Name:          "Bookstore",
+	Currency:      "USD",
+	DefaultLocale: "en-US",
+	Locales:       []string{"en-US"},
+	Features:      []string{},
+}
+
+// Matches #rgb and #rrggbb colors
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// storefrontCache holds every storefront in memory; the front end fetches its storefront on
+// every page load. Refreshing follows the feature flag cache.
+var storefrontCache = struct {
+	sync.RWMutex
+	storefronts map[string]Storefront
+	loadedAt    time.Time
+}{}
+
+// LoadStorefronts reads all storefronts from the database into the in-memory cache
+func LoadStorefronts() error {
+	rows, err := db.Query(`
+		SELECT tenant_id, name, currency, default_locale, locales, logo_url, primary_color, features, updated_at
+		FROM storefronts
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	storefronts := make(map[string]Storefront)
+	for rows.Next() {
+		var storefront Storefront
+		var locales, features string
+		if err := rows.Scan(&storefront.TenantID, &storefront.Name, &storefront.Currency, &storefront.DefaultLocale,
+			&locales, &storefront.LogoURL, &storefront.PrimaryColor, &features, &storefront.UpdatedAt); err != nil {
+			return err
+		}
+		storefront.Locales = splitTenants(locales)
+		storefront.Features = splitTenants(features)
+		storefronts[storefront.TenantID] = storefront
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	storefrontCache.Lock()
+	storefrontCache.storefronts = storefronts
+	storefrontCache.loadedAt = clock.Now()
+	storefrontCache.Unlock()
+	return nil
+}
+
+// getStorefront returns a tenant's storefront from the cache, refreshing the cache when it is stale
+func getStorefront(tenantID string) (Storefront, bool) {
+	storefrontCache.RLock()
+	stale := clock.Now().Sub(storefrontCache.loadedAt) > flagCacheTTL
+	storefrontCache.RUnlock()
+
+	if stale {
+		if err := LoadStorefronts(); err != nil {
+			log.Printf("Error refreshing storefronts: %v", err)
+		}
+	}
+
+	storefrontCache.RLock()
+	defer storefrontCache.RUnlock()
+	storefront, ok := storefrontCache.storefronts[tenantID]
+	return storefront, ok
+}
+
+// storefrontFor picks the storefront for a tenant: its own, else the default tenant's, else
+// the built-in one
+func storefrontFor(tenantID string) Storefront {
+	if storefront, ok := getStorefront(tenantID); ok && tenantID != "" {
+		return storefront
+	}
+	if storefront, ok := getStorefront(defaultStorefrontTenant); ok {
+		return storefront
+	}
+	return builtinStorefront
+}
+
+// saveStorefront inserts or replaces a storefront and refreshes the cache
+func saveStorefront(storefront Storefront) error {
+	_, err := db.Exec(`
+		INSERT INTO storefronts (tenant_id, name, currency, default_locale, locales, logo_url, primary_color, features, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tenant_id) DO UPDATE SET
+			name = excluded.name,
+			currency = excluded.currency,
+			default_locale = excluded.default_locale,
+			locales = excluded.locales,
+			logo_url = excluded.logo_url,
+			primary_color = excluded.primary_color,
+			features = excluded.features,
+			updated_at = excluded.updated_at
+	`, storefront.TenantID, storefront.Name, storefront.Currency, storefront.DefaultLocale, strings.Join(storefront.Locales, ","),
+		storefront.LogoURL, storefront.PrimaryColor, strings.Join(storefront.Features, ","), dbNow())
+	if err != nil {
+		return err
+	}
+	return LoadStorefronts()
+}
+
+// deleteStorefront removes a storefront and refreshes the cache, reporting whether it existed
+func deleteStorefront(tenantID string) (bool, error) {
+	result, err := db.Exec("DELETE FROM storefronts WHERE tenant_id = ?", tenantID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, LoadStorefronts()
+}
+
+// StorefrontHandler handles GET /api/storefront, the configuration the front end reads at boot.
+// The tenant comes from X-Tenant-ID as for feature flags, and flags are evaluated for the session user.
+func StorefrontHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	tenantID := r.Header.Get("X-Tenant-ID")
+	storefront := storefrontFor(tenantID)
+	response := StorefrontConfig{
+		TenantID:      storefront.TenantID,
+		Name:          storefront.Name,
+		Currency:      storefront.Currency,
+		DefaultLocale: storefront.DefaultLocale,
+		Locales:       storefront.Locales,
+		LogoURL:       storefront.LogoURL,
+		PrimaryColor:  storefront.PrimaryColor,
+		Features:      make(map[string]bool, len(storefront.Features)),
+	}
+	for _, key := range storefront.Features {
+		response.Features[key] = FeatureEnabledForRequest(r, key)
+	}
+
+	// Flag rollouts depend on the user, so only the browser may reuse the response
+	w.Header().Add("Vary", "X-Tenant-ID")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// StorefrontsHandler handles /api/admin/storefronts (list all storefronts, create one)
+func StorefrontsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if err := LoadStorefronts(); err != nil {
+			log.Printf("Error loading storefronts: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to load storefronts")
+			return
+		}
+
+		storefrontCache.RLock()
+		storefronts := make([]Storefront, 0, len(storefrontCache.storefronts))
+		for _, storefront := range storefrontCache.storefronts {
+			storefronts = append(storefronts, storefront)
+		}
+		storefrontCache.RUnlock()
+
+		writeJSON(w, r, http.StatusOK, storefronts)
+
+	case http.MethodPost:
+		storefront, ok := decodeStorefront(w, r)
+		if !ok {
+			return
+		}
+		if _, exists := getStorefront(storefront.TenantID); exists {
+			writeError(w, r, http.StatusConflict, "Storefront already exists")
+			return
+		}
+		if err := saveStorefront(storefront); err != nil {
+			log.Printf("Error creating storefront for tenant %s: %v", storefront.TenantID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to create storefront")
+			return
+		}
+		saved, _ := getStorefront(storefront.TenantID)
+		log.Printf("Created storefront for tenant %s", storefront.TenantID)
+		writeJSON(w, r, http.StatusCreated, saved)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// AdminStorefrontHandler handles /api/admin/storefronts/{tenant} (get, replace, delete)
+func AdminStorefrontHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID := strings.TrimPrefix(r.URL.Path, "/api/admin/storefronts/")
+	if tenantID == "" || strings.Contains(tenantID, "/") {
+		writeError(w, r, http.StatusBadRequest, "Invalid URL Format. Expected /api/admin/storefronts/{tenant}")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		storefront, ok := getStorefront(tenantID)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "Storefront not found")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, storefront)
+
+	case http.MethodPut:
+		storefront, ok := decodeStorefront(w, r)
+		if !ok {
+			return
+		}
+		if storefront.TenantID != "" && storefront.TenantID != tenantID {
+			writeError(w, r, http.StatusBadRequest, "Tenant in body does not match URL")
+			return
+		}
+		storefront.TenantID = tenantID
+		if err := saveStorefront(storefront); err != nil {
+			log.Printf("Error updating storefront for tenant %s: %v", tenantID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to update storefront")
+			return
+		}
+		saved, _ := getStorefront(tenantID)
+		log.Printf("Updated storefront for tenant %s", tenantID)
+		writeJSON(w, r, http.StatusOK, saved)
+
+	case http.MethodDelete:
+		existed, err := deleteStorefront(tenantID)
+		if err != nil {
+			log.Printf("Error deleting storefront for tenant %s: %v", tenantID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to delete storefront")
+			return
+		}
+		if !existed {
+			writeError(w, r, http.StatusNotFound, "Storefront not found")
+			return
+		}
+		log.Printf("Deleted storefront for tenant %s", tenantID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// decodeStorefront parses and validates a storefront from the request body, writing the error
+// response on failure. Locale tags are checked against the supported display locales.
+func decodeStorefront(w http.ResponseWriter, r *http.Request) (Storefront, bool) {
+	var storefront Storefront
+	if !decodeJSONBody(w, r, &storefront) {
+		return storefront, false
+	}
+
+	// PUT takes the tenant from the URL, POST must provide it
+	if r.Method == http.MethodPost && strings.TrimSpace(storefront.TenantID) == "" {
+		writeError(w, r, http.StatusBadRequest, "tenant_id is required")
+		return storefront, false
+	}
+	if strings.Contains(storefront.TenantID, "/") {
+		writeError(w, r, http.StatusBadRequest, "tenant_id must not contain '/'")
+		return storefront, false
+	}
+	if strings.TrimSpace(storefront.Name) == "" {
+		writeError(w, r, http.StatusBadRequest, "name is required")
+		return storefront, false
+	}
+	if len(storefront.Currency) != 3 {
+		writeError(w, r, http.StatusBadRequest, "currency must be a three-letter ISO 4217 code")
+		return storefront, false
+	}
+	storefront.Currency = strings.ToUpper(storefront.Currency)
+	if storefront.PrimaryColor != "" && !hexColorPattern.MatchString(storefront.PrimaryColor) {
+		writeError(w, r, http.StatusBadRequest, "primary_color must be a hex color like #1a73e8")
+		return storefront, false
+	}
+
+	locale, ok := displayLocales[strings.ToLower(storefront.DefaultLocale)]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "default_locale is not a supported locale")
+		return storefront, false
+	}
+	storefront.DefaultLocale = locale.Tag
+	locales := []string{locale.Tag}
+	for _, tag := range storefront.Locales {
+		locale, ok := displayLocales[strings.ToLower(strings.TrimSpace(tag))]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "Unsupported locale "+tag)
+			return storefront, false
+		}
+		if !slices.Contains(locales, locale.Tag) {
+			locales = append(locales, locale.Tag)
+		}
+	}
+	storefront.Locales = locales
+
+	features := []string{}
+	for _, key := range storefront.Features {
+		if key = strings.TrimSpace(key); key != "" && !slices.Contains(features, key) {
+			features = append(features, key)
+		}
+	}
+	storefront.Features = features
+
+	return storefront, true
+}
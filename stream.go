@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Rows written between flushes when streaming query results
+const streamFlushEvery = 500
+
+// errSkipRow is returned by a row scanner to leave the row out of the results
+var errSkipRow = errors.New("skip row")
+
+// streamJSONRows writes query results as they are read instead of collecting them first, so
+// memory stays flat however many rows there are. The body is a JSON array, or with ndjson one
+// object per line. Each row goes through the same visibility and time zone handling as
+// encodeJSON, but rows are never wrapped in the response envelope. Output is flushed every
+// streamFlushEvery rows, and streaming stops between rows once ctx is done. Rows scan turns
+// down with errSkipRow are left out. Headers are sent before the first row, so a failure part
+// way through can only cut the body short; the error is returned for logging and counted as a
+// partial write.
+func streamJSONRows[T any](ctx context.Context, w http.ResponseWriter, r *http.Request, rows *sql.Rows, ndjson bool, scan func(*sql.Rows) (T, error)) (written int, err error) {
+	defer rows.Close()
+	defer func() {
+		if err != nil {
+			responsePartialWrites.Add("streamed", 1)
+		}
+	}()
+	audience, location := audienceFor(r), TimeZoneFromContext(ctx)
+
+	contentType := "application/json"
+	if ndjson {
+		contentType = "application/x-ndjson"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(w)
+	encoder := json.NewEncoder(w) // Encode ends every value with a newline
+	separator, closing := []byte(","), []byte("]\n")
+	if !ndjson {
+		if _, err := w.Write([]byte("[")); err != nil {
+			return 0, err
+		}
+	}
+
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		row, err := scan(rows)
+		if errors.Is(err, errSkipRow) {
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+		if !ndjson && written > 0 {
+			if _, err := w.Write(separator); err != nil {
+				return written, err
+			}
+		}
+		err = recoverEncode(w, func(io.Writer) error {
+			return encoder.Encode(inTimeZone(visibleTo(row, audience), location))
+		})
+		if err != nil {
+			return written, err
+		}
+		written++
+		if written%streamFlushEvery == 0 {
+			if err := controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return written, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return written, err
+	}
+	if !ndjson {
+		if _, err := w.Write(closing); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// collectRows reads query results into a slice, for responses that can't be streamed. Rows
+// scan turns down with errSkipRow are left out.
+func collectRows[T any](ctx context.Context, rows *sql.Rows, scan func(*sql.Rows) (T, error)) ([]T, error) {
+	defer rows.Close()
+	collected := []T{}
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return collected, err
+		}
+		row, err := scan(rows)
+		if errors.Is(err, errSkipRow) {
+			continue
+		}
+		if err != nil {
+			return collected, err
+		}
+		collected = append(collected, row)
+	}
+	return collected, rows.Err()
+}
+
+// CatalogExportRow is one book in the catalog export, with its pricing, stock and reviews
+type CatalogExportRow struct {
+	ID            string     `json:"id"`
+	Title         string     `json:"title"`
+	Author        string     `json:"author"`
+	ISBN          *string    `json:"isbn"`
+	PublishDate   *time.Time `json:"publish_date" tz:"date"`
+	Price         *float64   `json:"price"` // null when the book has no pricing row
+	Currency      *string    `json:"currency"`
+	SalePrice     *float64   `json:"sale_price"`
+	CostPrice     *float64   `json:"cost_price" visibility:"admin"`
+	Quantity      *int       `json:"quantity"` // null when the book has no inventory row
+	Warehouse     *string    `json:"warehouse"`
+	AverageRating *float64   `json:"average_rating"`
+	TotalReviews  *int       `json:"total_reviews"`
+}
+
+// scanCatalogExportRow reads one row of the export query
+func scanCatalogExportRow(rows *sql.Rows) (CatalogExportRow, error) {
+	var row CatalogExportRow
+	var isbn, currency, warehouse sql.NullString
+	var publishDate sql.NullTime
+	var price, salePrice, costPrice, averageRating sql.NullFloat64
+	var quantity, totalReviews sql.NullInt64
+	err := rows.Scan(&row.ID, &row.Title, &row.Author, &isbn, &publishDate, &price, &currency, &salePrice, &costPrice,
+		&quantity, &warehouse, &averageRating, &totalReviews)
+	if err != nil {
+		return row, err
+	}
+
+	row.ISBN = nullStringPtr(isbn)
+	row.PublishDate = nullTimePtr(publishDate)
+	row.Currency = nullStringPtr(currency)
+	row.Warehouse = nullStringPtr(warehouse)
+	row.Price = nullFloatPtr(price)
+	row.SalePrice = nullFloatPtr(salePrice)
+	row.CostPrice = nullFloatPtr(costPrice)
+	row.AverageRating = nullFloatPtr(averageRating)
+	if quantity.Valid {
+		value := int(quantity.Int64)
+		row.Quantity = &value
+	}
+	if totalReviews.Valid {
+		value := int(totalReviews.Int64)
+		row.TotalReviews = &value
+	}
+	return row, nil
+}
+
+// nullFloatPtr maps NULL numbers to nil
+func nullFloatPtr(value sql.NullFloat64) *float64 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Float64
+}
+
+// queryCatalogExport starts the export query, in book ID order. An export reads every book,
+// so pass a batch priority context to keep it on the batch pool.
+func queryCatalogExport(ctx context.Context) (*sql.Rows, error) {
+	return dbFor(ctx).QueryContext(ctx, `
+		SELECT b.id, b.title, b.author, b.isbn, b.publish_date, p.price, p.currency, p.sale_price, p.cost_price,
+			i.quantity, i.warehouse, rv.average_rating, rv.total_reviews
+		FROM books b
+		LEFT JOIN pricing p ON p.book_id = b.id
+		LEFT JOIN inventory i ON i.book_id = b.id
+		LEFT JOIN reviews rv ON rv.book_id = b.id
+		ORDER BY b.id
+	`)
+}
+
+// CatalogExportHandler handles GET /api/admin/export/books, streaming the whole catalog in ID
+// order as a JSON array, or as newline-delimited JSON with ?format=ndjson
+func CatalogExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "ndjson" {
+		writeError(w, r, http.StatusBadRequest, "format must be json or ndjson")
+		return
+	}
+
+	ctx := withBatchPriority(r.Context())
+	rows, err := queryCatalogExport(ctx)
+	if err != nil {
+		log.Printf("Error starting catalog export: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to export catalog")
+		return
+	}
+
+	startTime := time.Now()
+	written, err := streamJSONRows(ctx, w, r, rows, format == "ndjson", scanCatalogExportRow)
+	if err != nil {
+		log.Printf("Catalog export stopped after %d books: %v", written, err)
+		return
+	}
+	log.Printf("Exported %d books in %v", written, time.Since(startTime))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBooksListComesFromTheDatabase(t *testing.T) {
+	server := newTestServer(t)
+	if _, err := db.Exec("INSERT INTO books (id, title, author) VALUES ('5', 'Imported Book', 'Some Author')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO pricing (book_id, price) VALUES ('5', 12.5)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, accept := range []string{"application/json", envelopeMediaType} {
+		status, body := doRequest(t, http.DefaultClient, http.MethodGet, server.URL+"/api/books", "", "Accept", accept)
+		if status != http.StatusOK {
+			t.Fatalf("GET /api/books with Accept %q = %d %s", accept, status, body)
+		}
+		var listed []Book
+		if accept == "application/json" {
+			if err := json.Unmarshal([]byte(body), &listed); err != nil {
+				t.Fatalf("decoding %s: %v", body, err)
+			}
+		} else {
+			var envelope struct {
+				Data []Book `json:"data"`
+			}
+			if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+				t.Fatalf("decoding %s: %v", body, err)
+			}
+			listed = envelope.Data
+		}
+		found := false
+		for _, book := range listed {
+			if book.ID == "5" {
+				found = book.Title == "Imported Book" && book.Price == 12.5
+			}
+		}
+		if !found {
+			t.Errorf("GET /api/books with Accept %q left out the imported book: %s", accept, body)
+		}
+	}
+}
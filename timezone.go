@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+	_ "time/tzdata" // Zone names must resolve even where the host has no zoneinfo files
+)
+
+const timeZoneKey contextKey = "time_zone"
+
+// timeZoneMiddleware validates the optional ?tz= parameter (an IANA zone name such as
+// Europe/Paris) and stores the zone for encodeJSON. Timestamps are stored and computed in UTC
+// everywhere; the zone only changes how they are written in JSON responses.
+func timeZoneMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("tz")
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// LoadLocation also accepts "" and "Local", which would leak the server's zone
+		location, err := time.LoadLocation(name)
+		if err != nil || name == "Local" {
+			writeError(w, r, http.StatusBadRequest, "Query parameter 'tz' must be an IANA time zone like Europe/Paris")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), timeZoneKey, location)))
+	})
+}
+
+// TimeZoneFromContext returns the zone requested with ?tz=, or UTC when there is none
+func TimeZoneFromContext(ctx context.Context) *time.Location {
+	if location, ok := ctx.Value(timeZoneKey).(*time.Location); ok {
+		return location
+	}
+	return time.UTC
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// inTimeZone returns a copy of a response value with every timestamp converted to the zone.
+// Struct fields tagged `tz:"date"` hold calendar dates and are left alone, since shifting
+// midnight UTC into another zone would change the day.
+func inTimeZone(value interface{}, location *time.Location) interface{} {
+	if value == nil || location == time.UTC {
+		return value
+	}
+	return convertTimes(reflect.ValueOf(value), location).Interface()
+}
+
+// convertTimes rebuilds a value with its time.Time values converted, copying containers on
+// the way down so handlers' data is never modified
+func convertTimes(value reflect.Value, location *time.Location) reflect.Value {
+	if value.Type() == timeType {
+		return reflect.ValueOf(value.Interface().(time.Time).In(location))
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		converted := reflect.New(value.Type().Elem())
+		converted.Elem().Set(convertTimes(value.Elem(), location))
+		return converted
+
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		converted := reflect.New(value.Type()).Elem()
+		converted.Set(convertTimes(value.Elem(), location))
+		return converted
+
+	case reflect.Struct:
+		converted := reflect.New(value.Type()).Elem()
+		converted.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() || field.Tag.Get("tz") == "date" {
+				continue
+			}
+			converted.Field(i).Set(convertTimes(value.Field(i), location))
+		}
+		return converted
+
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		converted := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			converted.Index(i).Set(convertTimes(value.Index(i), location))
+		}
+		return converted
+
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		converted := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			converted.SetMapIndex(iter.Key(), convertTimes(iter.Value(), location))
+		}
+		return converted
+	}
+	return value
+}
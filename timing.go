@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DetailMeta carries request-level information in v2 detail responses
+type DetailMeta struct {
+	Timing DetailTiming `json:"timing"`
+}
+
+// DetailTiming reports how a detail request spent its deadline. Offsets are measured from when
+// the deadline started, so sequential mode shows each stage starting after the previous one
+// ends while concurrent mode shows them overlapping.
+type DetailTiming struct {
+	DeadlineMs  float64       `json:"deadline_ms"`  // Configured route timeout
+	Stages      []StageTiming `json:"stages"`       // In start order; sections that were never fetched are left out
+	ElapsedMs   float64       `json:"elapsed_ms"`   // Time used when the response was built
+	RemainingMs float64       `json:"remaining_ms"` // Budget left at that point, 0 once it has run out
+}
+
+// StageTiming is the time one section took
+type StageTiming struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	StartMs    float64 `json:"start_ms"` // Offset from the start of the deadline
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// newDetailTiming builds the budget report for loaded details under ctx's deadline
+func newDetailTiming(ctx context.Context, details bookDetails) DetailTiming {
+	now := time.Now()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = now
+	}
+	requestStart := deadline.Add(-config.DetailRequestTimeout)
+
+	timing := DetailTiming{
+		DeadlineMs:  milliseconds(config.DetailRequestTimeout),
+		ElapsedMs:   milliseconds(now.Sub(requestStart)),
+		RemainingMs: milliseconds(max(deadline.Sub(now), 0)),
+		Stages:      []StageTiming{},
+	}
+
+	addStage := func(name, status string, startedAt time.Time, duration time.Duration) {
+		if startedAt.IsZero() {
+			return
+		}
+		timing.Stages = append(timing.Stages, StageTiming{
+			Name:       name,
+			Status:     status,
+			StartMs:    milliseconds(startedAt.Sub(requestStart)),
+			DurationMs: milliseconds(duration),
+		})
+	}
+	addStage("metadata", details.Metadata.Status(), details.Metadata.StartedAt, details.Metadata.Duration)
+	addStage("pricing", details.Pricing.Status(), details.Pricing.StartedAt, details.Pricing.Duration)
+	addStage("inventory", details.Inventory.Status(), details.Inventory.StartedAt, details.Inventory.Duration)
+	addStage("reviews", details.Reviews.Status(), details.Reviews.StartedAt, details.Reviews.Duration)
+	addStage("recommendations", details.Recommendations.Status(), details.Recommendations.StartedAt, details.Recommendations.Duration)
+
+	sort.SliceStable(timing.Stages, func(i, j int) bool { return timing.Stages[i].StartMs < timing.Stages[j].StartMs })
+	return timing
+}
+
+// setServerTiming reports the same stage durations in a Server-Timing header, which browser
+// dev tools display and which the frozen v1 body has no room for
+func setServerTiming(w http.ResponseWriter, timing DetailTiming) {
+	metrics := make([]string, 0, len(timing.Stages)+1)
+	for _, stage := range timing.Stages {
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%.3f", stage.Name, stage.DurationMs))
+	}
+	metrics = append(metrics, fmt.Sprintf("budget;desc=\"remaining\";dur=%.3f", timing.RemainingMs))
+	w.Header().Set("Server-Timing", strings.Join(metrics, ", "))
+}
+
+// milliseconds converts a duration to fractional milliseconds, to the microsecond
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
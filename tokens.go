@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	accessTokenPrefix            = "pat_"
+	accessTokenKey    contextKey = "access_token"
+
+	// Most live tokens one user may hold
+	maxAccessTokensPerUser = 20
+
+	// last_used_at is only rewritten when it is older than this, so busy scripts don't turn
+	// every read into a write
+	accessTokenUsageResolution = time.Minute
+)
+
+// accessTokenScope is something a personal access token may be granted: a set of methods on a
+// set of paths
+type accessTokenScope struct {
+	Methods []string
+	allows  func(path string) bool
+}
+
+// Scopes personal access tokens can carry. Anything not covered by a token's scopes is refused,
+// including everything under /api/users/, so a token can't mint or list tokens.
+var accessTokenScopes = map[string]accessTokenScope{
+	// Read the catalog: book lists, details, search and the change feed
+	"catalog:read": {
+		Methods: []string{http.MethodGet, http.MethodHead},
+		allows: func(path string) bool {
+			return path == "/api/books" || path == "/api/changes" ||
+				strings.HasPrefix(path, "/api/books/") || strings.HasPrefix(path, "/api/v2/books/")
+		},
+	},
+	// Rate books as the token's owner
+	"reviews:write": {
+		Methods: []string{http.MethodPost},
+		allows: func(path string) bool {
+			pathParts := strings.Split(path, "/") // {"", "api", "books", "1", "rating"}
+			return len(pathParts) == 5 && pathParts[2] == "books" && pathParts[3] != "" && pathParts[4] == "rating"
+		},
+	},
+}
+
+// accessTokenAllows reports whether any of scopes covers the request
+func accessTokenAllows(scopes []string, r *http.Request) bool {
+	for _, name := range scopes {
+		scope, ok := accessTokenScopes[name]
+		if ok && slices.Contains(scope.Methods, r.Method) && scope.allows(r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// newAccessToken returns a random bearer token; only its hash is stored
+func newAccessToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return accessTokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// accessTokenRequest is the body of POST /api/users/me/tokens
+type accessTokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in"` // Go duration; tokens without one last until revoked
+}
+
+const accessTokenColumns = "id, user_id, name, scopes, created_at, last_used_at, expires_at"
+
+func scanAccessToken(row interface{ Scan(...interface{}) error }) (PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	var scopes string
+	var lastUsedAt, expiresAt sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.CreatedAt, &lastUsedAt, &expiresAt)
+	token.Scopes = []string{}
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope != "" {
+			token.Scopes = append(token.Scopes, scope)
+		}
+	}
+	token.LastUsedAt = nullTimePtr(lastUsedAt)
+	token.ExpiresAt = nullTimePtr(expiresAt)
+	return token, err
+}
+
+// createAccessToken stores a new token for userID and returns it with the plain token
+func createAccessToken(ctx context.Context, userID string, req accessTokenRequest, expiresIn time.Duration) (PersonalAccessToken, string, error) {
+	plain, err := newAccessToken()
+	if err != nil {
+		return PersonalAccessToken{}, "", err
+	}
+	now := clock.Now().UTC().Truncate(time.Second)
+	token := PersonalAccessToken{
+		ID:        idGenerator.NewID(),
+		UserID:    userID,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		CreatedAt: now,
+	}
+	var expiresAt interface{}
+	if expiresIn > 0 {
+		expiry := now.Add(expiresIn)
+		token.ExpiresAt = &expiry
+		expiresAt = expiry.Format(sqliteTimestampLayout)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, token.ID, userID, req.Name, hashBearerToken(plain), strings.Join(req.Scopes, ","),
+		now.Format(sqliteTimestampLayout), expiresAt)
+	if err != nil {
+		return PersonalAccessToken{}, "", err
+	}
+	return token, plain, nil
+}
+
+// activeAccessTokenCondition matches tokens that can still be used
+const activeAccessTokenCondition = "revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)"
+
+// findActiveAccessToken looks up a usable token by its plain value
+func findActiveAccessToken(ctx context.Context, plain string) (PersonalAccessToken, bool, error) {
+	token, err := scanAccessToken(db.QueryRowContext(ctx,
+		"SELECT "+accessTokenColumns+" FROM personal_access_tokens WHERE token_hash = ? AND "+activeAccessTokenCondition,
+		hashBearerToken(plain), dbNow()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return token, false, nil
+	}
+	return token, err == nil, err
+}
+
+// listAccessTokens returns a user's usable tokens, newest first
+func listAccessTokens(ctx context.Context, userID string) ([]PersonalAccessToken, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT "+accessTokenColumns+" FROM personal_access_tokens WHERE user_id = ? AND "+activeAccessTokenCondition+
+			" ORDER BY created_at DESC, id", userID, dbNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// revokeAccessToken revokes one of a user's tokens, reporting false when there is no such usable token
+func revokeAccessToken(ctx context.Context, userID, id string) (bool, error) {
+	result, err := db.ExecContext(ctx,
+		"UPDATE personal_access_tokens SET revoked_at = ? WHERE user_id = ? AND id = ? AND "+activeAccessTokenCondition,
+		dbNow(), userID, id, dbNow())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// touchAccessToken records that a token was just used
+func touchAccessToken(ctx context.Context, id string) {
+	now := clock.Now().UTC()
+	_, err := db.ExecContext(ctx, `
+		UPDATE personal_access_tokens SET last_used_at = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`, now.Format(sqliteTimestampLayout), id, now.Add(-accessTokenUsageResolution).Format(sqliteTimestampLayout))
+	if err != nil {
+		log.Printf("Error recording use of access token %s: %v", id, err)
+	}
+}
+
+// accessTokenMiddleware lets a request carrying "Authorization: Bearer pat_..." act as the
+// token's owner, within the token's scopes. Like impersonation it puts a session for the owner
+// in the context, which sessionMiddleware then leaves alone.
+func accessTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plain, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(plain, accessTokenPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok, err := findActiveAccessToken(r.Context(), plain)
+		if err != nil {
+			log.Printf("Error looking up access token: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to check access token")
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, "Invalid, expired or revoked access token")
+			return
+		}
+		if !accessTokenAllows(token.Scopes, r) {
+			writeError(w, r, http.StatusForbidden, "Access token scopes ("+strings.Join(token.Scopes, ", ")+") don't cover "+r.Method+" "+r.URL.Path)
+			return
+		}
+		touchAccessToken(r.Context(), token.ID)
+
+		session := Session{ID: "token-" + token.ID, UserID: token.UserID}
+		if token.ExpiresAt != nil {
+			session.ExpiresAt = token.ExpiresAt.Unix()
+		}
+		ctx := context.WithValue(r.Context(), sessionKey, session)
+		ctx = context.WithValue(ctx, accessTokenKey, token)
+		// Token requests come from scripts; keep what they read out of shared caches
+		w.Header().Set("Cache-Control", "private, no-cache")
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// handleAccessTokens handles a logged-in user's personal access tokens:
+//
+//	GET    /api/users/me/tokens        Usable tokens, without their values
+//	POST   /api/users/me/tokens        Mint one with {"name", "scopes", "expires_in"}; the value is only shown here
+//	DELETE /api/users/me/tokens/{id}   Revoke one
+//
+// Only the account's own login session may manage tokens, not a token or an impersonating admin.
+func handleAccessTokens(w http.ResponseWriter, r *http.Request, pathParts []string) {
+	session, ok := SessionFromContext(r.Context())
+	_, impersonating := ImpersonationFromContext(r.Context())
+	_, viaToken := r.Context().Value(accessTokenKey).(PersonalAccessToken)
+	if pathParts[3] != "me" || !ok || session.Anonymous() || impersonating || viaToken {
+		writeError(w, r, http.StatusUnauthorized, "Log in to manage access tokens")
+		return
+	}
+	userID := session.UserID
+
+	switch {
+	case len(pathParts) == 5 && r.Method == http.MethodGet:
+		tokens, err := listAccessTokens(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error listing access tokens for %s: %v", userID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to list access tokens")
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-cache")
+		writeJSON(w, r, http.StatusOK, tokens)
+
+	case len(pathParts) == 5 && r.Method == http.MethodPost:
+		handleCreateAccessToken(w, r, userID)
+
+	case len(pathParts) == 6 && pathParts[5] != "" && r.Method == http.MethodDelete:
+		revoked, err := revokeAccessToken(r.Context(), userID, pathParts[5])
+		if err != nil {
+			log.Printf("Error revoking access token %s for %s: %v", pathParts[5], userID, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to revoke access token")
+			return
+		}
+		if !revoked {
+			writeError(w, r, http.StatusNotFound, "Access token not found")
+			return
+		}
+		recordAudit(r.Context(), AuditEntry{Actor: userID, Action: "token.revoke", UserID: userID, Status: http.StatusNoContent, Detail: pathParts[5]})
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(pathParts) <= 6:
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+
+	default:
+		writeError(w, r, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleCreateAccessToken validates and mints a token for userID
+func handleCreateAccessToken(w http.ResponseWriter, r *http.Request, userID string) {
+	var req accessTokenRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		writeError(w, r, http.StatusBadRequest, "name is required and at most 100 characters")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeError(w, r, http.StatusBadRequest, "scopes must list at least one of "+strings.Join(accessTokenScopeNames(), ", "))
+		return
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+	for _, scope := range req.Scopes {
+		if _, ok := accessTokenScopes[scope]; !ok {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown scope %q; known scopes are %s", scope, strings.Join(accessTokenScopeNames(), ", ")))
+			return
+		}
+	}
+	var expiresIn time.Duration
+	if req.ExpiresIn != "" {
+		parsed, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || parsed <= 0 {
+			writeError(w, r, http.StatusBadRequest, "expires_in must be a positive duration, e.g. 720h")
+			return
+		}
+		expiresIn = parsed
+	}
+
+	existing, err := listAccessTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error listing access tokens for %s: %v", userID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to create access token")
+		return
+	}
+	if len(existing) >= maxAccessTokensPerUser {
+		writeError(w, r, http.StatusConflict, fmt.Sprintf("At most %d access tokens; revoke one first", maxAccessTokensPerUser))
+		return
+	}
+
+	token, plain, err := createAccessToken(r.Context(), userID, req, expiresIn)
+	if err != nil {
+		log.Printf("Error creating access token for %s: %v", userID, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to create access token")
+		return
+	}
+	recordAudit(r.Context(), AuditEntry{Actor: userID, Action: "token.create", UserID: userID, Status: http.StatusCreated,
+		Detail: token.ID + " " + strings.Join(token.Scopes, ",")})
+	log.Printf("User %s created access token %q with scopes %v", userID, token.Name, token.Scopes)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusCreated, AccessTokenGrant{PersonalAccessToken: token, Token: plain})
+}
+
+// accessTokenScopeNames lists the known scopes in order
+func accessTokenScopeNames() []string {
+	names := make([]string, 0, len(accessTokenScopes))
+	for name := range accessTokenScopes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
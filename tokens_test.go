@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// mintAccessToken creates a token through the logged-in client and returns the grant
+func mintAccessToken(t *testing.T, client *http.Client, url, body string) AccessTokenGrant {
+	t.Helper()
+	status, response := doRequest(t, client, http.MethodPost, url+"/api/users/me/tokens", body)
+	var grant AccessTokenGrant
+	if err := json.Unmarshal([]byte(response), &grant); status != http.StatusCreated || err != nil {
+		t.Fatalf("minting %s = %d %s", body, status, response)
+	}
+	return grant
+}
+
+func TestAccessTokensRejectedOutsideTheirGrant(t *testing.T) {
+	server := newTestServer(t)
+	fixed := newFixedClock(time.Now())
+	previous := clock
+	clock = fixed
+	t.Cleanup(func() { clock = previous })
+
+	owner := newTestClient(t)
+	if status, body := doRequest(t, owner, http.MethodPost, server.URL+"/api/accounts", `{"user_id": "alice", "password": "Correct-Horse-42"}`); status != http.StatusCreated {
+		t.Fatalf("signing up = %d %s", status, body)
+	}
+	reader := mintAccessToken(t, owner, server.URL, `{"name": "reader", "scopes": ["catalog:read"]}`)
+	revoked := mintAccessToken(t, owner, server.URL, `{"name": "revoked", "scopes": ["catalog:read"]}`)
+	expiring := mintAccessToken(t, owner, server.URL, `{"name": "expiring", "scopes": ["catalog:read"], "expires_in": "1h"}`)
+	bearer := func(grant AccessTokenGrant) []string {
+		return []string{"Authorization", "Bearer " + grant.Token}
+	}
+
+	for _, grant := range []AccessTokenGrant{reader, revoked, expiring} {
+		if status, body := doRequest(t, http.DefaultClient, http.MethodGet, server.URL+"/api/books", "", bearer(grant)...); status != http.StatusOK {
+			t.Fatalf("catalog read with %s token = %d %s, want 200", grant.Name, status, body)
+		}
+	}
+
+	// Out of scope: a read-only token can't rate, nor manage tokens
+	if status, _ := doRequest(t, http.DefaultClient, http.MethodPost, server.URL+"/api/books/1/rating", `{"rating": 5}`, bearer(reader)...); status != http.StatusForbidden {
+		t.Errorf("rating with a catalog:read token = %d, want 403", status)
+	}
+	if status, _ := doRequest(t, http.DefaultClient, http.MethodPost, server.URL+"/api/users/me/tokens", `{"name": "more", "scopes": ["reviews:write"]}`, bearer(reader)...); status != http.StatusForbidden {
+		t.Errorf("minting with a token = %d, want 403", status)
+	}
+
+	if status, _ := doRequest(t, owner, http.MethodDelete, server.URL+"/api/users/me/tokens/"+revoked.ID, ""); status != http.StatusNoContent {
+		t.Fatalf("revoking = %d, want 204", status)
+	}
+	if status, _ := doRequest(t, http.DefaultClient, http.MethodGet, server.URL+"/api/books", "", bearer(revoked)...); status != http.StatusUnauthorized {
+		t.Errorf("catalog read with a revoked token = %d, want 401", status)
+	}
+
+	fixed.Advance(time.Hour + time.Second)
+	if status, _ := doRequest(t, http.DefaultClient, http.MethodGet, server.URL+"/api/books", "", bearer(expiring)...); status != http.StatusUnauthorized {
+		t.Errorf("catalog read with an expired token = %d, want 401", status)
+	}
+	if status, _ := doRequest(t, http.DefaultClient, http.MethodGet, server.URL+"/api/books", "", bearer(reader)...); status != http.StatusOK {
+		t.Errorf("catalog read with a token without expiry = %d, want 200", status)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Audiences a response can be written for. Model fields tagged `visibility:"admin"` are only
+// serialized for admin routes; untagged fields are visible to everyone. A tag may list several
+// audiences separated by commas.
+const (
+	audiencePublic = "public"
+	audienceAdmin  = "admin"
+)
+
+// audienceFor picks the audience of a response from its route. Everything under /api/admin/ is
+// written for operators; every other route gets the public view.
+func audienceFor(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/api/admin/") {
+		return audienceAdmin
+	}
+	return audiencePublic
+}
+
+// visibleTo returns a copy of a response value without the fields the audience may not see.
+// Hidden fields are removed from the type rather than zeroed, so they can't leak through a
+// missing omitempty. Values whose types carry no visibility tags are returned as they are.
+func visibleTo(value interface{}, audience string) interface{} {
+	if value == nil || audience == audienceAdmin {
+		return value
+	}
+	return stripHidden(reflect.ValueOf(value), audience).Interface()
+}
+
+// fieldVisible reports whether a struct field is serialized for the audience
+func fieldVisible(field reflect.StructField, audience string) bool {
+	tag, ok := field.Tag.Lookup("visibility")
+	if !ok {
+		return true
+	}
+	for _, allowed := range strings.Split(tag, ",") {
+		if strings.TrimSpace(allowed) == audience {
+			return true
+		}
+	}
+	return false
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+type visibilityKey struct {
+	t        reflect.Type
+	audience string
+}
+
+// Per type and audience: whether values need walking at all, and the filtered type.
+// Both are pure functions of the type, so racing stores write the same answer.
+var (
+	visibilityWalk  sync.Map // visibilityKey -> bool
+	visibilityTypes sync.Map // visibilityKey -> reflect.Type
+)
+
+// needsWalk reports whether values of a type may hold hidden fields: the type has a hidden
+// field somewhere inside, or an interface that could hold one
+func needsWalk(t reflect.Type, audience string) bool {
+	key := visibilityKey{t, audience}
+	if cached, ok := visibilityWalk.Load(key); ok {
+		return cached.(bool)
+	}
+	walk := analyseWalk(t, audience, map[reflect.Type]bool{})
+	visibilityWalk.Store(key, walk)
+	return walk
+}
+
+// analyseWalk computes needsWalk; seen holds types already being analysed, so recursive types end
+func analyseWalk(t reflect.Type, audience string, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	// Types that encode themselves are left alone; rebuilding them would lose the method
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return analyseWalk(t.Elem(), audience, seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.IsExported() && (!fieldVisible(field, audience) || analyseWalk(field.Type, audience, seen)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// visibleType returns the type a value has once hidden fields are removed. Structs with hidden
+// fields become new struct types built from their remaining exported fields, tags included,
+// so JSON names and tz:"date" markers carry over.
+func visibleType(t reflect.Type, audience string) reflect.Type {
+	if !needsWalk(t, audience) {
+		return t
+	}
+	key := visibilityKey{t, audience}
+	if cached, ok := visibilityTypes.Load(key); ok {
+		return cached.(reflect.Type)
+	}
+
+	filtered := t
+	switch t.Kind() {
+	case reflect.Ptr:
+		filtered = reflect.PointerTo(visibleType(t.Elem(), audience))
+	case reflect.Slice:
+		filtered = reflect.SliceOf(visibleType(t.Elem(), audience))
+	case reflect.Array:
+		filtered = reflect.ArrayOf(t.Len(), visibleType(t.Elem(), audience))
+	case reflect.Map:
+		filtered = reflect.MapOf(t.Key(), visibleType(t.Elem(), audience))
+	case reflect.Struct:
+		changed := false
+		var fields []reflect.StructField
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() || !fieldVisible(field, audience) {
+				changed = true
+				continue
+			}
+			if fieldType := visibleType(field.Type, audience); fieldType != field.Type {
+				field.Type = fieldType
+				changed = true
+			}
+			field.Index = nil
+			field.Offset = 0
+			fields = append(fields, field)
+		}
+		if changed {
+			filtered = reflect.StructOf(fields)
+		}
+	}
+	visibilityTypes.Store(key, filtered)
+	return filtered
+}
+
+// stripHidden rebuilds a value as visibleType describes it, copying containers on the way down
+// so handlers' data is never modified
+func stripHidden(value reflect.Value, audience string) reflect.Value {
+	if !needsWalk(value.Type(), audience) {
+		return value
+	}
+	filteredType := visibleType(value.Type(), audience)
+
+	switch value.Kind() {
+	case reflect.Interface:
+		// Only empty interfaces can hold a rebuilt type, which has no methods
+		if value.IsNil() || value.NumMethod() > 0 {
+			return value
+		}
+		converted := reflect.New(value.Type()).Elem()
+		converted.Set(stripHidden(value.Elem(), audience))
+		return converted
+
+	case reflect.Ptr:
+		if value.IsNil() {
+			return reflect.Zero(filteredType)
+		}
+		converted := reflect.New(filteredType.Elem())
+		converted.Elem().Set(stripHidden(value.Elem(), audience))
+		return converted
+
+	case reflect.Struct:
+		converted := reflect.New(filteredType).Elem()
+		if filteredType == value.Type() {
+			// Same fields; only values held in interfaces change
+			converted.Set(value)
+		}
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() || !fieldVisible(field, audience) {
+				continue
+			}
+			converted.FieldByName(field.Name).Set(stripHidden(value.Field(i), audience))
+		}
+		return converted
+
+	case reflect.Slice:
+		if value.IsNil() {
+			return reflect.Zero(filteredType)
+		}
+		converted := reflect.MakeSlice(filteredType, value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			converted.Index(i).Set(stripHidden(value.Index(i), audience))
+		}
+		return converted
+
+	case reflect.Array:
+		converted := reflect.New(filteredType).Elem()
+		for i := 0; i < value.Len(); i++ {
+			converted.Index(i).Set(stripHidden(value.Index(i), audience))
+		}
+		return converted
+
+	case reflect.Map:
+		if value.IsNil() {
+			return reflect.Zero(filteredType)
+		}
+		converted := reflect.MakeMapWithSize(filteredType, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			converted.SetMapIndex(iter.Key(), stripHidden(iter.Value(), audience))
+		}
+		return converted
+	}
+	return value
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"expvar"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests being served right now, for telling load from leaks
+var httpRequestsInFlight = expvar.NewInt("http_requests_in_flight")
+
+var watchdogWarnings = expvar.NewInt("watchdog_warnings")
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+}
+
+// inFlightMiddleware keeps httpRequestsInFlight up to date
+func inFlightMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpRequestsInFlight.Add(1)
+		defer httpRequestsInFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// goroutineSnapshot counts goroutines by stack at one moment
+type goroutineSnapshot struct {
+	takenAt time.Time
+	total   int
+	stacks  map[string]int
+}
+
+// takeGoroutineSnapshot groups the running goroutines by stack, as the goroutine profile does.
+// The debug=1 form lists each distinct stack once with its count:
+//
+//	3 @ 0x43e0ae 0x40a2d2 ...
+//	#	0x4c3a45	main.loadRecommendations+0x45	/src/details.go:120
+func takeGoroutineSnapshot() goroutineSnapshot {
+	var profile bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&profile, 1)
+
+	snapshot := goroutineSnapshot{takenAt: clock.Now().UTC(), stacks: map[string]int{}}
+	scanner := bufio.NewScanner(&profile)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	count := 0
+	var frames []string
+	flush := func() {
+		if count > 0 && len(frames) > 0 {
+			snapshot.stacks[strings.Join(frames, "\n")] += count
+			snapshot.total += count
+		}
+		count, frames = 0, nil
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "#"):
+			// Drop the program counter: "#", pc, function+offset, file:line
+			if fields := strings.Fields(line); len(fields) >= 4 {
+				frames = append(frames, fields[2]+" "+fields[3])
+			}
+		case strings.Contains(line, " @ "):
+			flush()
+			count, _ = strconv.Atoi(strings.Fields(line)[0])
+		case line == "":
+			flush()
+		}
+	}
+	flush()
+	return snapshot
+}
+
+// diffGoroutines lists the stacks with more goroutines now than in the baseline, most grown first
+func diffGoroutines(baseline, current goroutineSnapshot) GoroutineDiff {
+	diff := GoroutineDiff{
+		BaselineAt:    baseline.takenAt,
+		BaselineTotal: baseline.total,
+		TakenAt:       current.takenAt,
+		Total:         current.total,
+		Grown:         []GoroutineStackDiff{},
+	}
+	for stack, count := range current.stacks {
+		if before := baseline.stacks[stack]; count > before {
+			diff.Grown = append(diff.Grown, GoroutineStackDiff{Before: before, Now: count, Delta: count - before, Stack: stack})
+		}
+	}
+	sort.Slice(diff.Grown, func(i, j int) bool {
+		if diff.Grown[i].Delta != diff.Grown[j].Delta {
+			return diff.Grown[i].Delta > diff.Grown[j].Delta
+		}
+		return diff.Grown[i].Stack < diff.Grown[j].Stack
+	})
+	return diff
+}
+
+// watchdogState is the watchdog's recent samples and the snapshot diffs are taken against
+var watchdogState = struct {
+	sync.Mutex
+	goroutines []int
+	inFlight   []int64
+	baseline   goroutineSnapshot
+}{}
+
+// sampleWatchdog records one sample and warns when goroutines have grown at every one of the last
+// samples while requests in flight have not: goroutines that outlive their requests, like a
+// fan-in whose readers gave up before every sender was done
+func sampleWatchdog(samples, minGrowth int) {
+	goroutines := runtime.NumGoroutine()
+	inFlight := httpRequestsInFlight.Value()
+
+	watchdogState.Lock()
+	defer watchdogState.Unlock()
+	watchdogState.goroutines = append(watchdogState.goroutines, goroutines)
+	watchdogState.inFlight = append(watchdogState.inFlight, inFlight)
+	if len(watchdogState.goroutines) > samples {
+		watchdogState.goroutines = watchdogState.goroutines[1:]
+		watchdogState.inFlight = watchdogState.inFlight[1:]
+	}
+	if len(watchdogState.goroutines) < samples {
+		return
+	}
+
+	for i := 1; i < samples; i++ {
+		if watchdogState.goroutines[i] <= watchdogState.goroutines[i-1] {
+			return
+		}
+	}
+	first, last := watchdogState.goroutines[0], watchdogState.goroutines[samples-1]
+	requestGrowth := int(watchdogState.inFlight[samples-1] - watchdogState.inFlight[0])
+	if last-first < minGrowth || requestGrowth >= last-first {
+		return
+	}
+
+	watchdogWarnings.Add(1)
+	log.Printf("Warning: goroutines grew at each of the last %d samples, from %d to %d, while requests in flight went from %d to %d; "+
+		"see /debug/goroutine-diff for the stacks that grew", samples, first, last, watchdogState.inFlight[0], watchdogState.inFlight[samples-1])
+	// Start over, so a steady leak warns once per window rather than at every sample
+	watchdogState.goroutines = watchdogState.goroutines[:0]
+	watchdogState.inFlight = watchdogState.inFlight[:0]
+}
+
+// StartWatchdog samples goroutine and in-flight request counts every interval until ctx is done.
+// The goroutine-diff baseline is taken now, so the first diff covers everything since startup.
+func StartWatchdog(ctx context.Context, interval time.Duration, samples, minGrowth int) {
+	watchdogState.Lock()
+	watchdogState.baseline = takeGoroutineSnapshot()
+	watchdogState.Unlock()
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sampleWatchdog(samples, minGrowth)
+			}
+		}
+	}()
+}
+
+// GoroutineDiffHandler handles GET /debug/goroutine-diff: the stacks that have more goroutines
+// than at the baseline. The baseline is startup until ?reset=true moves it to now, so a diff can
+// cover exactly the window a leak is suspected in.
+func GoroutineDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	current := takeGoroutineSnapshot()
+
+	watchdogState.Lock()
+	baseline := watchdogState.baseline
+	if baseline.stacks == nil {
+		baseline = current
+	}
+	if r.URL.Query().Get("reset") == "true" {
+		watchdogState.baseline = current
+	}
+	watchdogState.Unlock()
+
+	diff := diffGoroutines(baseline, current)
+	diff.InFlight = httpRequestsInFlight.Value()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, diff)
+}